@@ -0,0 +1,141 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/chuckiihub/signing-service/api/dto"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// The passphrase travels in a header so it does not end up in access logs.
+const backupPassphraseHeader = "X-Backup-Passphrase"
+
+// Downloads a backup archive with every device, key and signature.
+func (context *Server) BackupExport(response http.ResponseWriter, request *http.Request) {
+	archive := &backupResponseWriter{response: response}
+	if err := context.backupService.Export(request.Context(), archive, request.Header.Get(backupPassphraseHeader)); err != nil {
+		if !archive.started {
+			WriteAppError(response, err)
+			return
+		}
+
+		// The archive is streamed, once it started the status can't change.
+		// Aborting drops the connection, so the client gets a truncated file
+		// without its trailer instead of one that looks complete.
+		slog.Error("backup export failed after it started", "error", err.Error())
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// Sends the headers of the download with the first bytes of the archive, so
+// errors before that are still answered with a proper status code.
+type backupResponseWriter struct {
+	response http.ResponseWriter
+	started  bool
+}
+
+func (writer *backupResponseWriter) Write(data []byte) (int, error) {
+	if !writer.started {
+		fileName := fmt.Sprintf("signing-service-%s.backup.gz", time.Now().UTC().Format("20060102T150405Z"))
+		writer.response.Header().Set("Content-Type", "application/gzip")
+		writer.response.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+		writer.response.WriteHeader(http.StatusOK)
+		writer.started = true
+	}
+
+	return writer.response.Write(data)
+}
+
+// Restores a backup archive sent as the request body.
+func (context *Server) BackupRestore(response http.ResponseWriter, request *http.Request) {
+	summary, err := context.backupService.Import(request.Context(), request.Body, request.Header.Get(backupPassphraseHeader))
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, summary)
+}
+
+// Lists the audit log, optionally filtered by `tenant`, `actor`, `action`, `deviceId`,
+// `requestId` and time (`from`, `to` as RFC3339), in the order it was written.
+func (context *Server) AuditLogList(response http.ResponseWriter, request *http.Request) {
+	query, err := parseAuditEventQuery(request.URL.Query())
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	pageRequest, err := parsePageRequest(request)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	events, err := context.auditLogService.List(request.Context(), query, pageRequest)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewPageResponse(events, func(event *domain.AuditEvent) domain.AuditEvent {
+		return *event
+	}))
+}
+
+func parseAuditEventQuery(values url.Values) (persistence.AuditEventQuery, error) {
+	query := persistence.AuditEventQuery{
+		TenantID:   values.Get("tenant"),
+		Actor:      values.Get("actor"),
+		Action:     values.Get("action"),
+		DeviceUUID: values.Get("deviceId"),
+		RequestID:  values.Get("requestId"),
+	}
+
+	var err error
+	if value := values.Get("from"); value != "" {
+		if query.From, err = time.Parse(time.RFC3339, value); err != nil {
+			return query, invalidParam("from", "must be a RFC3339 date")
+		}
+	}
+
+	if value := values.Get("to"); value != "" {
+		if query.To, err = time.Parse(time.RFC3339, value); err != nil {
+			return query, invalidParam("to", "must be a RFC3339 date")
+		}
+	}
+
+	return query, nil
+}
+
+// The signed checkpoints of the audit log along with the key to verify them.
+func (context *Server) AuditLogCheckpoints(response http.ResponseWriter, request *http.Request) {
+	checkpoints, err := context.auditLogService.Checkpoints(request.Context())
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	publicKey, keyID := context.auditLogService.PublicKey()
+	WriteAPIResponse(response, http.StatusOK, dto.AuditCheckpointsResponse{
+		KeyID:       keyID,
+		PublicKey:   publicKey,
+		Checkpoints: checkpoints,
+	})
+}
+
+// Checks the hashes and checkpoints of the whole audit log.
+func (context *Server) AuditLogVerify(response http.ResponseWriter, request *http.Request) {
+	verification, err := context.auditLogService.Verify(request.Context())
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, verification)
+}
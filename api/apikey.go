@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/chuckiihub/signing-service/api/dto"
+	"github.com/chuckiihub/signing-service/api/validation"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/service"
+	"github.com/gorilla/mux"
+)
+
+// Clients send their API key either as a bearer token or in this header.
+const apiKeyHeader = "X-API-Key"
+
+// Authenticates the requests carrying an API key, the identity of the request
+// is the key. Requests without a key go through as anonymous, the routes that
+// need a key reject them (see authenticated).
+func (context *Server) authenticationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		secret := apiKeyFromRequest(request)
+		if secret == "" {
+			next.ServeHTTP(response, request)
+			return
+		}
+
+		caller, err := context.apiKeyService.Authenticate(request.Context(), clientAddress(request), secret)
+		if err != nil {
+			var rateLimit *service.RateLimitError
+			if !errors.As(err, &rateLimit) {
+				response.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			WriteAppError(response, err)
+			return
+		}
+
+		next.ServeHTTP(response, request.WithContext(identity.NewContext(request.Context(), caller)))
+	})
+}
+
+func apiKeyFromRequest(request *http.Request) string {
+	if key := request.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+
+	scheme, token, found := strings.Cut(request.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	return ""
+}
+
+// Failed attempts are counted per address. Forwarded headers are not trusted,
+// anyone could send them, so behind a proxy every client shares its address.
+func clientAddress(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
+
+// Only lets through requests authenticated with a key. What the key can do is
+// up to the services, which answer with a 403 when it lacks the scope.
+func authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		if !identity.FromContext(request.Context()).IsAuthenticated() {
+			response.Header().Set("WWW-Authenticate", "Bearer")
+			WriteAppError(response, apperrors.WrapError(errors.New("send the API key in the Authorization or X-API-Key header"), apperrors.Unauthorized))
+			return
+		}
+
+		handler(response, request)
+	}
+}
+
+// Creates an API key, the response is the only time its secret is shown.
+func (context *Server) APIKeyCreate(response http.ResponseWriter, request *http.Request) {
+	var createRequest dto.APIKeyCreateRequest
+	if err := json.NewDecoder(request.Body).Decode(&createRequest); err != nil {
+		WriteInvalidRequestBodyError(response, err)
+		return
+	}
+
+	validator := validation.NewRequestValidator()
+	if err := validator.Validate(createRequest); err != nil {
+		WriteAppError(response, validator.Error(err))
+		return
+	}
+
+	key, secret, err := context.apiKeyService.Create(request.Context(), createRequest.Name, createRequest.Tenant, service.APIKeyGrant{
+		Scopes:  createRequest.Scopes,
+		Roles:   createRequest.Roles,
+		Devices: createRequest.Devices,
+	}, createRequest.ExpiresAt)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusCreated, dto.APIKeyCreatedResponse{
+		APIKeyResponse: dto.NewAPIKeyResponse(key),
+		Key:            secret,
+	})
+}
+
+func (context *Server) APIKeyList(response http.ResponseWriter, request *http.Request) {
+	pageRequest, err := parsePageRequest(request)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	keys, err := context.apiKeyService.List(request.Context(), pageRequest)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewPageResponse(keys, dto.NewAPIKeyResponse))
+}
+
+func (context *Server) APIKeyGet(response http.ResponseWriter, request *http.Request) {
+	key, err := context.apiKeyService.Get(request.Context(), mux.Vars(request)["id"])
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	if key == nil {
+		WriteAppError(response, apperrors.WrapCodedError(errors.New("API key not found"), apperrors.NotFound, apperrors.CodeAPIKeyNotFound))
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewAPIKeyResponse(key))
+}
+
+func (context *Server) APIKeyRevoke(response http.ResponseWriter, request *http.Request) {
+	key, err := context.apiKeyService.Revoke(request.Context(), mux.Vars(request)["id"])
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewAPIKeyResponse(key))
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/tlsconfig"
+)
+
+// Passes the client certificate of the request, if any, on to the services.
+// Only verified certificates count, the TLS listener checks them against the
+// client CA.
+func clientCertificateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(response, request)
+			return
+		}
+
+		certificate := request.TLS.VerifiedChains[0][0]
+		ctx := identity.NewCertificateContext(request.Context(), identity.Certificate{
+			Subject: certificate.Subject.String(),
+			Devices: tlsconfig.BoundDevices(certificate),
+		})
+		next.ServeHTTP(response, request.WithContext(ctx))
+	})
+}
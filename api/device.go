@@ -2,11 +2,19 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/chuckiihub/signing-service/api/dto"
 	"github.com/chuckiihub/signing-service/api/validation"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/service"
 	"github.com/gorilla/mux"
 )
 
@@ -18,24 +26,25 @@ func (context *Server) DeviceCreate(response http.ResponseWriter, request *http.
 
 	err := json.NewDecoder(request.Body).Decode(&creationRequest)
 	if err != nil {
-		WriteInvalidRequestBodyError(response)
+		WriteInvalidRequestBodyError(response, err)
 		return
 	}
 
 	validator := validation.NewRequestValidator()
 	if err := validator.Validate(creationRequest); err != nil {
-		WriteErrorResponse(response, http.StatusBadRequest, validator.GetValidationFailureErrors(err))
+		WriteAppError(response, validator.Error(err))
 		return
 	}
 
 	signatureAlgorithm, err := creationRequest.GetSignatureAlgorithm()
 	if err != nil {
 		// this is actually handled by the validator.
-		WriteErrorResponse(response, http.StatusBadRequest, []string{"not supported algorithm"})
+		WriteAppError(response, invalidParam("algorithm", "is not supported"))
 		return
 	}
 
 	device, err := context.deviceService.Create(
+		request.Context(),
 		signatureAlgorithm,
 		creationRequest.Label,
 	)
@@ -53,44 +62,237 @@ func (context *Server) DeviceGet(response http.ResponseWriter, request *http.Req
 	uuid := vars["uuid"]
 
 	if uuid == "" {
-		WriteErrorResponse(response, http.StatusBadRequest, []string{
-			"UUID is required",
-		})
+		WriteAppError(response, invalidParam("uuid", "is required"))
 		return
 	}
 
-	device, err := context.deviceService.Get(uuid)
+	device, err := context.deviceService.Get(request.Context(), uuid)
 	if err != nil {
 		WriteAppError(response, err)
 		return
 	}
 
 	if device == nil {
-		WriteNotFoundError(response)
+		WriteAppError(response, apperrors.WrapCodedError(errors.New("device not found"), apperrors.NotFound, apperrors.CodeDeviceNotFound))
 		return
 	}
 
+	response.Header().Set("ETag", deviceETag(device))
 	WriteAPIResponse(response, http.StatusOK, dto.NewDeviceResponse(device))
 }
 
+// Lists the devices, optionally filtered by `algorithm`, `state`, `label`
+// (substring), `tag` (`key:value`, repeatable), creation dates (`createdFrom`,
+// `createdTo` as RFC3339) and signature counter (`counterFrom`, `counterTo`),
+// sorted by `sort=created|label|counter` and `order=asc|desc`.
 func (context *Server) DeviceList(response http.ResponseWriter, request *http.Request) {
-	pageString := request.URL.Query().Get("page")
-	page, err := strconv.Atoi(pageString)
+	searchRequest, err := parseDeviceSearchRequest(request.URL.Query())
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	validator := validation.NewRequestValidator()
+	if err := validator.Validate(searchRequest); err != nil {
+		WriteAppError(response, validator.Error(err))
+		return
+	}
+
+	pageRequest, err := parsePageRequest(request)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	devices, err := context.deviceService.Search(request.Context(), dto.NewDeviceQuery(searchRequest), pageRequest)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewPageResponse(devices, func(device *domain.Device) dto.DeviceResponse {
+		return dto.NewDeviceResponse(device)
+	}))
+}
+
+// The query string values are strings, so the numbers, dates and tags are
+// parsed here and the validator takes care of the rest.
+func parseDeviceSearchRequest(values url.Values) (dto.DeviceSearchRequest, error) {
+	searchRequest := dto.DeviceSearchRequest{
+		Algorithm: values.Get("algorithm"),
+		State:     values.Get("state"),
+		Label:     values.Get("label"),
+		Sort:      values.Get("sort"),
+		Order:     values.Get("order"),
+	}
+
+	if tags := values["tag"]; len(tags) > 0 {
+		searchRequest.Tags = make(map[string]string, len(tags))
+		for _, tag := range tags {
+			key, value, _ := strings.Cut(tag, ":")
+			searchRequest.Tags[key] = value
+		}
+	}
+
+	for _, counter := range []struct {
+		name  string
+		value **int
+	}{{"counterFrom", &searchRequest.CounterFrom}, {"counterTo", &searchRequest.CounterTo}} {
+		if value := values.Get(counter.name); value != "" {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return searchRequest, invalidParam(counter.name, "must be an integer")
+			}
+			*counter.value = &number
+		}
+	}
+
+	var err error
+	if value := values.Get("createdFrom"); value != "" {
+		if searchRequest.CreatedFrom, err = time.Parse(time.RFC3339, value); err != nil {
+			return searchRequest, invalidParam("createdFrom", "must be a RFC3339 date")
+		}
+	}
+
+	if value := values.Get("createdTo"); value != "" {
+		if searchRequest.CreatedTo, err = time.Parse(time.RFC3339, value); err != nil {
+			return searchRequest, invalidParam("createdTo", "must be a RFC3339 date")
+		}
+	}
+
+	return searchRequest, nil
+}
+
+func (context *Server) DeviceSuspend(response http.ResponseWriter, request *http.Request) {
+	context.deviceStateChange(response, request, domain.DeviceStateSuspended)
+}
+
+func (context *Server) DeviceActivate(response http.ResponseWriter, request *http.Request) {
+	context.deviceStateChange(response, request, domain.DeviceStateActive)
+}
+
+func (context *Server) deviceStateChange(response http.ResponseWriter, request *http.Request, state domain.DeviceState) {
+	uuid := mux.Vars(request)["uuid"]
+
+	changeRequest, ok := parseDeviceStateChangeRequest(response, request)
+	if !ok {
+		return
+	}
+
+	device, err := context.deviceService.ChangeState(request.Context(), uuid, state, changeRequest.Reason)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	response.Header().Set("ETag", deviceETag(device))
+	WriteAPIResponse(response, http.StatusOK, dto.NewDeviceResponse(device))
+}
+
+// Writes a closing signature to the chain of the device, which will never sign again.
+func (context *Server) DeviceDecommission(response http.ResponseWriter, request *http.Request) {
+	uuid := mux.Vars(request)["uuid"]
+
+	changeRequest, ok := parseDeviceStateChangeRequest(response, request)
+	if !ok {
+		return
+	}
+
+	device, closingSignature, err := context.signatureService.Decommission(request.Context(), uuid, changeRequest.Reason)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.DeviceDecommissionResponse{
+		Device:           dto.NewDeviceResponse(device),
+		ClosingSignature: dto.NewSignatureResponseFromSignature(closingSignature),
+	})
+}
+
+// The body is optional, the reason can be left out.
+func parseDeviceStateChangeRequest(response http.ResponseWriter, request *http.Request) (dto.DeviceStateChangeRequest, bool) {
+	var changeRequest dto.DeviceStateChangeRequest
+
+	if request.ContentLength != 0 {
+		if err := json.NewDecoder(request.Body).Decode(&changeRequest); err != nil {
+			WriteInvalidRequestBodyError(response, err)
+			return changeRequest, false
+		}
+	}
+
+	validator := validation.NewRequestValidator()
+	if err := validator.Validate(changeRequest); err != nil {
+		WriteAppError(response, validator.Error(err))
+		return changeRequest, false
+	}
+
+	return changeRequest, true
+}
+
+// Changes the label or tags of a device. The If-Match header must hold the ETag
+// of the device as the client read it, so changes made meanwhile by someone
+// else are not overwritten.
+func (context *Server) DeviceUpdate(response http.ResponseWriter, request *http.Request) {
+	uuid := mux.Vars(request)["uuid"]
+
+	ifMatch := request.Header.Get("If-Match")
+	if ifMatch == "" {
+		WriteAppError(response, apperrors.WrapError(errors.New("the If-Match header with the ETag of the device is required"), apperrors.PreconditionRequired))
+		return
+	}
+
+	expectedRevision, err := parseDeviceETag(ifMatch)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	var updateRequest dto.DeviceUpdateRequest
+	decoder := json.NewDecoder(request.Body)
+	// keys, counter and chain cannot be changed, better say so than ignoring them
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&updateRequest); err != nil {
+		WriteInvalidRequestBodyError(response, fmt.Errorf("only label and tags can be changed: %w", err))
+		return
+	}
 
-	if err != nil || page < 1 || pageString == "" {
-		page = 1
+	validator := validation.NewRequestValidator()
+	if err := validator.Validate(updateRequest); err != nil {
+		WriteAppError(response, validator.Error(err))
+		return
 	}
 
-	devices, err := context.deviceService.List(page)
+	device, err := context.deviceService.Update(request.Context(), uuid, service.DeviceChanges{
+		Label: updateRequest.Label,
+		Tags:  updateRequest.Tags,
+	}, expectedRevision)
 	if err != nil {
 		WriteAppError(response, err)
 		return
 	}
 
-	devicesResponse := make([]dto.DeviceResponse, 0)
-	for _, device := range devices {
-		devicesResponse = append(devicesResponse, dto.NewDeviceResponse(&device))
+	response.Header().Set("ETag", deviceETag(device))
+	WriteAPIResponse(response, http.StatusOK, dto.NewDeviceResponse(device))
+}
+
+func deviceETag(device *domain.Device) string {
+	return strconv.Quote(strconv.FormatUint(device.Revision, 10))
+}
+
+// `*` matches any revision, for clients that don't mind overwriting.
+func parseDeviceETag(etag string) (uint64, error) {
+	if strings.TrimSpace(etag) == "*" {
+		return service.AnyRevision, nil
+	}
+
+	unquoted, err := strconv.Unquote(strings.TrimPrefix(etag, "W/"))
+	if err == nil {
+		var revision uint64
+		if revision, err = strconv.ParseUint(unquoted, 10, 64); err == nil {
+			return revision, nil
+		}
 	}
 
-	WriteAPIResponse(response, http.StatusOK, devicesResponse)
+	return 0, invalidParam("If-Match", "is not an ETag of a device")
 }
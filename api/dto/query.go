@@ -0,0 +1,55 @@
+package dto
+
+import (
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// The filters of the listings as the persistence takes them. The requests
+// must be validated first.
+
+func NewDeviceQuery(searchRequest DeviceSearchRequest) persistence.DeviceQuery {
+	query := persistence.DeviceQuery{
+		State:         domain.DeviceState(searchRequest.State),
+		LabelContains: searchRequest.Label,
+		Tags:          searchRequest.Tags,
+		CreatedFrom:   searchRequest.CreatedFrom,
+		CreatedTo:     searchRequest.CreatedTo,
+		CounterFrom:   searchRequest.CounterFrom,
+		CounterTo:     searchRequest.CounterTo,
+	}
+
+	if searchRequest.Algorithm != "" {
+		// already validated
+		algorithm, _ := (&DeviceCreationRequest{Algorithm: searchRequest.Algorithm}).GetSignatureAlgorithm()
+		query.Algorithm = &algorithm
+	}
+
+	switch searchRequest.Sort {
+	case "label":
+		query.SortBy = persistence.SortDevicesByLabel
+	case "counter":
+		query.SortBy = persistence.SortDevicesBySignatureCounter
+	}
+
+	if searchRequest.Order == "desc" {
+		query.Order = persistence.OrderDescending
+	}
+
+	return query
+}
+
+func NewSignatureQuery(listRequest DeviceSignaturesRequest) persistence.SignatureQuery {
+	query := persistence.SignatureQuery{
+		CounterFrom: listRequest.CounterFrom,
+		CounterTo:   listRequest.CounterTo,
+		CreatedFrom: listRequest.From,
+		CreatedTo:   listRequest.To,
+		Order:       persistence.OrderAscending,
+	}
+	if listRequest.Order == "desc" {
+		query.Order = persistence.OrderDescending
+	}
+
+	return query
+}
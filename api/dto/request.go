@@ -2,6 +2,7 @@ package dto
 
 import (
 	"errors"
+	"time"
 
 	"github.com/chuckiihub/signing-service/crypto"
 )
@@ -24,9 +25,34 @@ func (request *DeviceCreationRequest) GetSignatureAlgorithm() (crypto.SignatureA
 	}
 }
 
-// Client request to sign new data
+// Client request to change the mutable fields of a device, sent as a JSON merge
+// patch: missing fields are left as they are and a null tag removes it.
+type DeviceUpdateRequest struct {
+	Label *string            `json:"label" validate:"omitempty,min=1,max=128"`
+	Tags  map[string]*string `json:"tags" validate:"omitempty,max=20,dive,keys,min=1,max=64,endkeys,omitempty,max=256"`
+}
+
+// Client request to suspend, reactivate or decommission a device. The reason
+// is optional and stored with the device.
+type DeviceStateChangeRequest struct {
+	Reason string `json:"reason" validate:"max=256"`
+}
+
+// Client request to sign new data. Metadata is optional, e.g. a transaction ID or a register ID.
 type SignatureCreateRequest struct {
-	Data string `json:"data" validate:"required"`
+	Data     string            `json:"data" validate:"required"`
+	Metadata map[string]string `json:"metadata" validate:"omitempty,max=20,dive,keys,min=1,max=64,endkeys,max=256"`
+}
+
+// Message of a register in a signing session: `sign` to sign data, or `ack`
+// with the counter of the last signature it received.
+type SigningSessionRequest struct {
+	Type string `json:"type" validate:"required,oneof=sign ack"`
+	// Sent back with the result, so the client can match them.
+	Id       string            `json:"id" validate:"max=64"`
+	Data     string            `json:"data" validate:"required_if=Type sign"`
+	Metadata map[string]string `json:"metadata" validate:"omitempty,max=20,dive,keys,min=1,max=64,endkeys,max=256"`
+	Counter  int               `json:"counter" validate:"gte=0"`
 }
 
 // Client request to verified already signed data
@@ -34,3 +60,56 @@ type SignatureVerifyRequest struct {
 	SignedData string `json:"signedData" validate:"required,min=1"`
 	Signature  string `json:"signature" validate:"required,min=1"`
 }
+
+// Client filters to list the signatures of a single device. It is filled
+// from the query string of the request.
+type DeviceSignaturesRequest struct {
+	Order       string `validate:"omitempty,oneof=asc desc"`
+	CounterFrom int    `validate:"gte=0"`
+	CounterTo   int    `validate:"gte=0"`
+	From        time.Time
+	To          time.Time
+}
+
+// Client filters to search devices. It is filled from the query string of the
+// request, tags come as `key:value` (or just `key` to require the tag).
+type DeviceSearchRequest struct {
+	Algorithm   string            `validate:"omitempty,supported-encryption"`
+	State       string            `validate:"omitempty,oneof=active suspended decommissioned"`
+	Label       string            `validate:"max=128"`
+	Tags        map[string]string `validate:"max=20,dive,keys,min=1,max=64,endkeys,max=256"`
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	CounterFrom *int   `validate:"omitempty,gte=0"`
+	CounterTo   *int   `validate:"omitempty,gte=0"`
+	Sort        string `validate:"omitempty,oneof=created label counter"`
+	Order       string `validate:"omitempty,oneof=asc desc"`
+}
+
+// Admin request to create an API key, scopes are checked by the service.
+type APIKeyCreateRequest struct {
+	Name string `json:"name" validate:"required,min=1,max=64"`
+	// Defaults to the tenant of the caller, only operators can pick another one.
+	Tenant string `json:"tenant" validate:"max=64"`
+	// At least one scope or role is needed.
+	Scopes []string `json:"scopes" validate:"max=20"`
+	Roles  []string `json:"roles" validate:"max=20"`
+	// Restricts the key to these devices, required by roles like signer.
+	Devices   []string   `json:"devices" validate:"max=100,dive,uuid"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// Client request to register a webhook endpoint. No types means every type.
+type WebhookCreateRequest struct {
+	Url         string   `json:"url" validate:"required,url,max=2048"`
+	Types       []string `json:"types" validate:"max=10,dive,oneof=signature.created device.created device.updated device.state_changed chain.integrity_failed"`
+	Description string   `json:"description" validate:"max=256"`
+}
+
+// Client request to page through a listing. It is filled from the query
+// string of the request.
+type PageRequest struct {
+	Cursor       string `validate:"omitempty,base64rawurl"`
+	Limit        int    `validate:"gte=0"`
+	IncludeTotal bool
+}
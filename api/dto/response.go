@@ -1,39 +1,76 @@
 package dto
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
 	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/persistence"
 )
 
 // Represents the server's response to the client's request to create a new device.
 type DeviceResponse struct {
-	Id         string `json:"uuid"`
-	Label      string `json:"label"`
-	Algorithm  string `json:"algorithm"`
-	PublicKey  string `json:"publicKey"`
-	PrivateKey string `json:"privateKey"`
+	Id        string `json:"uuid"`
+	TenantId  string `json:"tenantId"`
+	Label     string `json:"label"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"publicKey"`
+	// Only sent to keys with the device:keys scope.
+	PrivateKey     string            `json:"privateKey,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	State          string            `json:"state"`
+	StateReason    string            `json:"stateReason,omitempty"`
+	StateChangedAt time.Time         `json:"stateChangedAt"`
+	StateChangedBy string            `json:"stateChangedBy"`
+	// The listing can be filtered and sorted by these two.
+	SignatureCounter int       `json:"signatureCounter"`
+	CreatedAt        time.Time `json:"createdAt"`
+	// Same as the ETag, without the quotes.
+	Revision uint64 `json:"revision"`
+}
+
+// Response to a decommission, with the signature that closed the chain of the device.
+type DeviceDecommissionResponse struct {
+	Device           DeviceResponse     `json:"device"`
+	ClosingSignature *SignatureResponse `json:"closingSignature"`
 }
 
 type SignatureResponse struct {
-	Id         string `json:"uuid"`
-	DeviceId   string `json:"deviceId"`
-	SignedData string `json:"signedData"`
-	Signature  string `json:"signature"`
+	Id                string            `json:"uuid"`
+	DeviceId          string            `json:"deviceId"`
+	Counter           int               `json:"counter"`
+	SignedData        string            `json:"signedData"`
+	Signature         string            `json:"signature"`
+	PreviousSignature string            `json:"previousSignature"`
+	Algorithm         string            `json:"algorithm"`
+	KeyVersion        int               `json:"keyVersion"`
+	CreatedAt         time.Time         `json:"createdAt"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	IdempotencyKey    string            `json:"idempotencyKey,omitempty"`
 }
 
 func NewSignatureResponseFromSignature(signature *domain.Signature) *SignatureResponse {
-	return &SignatureResponse{
-		Id:         signature.UUID,
-		DeviceId:   signature.DeviceUUID,
-		SignedData: signature.SignedData,
-		Signature:  signature.Signature,
-	}
+	response := NewSignatureResponse(signature)
+	response.Id = signature.UUID
+
+	return &response
 }
 
 func NewSignatureResponse(signature *domain.Signature) SignatureResponse {
 	return SignatureResponse{
-		DeviceId:   signature.DeviceUUID,
-		SignedData: signature.SignedData,
-		Signature:  signature.Signature,
+		DeviceId:          signature.DeviceUUID,
+		Counter:           signature.Counter,
+		SignedData:        signature.SignedData,
+		Signature:         signature.Signature,
+		PreviousSignature: signature.PreviousSignature,
+		Algorithm:         signature.Algorithm.String(),
+		KeyVersion:        signature.KeyVersion,
+		CreatedAt:         signature.CreatedAt,
+		Metadata:          signature.Metadata,
+		IdempotencyKey:    signature.IdempotencyKey,
 	}
 }
 
@@ -41,10 +78,258 @@ func NewDeviceResponse(device *domain.Device) DeviceResponse {
 	publicKeyPEM := string(device.PublicKey)
 
 	return DeviceResponse{
-		Id:         device.UUID,
-		Label:      device.Label,
-		Algorithm:  device.Algorithm.String(),
-		PublicKey:  publicKeyPEM,
-		PrivateKey: string(device.PrivateKey),
+		Id:             device.UUID,
+		TenantId:       device.Tenant(),
+		Label:          device.Label,
+		Algorithm:      device.Algorithm.String(),
+		PublicKey:      publicKeyPEM,
+		PrivateKey:     string(device.PrivateKey),
+		Tags:           device.Tags,
+		State:          string(device.CurrentState()),
+		StateReason:    device.StateReason,
+		StateChangedAt: device.StateChangedAt,
+		StateChangedBy: device.StateChangedBy,
+
+		SignatureCounter: device.SignatureCounter,
+		CreatedAt:        device.CreatedAt,
+		Revision:         device.Revision,
+	}
+}
+
+// Message of the server in a signing session: `ready` once connected,
+// `signature` for every signature and `error` for the requests that failed.
+type SigningSessionMessage struct {
+	Type      string             `json:"type"`
+	Id        string             `json:"id,omitempty"`
+	DeviceId  string             `json:"deviceId,omitempty"`
+	Signature *SignatureResponse `json:"signature,omitempty"`
+	// Signatures made before a reconnect the client did not acknowledge.
+	Resent bool     `json:"resent,omitempty"`
+	Error  *Problem `json:"error,omitempty"`
+}
+
+// Data of an event of /api/v0/events, with the signature or the device
+// depending on its type. Devices never carry their private key here.
+type EventResponse struct {
+	Id        string             `json:"id"`
+	Type      string             `json:"type"`
+	DeviceId  string             `json:"deviceId"`
+	Time      time.Time          `json:"time"`
+	Signature *SignatureResponse `json:"signature,omitempty"`
+	Device    *DeviceResponse    `json:"device,omitempty"`
+	// What the audit found, for chain.integrity_failed.
+	ChainAudit *domain.ChainAudit `json:"chainAudit,omitempty"`
+}
+
+func NewEventResponse(event *domain.Event) EventResponse {
+	response := EventResponse{
+		Id:         event.ID,
+		Type:       string(event.Type),
+		DeviceId:   event.DeviceUUID,
+		Time:       event.Time,
+		ChainAudit: event.ChainAudit,
+	}
+	if event.Signature != nil {
+		response.Signature = NewSignatureResponseFromSignature(event.Signature)
+	}
+	if event.Device != nil {
+		device := NewDeviceResponse(event.Device)
+		response.Device = &device
+	}
+
+	return response
+}
+
+// Wraps a page of any listing. NextCursor has to be sent back as the
+// `cursor` query parameter to fetch the following page.
+type PageResponse[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+	Total      *int   `json:"total,omitempty"`
+	// Set when the listing leaves out part of the storage, e.g. the archived
+	// signatures, which the total doesn't count either.
+	Partial bool `json:"partial,omitempty"`
+}
+
+func NewPageResponse[S any, T any](page persistence.Page[S], toResponse func(*S) T) PageResponse[T] {
+	items := make([]T, 0, len(page.Items))
+	for i := range page.Items {
+		items = append(items, toResponse(&page.Items[i]))
+	}
+
+	return PageResponse[T]{
+		Items:      items,
+		NextCursor: page.NextCursor,
+		HasMore:    page.HasMore,
+		Total:      page.Total,
+		Partial:    page.Partial,
+	}
+}
+
+// Checkpoints of the audit log and the public key of the service that signed them.
+type AuditCheckpointsResponse struct {
+	KeyID       string                   `json:"keyId"`
+	PublicKey   string                   `json:"publicKey"`
+	Checkpoints []domain.AuditCheckpoint `json:"checkpoints"`
+}
+
+// API keys never leave the service with their secret, apart from the response
+// to their creation.
+type APIKeyResponse struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	TenantId  string     `json:"tenantId"`
+	Scopes    []string   `json:"scopes"`
+	Roles     []string   `json:"roles,omitempty"`
+	Devices   []string   `json:"devices,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	CreatedBy string     `json:"createdBy"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	RevokedBy string     `json:"revokedBy,omitempty"`
+}
+
+// The secret is only sent once, in this response.
+type APIKeyCreatedResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+func NewAPIKeyResponse(key *domain.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		Id:        key.ID,
+		Name:      key.Name,
+		TenantId:  key.TenantID,
+		Scopes:    key.Scopes,
+		Roles:     key.Roles,
+		Devices:   key.Devices,
+		CreatedAt: key.CreatedAt,
+		CreatedBy: key.CreatedBy,
+		ExpiresAt: key.ExpiresAt,
+		RevokedAt: key.RevokedAt,
+		RevokedBy: key.RevokedBy,
+	}
+}
+
+type WebhookResponse struct {
+	Id          string    `json:"id"`
+	TenantId    string    `json:"tenantId"`
+	Url         string    `json:"url"`
+	Types       []string  `json:"types"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	CreatedBy   string    `json:"createdBy"`
+}
+
+// The secret is only sent once, in this response.
+type WebhookCreatedResponse struct {
+	WebhookResponse
+	Secret string `json:"secret"`
+}
+
+func NewWebhookResponse(endpoint *domain.WebhookEndpoint) WebhookResponse {
+	types := make([]string, 0, len(endpoint.Types))
+	for _, eventType := range endpoint.Types {
+		types = append(types, string(eventType))
+	}
+
+	return WebhookResponse{
+		Id:          endpoint.ID,
+		TenantId:    endpoint.TenantID,
+		Url:         endpoint.URL,
+		Types:       types,
+		Description: endpoint.Description,
+		CreatedAt:   endpoint.CreatedAt,
+		CreatedBy:   endpoint.CreatedBy,
+	}
+}
+
+type WebhookDeliveryResponse struct {
+	Id            string                   `json:"id"`
+	WebhookId     string                   `json:"webhookId"`
+	EventId       string                   `json:"eventId"`
+	EventType     string                   `json:"eventType"`
+	Status        string                   `json:"status"`
+	Payload       json.RawMessage          `json:"payload"`
+	Attempts      []WebhookAttemptResponse `json:"attempts"`
+	NextAttemptAt *time.Time               `json:"nextAttemptAt,omitempty"`
+	CreatedAt     time.Time                `json:"createdAt"`
+	DeliveredAt   *time.Time               `json:"deliveredAt,omitempty"`
+	ReplayOf      string                   `json:"replayOf,omitempty"`
+}
+
+type WebhookAttemptResponse struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+func NewWebhookDeliveryResponse(delivery *domain.WebhookDelivery) WebhookDeliveryResponse {
+	response := WebhookDeliveryResponse{
+		Id:          delivery.ID,
+		WebhookId:   delivery.EndpointID,
+		EventId:     delivery.EventID,
+		EventType:   string(delivery.EventType),
+		Status:      string(delivery.Status),
+		Payload:     delivery.Payload,
+		Attempts:    make([]WebhookAttemptResponse, 0, len(delivery.Attempts)),
+		CreatedAt:   delivery.CreatedAt,
+		DeliveredAt: delivery.DeliveredAt,
+		ReplayOf:    delivery.ReplayOf,
+	}
+	if delivery.Status == domain.WebhookDeliveryPending {
+		response.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	for _, attempt := range delivery.Attempts {
+		response.Attempts = append(response.Attempts, WebhookAttemptResponse{
+			At:         attempt.At,
+			StatusCode: attempt.StatusCode,
+			Error:      attempt.Error,
+			DurationMs: attempt.Duration.Milliseconds(),
+		})
+	}
+
+	return response
+}
+
+// The type of the problems is this followed by their code.
+const ProblemTypePrefix = "urn:signing-service:problem:"
+
+// Body of every error response, an RFC 7807 problem (application/problem+json)
+// with the stable code of the error next to the standard members.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+	// Same as the X-Request-ID header, to quote when reporting the problem.
+	RequestId     string                   `json:"requestId,omitempty"`
+	InvalidParams []apperrors.InvalidParam `json:"invalid-params,omitempty"`
+}
+
+// Errors that are not an AppError are internal errors, and requests cancelled
+// or timed out somewhere down the layers are not bugs either way.
+func NewProblem(err error) Problem {
+	var appErr apperrors.AppError
+	if !errors.As(err, &appErr) {
+		appErr = apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		appErr = apperrors.WrapError(err, apperrors.Unavailable)
+		appErr.Detail = "the request was cancelled or took too long"
+	}
+
+	code := appErr.ErrorCode()
+	return Problem{
+		Type:          ProblemTypePrefix + code,
+		Title:         appErr.ErrorTitle(),
+		Status:        appErr.Type,
+		Detail:        appErr.ErrorDetail(),
+		Code:          code,
+		InvalidParams: appErr.InvalidParams,
 	}
 }
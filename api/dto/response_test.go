@@ -1,25 +1,44 @@
 package dto
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/chuckiihub/signing-service/crypto"
 	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestNewSignatureResponseFromSignature(t *testing.T) {
 	signature := &domain.Signature{
-		DeviceUUID: "test-device-uuid",
-		SignedData: "test-signed-data",
-		Signature:  "test-signature",
+		UUID:              "test-uuid",
+		DeviceUUID:        "test-device-uuid",
+		Counter:           7,
+		SignedData:        "test-signed-data",
+		Signature:         "test-signature",
+		PreviousSignature: "test-previous-signature",
+		Algorithm:         crypto.SignatureAlgorithmECC,
+		KeyVersion:        1,
+		CreatedAt:         time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Metadata:          map[string]string{"transactionId": "tx-1"},
 	}
 
 	response := NewSignatureResponseFromSignature(signature)
 
+	assert.Equal(t, signature.UUID, response.Id)
 	assert.Equal(t, signature.DeviceUUID, response.DeviceId)
+	assert.Equal(t, signature.Counter, response.Counter)
 	assert.Equal(t, signature.SignedData, response.SignedData)
 	assert.Equal(t, signature.Signature, response.Signature)
+	assert.Equal(t, signature.PreviousSignature, response.PreviousSignature)
+	assert.Equal(t, "ECC", response.Algorithm)
+	assert.Equal(t, signature.KeyVersion, response.KeyVersion)
+	assert.Equal(t, signature.CreatedAt, response.CreatedAt)
+	assert.Equal(t, signature.Metadata, response.Metadata)
 }
 
 func TestNewSignatureResponse(t *testing.T) {
@@ -43,6 +62,10 @@ func TestNewDeviceResponse(t *testing.T) {
 		Algorithm:  crypto.SignatureAlgorithmRSA,
 		PublicKey:  []byte("test-public-key"),
 		PrivateKey: []byte("test-private-key"),
+
+		SignatureCounter: 3,
+		CreatedAt:        time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Revision:         5,
 	}
 
 	response := NewDeviceResponse(device)
@@ -52,4 +75,45 @@ func TestNewDeviceResponse(t *testing.T) {
 	assert.Equal(t, device.Algorithm.String(), response.Algorithm)
 	assert.Equal(t, string(device.PublicKey), response.PublicKey)
 	assert.Equal(t, string(device.PrivateKey), response.PrivateKey)
+	assert.Equal(t, 3, response.SignatureCounter)
+	assert.Equal(t, device.CreatedAt, response.CreatedAt)
+	assert.Equal(t, uint64(5), response.Revision)
+	// devices stored before lifecycle states existed are active
+	assert.Equal(t, "active", response.State)
+}
+
+func TestNewProblem(t *testing.T) {
+	problem := NewProblem(apperrors.WrapCodedError(errors.New("device not found"), apperrors.NotFound, apperrors.CodeDeviceNotFound))
+	assert.Equal(t, Problem{
+		Type:   ProblemTypePrefix + "device_not_found",
+		Title:  "Device not found",
+		Status: 404,
+		Detail: "device not found",
+		Code:   "device_not_found",
+	}, problem)
+
+	// wrapped with only a status, the code is the one of the status
+	problem = NewProblem(fmt.Errorf("listing: %w", apperrors.WrapError(errors.New("cursor is not valid"), apperrors.BadRequest)))
+	assert.Equal(t, "bad_request", problem.Code)
+	assert.Equal(t, "cursor is not valid", problem.Detail)
+
+	problem = NewProblem(apperrors.NewInvalidParamsError(apperrors.InvalidParam{Name: "label", Reason: "is required"}))
+	assert.Equal(t, 400, problem.Status)
+	assert.Equal(t, "invalid_params", problem.Code)
+	assert.Equal(t, []apperrors.InvalidParam{{Name: "label", Reason: "is required"}}, problem.InvalidParams)
+}
+
+func TestNewProblem_ServerErrorsDontTellWhy(t *testing.T) {
+	problem := NewProblem(errors.New("dial tcp 10.0.0.3:6379: connection refused"))
+	assert.Equal(t, 500, problem.Status)
+	assert.Equal(t, "internal_error", problem.Code)
+	assert.Empty(t, problem.Detail)
+
+	problem = NewProblem(apperrors.WrapError(errors.New("lock lease lost"), apperrors.Unavailable))
+	assert.Equal(t, "unavailable", problem.Code)
+	assert.Empty(t, problem.Detail)
+
+	problem = NewProblem(apperrors.WrapError(context.Canceled, apperrors.InternalError))
+	assert.Equal(t, 503, problem.Status)
+	assert.Equal(t, "the request was cancelled or took too long", problem.Detail)
 }
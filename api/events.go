@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chuckiihub/signing-service/api/dto"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/service"
+)
+
+// Proxies close connections that stay quiet for too long.
+const eventsHeartbeatPeriod = 15 * time.Second
+
+// Streams the events as Server-Sent Events, optionally only those of a
+// `deviceId` and of some `type`s (repeatable). Clients reconnecting with the
+// `Last-Event-ID` header, or the `lastEventId` query parameter, get the events
+// they missed first. An event may be sent twice around a reconnect, clients
+// skip the IDs they already have.
+//
+// A client reading slower than the events come is disconnected, it resumes
+// from its last event like after any other disconnect.
+func (context *Server) Events(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	filter := service.EventFilter{DeviceUUID: query.Get("deviceId")}
+	for _, eventType := range query["type"] {
+		filter.Types = append(filter.Types, domain.EventType(eventType))
+	}
+
+	lastEventID := request.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("lastEventId")
+	}
+
+	subscription, missed, err := context.eventService.Subscribe(request.Context(), filter, lastEventID)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+	defer subscription.Close()
+
+	controller := http.NewResponseController(response)
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	// keeps nginx from buffering the stream
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+
+	// the missed events may come again from the subscription
+	sent := make(map[string]struct{}, len(missed))
+	for _, event := range missed {
+		if err := writeEvent(response, &event); err != nil {
+			return
+		}
+		sent[event.ID] = struct{}{}
+	}
+	if err := controller.Flush(); err != nil {
+		slog.Error("could not stream the events", "error", err.Error())
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeatPeriod)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(response, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event, open := <-subscription.Events():
+			if !open {
+				if subscription.Lagged() {
+					slog.Warn("events subscriber fell behind, disconnecting it")
+				}
+				return
+			}
+			if _, found := sent[event.ID]; found {
+				continue
+			}
+			if err := writeEvent(response, &event); err != nil {
+				return
+			}
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// The data of the events streamed, also the body of the webhooks.
+func EncodeEvent(event *domain.Event) ([]byte, error) {
+	return json.Marshal(dto.NewEventResponse(event))
+}
+
+func writeEvent(w io.Writer, event *domain.Event) error {
+	data, err := EncodeEvent(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
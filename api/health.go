@@ -10,8 +10,8 @@ import (
 // The structure that the service answers here goes from this endpoint (API is alive)
 // to the SignatureService, DeviceServices and it's DBs.
 func (s *Server) Health(response http.ResponseWriter, request *http.Request) {
-	signatureService := s.signatureService.CheckHealth()
-	deviceService := s.deviceService.CheckHealth()
+	signatureService := s.signatureService.CheckHealth(request.Context())
+	deviceService := s.deviceService.CheckHealth(request.Context())
 
 	health := domain.Health{
 		Status:  "pass",
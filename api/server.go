@@ -1,12 +1,19 @@
 package api
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 
+	"github.com/chuckiihub/signing-service/api/dto"
+	"github.com/chuckiihub/signing-service/api/validation"
 	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/chuckiihub/signing-service/requestid"
 	"github.com/chuckiihub/signing-service/service"
 	"github.com/gorilla/mux"
 )
@@ -16,86 +23,219 @@ type Response struct {
 	Data interface{} `json:"data"`
 }
 
-// ErrorResponse is the generic error API response container.
-type ErrorResponse struct {
-	Errors []string `json:"errors"`
-}
-
 // Server manages HTTP requests and dispatches them to the appropriate services.
 type Server struct {
 	listenAddress    string
 	deviceService    service.DeviceService
 	signatureService service.SignatureService
+	backupService    service.BackupService
+	auditLogService  service.AuditLogService
+	apiKeyService    service.APIKeyService
+	// Long lived WebSocket sessions of the registers.
+	signingSessionService service.SigningSessionService
+	// Feed of /api/v0/events.
+	eventService   service.EventService
+	webhookService service.WebhookService
+	// nil to listen on plain HTTP
+	tlsConfig *tls.Config
 }
 
 // NewServer is a factory to instantiate a new Server.
-func NewServer(listenAddress string, deviceService service.DeviceService, signatureService service.SignatureService) *Server {
+func NewServer(
+	listenAddress string,
+	deviceService service.DeviceService,
+	signatureService service.SignatureService,
+	backupService service.BackupService,
+	auditLogService service.AuditLogService,
+	apiKeyService service.APIKeyService,
+	signingSessionService service.SigningSessionService,
+	eventService service.EventService,
+	webhookService service.WebhookService,
+	tlsConfig *tls.Config,
+) *Server {
 	return &Server{
-		listenAddress:    listenAddress,
-		deviceService:    deviceService,
-		signatureService: signatureService,
+		listenAddress:         listenAddress,
+		deviceService:         deviceService,
+		signatureService:      signatureService,
+		backupService:         backupService,
+		auditLogService:       auditLogService,
+		apiKeyService:         apiKeyService,
+		signingSessionService: signingSessionService,
+		eventService:          eventService,
+		webhookService:        webhookService,
+		tlsConfig:             tlsConfig,
 	}
 }
 
-// Run registers all HandlerFuncs for the existing HTTP routes and starts the Server.
+// Run starts the Server with the routes of NewHandler.
 func (s *Server) Run() error {
+	server := &http.Server{Addr: s.listenAddress, Handler: s.NewHandler(), TLSConfig: s.tlsConfig}
+	if s.tlsConfig != nil {
+		// the certificates come from the TLS configuration
+		return server.ListenAndServeTLS("", "")
+	}
+
+	return server.ListenAndServe()
+}
+
+// NewHandler registers all HandlerFuncs for the existing HTTP routes, the
+// listener is up to the caller.
+func (s *Server) NewHandler() http.Handler {
 	router := mux.NewRouter()
+	router.Use(requestIDMiddleware)
+	router.Use(clientCertificateMiddleware)
+	router.Use(s.authenticationMiddleware)
 
+	// the health check and the docs are the only routes open to anyone
 	router.HandleFunc("/api/v0/health", s.Health)
 
-	router.HandleFunc("/api/v0/device", s.DeviceCreate).Methods("POST")
-	router.HandleFunc("/api/v0/device/{uuid}", s.DeviceGet).Methods("GET")
-	router.HandleFunc("/api/v0/device", s.DeviceList).Methods("GET")
+	router.HandleFunc("/api/v0/device", authenticated(s.DeviceCreate)).Methods("POST")
+	router.HandleFunc("/api/v0/device/{uuid}", authenticated(s.DeviceGet)).Methods("GET")
+	router.HandleFunc("/api/v0/device", authenticated(s.DeviceList)).Methods("GET")
+	router.HandleFunc("/api/v0/device/{uuid}", authenticated(s.DeviceUpdate)).Methods("PATCH")
+	router.HandleFunc("/api/v0/device/{uuid}/suspend", authenticated(s.DeviceSuspend)).Methods("POST")
+	router.HandleFunc("/api/v0/device/{uuid}/activate", authenticated(s.DeviceActivate)).Methods("POST")
+	router.HandleFunc("/api/v0/device/{uuid}/decommission", authenticated(s.DeviceDecommission)).Methods("POST")
 
-	router.HandleFunc("/api/v0/device/{deviceId}/sign", s.SignatureCreate).Methods("POST")
+	router.HandleFunc("/api/v0/device/{deviceId}/sign", authenticated(s.SignatureCreate)).Methods("POST")
+	router.HandleFunc("/api/v0/device/{deviceId}/session", authenticated(s.SigningSession)).Methods("GET")
 	// Using post as the signedData might be large
-	router.HandleFunc("/api/v0/device/{deviceId}/verify", s.SignatureVerify).Methods("POST")
+	router.HandleFunc("/api/v0/device/{deviceId}/verify", authenticated(s.SignatureVerify)).Methods("POST")
+	router.HandleFunc("/api/v0/device/{deviceId}/signatures", authenticated(s.SignatureListByDevice)).Methods("GET")
+	router.HandleFunc("/api/v0/device/{deviceId}/audit", authenticated(s.SignatureChainAudit)).Methods("GET")
+	router.HandleFunc("/api/v0/device/{deviceId}/chain", authenticated(s.SignatureChainExport)).Methods("GET")
+
+	router.HandleFunc("/api/v0/signature/{signature}", authenticated(s.SignatureGet)).Methods("GET")
+	router.HandleFunc("/api/v0/signature", authenticated(s.SignatureList)).Methods("GET")
+
+	router.HandleFunc("/api/v0/events", authenticated(s.Events)).Methods("GET")
+
+	router.HandleFunc("/api/v0/webhooks", authenticated(s.WebhookCreate)).Methods("POST")
+	router.HandleFunc("/api/v0/webhooks", authenticated(s.WebhookList)).Methods("GET")
+	// before {id}, which would match it too
+	router.HandleFunc("/api/v0/webhooks/dead-letters", authenticated(s.WebhookDeadLetters)).Methods("GET")
+	router.HandleFunc("/api/v0/webhooks/deliveries/{id}/replay", authenticated(s.WebhookReplay)).Methods("POST")
+	router.HandleFunc("/api/v0/webhooks/{id}", authenticated(s.WebhookGet)).Methods("GET")
+	router.HandleFunc("/api/v0/webhooks/{id}", authenticated(s.WebhookDelete)).Methods("DELETE")
+	router.HandleFunc("/api/v0/webhooks/{id}/deliveries", authenticated(s.WebhookDeliveries)).Methods("GET")
 
-	router.HandleFunc("/api/v0/signature/{signature}", s.SignatureGet).Methods("GET")
-	router.HandleFunc("/api/v0/signature", s.SignatureList).Methods("GET")
+	router.HandleFunc("/api/v0/admin/backup", authenticated(s.BackupExport)).Methods("GET")
+	router.HandleFunc("/api/v0/admin/restore", authenticated(s.BackupRestore)).Methods("POST")
+	router.HandleFunc("/api/v0/admin/audit", authenticated(s.AuditLogList)).Methods("GET")
+	router.HandleFunc("/api/v0/admin/audit/checkpoints", authenticated(s.AuditLogCheckpoints)).Methods("GET")
+	router.HandleFunc("/api/v0/admin/audit/verify", authenticated(s.AuditLogVerify)).Methods("GET")
+	router.HandleFunc("/api/v0/admin/keys", authenticated(s.APIKeyCreate)).Methods("POST")
+	router.HandleFunc("/api/v0/admin/keys", authenticated(s.APIKeyList)).Methods("GET")
+	router.HandleFunc("/api/v0/admin/keys/{id}", authenticated(s.APIKeyGet)).Methods("GET")
+	router.HandleFunc("/api/v0/admin/keys/{id}/revoke", authenticated(s.APIKeyRevoke)).Methods("POST")
 
 	router.HandleFunc("/api/v0/docs", s.ServeDocs).Methods("GET")
 	router.HandleFunc("/", s.ServeDocs).Methods("GET")
 
-	return http.ListenAndServe(s.listenAddress, router)
+	// unknown routes are problems too, not the plain text of net/http
+	router.NotFoundHandler = requestIDMiddleware(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		WriteNotFoundError(response)
+	}))
+	router.MethodNotAllowedHandler = requestIDMiddleware(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		WriteAppError(response, apperrors.WrapCodedError(fmt.Errorf("%s is not allowed on %s", request.Method, request.URL.Path), http.StatusMethodNotAllowed, apperrors.CodeMethodNotAllowed))
+	}))
+
+	return router
+}
+
+// Clients may send their own request ID to correlate with their logs, otherwise
+// one is generated. Either way it is sent back and recorded in the audit log.
+const requestIDHeader = "X-Request-ID"
+
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		requestID := request.Header.Get(requestIDHeader)
+		if !requestid.IsValid(requestID) {
+			requestID = requestid.New()
+		}
+
+		response.Header().Set(requestIDHeader, requestID)
+		next.ServeHTTP(response, request.WithContext(requestid.NewContext(request.Context(), requestID)))
+	})
 }
 
 func (s *Server) ServeDocs(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "static/docs/api.html")
 }
 
+// Reads the `cursor`, `limit` and `includeTotal` query parameters shared by every
+// listing endpoint. The limit is capped later on by the services.
+func parsePageRequest(request *http.Request) (persistence.PageRequest, error) {
+	values := request.URL.Query()
+	pageRequest := dto.PageRequest{Cursor: values.Get("cursor")}
+
+	if limitString := values.Get("limit"); limitString != "" {
+		limit, err := strconv.Atoi(limitString)
+		if err != nil {
+			return persistence.PageRequest{}, invalidParam("limit", "must be an integer")
+		}
+		pageRequest.Limit = limit
+	}
+
+	if includeTotalString := values.Get("includeTotal"); includeTotalString != "" {
+		includeTotal, err := strconv.ParseBool(includeTotalString)
+		if err != nil {
+			return persistence.PageRequest{}, invalidParam("includeTotal", "must be a boolean")
+		}
+		pageRequest.IncludeTotal = includeTotal
+	}
+
+	validator := validation.NewRequestValidator()
+	if err := validator.Validate(pageRequest); err != nil {
+		return persistence.PageRequest{}, validator.Error(err)
+	}
+
+	return persistence.PageRequest{
+		Cursor:       pageRequest.Cursor,
+		Limit:        pageRequest.Limit,
+		IncludeTotal: pageRequest.IncludeTotal,
+	}, nil
+}
+
 // Handy function, for example, when JSON body is not valid
-func WriteInvalidRequestBodyError(w http.ResponseWriter) {
-	WriteErrorResponse(w, http.StatusBadRequest, []string{http.StatusText(http.StatusBadRequest)})
+func WriteInvalidRequestBodyError(w http.ResponseWriter, err error) {
+	WriteAppError(w, apperrors.WrapCodedError(err, apperrors.BadRequest, apperrors.CodeInvalidBody))
+}
+
+// For the parameters checked by hand, the validator ones come from
+// RequestValidator.Error.
+func invalidParam(name string, reason string) error {
+	return apperrors.NewInvalidParamsError(apperrors.InvalidParam{Name: name, Reason: reason})
 }
 
 // WriteInternalError writes a default internal error message as an HTTP response.
 func WriteInternalError(w http.ResponseWriter) {
-	WriteAPIResponse(w, http.StatusInternalServerError, []byte(http.StatusText(http.StatusInternalServerError)))
+	WriteProblem(w, dto.NewProblem(apperrors.WrapError(errors.New(http.StatusText(http.StatusInternalServerError)), apperrors.InternalError)))
 }
 
-// WriteErrorResponse takes an HTTP status code and a slice of errors
-// and writes those as an HTTP error response in a structured format.
-func WriteErrorResponse(w http.ResponseWriter, code int, errors []string) {
-	w.WriteHeader(code)
+// Errors are RFC 7807 problems.
+const problemContentType = "application/problem+json"
 
-	errorResponse := ErrorResponse{
-		Errors: errors,
-	}
+// WriteProblem writes the problem as an HTTP error response, with the request
+// ID the middleware gave the response.
+func WriteProblem(w http.ResponseWriter, problem dto.Problem) {
+	problem.RequestId = w.Header().Get(requestIDHeader)
 
-	bytes, err := json.Marshal(errorResponse)
+	bytes, err := json.Marshal(problem)
 	if err != nil {
-		WriteInternalError(w)
+		slog.Error("could not encode the problem", "error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(problem.Status)
 	w.Write(bytes)
 }
 
 // WriteAPIResponse takes an HTTP status code and a generic data struct
 // and writes those as an HTTP response in a structured format.
 func WriteAPIResponse(w http.ResponseWriter, code int, data interface{}) {
-	w.WriteHeader(code)
-
 	response := Response{
 		Data: data,
 	}
@@ -103,37 +243,43 @@ func WriteAPIResponse(w http.ResponseWriter, code int, data interface{}) {
 	bytes, err := json.MarshalIndent(response, "", "  ")
 	if err != nil {
 		WriteInternalError(w)
+		return
 	}
 
+	w.WriteHeader(code)
 	w.Write(bytes)
 }
 
-// WriteAPIResponse takes an HTTP status code and a generic data struct
-// and writes those as an HTTP response in a structured format.
+// For the routes whose resource is missing, the services say which one when
+// they know.
 func WriteNotFoundError(w http.ResponseWriter) {
-	WriteErrorResponse(w, http.StatusNotFound, []string{http.StatusText(http.StatusNotFound)})
+	WriteAppError(w, apperrors.WrapError(errors.New("the resource does not exist"), apperrors.NotFound))
 }
 
 // The Device and Signature services might return different types of errors
 // and as those are not responsible for the transport layer, I am wrapping
 // the errors so the caller (HandlerFunc) knows what type of Status Code should
-// return.
+// return. The code and title of the error go to the client, and its message
+// too unless it is a server error.
 func WriteAppError(w http.ResponseWriter, err error) {
-	var appErr *apperrors.AppError
+	problem := newProblem(err)
 
-	statusCode := http.StatusInternalServerError
-	if errors.As(err, &appErr) {
-		statusCode = appErr.Type
+	// the client can try again later
+	var rateLimit *service.RateLimitError
+	if errors.As(err, &rateLimit) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(rateLimit.RetryAfter.Seconds())+1))
 	}
 
-	content := []string{http.StatusText(http.StatusBadRequest)}
-	if err.Error() != "" {
-		content = []string{err.Error()}
-	}
+	WriteProblem(w, problem)
+}
 
-	if statusCode == http.StatusInternalServerError {
+// The problem the error is answered with. Internal errors are logged, their
+// message is not sent.
+func newProblem(err error) dto.Problem {
+	problem := dto.NewProblem(err)
+	if problem.Status == http.StatusInternalServerError {
 		slog.Error("Unhandled internal error", "error", err.Error())
 	}
 
-	WriteErrorResponse(w, statusCode, content)
+	return problem
 }
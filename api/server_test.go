@@ -0,0 +1,553 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/api/dto"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/chuckiihub/signing-service/service"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testServer struct {
+	url  string
+	keys service.APIKeyService
+	// Secret the requests are sent with, an admin key of the default tenant
+	// unless changed with as.
+	secret string
+}
+
+// Serves the same stack of services as main, minus the audit log, the backups
+// and the webhooks.
+func newTestServer(t *testing.T) testServer {
+	tenants, err := persistence.NewVolatileTenantRepository(nil)
+	require.NoError(t, err)
+
+	devicePersistence := persistence.NewVolatileDeviceRepository()
+	signaturePersistence := persistence.NewVolatileSignatureRepository()
+	lockService := service.NewVolatileLockService(time.Second)
+	pageLimits := service.PageLimits{Default: 10, Max: 10}
+	eventBus := service.NewEventBus(16, 16)
+
+	deviceService := service.NewAuthorizedDeviceService(service.NewTenantDeviceService(service.NewDeviceService(devicePersistence, lockService, pageLimits, eventBus), tenants))
+	signatureService := service.NewAuthorizedSignatureService(service.NewIdempotentSignatureService(service.NewTenantSignatureService(service.NewSignatureService(devicePersistence, signaturePersistence, lockService, pageLimits, eventBus), tenants), signaturePersistence, time.Hour))
+	apiKeyService := service.NewAPIKeyService(persistence.NewVolatileAPIKeyRepository(), tenants, service.DefaultAccessPolicy(), pageLimits, 3, time.Minute)
+	signingSessionService := service.NewSigningSessionService(signatureService, 10, time.Minute)
+	eventService := service.NewAuthorizedEventService(service.NewEventService(eventBus, devicePersistence, signaturePersistence))
+
+	server := NewServer("", deviceService, signatureService, nil, nil, apiKeyService, signingSessionService, eventService, nil, nil)
+	httpServer := httptest.NewServer(server.NewHandler())
+	t.Cleanup(httpServer.Close)
+
+	_, secret, err := apiKeyService.Create(context.Background(), "admin", "", service.APIKeyGrant{Roles: []string{service.RoleAdmin}}, nil)
+	require.NoError(t, err)
+
+	return testServer{url: httpServer.URL, keys: apiKeyService, secret: secret}
+}
+
+// The same server, with the requests sent with another key.
+func (server testServer) as(secret string) testServer {
+	server.secret = secret
+	return server
+}
+
+// Admins can't sign, signers only sign with the devices they are given.
+func (server testServer) signerKey(t *testing.T, deviceIds ...string) string {
+	_, secret, err := server.keys.Create(context.Background(), "signer", "", service.APIKeyGrant{Roles: []string{service.RoleSigner}, Devices: deviceIds}, nil)
+	require.NoError(t, err)
+
+	return secret
+}
+
+// Sends the request with the admin key, headers are given as name and value pairs.
+func (server testServer) do(t *testing.T, method string, path string, body string, headers ...string) *http.Response {
+	request, err := http.NewRequest(method, server.url+path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	request.Header.Set("Authorization", "Bearer "+server.secret)
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	t.Cleanup(func() { response.Body.Close() })
+
+	return response
+}
+
+func (server testServer) createDevice(t *testing.T, label string) dto.DeviceResponse {
+	response := server.do(t, http.MethodPost, "/api/v0/device", `{"label":"`+label+`","algorithm":"ECC"}`)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+
+	return decodeData[dto.DeviceResponse](t, response)
+}
+
+// Signs with the key of the server, a signer of the device.
+func (server testServer) sign(t *testing.T, deviceId string, data string, headers ...string) *http.Response {
+	return server.do(t, http.MethodPost, "/api/v0/device/"+deviceId+"/sign", `{"data":"`+data+`"}`, headers...)
+}
+
+func decodeData[T any](t *testing.T, response *http.Response) T {
+	t.Helper()
+
+	var body struct {
+		Data T `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&body))
+
+	return body.Data
+}
+
+// Checks the shape every problem has and returns it.
+func decodeProblem(t *testing.T, response *http.Response, status int, code string) dto.Problem {
+	t.Helper()
+
+	assert.Equal(t, status, response.StatusCode)
+	assert.Equal(t, problemContentType, response.Header.Get("Content-Type"))
+
+	var problem dto.Problem
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&problem))
+	assert.Equal(t, status, problem.Status)
+	assert.Equal(t, code, problem.Code)
+	assert.NotEmpty(t, problem.Title)
+	assert.NotEmpty(t, problem.RequestId)
+	assert.Equal(t, response.Header.Get(requestIDHeader), problem.RequestId)
+
+	return problem
+}
+
+func invalidParamNames(problem dto.Problem) []string {
+	var names []string
+	for _, param := range problem.InvalidParams {
+		names = append(names, param.Name)
+	}
+	return names
+}
+
+func TestServer_Problems(t *testing.T) {
+	server := newTestServer(t)
+
+	t.Run("without an API key", func(t *testing.T) {
+		response, err := http.Get(server.url + "/api/v0/device")
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		decodeProblem(t, response, http.StatusUnauthorized, apperrors.CodeUnauthorized)
+		assert.Equal(t, "Bearer", response.Header.Get("WWW-Authenticate"))
+	})
+
+	t.Run("unknown route", func(t *testing.T) {
+		decodeProblem(t, server.do(t, http.MethodGet, "/api/v0/nothing", ""), http.StatusNotFound, apperrors.CodeNotFound)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		decodeProblem(t, server.do(t, http.MethodDelete, "/api/v0/device", ""), http.StatusMethodNotAllowed, apperrors.CodeMethodNotAllowed)
+	})
+
+	t.Run("unknown device", func(t *testing.T) {
+		decodeProblem(t, server.do(t, http.MethodGet, "/api/v0/device/missing", ""), http.StatusNotFound, apperrors.CodeDeviceNotFound)
+	})
+
+	t.Run("the request ID of the client is kept", func(t *testing.T) {
+		response := server.do(t, http.MethodGet, "/api/v0/nothing", "", requestIDHeader, "client-request-1")
+
+		problem := decodeProblem(t, response, http.StatusNotFound, apperrors.CodeNotFound)
+		assert.Equal(t, "client-request-1", problem.RequestId)
+	})
+}
+
+func TestServer_DeviceUpdate(t *testing.T) {
+	server := newTestServer(t)
+	device := server.createDevice(t, "till")
+	path := "/api/v0/device/" + device.Id
+
+	get := server.do(t, http.MethodGet, path, "")
+	require.Equal(t, http.StatusOK, get.StatusCode)
+	etag := get.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+
+	t.Run("without If-Match", func(t *testing.T) {
+		decodeProblem(t, server.do(t, http.MethodPatch, path, `{"label":"other"}`), http.StatusPreconditionRequired, apperrors.CodeRevisionRequired)
+	})
+
+	t.Run("with an invalid ETag", func(t *testing.T) {
+		problem := decodeProblem(t, server.do(t, http.MethodPatch, path, `{"label":"other"}`, "If-Match", "not-an-etag"), http.StatusBadRequest, apperrors.CodeInvalidParams)
+		assert.Equal(t, []string{"If-Match"}, invalidParamNames(problem))
+	})
+
+	t.Run("with fields that can't be changed", func(t *testing.T) {
+		decodeProblem(t, server.do(t, http.MethodPatch, path, `{"publicKey":"key"}`, "If-Match", etag), http.StatusBadRequest, apperrors.CodeInvalidBody)
+	})
+
+	response := server.do(t, http.MethodPatch, path, `{"label":"counter 2","tags":{"store":"berlin"}}`, "If-Match", etag)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	updated := decodeData[dto.DeviceResponse](t, response)
+	assert.Equal(t, "counter 2", updated.Label)
+	assert.Equal(t, map[string]string{"store": "berlin"}, updated.Tags)
+
+	newETag := response.Header.Get("ETag")
+	assert.NotEmpty(t, newETag)
+	assert.NotEqual(t, etag, newETag)
+	assert.Equal(t, strconv.Quote(strconv.FormatUint(updated.Revision, 10)), newETag)
+
+	t.Run("with a stale ETag", func(t *testing.T) {
+		decodeProblem(t, server.do(t, http.MethodPatch, path, `{"label":"late"}`, "If-Match", etag), http.StatusPreconditionFailed, apperrors.CodeRevisionMismatch)
+	})
+
+	t.Run("with any revision", func(t *testing.T) {
+		response := server.do(t, http.MethodPatch, path, `{"label":"whatever"}`, "If-Match", "*")
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "whatever", decodeData[dto.DeviceResponse](t, response).Label)
+		newETag = response.Header.Get("ETag")
+	})
+
+	t.Run("with a weak ETag", func(t *testing.T) {
+		response := server.do(t, http.MethodPatch, path, `{"tags":{"store":null}}`, "If-Match", "W/"+newETag)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Empty(t, decodeData[dto.DeviceResponse](t, response).Tags)
+	})
+}
+
+func TestServer_DeviceSearch(t *testing.T) {
+	server := newTestServer(t)
+	tagged := server.createDevice(t, "till 1")
+	server.createDevice(t, "till 2")
+
+	response := server.do(t, http.MethodPatch, "/api/v0/device/"+tagged.Id, `{"tags":{"store":"berlin"}}`, "If-Match", `"1"`)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	search := func(t *testing.T, query url.Values) dto.PageResponse[dto.DeviceResponse] {
+		response := server.do(t, http.MethodGet, "/api/v0/device?"+query.Encode(), "")
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		return decodeData[dto.PageResponse[dto.DeviceResponse]](t, response)
+	}
+
+	t.Run("by tag", func(t *testing.T) {
+		page := search(t, url.Values{"tag": {"store:berlin"}})
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, tagged.Id, page.Items[0].Id)
+	})
+
+	t.Run("sorted", func(t *testing.T) {
+		page := search(t, url.Values{"sort": {"label"}, "order": {"desc"}, "counterFrom": {"0"}, "createdFrom": {"2000-01-01T00:00:00Z"}})
+		require.Len(t, page.Items, 2)
+		assert.Equal(t, "till 2", page.Items[0].Label)
+		assert.Equal(t, "till 1", page.Items[1].Label)
+	})
+
+	t.Run("sorted by counter", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, server.as(server.signerKey(t, tagged.Id)).sign(t, tagged.Id, "receipt").StatusCode)
+
+		page := search(t, url.Values{"sort": {"counter"}, "order": {"desc"}})
+		require.Len(t, page.Items, 2)
+		assert.Equal(t, tagged.Id, page.Items[0].Id)
+		assert.Equal(t, 1, page.Items[0].SignatureCounter)
+		assert.Equal(t, 0, page.Items[1].SignatureCounter)
+		assert.False(t, page.Items[0].CreatedAt.IsZero())
+	})
+
+	for name, test := range map[string]struct {
+		query url.Values
+		param string
+	}{
+		"counter not a number": {url.Values{"counterFrom": {"one"}}, "counterFrom"},
+		"negative counter":     {url.Values{"counterTo": {"-1"}}, "counterTo"},
+		"date not RFC3339":     {url.Values{"createdFrom": {"2024-01-01"}}, "createdFrom"},
+		"unknown state":        {url.Values{"state": {"sleeping"}}, "state"},
+		"unknown sort":         {url.Values{"sort": {"color"}}, "sort"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			problem := decodeProblem(t, server.do(t, http.MethodGet, "/api/v0/device?"+test.query.Encode(), ""), http.StatusBadRequest, apperrors.CodeInvalidParams)
+			require.Len(t, problem.InvalidParams, 1)
+			assert.Contains(t, strings.ToLower(problem.InvalidParams[0].Name), strings.ToLower(test.param))
+		})
+	}
+}
+
+func TestServer_SignatureCreateIdempotencyKey(t *testing.T) {
+	server := newTestServer(t)
+	device := server.createDevice(t, "till")
+	server = server.as(server.signerKey(t, device.Id))
+
+	t.Run("invalid key", func(t *testing.T) {
+		problem := decodeProblem(t, server.sign(t, device.Id, "receipt", IdempotencyKeyHeader, strings.Repeat("k", 256)), http.StatusBadRequest, apperrors.CodeInvalidParams)
+		assert.Equal(t, []string{IdempotencyKeyHeader}, invalidParamNames(problem))
+	})
+
+	first := server.sign(t, device.Id, "receipt", IdempotencyKeyHeader, "receipt-1")
+	require.Equal(t, http.StatusCreated, first.StatusCode)
+	signature := decodeData[dto.SignatureResponse](t, first)
+
+	retry := server.sign(t, device.Id, "receipt", IdempotencyKeyHeader, "receipt-1")
+	require.Equal(t, http.StatusCreated, retry.StatusCode)
+	retried := decodeData[dto.SignatureResponse](t, retry)
+	assert.Equal(t, signature.Id, retried.Id)
+	assert.Equal(t, 1, retried.Counter)
+
+	decodeProblem(t, server.sign(t, device.Id, "other receipt", IdempotencyKeyHeader, "receipt-1"), http.StatusUnprocessableEntity, apperrors.CodeIdempotencyKeyReused)
+
+	// without a key every request signs
+	other := server.sign(t, device.Id, "receipt")
+	require.Equal(t, http.StatusCreated, other.StatusCode)
+	assert.Equal(t, 2, decodeData[dto.SignatureResponse](t, other).Counter)
+}
+
+func TestServer_SignatureVerify(t *testing.T) {
+	server := newTestServer(t)
+	device := server.createDevice(t, "till")
+
+	response := server.as(server.signerKey(t, device.Id)).sign(t, device.Id, "receipt")
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	signature := decodeData[dto.SignatureResponse](t, response)
+
+	verify := func(server testServer, deviceId string, signedData string) *http.Response {
+		body, err := json.Marshal(dto.SignatureVerifyRequest{SignedData: signedData, Signature: signature.Signature})
+		require.NoError(t, err)
+		return server.do(t, http.MethodPost, "/api/v0/device/"+deviceId+"/verify", string(body))
+	}
+
+	valid := verify(server, device.Id, signature.SignedData)
+	require.Equal(t, http.StatusOK, valid.StatusCode)
+	assert.Equal(t, "valid", decodeData[string](t, valid))
+
+	invalid := verify(server, device.Id, "other data")
+	require.Equal(t, http.StatusTeapot, invalid.StatusCode)
+	assert.Equal(t, "invalid", decodeData[string](t, invalid))
+
+	t.Run("unknown device", func(t *testing.T) {
+		decodeProblem(t, verify(server, "missing", signature.SignedData), http.StatusNotFound, apperrors.CodeDeviceNotFound)
+	})
+
+	t.Run("without the verify scope", func(t *testing.T) {
+		_, secret, err := server.keys.Create(context.Background(), "viewer", "", service.APIKeyGrant{Roles: []string{service.RoleViewer}}, nil)
+		require.NoError(t, err)
+
+		decodeProblem(t, verify(server.as(secret), device.Id, signature.SignedData), http.StatusForbidden, apperrors.CodeMissingScope)
+	})
+}
+
+type sentEvent struct {
+	id        string
+	eventType string
+	data      dto.EventResponse
+}
+
+// Opens the event stream, it is closed with the test.
+func (server testServer) events(t *testing.T, query string, headers ...string) (*http.Response, *bufio.Reader) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.url+"/api/v0/events?"+query, nil)
+	require.NoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+server.secret)
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	t.Cleanup(func() { response.Body.Close() })
+
+	return response, bufio.NewReader(response.Body)
+}
+
+// Reads up to the blank line ending an event, skipping the heartbeats.
+func readEvent(t *testing.T, reader *bufio.Reader) sentEvent {
+	t.Helper()
+
+	var event sentEvent
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "" && event.id != "":
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.data))
+		}
+	}
+}
+
+func TestServer_Events(t *testing.T) {
+	server := newTestServer(t)
+
+	response, reader := server.events(t, "")
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", response.Header.Get("Cache-Control"))
+	assert.Equal(t, "no", response.Header.Get("X-Accel-Buffering"))
+
+	device := server.createDevice(t, "till")
+	signer := server.as(server.signerKey(t, device.Id))
+	require.Equal(t, http.StatusCreated, signer.sign(t, device.Id, "receipt 1").StatusCode)
+
+	created := readEvent(t, reader)
+	assert.Equal(t, "device.created", created.eventType)
+	assert.Equal(t, created.id, created.data.Id)
+	assert.Equal(t, device.Id, created.data.DeviceId)
+
+	signed := readEvent(t, reader)
+	assert.Equal(t, "signature.created", signed.eventType)
+	require.NotNil(t, signed.data.Signature)
+	assert.Equal(t, 1, signed.data.Signature.Counter)
+	response.Body.Close()
+
+	// signed while nobody listens
+	var missed []string
+	for _, data := range []string{"receipt 2", "receipt 3"} {
+		response := signer.sign(t, device.Id, data)
+		require.Equal(t, http.StatusCreated, response.StatusCode)
+		missed = append(missed, decodeData[dto.SignatureResponse](t, response).Id)
+	}
+
+	resume := func(t *testing.T, query string, headers ...string) {
+		response, reader := server.events(t, query, headers...)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		// events around the last one may come again, but only once each
+		seen := map[string]bool{}
+		var resumed []string
+		for len(resumed) < len(missed) {
+			event := readEvent(t, reader)
+			require.False(t, seen[event.id], "event %s sent twice", event.id)
+			seen[event.id] = true
+
+			assert.Equal(t, "signature.created", event.eventType)
+			if event.id != signed.id {
+				resumed = append(resumed, event.data.Signature.Id)
+			}
+		}
+		assert.Equal(t, missed, resumed)
+	}
+
+	t.Run("with the Last-Event-ID header", func(t *testing.T) {
+		resume(t, "type=signature.created", "Last-Event-ID", signed.id)
+	})
+
+	t.Run("with the lastEventId parameter", func(t *testing.T) {
+		resume(t, url.Values{"type": {"signature.created"}, "deviceId": {device.Id}, "lastEventId": {signed.id}}.Encode())
+	})
+
+	t.Run("with an invalid event ID", func(t *testing.T) {
+		response, _ := server.events(t, "", "Last-Event-ID", "not-an-id")
+		decodeProblem(t, response, http.StatusBadRequest, apperrors.CodeBadRequest)
+	})
+
+	t.Run("with an unknown type", func(t *testing.T) {
+		response, _ := server.events(t, "type=device.painted")
+		decodeProblem(t, response, http.StatusBadRequest, apperrors.CodeBadRequest)
+	})
+}
+
+// Dials the signing session of the device with the key of the server.
+func (server testServer) dialSession(t *testing.T, deviceId string, query string) (*websocket.Conn, *http.Response, error) {
+	sessionURL := "ws" + strings.TrimPrefix(server.url, "http") + "/api/v0/device/" + deviceId + "/session?" + query
+	connection, response, err := websocket.DefaultDialer.Dial(sessionURL, http.Header{"Authorization": {"Bearer " + server.secret}})
+	if connection != nil {
+		t.Cleanup(func() { connection.Close() })
+		connection.SetReadDeadline(time.Now().Add(10 * time.Second))
+	}
+
+	return connection, response, err
+}
+
+func readSessionMessage(t *testing.T, connection *websocket.Conn) dto.SigningSessionMessage {
+	t.Helper()
+
+	var message dto.SigningSessionMessage
+	require.NoError(t, connection.ReadJSON(&message))
+	return message
+}
+
+func TestServer_SigningSession(t *testing.T) {
+	server := newTestServer(t)
+	device := server.createDevice(t, "till")
+	other := server.createDevice(t, "other till")
+	server = server.as(server.signerKey(t, device.Id))
+
+	connection, _, err := server.dialSession(t, device.Id, "")
+	require.NoError(t, err)
+
+	ready := readSessionMessage(t, connection)
+	assert.Equal(t, "ready", ready.Type)
+	assert.Equal(t, device.Id, ready.DeviceId)
+
+	for i, data := range []string{"receipt 1", "receipt 2"} {
+		require.NoError(t, connection.WriteJSON(dto.SigningSessionRequest{Type: "sign", Id: data, Data: data}))
+
+		message := readSessionMessage(t, connection)
+		assert.Equal(t, "signature", message.Type)
+		assert.Equal(t, data, message.Id)
+		require.NotNil(t, message.Signature)
+		assert.Equal(t, i+1, message.Signature.Counter)
+		assert.False(t, message.Resent)
+	}
+
+	// acks are not answered, the next reply is the error
+	require.NoError(t, connection.WriteJSON(dto.SigningSessionRequest{Type: "ack", Counter: 1}))
+
+	require.NoError(t, connection.WriteMessage(websocket.TextMessage, []byte("not json")))
+	message := readSessionMessage(t, connection)
+	assert.Equal(t, "error", message.Type)
+	require.NotNil(t, message.Error)
+	assert.Equal(t, http.StatusBadRequest, message.Error.Status)
+	assert.Equal(t, apperrors.CodeInvalidBody, message.Error.Code)
+	assert.NotEmpty(t, message.Error.RequestId)
+
+	require.NoError(t, connection.WriteJSON(dto.SigningSessionRequest{Type: "sign", Id: "empty"}))
+	message = readSessionMessage(t, connection)
+	assert.Equal(t, "error", message.Type)
+	assert.Equal(t, "empty", message.Id)
+	require.NotNil(t, message.Error)
+	assert.Equal(t, apperrors.CodeInvalidParams, message.Error.Code)
+	connection.Close()
+
+	t.Run("resumed", func(t *testing.T) {
+		connection, _, err := server.dialSession(t, device.Id, "lastAckedCounter=0")
+		require.NoError(t, err)
+		assert.Equal(t, "ready", readSessionMessage(t, connection).Type)
+
+		// the first one was acknowledged before
+		resent := readSessionMessage(t, connection)
+		assert.Equal(t, "signature", resent.Type)
+		assert.True(t, resent.Resent)
+		require.NotNil(t, resent.Signature)
+		assert.Equal(t, 2, resent.Signature.Counter)
+	})
+
+	t.Run("with an invalid lastAckedCounter", func(t *testing.T) {
+		_, response, err := server.dialSession(t, device.Id, "lastAckedCounter=-1")
+		require.ErrorIs(t, err, websocket.ErrBadHandshake)
+		defer response.Body.Close()
+
+		problem := decodeProblem(t, response, http.StatusBadRequest, apperrors.CodeInvalidParams)
+		assert.Equal(t, []string{"lastAckedCounter"}, invalidParamNames(problem))
+	})
+
+	t.Run("with a device of somebody else", func(t *testing.T) {
+		_, response, err := server.dialSession(t, other.Id, "")
+		require.ErrorIs(t, err, websocket.ErrBadHandshake)
+		defer response.Body.Close()
+
+		decodeProblem(t, response, http.StatusForbidden, apperrors.CodeDeviceNotAssigned)
+	})
+}
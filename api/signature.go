@@ -1,13 +1,18 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
-	"log/slog"
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/chuckiihub/signing-service/api/dto"
 	"github.com/chuckiihub/signing-service/api/validation"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/service"
 	"github.com/gorilla/mux"
 )
 
@@ -26,17 +31,26 @@ func (context *Server) SignatureCreate(response http.ResponseWriter, request *ht
 
 	err := json.NewDecoder(request.Body).Decode(&creationRequest)
 	if err != nil {
-		WriteAPIResponse(response, http.StatusBadRequest, err.Error())
+		WriteInvalidRequestBodyError(response, err)
 		return
 	}
 
 	validator := validation.NewRequestValidator()
 	if err := validator.Validate(creationRequest); err != nil {
-		WriteErrorResponse(response, http.StatusBadRequest, validator.GetValidationFailureErrors(err))
+		WriteAppError(response, validator.Error(err))
 		return
 	}
 
-	signature, err := context.signatureService.Sign(deviceId, creationRequest.Data)
+	ctx := request.Context()
+	if idempotencyKey := request.Header.Get(IdempotencyKeyHeader); idempotencyKey != "" {
+		if !service.ValidIdempotencyKey(idempotencyKey) {
+			WriteAppError(response, invalidParam(IdempotencyKeyHeader, "must be 1 to 255 printable ASCII characters"))
+			return
+		}
+		ctx = service.WithIdempotencyKey(ctx, idempotencyKey)
+	}
+
+	signature, err := context.signatureService.Sign(ctx, deviceId, creationRequest.Data, creationRequest.Metadata)
 	if err != nil {
 		WriteAppError(response, err)
 		return
@@ -47,25 +61,27 @@ func (context *Server) SignatureCreate(response http.ResponseWriter, request *ht
 	WriteAPIResponse(response, http.StatusCreated, signatureResponse)
 }
 
+// Retries of a signature request with the same key get the first signature
+// back instead of a new one.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 func (context *Server) SignatureGet(response http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	signatureString := vars["signature"]
 
 	if signatureString == "" {
-		WriteErrorResponse(response, http.StatusBadRequest, []string{
-			"Signature is required",
-		})
+		WriteAppError(response, invalidParam("signature", "is required"))
 		return
 	}
 
-	signature, err := context.signatureService.Get(signatureString)
+	signature, err := context.signatureService.Get(request.Context(), signatureString)
 	if err != nil {
 		WriteAppError(response, err)
 		return
 	}
 
 	if signature == nil {
-		WriteNotFoundError(response)
+		WriteAppError(response, apperrors.WrapCodedError(errors.New("signature not found"), apperrors.NotFound, apperrors.CodeSignatureNotFound))
 		return
 	}
 
@@ -86,19 +102,21 @@ func (context *Server) SignatureVerify(response http.ResponseWriter, request *ht
 	var verifyRequest dto.SignatureVerifyRequest
 	err := json.NewDecoder(request.Body).Decode(&verifyRequest)
 	if err != nil {
-		WriteAPIResponse(response, http.StatusBadRequest, err.Error())
+		WriteInvalidRequestBodyError(response, err)
 		return
 	}
 
 	validator := validation.NewRequestValidator()
 	if err := validator.Validate(verifyRequest); err != nil {
-		WriteErrorResponse(response, http.StatusBadRequest, validator.GetValidationFailureErrors(err))
+		WriteAppError(response, validator.Error(err))
 		return
 	}
 
-	verified, err := context.signatureService.Verify(deviceId, verifyRequest.SignedData, verifyRequest.Signature)
+	// only a signature that does not match is invalid, a missing device or
+	// scope are errors like anywhere else
+	verified, err := context.signatureService.Verify(request.Context(), deviceId, verifyRequest.SignedData, verifyRequest.Signature)
 	if err != nil {
-		WriteAPIResponse(response, http.StatusTeapot, "invalid")
+		WriteAppError(response, err)
 		return
 	}
 
@@ -113,24 +131,132 @@ func (context *Server) SignatureVerify(response http.ResponseWriter, request *ht
 
 // List services.
 func (context *Server) SignatureList(response http.ResponseWriter, request *http.Request) {
-	pageString := request.URL.Query().Get("page")
-	page, err := strconv.Atoi(pageString)
+	pageRequest, err := parsePageRequest(request)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	signatures, err := context.signatureService.List(request.Context(), pageRequest)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewPageResponse(signatures, dto.NewSignatureResponseFromSignature))
+}
+
+// Lists the signatures of a device. Supports ordering by counter (`order=asc|desc`),
+// counter ranges (`counterFrom`, `counterTo`) and time ranges (`from`, `to` as RFC3339).
+func (context *Server) SignatureListByDevice(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	deviceId := vars["deviceId"]
+
+	if deviceId == "" {
+		WriteNotFoundError(response)
+		return
+	}
+
+	listRequest, err := parseDeviceSignaturesRequest(request.URL.Query())
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	validator := validation.NewRequestValidator()
+	if err := validator.Validate(listRequest); err != nil {
+		WriteAppError(response, validator.Error(err))
+		return
+	}
+
+	pageRequest, err := parsePageRequest(request)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	signatures, err := context.signatureService.ListByDevice(request.Context(), deviceId, dto.NewSignatureQuery(listRequest), pageRequest)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewPageResponse(signatures, dto.NewSignatureResponseFromSignature))
+}
+
+// Re-verifies the whole chain of a device: every signature, the counter and the
+// previous signature each one embeds. A broken chain is still a 200, the
+// report says where it breaks.
+func (context *Server) SignatureChainAudit(response http.ResponseWriter, request *http.Request) {
+	deviceId := mux.Vars(request)["deviceId"]
 
-	if err != nil || page < 1 || pageString == "" {
-		page = 1
+	if deviceId == "" {
+		WriteNotFoundError(response)
+		return
 	}
 
-	signatures, err := context.signatureService.List(page)
+	audit, err := context.signatureService.Audit(request.Context(), deviceId)
 	if err != nil {
-		slog.Error("could not list signatures", "error", err.Error())
-		WriteInternalError(response)
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, audit)
+}
+
+// Downloads the public key and the whole chain of a device as JSON lines, the
+// input of the offline verifier (cmd/verify).
+func (context *Server) SignatureChainExport(response http.ResponseWriter, request *http.Request) {
+	deviceId := mux.Vars(request)["deviceId"]
+
+	if deviceId == "" {
+		WriteNotFoundError(response)
+		return
+	}
+
+	// Built in memory first, like the backups, so a failure is still a proper
+	// error and not a truncated chain that would look broken to the auditor.
+	var export bytes.Buffer
+	if err := context.signatureService.ExportChain(request.Context(), deviceId, &export); err != nil {
+		WriteAppError(response, err)
 		return
 	}
 
-	signaturesResponse := make([]dto.SignatureResponse, 0)
-	for _, signature := range signatures {
-		signaturesResponse = append(signaturesResponse, *dto.NewSignatureResponseFromSignature(&signature))
+	response.Header().Set("Content-Type", "application/x-ndjson")
+	response.Header().Set("Content-Disposition", `attachment; filename="`+deviceId+`.chain.jsonl"`)
+	response.WriteHeader(http.StatusOK)
+	response.Write(export.Bytes())
+}
+
+// The query string values are strings, so the numbers and dates are parsed here
+// and the validator takes care of the rest.
+func parseDeviceSignaturesRequest(values url.Values) (dto.DeviceSignaturesRequest, error) {
+	listRequest := dto.DeviceSignaturesRequest{Order: values.Get("order")}
+
+	var err error
+	if value := values.Get("counterFrom"); value != "" {
+		if listRequest.CounterFrom, err = strconv.Atoi(value); err != nil {
+			return listRequest, invalidParam("counterFrom", "must be an integer")
+		}
+	}
+
+	if value := values.Get("counterTo"); value != "" {
+		if listRequest.CounterTo, err = strconv.Atoi(value); err != nil {
+			return listRequest, invalidParam("counterTo", "must be an integer")
+		}
+	}
+
+	if value := values.Get("from"); value != "" {
+		if listRequest.From, err = time.Parse(time.RFC3339, value); err != nil {
+			return listRequest, invalidParam("from", "must be a RFC3339 date")
+		}
+	}
+
+	if value := values.Get("to"); value != "" {
+		if listRequest.To, err = time.Parse(time.RFC3339, value); err != nil {
+			return listRequest, invalidParam("to", "must be a RFC3339 date")
+		}
 	}
 
-	WriteAPIResponse(response, http.StatusOK, signaturesResponse)
+	return listRequest, nil
 }
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chuckiihub/signing-service/api/dto"
+	"github.com/chuckiihub/signing-service/api/validation"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/requestid"
+	"github.com/chuckiihub/signing-service/service"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// The server pings this often and closes the connection when the pong
+	// does not come back in time.
+	signingSessionPingPeriod = 30 * time.Second
+	signingSessionPongWait   = 60 * time.Second
+	signingSessionWriteWait  = 10 * time.Second
+	signingSessionMaxMessage = 64 * 1024
+	// Requests read but not signed yet. When it is full the server stops
+	// reading, so a client sending faster than the device signs is slowed
+	// down by the connection itself.
+	signingSessionQueueSize = 16
+)
+
+var signingSessionUpgrader = websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+// Work for the writer of a session: a request to sign, or a message ready to be sent.
+type signingSessionJob struct {
+	request dto.SigningSessionRequest
+	reply   *dto.SigningSessionMessage
+}
+
+// Opens a WebSocket signing with one device. Requests are signed in the order
+// they come and answered in that order. Reconnecting with `lastAckedCounter`
+// resumes the session, the signatures after that counter are sent again.
+func (context *Server) SigningSession(response http.ResponseWriter, request *http.Request) {
+	deviceId := mux.Vars(request)["deviceId"]
+
+	lastAcked := 0
+	if value := request.URL.Query().Get("lastAckedCounter"); value != "" {
+		counter, err := strconv.Atoi(value)
+		if err != nil || counter < 0 {
+			WriteAppError(response, invalidParam("lastAckedCounter", "must be a positive integer"))
+			return
+		}
+		lastAcked = counter
+	}
+
+	// before upgrading, so clients that can't sign get a proper HTTP error
+	session, unacknowledged, err := context.signingSessionService.Open(request.Context(), deviceId, lastAcked)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+	defer session.Close()
+
+	connection, err := signingSessionUpgrader.Upgrade(response, request, nil)
+	if err != nil {
+		// the upgrader already answered
+		return
+	}
+	defer connection.Close()
+
+	ctx, cancel := contextWithCancel(request)
+	defer cancel()
+
+	jobs := make(chan signingSessionJob, signingSessionQueueSize)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		defer cancel()
+		// unblocks the reader when the writer gives up first
+		defer connection.Close()
+		writeSigningSession(ctx, connection, session, jobs, unacknowledged)
+	}()
+
+	readSigningSession(ctx, connection, session, jobs)
+
+	// requests still queued are not signed, resuming tells the client which were
+	cancel()
+	<-written
+}
+
+// The handlers name their receiver context, which hides the package.
+func contextWithCancel(request *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithCancel(request.Context())
+}
+
+// Reads until the connection breaks or the writer gives up. Acks are applied
+// right away, everything else goes through the writer to keep the order.
+func readSigningSession(ctx context.Context, connection *websocket.Conn, session *service.SigningSession, jobs chan<- signingSessionJob) {
+	connection.SetReadLimit(signingSessionMaxMessage)
+	connection.SetReadDeadline(time.Now().Add(signingSessionPongWait))
+	connection.SetPongHandler(func(string) error {
+		return connection.SetReadDeadline(time.Now().Add(signingSessionPongWait))
+	})
+
+	validator := validation.NewRequestValidator()
+	for {
+		messageType, message, err := connection.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Debug("signing session closed", "deviceId", session.DeviceId, "error", err.Error())
+			}
+			return
+		}
+		connection.SetReadDeadline(time.Now().Add(signingSessionPongWait))
+
+		var job signingSessionJob
+		if messageType != websocket.TextMessage || json.Unmarshal(message, &job.request) != nil {
+			job.reply = signingSessionError(ctx, "", apperrors.WrapCodedError(errors.New("messages must be JSON text"), apperrors.BadRequest, apperrors.CodeInvalidBody))
+		} else if err := validator.Validate(job.request); err != nil {
+			job.reply = signingSessionError(ctx, job.request.Id, validator.Error(err))
+		} else if job.request.Type == "ack" {
+			session.Ack(job.request.Counter)
+			continue
+		}
+
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// The only goroutine writing to the connection, apart from the pings which
+// gorilla allows to send concurrently.
+func writeSigningSession(ctx context.Context, connection *websocket.Conn, session *service.SigningSession, jobs <-chan signingSessionJob, unacknowledged []domain.Signature) {
+	write := func(message *dto.SigningSessionMessage) bool {
+		connection.SetWriteDeadline(time.Now().Add(signingSessionWriteWait))
+		if err := connection.WriteJSON(message); err != nil {
+			slog.Debug("could not write to the signing session", "deviceId", session.DeviceId, "error", err.Error())
+			return false
+		}
+		return true
+	}
+
+	if !write(&dto.SigningSessionMessage{Type: "ready", DeviceId: session.DeviceId}) {
+		return
+	}
+
+	for i := range unacknowledged {
+		if !write(&dto.SigningSessionMessage{Type: "signature", Signature: dto.NewSignatureResponseFromSignature(&unacknowledged[i]), Resent: true}) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(signingSessionPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			connection.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(signingSessionWriteWait))
+			return
+
+		case <-ticker.C:
+			if err := connection.WriteControl(websocket.PingMessage, nil, time.Now().Add(signingSessionWriteWait)); err != nil {
+				return
+			}
+
+		case job := <-jobs:
+			reply := job.reply
+			if reply == nil {
+				signature, err := session.Sign(ctx, job.request.Data, job.request.Metadata)
+				if err != nil {
+					reply = signingSessionError(ctx, job.request.Id, err)
+				} else {
+					reply = &dto.SigningSessionMessage{Type: "signature", Id: job.request.Id, Signature: dto.NewSignatureResponseFromSignature(signature)}
+				}
+			}
+
+			if !write(reply) {
+				return
+			}
+		}
+	}
+}
+
+// Failed requests are answered with the same problem the HTTP API would answer.
+func signingSessionError(ctx context.Context, id string, err error) *dto.SigningSessionMessage {
+	problem := newProblem(err)
+	problem.RequestId = requestid.FromContext(ctx)
+
+	return &dto.SigningSessionMessage{Type: "error", Id: id, Error: &problem}
+}
@@ -1,9 +1,12 @@
 package validation
 
 import (
+	"reflect"
 	"slices"
+	"strings"
 
 	"github.com/chuckiihub/signing-service/crypto"
+	apperrors "github.com/chuckiihub/signing-service/errors"
 	"github.com/go-playground/validator/v10"
 )
 
@@ -13,18 +16,27 @@ type RequestValidator struct {
 	validator *validator.Validate
 }
 
-func (validation *RequestValidator) GetValidationFailureErrors(err error) []string {
-	var errors []string
-	if validationErrors, ok := err.(validator.ValidationErrors); ok {
-		for _, validationErr := range validationErrors {
-			errors = append(errors, validationErr.Field()+" failed the following validation: "+validationErr.Tag())
+// The fields that failed, named as in the JSON of the request.
+func (validation *RequestValidator) GetInvalidParams(err error) []apperrors.InvalidParam {
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return []apperrors.InvalidParam{{Name: "body", Reason: err.Error()}}
+	}
 
-		}
-	} else {
-		errors = append(errors, err.Error())
+	params := make([]apperrors.InvalidParam, 0, len(validationErrors))
+	for _, validationErr := range validationErrors {
+		params = append(params, apperrors.InvalidParam{
+			Name:   paramName(validationErr),
+			Reason: failureReason(validationErr),
+		})
 	}
 
-	return errors
+	return params
+}
+
+// The 400 answered for a request that failed validation.
+func (validation *RequestValidator) Error(err error) error {
+	return apperrors.NewInvalidParamsError(validation.GetInvalidParams(err)...)
 }
 
 func (validation *RequestValidator) Validate(request interface{}) error {
@@ -34,10 +46,62 @@ func (validation *RequestValidator) Validate(request interface{}) error {
 func NewRequestValidator() RequestValidator {
 	validate = validator.New()
 	validate.RegisterValidation("supported-encryption", validateSignatureAlgorithm)
+	validate.RegisterTagNameFunc(jsonName)
 
 	return RequestValidator{validator: validate}
 }
 
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+
+	return name
+}
+
+// The namespace without the name of the request struct, so nested fields
+// read as `metadata[register]` or `tags[0]`.
+func paramName(validationErr validator.FieldError) string {
+	_, name, found := strings.Cut(validationErr.Namespace(), ".")
+	if !found {
+		return validationErr.Field()
+	}
+
+	return name
+}
+
+func failureReason(validationErr validator.FieldError) string {
+	param := validationErr.Param()
+
+	switch validationErr.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return "must be one of: " + strings.Join(strings.Fields(param), ", ")
+	case "supported-encryption":
+		return "must be one of: " + strings.Join(crypto.GetSupportedAlgorithms(), ", ")
+	case "min", "gte":
+		return "must be at least " + param + lengthUnit(validationErr)
+	case "max", "lte":
+		return "must be at most " + param + lengthUnit(validationErr)
+	}
+
+	return "failed the following validation: " + validationErr.Tag()
+}
+
+// min and max count characters or items unless the field is a number.
+func lengthUnit(validationErr validator.FieldError) string {
+	switch validationErr.Kind() {
+	case reflect.String:
+		return " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return " items"
+	}
+
+	return ""
+}
+
 // Validates automatically that the string is one of SignatureAlgorithm enum
 func validateSignatureAlgorithm(fieldLevel validator.FieldLevel) bool {
 	algorithm := fieldLevel.Field().String()
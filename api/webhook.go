@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/chuckiihub/signing-service/api/dto"
+	"github.com/chuckiihub/signing-service/api/validation"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/service"
+	"github.com/gorilla/mux"
+)
+
+// Registers a webhook endpoint, the response is the only time its secret is shown.
+func (context *Server) WebhookCreate(response http.ResponseWriter, request *http.Request) {
+	var createRequest dto.WebhookCreateRequest
+	if err := json.NewDecoder(request.Body).Decode(&createRequest); err != nil {
+		WriteInvalidRequestBodyError(response, err)
+		return
+	}
+
+	validator := validation.NewRequestValidator()
+	if err := validator.Validate(createRequest); err != nil {
+		WriteAppError(response, validator.Error(err))
+		return
+	}
+
+	types := make([]domain.EventType, 0, len(createRequest.Types))
+	for _, eventType := range createRequest.Types {
+		types = append(types, domain.EventType(eventType))
+	}
+
+	endpoint, err := context.webhookService.Create(request.Context(), createRequest.Url, types, createRequest.Description)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusCreated, dto.WebhookCreatedResponse{
+		WebhookResponse: dto.NewWebhookResponse(endpoint),
+		Secret:          endpoint.Secret,
+	})
+}
+
+func (context *Server) WebhookList(response http.ResponseWriter, request *http.Request) {
+	pageRequest, err := parsePageRequest(request)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	endpoints, err := context.webhookService.List(request.Context(), pageRequest)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewPageResponse(endpoints, dto.NewWebhookResponse))
+}
+
+func (context *Server) WebhookGet(response http.ResponseWriter, request *http.Request) {
+	endpoint, err := context.webhookService.Get(request.Context(), mux.Vars(request)["id"])
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	if endpoint == nil {
+		WriteAppError(response, apperrors.WrapCodedError(service.ErrWebhookEndpointNotFound, apperrors.NotFound, apperrors.CodeWebhookNotFound))
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewWebhookResponse(endpoint))
+}
+
+func (context *Server) WebhookDelete(response http.ResponseWriter, request *http.Request) {
+	endpoint, err := context.webhookService.Delete(request.Context(), mux.Vars(request)["id"])
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewWebhookResponse(endpoint))
+}
+
+// The deliveries of an endpoint, oldest first, with every attempt.
+func (context *Server) WebhookDeliveries(response http.ResponseWriter, request *http.Request) {
+	pageRequest, err := parsePageRequest(request)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	deliveries, err := context.webhookService.Deliveries(request.Context(), mux.Vars(request)["id"], pageRequest)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewPageResponse(deliveries, dto.NewWebhookDeliveryResponse))
+}
+
+func (context *Server) WebhookDeadLetters(response http.ResponseWriter, request *http.Request) {
+	pageRequest, err := parsePageRequest(request)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	deliveries, err := context.webhookService.DeadLetters(request.Context(), pageRequest)
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, dto.NewPageResponse(deliveries, dto.NewWebhookDeliveryResponse))
+}
+
+// Sends a delivery again as a new one, which is returned.
+func (context *Server) WebhookReplay(response http.ResponseWriter, request *http.Request) {
+	delivery, err := context.webhookService.Replay(request.Context(), mux.Vars(request)["id"])
+	if err != nil {
+		WriteAppError(response, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusAccepted, dto.NewWebhookDeliveryResponse(delivery))
+}
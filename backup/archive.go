@@ -0,0 +1,384 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/chuckiihub/signing-service/chain"
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+// A backup is gzipped JSON lines, so it is written and read as a stream and
+// neither side has to hold the whole state: a header, then every device
+// followed by its signatures in counter order, and a trailer with the counts
+// and the checksum of every line before it.
+//
+// The checksum only catches corruption, whoever changes an archive can
+// compute it again. Encrypted archives are authenticated with an HMAC keyed
+// from the passphrase as well, and the chains are verified with the public
+// keys of the devices either way.
+const (
+	Format         = "signing-service-backup"
+	CurrentVersion = 1
+)
+
+var (
+	ErrUnsupportedArchive = errors.New("not a supported backup archive")
+	ErrChecksumMismatch   = errors.New("backup checksum does not match its content")
+	ErrIncompleteArchive  = errors.New("the backup archive is incomplete")
+	ErrNotAuthentic       = errors.New("backup was not written with this passphrase or was changed since")
+	ErrNotEncrypted       = errors.New("backup is not encrypted but a passphrase was given, it was exported without one or the encryption was stripped")
+	ErrBrokenChain        = errors.New("a signature chain of the backup is broken")
+)
+
+// The first line. When Encryption is set the private keys of the devices are
+// encrypted with a key derived from a passphrase.
+type Header struct {
+	Format     string         `json:"format"`
+	Version    int            `json:"version"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	Encryption *KeyEncryption `json:"encryption,omitempty"`
+}
+
+// Every line after the header has one of the fields, End being the last one.
+type Entry struct {
+	Device    *domain.Device    `json:"device,omitempty"`
+	Signature *domain.Signature `json:"signature,omitempty"`
+	End       *Trailer          `json:"end,omitempty"`
+}
+
+type Trailer struct {
+	Devices    int `json:"devices"`
+	Signatures int `json:"signatures"`
+	// Hex SHA-256 of the lines before the trailer, newlines included.
+	Checksum string `json:"checksum"`
+	// Hex HMAC-SHA256 of the same lines, only in encrypted archives.
+	MAC string `json:"mac,omitempty"`
+}
+
+type Writer struct {
+	gzip    *gzip.Writer
+	encoder *json.Encoder
+	digest  hash.Hash
+	mac     hash.Hash
+	sealer  *KeySealer
+	trailer Trailer
+}
+
+// NewWriter writes the header right away. With a sealer the private keys of
+// the devices are encrypted with it, otherwise they are written as they are.
+func NewWriter(w io.Writer, createdAt time.Time, sealer *KeySealer) (*Writer, error) {
+	writer := &Writer{gzip: gzip.NewWriter(w), digest: sha256.New(), sealer: sealer}
+	header := Header{Format: Format, Version: CurrentVersion, CreatedAt: createdAt}
+
+	if sealer != nil {
+		header.Encryption = sealer.Params()
+		writer.mac = sealer.mac()
+		writer.encoder = json.NewEncoder(io.MultiWriter(writer.gzip, writer.digest, writer.mac))
+	} else {
+		writer.encoder = json.NewEncoder(io.MultiWriter(writer.gzip, writer.digest))
+	}
+
+	if err := writer.encoder.Encode(header); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// The signatures of the device have to follow it, in counter order.
+func (writer *Writer) WriteDevice(device domain.Device) error {
+	if writer.sealer != nil {
+		sealed, err := writer.sealer.Seal(device.UUID, device.PrivateKey)
+		if err != nil {
+			return err
+		}
+		device.PrivateKey = sealed
+	}
+
+	writer.trailer.Devices++
+	return writer.encoder.Encode(Entry{Device: &device})
+}
+
+func (writer *Writer) WriteSignature(signature *domain.Signature) error {
+	writer.trailer.Signatures++
+	return writer.encoder.Encode(Entry{Signature: signature})
+}
+
+// Close writes the trailer, without it the archive is not accepted.
+func (writer *Writer) Close() error {
+	writer.trailer.Checksum = hex.EncodeToString(writer.digest.Sum(nil))
+	if writer.mac != nil {
+		writer.trailer.MAC = hex.EncodeToString(writer.mac.Sum(nil))
+	}
+
+	if err := json.NewEncoder(writer.gzip).Encode(Entry{End: &writer.trailer}); err != nil {
+		return err
+	}
+
+	return writer.gzip.Close()
+}
+
+// Reader returns the devices and signatures of an archive one at a time,
+// checking as it goes that every signature belongs to the device before it and
+// that the chains are intact, as the chain audit does: every signature
+// verifies with the public key of its device and links to the one before it,
+// up to the counter and last signature of the device. The checksum and the
+// HMAC can only be checked at the end, so nothing read is to be trusted before
+// Next returns io.EOF.
+type Reader struct {
+	scanner *bufio.Scanner
+	digest  hash.Hash
+	mac     hash.Hash
+	header  Header
+	sealer  *KeySealer
+	counted Trailer
+	done    bool
+
+	// Only the UUIDs of the devices are kept, to find duplicates.
+	devices     map[string]bool
+	device      *domain.Device
+	auditor     *chain.Auditor
+	lastCounter int
+}
+
+// NewReader reads and checks the header. The passphrase is needed when the
+// keys were encrypted, and is rejected otherwise.
+func NewReader(r io.Reader, passphrase string) (*Reader, error) {
+	return newReader(r, func(header Header) (*KeySealer, error) {
+		if header.Encryption == nil {
+			if passphrase != "" {
+				return nil, ErrNotEncrypted
+			}
+			return nil, nil
+		}
+
+		return OpenKeySealer(passphrase, *header.Encryption)
+	})
+}
+
+// NewSealedReader checks an archive like NewReader but leaves the private keys
+// as they are in it, encrypted or not. Without the passphrase the HMAC of
+// encrypted archives is not checked.
+func NewSealedReader(r io.Reader) (*Reader, error) {
+	return newReader(r, func(Header) (*KeySealer, error) { return nil, nil })
+}
+
+func newReader(r io.Reader, openSealer func(header Header) (*KeySealer, error)) (*Reader, error) {
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, ErrUnsupportedArchive
+	}
+
+	reader := &Reader{
+		scanner: bufio.NewScanner(gzipReader),
+		digest:  sha256.New(),
+		devices: make(map[string]bool),
+	}
+	// signed data is client data, lines can be long
+	reader.scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line, err := reader.next(&reader.header)
+	if err != nil {
+		return nil, ErrUnsupportedArchive
+	}
+
+	if reader.header.Format != Format {
+		return nil, ErrUnsupportedArchive
+	}
+
+	if reader.header.Version < 1 || reader.header.Version > CurrentVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupportedArchive, reader.header.Version)
+	}
+
+	if reader.sealer, err = openSealer(reader.header); err != nil {
+		return nil, err
+	}
+
+	if reader.sealer != nil {
+		reader.mac = reader.sealer.mac()
+	}
+	reader.checksum(line)
+
+	return reader, nil
+}
+
+func (reader *Reader) Header() Header {
+	return reader.header
+}
+
+// Next returns the next entry, a device (with its private key decrypted) or a
+// signature. After the last one it checks the trailer and returns io.EOF.
+func (reader *Reader) Next(ctx context.Context) (Entry, error) {
+	if reader.done {
+		return Entry{}, io.EOF
+	}
+
+	var entry Entry
+	line, err := reader.next(&entry)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return Entry{}, ErrIncompleteArchive
+		}
+		return Entry{}, err
+	}
+
+	if entry.End == nil {
+		reader.checksum(line)
+	}
+
+	switch {
+	case entry.Device != nil:
+		return entry, reader.readDevice(ctx, entry.Device)
+	case entry.Signature != nil:
+		return entry, reader.readSignature(ctx, entry.Signature)
+	case entry.End != nil:
+		return Entry{}, reader.finish(entry.End)
+	}
+
+	return Entry{}, errors.New("backup contains an empty entry")
+}
+
+func (reader *Reader) readDevice(ctx context.Context, device *domain.Device) error {
+	if err := reader.finishDevice(); err != nil {
+		return err
+	}
+
+	if device.UUID == "" {
+		return errors.New("backup contains a device without uuid")
+	}
+
+	if reader.devices[device.UUID] {
+		return fmt.Errorf("backup contains device %s twice", device.UUID)
+	}
+	reader.devices[device.UUID] = true
+	reader.device = device
+	reader.lastCounter = 0
+	reader.counted.Devices++
+
+	deviceCrypto, err := crypto.NewCrypto(device.Algorithm)
+	if err != nil {
+		return fmt.Errorf("device %s: %w", device.UUID, err)
+	}
+
+	publicKey := device.PublicKey
+	reader.auditor = chain.NewAuditor(device.UUID, device.SignatureCounter, func(ctx context.Context, signedData []byte, signature []byte) (bool, error) {
+		valid, err := deviceCrypto.VerifyWithPublicKey(ctx, signedData, signature, publicKey)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return false, ctxErr
+		}
+
+		return err == nil && valid, nil
+	})
+
+	if reader.sealer != nil {
+		privateKey, err := reader.sealer.Open(device.UUID, device.PrivateKey)
+		if err != nil {
+			return err
+		}
+		device.PrivateKey = privateKey
+	}
+
+	return nil
+}
+
+func (reader *Reader) readSignature(ctx context.Context, signature *domain.Signature) error {
+	if reader.device == nil || signature.DeviceUUID != reader.device.UUID {
+		return fmt.Errorf("signature %s does not follow its device %s", signature.UUID, signature.DeviceUUID)
+	}
+
+	if signature.Counter != reader.lastCounter+1 {
+		return fmt.Errorf("signature chain of device %s is broken at counter %d", signature.DeviceUUID, signature.Counter)
+	}
+	reader.lastCounter = signature.Counter
+	reader.counted.Signatures++
+
+	return reader.auditor.Check(ctx, *signature)
+}
+
+func (reader *Reader) finishDevice() error {
+	if reader.device == nil {
+		return nil
+	}
+
+	if reader.lastCounter != reader.device.SignatureCounter {
+		return fmt.Errorf("device %s has counter %d but the backup has %d signatures", reader.device.UUID, reader.device.SignatureCounter, reader.lastCounter)
+	}
+
+	if audit := reader.auditor.Finish(reader.device.LastSignature, time.Time{}); !audit.Intact {
+		return fmt.Errorf("%w: device %s at counter %d: %s", ErrBrokenChain, reader.device.UUID, audit.FirstBreak.Counter, audit.FirstBreak.Detail)
+	}
+
+	return nil
+}
+
+func (reader *Reader) finish(trailer *Trailer) error {
+	if err := reader.finishDevice(); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(reader.digest.Sum(nil)) != trailer.Checksum {
+		return ErrChecksumMismatch
+	}
+
+	if reader.mac != nil {
+		mac, err := hex.DecodeString(trailer.MAC)
+		if err != nil || !hmac.Equal(mac, reader.mac.Sum(nil)) {
+			return ErrNotAuthentic
+		}
+	}
+
+	if trailer.Devices != reader.counted.Devices || trailer.Signatures != reader.counted.Signatures {
+		return fmt.Errorf("%w: %d devices and %d signatures of %d and %d", ErrIncompleteArchive, reader.counted.Devices, reader.counted.Signatures, trailer.Devices, trailer.Signatures)
+	}
+
+	var extra Entry
+	if _, err := reader.next(&extra); !errors.Is(err, io.EOF) {
+		return errors.New("backup has content after its end")
+	}
+
+	reader.done = true
+	return io.EOF
+}
+
+// Blank lines are skipped, anything else must be a JSON object. The line is
+// only valid until the next call.
+func (reader *Reader) next(value any) ([]byte, error) {
+	for reader.scanner.Scan() {
+		line := reader.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		return line, json.Unmarshal(line, value)
+	}
+
+	if err := reader.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+// Adds a line to the checksum and the HMAC as it was written, with its
+// newline.
+func (reader *Reader) checksum(line []byte) {
+	reader.digest.Write(line)
+	reader.digest.Write([]byte("\n"))
+
+	if reader.mac != nil {
+		reader.mac.Write(line)
+		reader.mac.Write([]byte("\n"))
+	}
+}
@@ -0,0 +1,314 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/chain"
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+var testCreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// A device with a real chain of the given length, signed as the signature
+// service does.
+func newTestDevice(t *testing.T, uuid string, length int) (domain.Device, []domain.Signature) {
+	eccCrypto, _ := crypto.NewCrypto(crypto.SignatureAlgorithmECC)
+	keyPair, err := eccCrypto.GenerateKeyPair(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to generate keys: %v", err)
+	}
+	publicKey, privateKey, _ := eccCrypto.Marshal(keyPair)
+
+	device := domain.Device{UUID: uuid, Algorithm: crypto.SignatureAlgorithmECC, PublicKey: publicKey, PrivateKey: privateKey, LastSignature: chain.GenesisLink(uuid)}
+	signatures := make([]domain.Signature, 0, length)
+	for counter := 1; counter <= length; counter++ {
+		signedData := chain.Encode(counter, fmt.Sprintf("data-%d", counter), device.LastSignature)
+		signature, err := eccCrypto.Sign(context.Background(), []byte(signedData), privateKey)
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+
+		signatures = append(signatures, domain.Signature{
+			UUID:              fmt.Sprintf("%s-signature-%d", uuid, counter),
+			DeviceUUID:        uuid,
+			Counter:           counter,
+			SignedData:        signedData,
+			Signature:         base64.StdEncoding.EncodeToString(signature),
+			PreviousSignature: device.LastSignature,
+		})
+		device.SignatureCounter = counter
+		device.LastSignature = signatures[counter-1].Signature
+	}
+
+	return device, signatures
+}
+
+// Two devices, the first one with two signatures, which change can alter
+// before they are written.
+func writeTestArchive(t *testing.T, sealer *KeySealer, change func(signatures []domain.Signature) []domain.Signature) *bytes.Buffer {
+	deviceA, signatures := newTestDevice(t, "device-a", 2)
+	deviceB, _ := newTestDevice(t, "device-b", 0)
+	if change != nil {
+		signatures = change(signatures)
+	}
+
+	var archive bytes.Buffer
+	writer, err := NewWriter(&archive, testCreatedAt, sealer)
+	if err != nil {
+		t.Fatalf("Failed to start archive: %v", err)
+	}
+
+	writer.WriteDevice(deviceA)
+	for i := range signatures {
+		writer.WriteSignature(&signatures[i])
+	}
+	writer.WriteDevice(deviceB)
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	return &archive
+}
+
+func readArchive(reader *Reader) ([]Entry, error) {
+	var entries []Entry
+	for {
+		entry, err := reader.Next(context.Background())
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// Rewrites the content of an archive, keeping its trailer.
+func rewriteArchive(t *testing.T, archive *bytes.Buffer, change func(content []byte) []byte) *bytes.Buffer {
+	gzipReader, err := gzip.NewReader(archive)
+	if err != nil {
+		t.Fatalf("Failed to decompress archive: %v", err)
+	}
+	content, _ := io.ReadAll(gzipReader)
+
+	var rewritten bytes.Buffer
+	gzipWriter := gzip.NewWriter(&rewritten)
+	gzipWriter.Write(change(content))
+	gzipWriter.Close()
+
+	return &rewritten
+}
+
+func TestWriteAndRead(t *testing.T) {
+	sealer, _ := NewKeySealer("passphrase")
+	archive := writeTestArchive(t, sealer, nil)
+
+	if bytes.Contains(rewriteArchive(t, bytes.NewBuffer(archive.Bytes()), func(content []byte) []byte { return content }).Bytes(), []byte("PRIVATE")) {
+		t.Fatal("Expected the private keys to be encrypted")
+	}
+
+	reader, err := NewReader(archive, "passphrase")
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	if header := reader.Header(); !header.CreatedAt.Equal(testCreatedAt) || header.Encryption == nil {
+		t.Fatalf("Unexpected header %+v", header)
+	}
+
+	entries, err := readArchive(reader)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+
+	if len(entries) != 4 || entries[0].Device == nil || entries[1].Signature == nil || entries[3].Device == nil {
+		t.Fatalf("Unexpected archive content %v", entries)
+	}
+
+	if !bytes.Contains(entries[0].Device.PrivateKey, []byte("PRIVATE")) {
+		t.Fatalf("Expected the private key to be decrypted, got %q", entries[0].Device.PrivateKey)
+	}
+}
+
+func TestReadDetectsTamperedContent(t *testing.T) {
+	tampered := rewriteArchive(t, writeTestArchive(t, nil, nil), func(content []byte) []byte {
+		return bytes.Replace(content, []byte("device-a-signature-2"), []byte("device-a-signature-3"), 1)
+	})
+
+	reader, err := NewReader(tampered, "")
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	if _, err := readArchive(reader); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Expected a checksum mismatch, got %v", err)
+	}
+}
+
+func TestReadDetectsTruncatedArchive(t *testing.T) {
+	truncated := rewriteArchive(t, writeTestArchive(t, nil, nil), func(content []byte) []byte {
+		lines := bytes.SplitAfter(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))
+		return bytes.Join(lines[:len(lines)-1], nil)
+	})
+
+	reader, err := NewReader(truncated, "")
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	if _, err := readArchive(reader); !errors.Is(err, ErrIncompleteArchive) {
+		t.Fatalf("Expected an incomplete archive, got %v", err)
+	}
+}
+
+func TestReadRejectsOtherFiles(t *testing.T) {
+	if _, err := NewReader(bytes.NewBufferString("not a backup"), ""); !errors.Is(err, ErrUnsupportedArchive) {
+		t.Fatalf("Expected ErrUnsupportedArchive, got %v", err)
+	}
+}
+
+func TestReadDetectsBrokenChains(t *testing.T) {
+	for name, change := range map[string]func(signatures []domain.Signature) []domain.Signature{
+		"missing signature": func(signatures []domain.Signature) []domain.Signature {
+			return signatures[1:]
+		},
+		"signature of another device": func(signatures []domain.Signature) []domain.Signature {
+			signatures[1].DeviceUUID = "device-c"
+			return signatures
+		},
+		"counter ahead of the signatures": func(signatures []domain.Signature) []domain.Signature {
+			return signatures[:1]
+		},
+		"changed signed data": func(signatures []domain.Signature) []domain.Signature {
+			signatures[1].SignedData = strings.Replace(signatures[1].SignedData, "data-2", "data-x", 1)
+			return signatures
+		},
+		"changed signature": func(signatures []domain.Signature) []domain.Signature {
+			signatures[0].Signature = signatures[1].Signature
+			return signatures
+		},
+	} {
+		reader, err := NewReader(writeTestArchive(t, nil, change), "")
+		if err != nil {
+			t.Fatalf("Failed to open archive: %v", err)
+		}
+
+		if _, err := readArchive(reader); err == nil {
+			t.Fatalf("Expected the %s to be detected", name)
+		}
+	}
+}
+
+// The checksum is computed again after changing the content, as anyone
+// changing an archive would, but the HMAC can't be without the passphrase.
+func TestReadDetectsRewrittenEncryptedArchives(t *testing.T) {
+	sealer, _ := NewKeySealer("passphrase")
+	archive := writeTestArchive(t, sealer, nil)
+
+	rewritten := rewriteArchive(t, archive, func(content []byte) []byte {
+		lines := bytes.SplitAfter(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))
+		body := bytes.Join(lines[:len(lines)-1], nil)
+		body = bytes.Replace(body, []byte("2024-01-01"), []byte("2023-01-01"), 1)
+
+		var end Entry
+		json.Unmarshal(lines[len(lines)-1], &end)
+		checksum := sha256.Sum256(body)
+		end.End.Checksum = hex.EncodeToString(checksum[:])
+		trailer, _ := json.Marshal(end)
+
+		return append(body, append(trailer, '\n')...)
+	})
+
+	reader, err := NewReader(rewritten, "passphrase")
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	if _, err := readArchive(reader); !errors.Is(err, ErrNotAuthentic) {
+		t.Fatalf("Expected the archive not to be authentic, got %v", err)
+	}
+}
+
+func TestReadRejectsPassphraseForUnencryptedArchives(t *testing.T) {
+	if _, err := NewReader(writeTestArchive(t, nil, nil), "passphrase"); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("Expected ErrNotEncrypted, got %v", err)
+	}
+}
+
+func TestSealedReaderKeepsTheKeysEncrypted(t *testing.T) {
+	sealer, _ := NewKeySealer("passphrase")
+
+	reader, err := NewSealedReader(writeTestArchive(t, sealer, nil))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	entries, err := readArchive(reader)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+
+	if bytes.Contains(entries[0].Device.PrivateKey, []byte("PRIVATE")) {
+		t.Fatal("Expected the private key to stay encrypted")
+	}
+}
+
+func TestSealAndOpenKeys(t *testing.T) {
+	sealer, err := NewKeySealer("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Failed to create sealer: %v", err)
+	}
+
+	sealed, err := sealer.Seal("device-a", []byte("private-a"))
+	if err != nil {
+		t.Fatalf("Failed to seal key: %v", err)
+	}
+
+	opener, _ := OpenKeySealer("correct horse battery staple", *sealer.Params())
+	privateKey, err := opener.Open("device-a", sealed)
+	if err != nil || string(privateKey) != "private-a" {
+		t.Fatalf("Failed to open sealed key: %v", err)
+	}
+
+	if _, err := opener.Open("device-b", sealed); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatal("A key sealed for a device should not open for another device")
+	}
+
+	wrongOpener, _ := OpenKeySealer("wrong", *sealer.Params())
+	if _, err := wrongOpener.Open("device-a", sealed); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatal("Expected a wrong passphrase to fail")
+	}
+}
+
+func TestOpenKeySealerRejectsCostlyParameters(t *testing.T) {
+	sealer, _ := NewKeySealer("passphrase")
+
+	for _, change := range []func(params *KeyEncryption){
+		func(params *KeyEncryption) { params.N = 1 << 30 },
+		func(params *KeyEncryption) { params.R = 1 << 20 },
+		func(params *KeyEncryption) { params.P = 1 << 20 },
+		func(params *KeyEncryption) { params.Salt = make([]byte, 1<<20) },
+	} {
+		params := sealer.Params()
+		change(params)
+
+		if _, err := OpenKeySealer("passphrase", *params); err == nil {
+			t.Fatalf("Expected the parameters %+v to be rejected", params)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+var ErrWrongPassphrase = errors.New("could not decrypt the keys, wrong passphrase?")
+
+// KeyEncryption describes how the private keys of an archive were encrypted,
+// everything but the passphrase is needed to decrypt them.
+type KeyEncryption struct {
+	Cipher string `json:"cipher"`
+	KDF    string `json:"kdf"`
+	Salt   []byte `json:"salt"`
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+}
+
+const (
+	cipherAESGCM = "AES-256-GCM"
+	kdfScrypt    = "scrypt"
+
+	// The parameters NewKeySealer writes, the only ones accepted when reading.
+	// They come from the archive, so anything else could make a restore
+	// allocate gigabytes or spin for minutes before the passphrase is checked.
+	scryptN        = 1 << 15
+	scryptR        = 8
+	scryptP        = 1
+	scryptSaltSize = 16
+)
+
+// KeySealer encrypts and decrypts private keys with a passphrase, and
+// authenticates the whole archive with it.
+type KeySealer struct {
+	params KeyEncryption
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// NewKeySealer creates a sealer for a new archive, with a random salt.
+func NewKeySealer(passphrase string) (*KeySealer, error) {
+	salt := make([]byte, scryptSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return OpenKeySealer(passphrase, KeyEncryption{
+		Cipher: cipherAESGCM,
+		KDF:    kdfScrypt,
+		Salt:   salt,
+		N:      scryptN,
+		R:      scryptR,
+		P:      scryptP,
+	})
+}
+
+// OpenKeySealer creates a sealer using the parameters stored in an archive.
+func OpenKeySealer(passphrase string, params KeyEncryption) (*KeySealer, error) {
+	if passphrase == "" {
+		return nil, errors.New("a passphrase is required")
+	}
+
+	if params.Cipher != cipherAESGCM || params.KDF != kdfScrypt {
+		return nil, errors.New("unsupported key encryption " + params.Cipher + "/" + params.KDF)
+	}
+
+	if params.N != scryptN || params.R != scryptR || params.P != scryptP || len(params.Salt) != scryptSaltSize {
+		return nil, fmt.Errorf("unsupported scrypt parameters N=%d r=%d p=%d with a salt of %d bytes", params.N, params.R, params.P, len(params.Salt))
+	}
+
+	// the first half encrypts the keys, the second one authenticates the archive
+	key, err := scrypt.Key([]byte(passphrase), params.Salt, params.N, params.R, params.P, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeySealer{params: params, aead: aead, macKey: key[32:]}, nil
+}
+
+// HMAC-SHA256 keyed from the passphrase. Unlike the checksum it can't be
+// computed again by whoever changes the archive without knowing it.
+func (sealer *KeySealer) mac() hash.Hash {
+	return hmac.New(sha256.New, sealer.macKey)
+}
+
+func (sealer *KeySealer) Params() *KeyEncryption {
+	params := sealer.params
+	return &params
+}
+
+// Seal encrypts a private key. The device UUID is authenticated as well so a
+// key cannot be moved to another device inside the archive.
+func (sealer *KeySealer) Seal(deviceUUID string, privateKey []byte) ([]byte, error) {
+	nonce := make([]byte, sealer.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return sealer.aead.Seal(nonce, nonce, privateKey, []byte(deviceUUID)), nil
+}
+
+func (sealer *KeySealer) Open(deviceUUID string, sealed []byte) ([]byte, error) {
+	nonceSize := sealer.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, ErrWrongPassphrase
+	}
+
+	privateKey, err := sealer.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(deviceUUID))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return privateKey, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chuckiihub/signing-service/backup"
+	"github.com/chuckiihub/signing-service/config"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/service"
+)
+
+// The backup subcommand talks to the admin endpoints of a running service, as
+// the state lives in the service (and in the volatile configuration, only there).
+// The passphrase is read from SIGNING_SERVICE_BACKUP_PASSPHRASE so it does
+// not show up in the process list.
+const backupUsage = `usage:
+  signing-service backup export  [-server URL] -out FILE
+  signing-service backup import  [-server URL] -in FILE
+  signing-service backup inspect -in FILE
+
+The private keys are encrypted with SIGNING_SERVICE_BACKUP_PASSPHRASE when set,
+which also authenticates the archive. Inspect verifies the chains with the
+public keys, and the keys and the authentication when the passphrase is set.
+Export and import call the service with the API key in SIGNING_SERVICE_API_KEY,
+which needs the admin scope.
+`
+
+func runBackupCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, backupUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("backup "+args[0], flag.ContinueOnError)
+	server := flags.String("server", "http://localhost"+config.DefaultListenAddress, "base URL of the signing service")
+	inFile := flags.String("in", "", "archive to read")
+	outFile := flags.String("out", "", "archive to write")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	passphrase := config.GetBackupPassphrase()
+	baseURL := strings.TrimSuffix(*server, "/") + "/api/v0/admin"
+
+	var err error
+	switch {
+	case args[0] == "export" && *outFile != "":
+		err = exportBackup(baseURL, passphrase, *outFile)
+	case args[0] == "import" && *inFile != "":
+		err = importBackup(baseURL, passphrase, *inFile)
+	case args[0] == "inspect" && *inFile != "":
+		err = inspectBackup(passphrase, *inFile)
+	default:
+		fmt.Fprint(os.Stderr, backupUsage)
+		return 2
+	}
+
+	if err != nil {
+		slog.Error("backup "+args[0]+" failed", "error", err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+func exportBackup(baseURL string, passphrase string, outFile string) error {
+	request, err := http.NewRequest(http.MethodGet, baseURL+"/backup", nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-Backup-Passphrase", passphrase)
+
+	body, err := doAdminRequest(request)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// written next to the target first, so a failed export doesn't leave half
+	// an archive behind under the name of a good one
+	file, err := os.CreateTemp(filepath.Dir(outFile), filepath.Base(outFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	written, err := io.Copy(file, body)
+	if err != nil {
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(file.Name(), outFile); err != nil {
+		return err
+	}
+
+	slog.Info("backup written", "file", outFile, "bytes", written)
+	return nil
+}
+
+func importBackup(baseURL string, passphrase string, inFile string) error {
+	archive, err := os.Open(inFile)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	request, err := http.NewRequest(http.MethodPost, baseURL+"/restore", archive)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-Backup-Passphrase", passphrase)
+	request.Header.Set("Content-Type", "application/gzip")
+
+	body, err := doAdminRequest(request)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	summary, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("backup restored", "file", inFile, "response", string(summary))
+	return nil
+}
+
+// Checks an archive offline: format, checksum, chains and, if a passphrase is
+// given, the HMAC and that every private key can be decrypted.
+func inspectBackup(passphrase string, inFile string) error {
+	file, err := os.Open(inFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader *backup.Reader
+	if passphrase != "" {
+		reader, err = backup.NewReader(file, passphrase)
+	} else {
+		reader, err = backup.NewSealedReader(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	devices, signatures := 0, 0
+	for {
+		entry, err := reader.Next(context.Background())
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if entry.Device != nil {
+			devices++
+		} else {
+			signatures++
+		}
+	}
+
+	header := reader.Header()
+	fmt.Printf("created at:  %s\nencrypted:   %t\ndevices:     %d\nsignatures:  %d\n",
+		header.CreatedAt, header.Encryption != nil, devices, signatures)
+	return nil
+}
+
+// The body of a successful response is for the caller to close.
+func doAdminRequest(request *http.Request) (io.ReadCloser, error) {
+	request.Header.Set("Authorization", "Bearer "+config.GetAPIKey())
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return nil, errors.New(response.Status + ": " + string(body))
+	}
+
+	return response.Body, nil
+}
+
+func restoreBackupFile(backupService service.BackupService, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// recorded in the audit log as done by whoever started the service
+	ctx := identity.NewContext(context.Background(), identity.Identity{Name: "startup -restore"})
+	summary, err := backupService.Import(ctx, file, config.GetBackupPassphrase())
+	if err != nil {
+		return err
+	}
+
+	slog.Info("backup restored", "file", fileName, "devices", summary.Devices, "signatures", summary.Signatures)
+	return nil
+}
@@ -0,0 +1,154 @@
+package chain
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+// Checks a signature against the key of the device. Errors are only for
+// things like a cancelled context, an invalid signature is just false.
+type VerifyFunc func(ctx context.Context, signedData []byte, signature []byte) (bool, error)
+
+// Auditor checks the signatures of a device one by one, in counter order.
+// Each one must verify with the device key and embed its own counter and the
+// signature before it, so a single changed, removed or inserted signature
+// breaks the chain from there on.
+type Auditor struct {
+	deviceUUID    string
+	deviceCounter int
+	verify        VerifyFunc
+	audit         domain.ChainAudit
+
+	lastCounter   int
+	lastSignature string
+	// Previous signature of the last one, duplicates must link to it too.
+	lastLink string
+}
+
+// deviceCounter is the signature counter of the device, signatures are
+// expected from 1 up to it.
+func NewAuditor(deviceUUID string, deviceCounter int, verify VerifyFunc) *Auditor {
+	return &Auditor{
+		deviceUUID:    deviceUUID,
+		deviceCounter: deviceCounter,
+		verify:        verify,
+		lastSignature: GenesisLink(deviceUUID),
+		audit: domain.ChainAudit{
+			DeviceUUID:    deviceUUID,
+			DeviceCounter: deviceCounter,
+			Gaps:          []domain.CounterGap{},
+			Duplicates:    []int{},
+			Issues:        []domain.ChainIssue{},
+		},
+	}
+}
+
+// Check must be called for every signature in counter order, duplicates next
+// to each other. It only fails if verify does.
+func (auditor *Auditor) Check(ctx context.Context, signature domain.Signature) error {
+	auditor.audit.SignaturesChecked++
+
+	if signature.DeviceUUID != auditor.deviceUUID {
+		auditor.report(domain.ChainIssueBrokenLink, signature, "signature belongs to device "+signature.DeviceUUID)
+		return nil
+	}
+
+	expectedLink := auditor.lastSignature
+	duplicate := auditor.lastCounter > 0 && signature.Counter == auditor.lastCounter
+	afterGap := signature.Counter > auditor.lastCounter+1
+
+	if duplicate {
+		expectedLink = auditor.lastLink
+		if duplicates := auditor.audit.Duplicates; len(duplicates) == 0 || duplicates[len(duplicates)-1] != signature.Counter {
+			auditor.audit.Duplicates = append(auditor.audit.Duplicates, signature.Counter)
+		}
+		auditor.report(domain.ChainIssueDuplicate, signature, "another signature has the same counter")
+	}
+
+	if afterGap {
+		auditor.gap(auditor.lastCounter+1, signature.Counter-1)
+	}
+
+	counter, link, err := Decode(signature.SignedData)
+	switch {
+	case err != nil:
+		auditor.report(domain.ChainIssueCounterMismatch, signature, err.Error())
+	case counter != signature.Counter:
+		auditor.report(domain.ChainIssueCounterMismatch, signature, fmt.Sprintf("signed data embeds counter %d", counter))
+	case afterGap:
+		// the signature it links to is missing, the gap already breaks the chain
+	case link != expectedLink || signature.PreviousSignature != expectedLink:
+		auditor.report(domain.ChainIssueBrokenLink, signature, "signed data does not embed the previous signature of the chain")
+	}
+
+	valid := false
+	if decodedSignature, err := base64.StdEncoding.DecodeString(signature.Signature); err == nil {
+		if valid, err = auditor.verify(ctx, []byte(signature.SignedData), decodedSignature); err != nil {
+			return err
+		}
+	}
+	if !valid {
+		auditor.report(domain.ChainIssueInvalidSignature, signature, "signature does not verify with the key of the device")
+	}
+
+	if !duplicate {
+		auditor.lastLink = expectedLink
+		auditor.lastSignature = signature.Signature
+		auditor.lastCounter = signature.Counter
+	}
+
+	return nil
+}
+
+// Finish checks the end of the chain and returns the report. lastSignature is
+// the one the device points to, the chain must end there.
+func (auditor *Auditor) Finish(lastSignature string, auditedAt time.Time) domain.ChainAudit {
+	switch {
+	case auditor.deviceCounter > auditor.lastCounter:
+		auditor.gap(auditor.lastCounter+1, auditor.deviceCounter)
+	case auditor.deviceCounter < auditor.lastCounter:
+		auditor.audit.Issues = append(auditor.audit.Issues, domain.ChainIssue{
+			Kind:    domain.ChainIssueBrokenLink,
+			Counter: auditor.lastCounter,
+			Detail:  fmt.Sprintf("the chain goes on after the counter of the device (%d)", auditor.deviceCounter),
+		})
+	case lastSignature != auditor.lastSignature:
+		auditor.audit.Issues = append(auditor.audit.Issues, domain.ChainIssue{
+			Kind:    domain.ChainIssueBrokenLink,
+			Counter: auditor.lastCounter,
+			Detail:  "the device does not point to the last signature of its chain",
+		})
+	}
+
+	audit := auditor.audit
+	audit.Intact = len(audit.Issues) == 0
+	if !audit.Intact {
+		// issues are found in counter order
+		audit.FirstBreak = &audit.Issues[0]
+	}
+	audit.AuditedAt = auditedAt
+
+	return audit
+}
+
+func (auditor *Auditor) gap(from int, to int) {
+	auditor.audit.Gaps = append(auditor.audit.Gaps, domain.CounterGap{From: from, To: to})
+	auditor.audit.Issues = append(auditor.audit.Issues, domain.ChainIssue{
+		Kind:    domain.ChainIssueGap,
+		Counter: from,
+		Detail:  fmt.Sprintf("signatures %d to %d are missing", from, to),
+	})
+}
+
+func (auditor *Auditor) report(kind domain.ChainIssueKind, signature domain.Signature, detail string) {
+	auditor.audit.Issues = append(auditor.audit.Issues, domain.ChainIssue{
+		Kind:          kind,
+		Counter:       signature.Counter,
+		SignatureUUID: signature.UUID,
+		Detail:        detail,
+	})
+}
@@ -0,0 +1,50 @@
+package chain
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The rules to chain the signatures of a device live here so the service and
+// the offline verifier (cmd/verify) can never disagree on them.
+//
+// The signed data is `<counter>_<data>_<base64 of the previous signature>`,
+// where the previous signature of the first one is the genesis link of the
+// device.
+
+// Encode builds the data that is actually signed for the counter-th signature.
+func Encode(counter int, data string, previousSignature string) string {
+	return fmt.Sprintf("%d_%s_%s", counter, data, base64.StdEncoding.EncodeToString([]byte(previousSignature)))
+}
+
+// Decode reverses Encode, returning the counter and the previous signature
+// embedded in the signed data. The data itself may contain underscores but
+// neither the counter nor base64 do.
+func Decode(signedData string) (int, string, error) {
+	counterPart, rest, found := strings.Cut(signedData, "_")
+	lastUnderscore := strings.LastIndex(rest, "_")
+	if !found || lastUnderscore < 0 {
+		return 0, "", errors.New("signed data is not chained")
+	}
+
+	counter, err := strconv.Atoi(counterPart)
+	if err != nil {
+		return 0, "", errors.New("signed data does not start with a counter")
+	}
+
+	previous, err := base64.StdEncoding.DecodeString(rest[lastUnderscore+1:])
+	if err != nil {
+		return 0, "", errors.New("signed data does not end with the previous signature")
+	}
+
+	return counter, string(previous), nil
+}
+
+// GenesisLink is what a new device holds as its last signature, so the first
+// signature of the chain is bound to the device.
+func GenesisLink(deviceUUID string) string {
+	return base64.StdEncoding.EncodeToString([]byte(deviceUUID))
+}
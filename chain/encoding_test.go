@@ -0,0 +1,24 @@
+package chain
+
+import "testing"
+
+func TestEncodeAndDecode(t *testing.T) {
+	signedData := Encode(42, "data_with_underscores", "previous-signature==")
+
+	counter, previous, err := Decode(signedData)
+	if err != nil {
+		t.Fatalf("Failed to decode %s: %v", signedData, err)
+	}
+
+	if counter != 42 || previous != "previous-signature==" {
+		t.Fatalf("Decoded %d and %s from %s", counter, previous, signedData)
+	}
+}
+
+func TestDecodeRejectsUnchainedData(t *testing.T) {
+	for _, signedData := range []string{"", "data", "x_data_cHJldmlvdXM=", "1_data_not base64!"} {
+		if _, _, err := Decode(signedData); err == nil {
+			t.Errorf("%q should not decode", signedData)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package chain
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+// An exported chain is JSON lines: a header with what is needed to verify the
+// chain (the public key but never the private one) followed by the signatures
+// in counter order, one per line. Being line based it can be streamed, read
+// with any tool and checked offline with cmd/verify.
+const (
+	ExportFormat         = "signing-service-chain"
+	CurrentExportVersion = 1
+)
+
+var ErrUnsupportedExport = errors.New("not a supported chain export")
+
+type ExportHeader struct {
+	Format        string    `json:"format"`
+	Version       int       `json:"version"`
+	DeviceUUID    string    `json:"deviceId"`
+	Label         string    `json:"label"`
+	Algorithm     string    `json:"algorithm"`
+	PublicKey     string    `json:"publicKey"`
+	DeviceCounter int       `json:"deviceCounter"`
+	LastSignature string    `json:"lastSignature"`
+	ExportedAt    time.Time `json:"exportedAt"`
+}
+
+// Exported signatures do not depend on the JSON of domain.Signature, which
+// may change, and carry the algorithm by name.
+type ExportedSignature struct {
+	UUID              string            `json:"uuid"`
+	DeviceUUID        string            `json:"deviceId"`
+	Counter           int               `json:"counter"`
+	SignedData        string            `json:"signedData"`
+	Signature         string            `json:"signature"`
+	PreviousSignature string            `json:"previousSignature"`
+	Algorithm         string            `json:"algorithm"`
+	KeyVersion        int               `json:"keyVersion"`
+	CreatedAt         time.Time         `json:"createdAt"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+}
+
+func NewExportHeader(device *domain.Device, exportedAt time.Time) ExportHeader {
+	return ExportHeader{
+		Format:        ExportFormat,
+		Version:       CurrentExportVersion,
+		DeviceUUID:    device.UUID,
+		Label:         device.Label,
+		Algorithm:     device.Algorithm.String(),
+		PublicKey:     string(device.PublicKey),
+		DeviceCounter: device.SignatureCounter,
+		LastSignature: device.LastSignature,
+		ExportedAt:    exportedAt,
+	}
+}
+
+type ExportWriter struct {
+	encoder *json.Encoder
+}
+
+// Writes the header right away, then every signature given to Write.
+func NewExportWriter(w io.Writer, header ExportHeader) (*ExportWriter, error) {
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(header); err != nil {
+		return nil, err
+	}
+
+	return &ExportWriter{encoder: encoder}, nil
+}
+
+func (writer *ExportWriter) Write(signature domain.Signature) error {
+	return writer.encoder.Encode(ExportedSignature{
+		UUID:              signature.UUID,
+		DeviceUUID:        signature.DeviceUUID,
+		Counter:           signature.Counter,
+		SignedData:        signature.SignedData,
+		Signature:         signature.Signature,
+		PreviousSignature: signature.PreviousSignature,
+		Algorithm:         signature.Algorithm.String(),
+		KeyVersion:        signature.KeyVersion,
+		CreatedAt:         signature.CreatedAt,
+		Metadata:          signature.Metadata,
+	})
+}
+
+type ExportReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// Reads and checks the header, the signatures are read with Next.
+func NewExportReader(r io.Reader) (*ExportReader, ExportHeader, error) {
+	reader := &ExportReader{scanner: bufio.NewScanner(r)}
+	// signed data is client data, lines can be long
+	reader.scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var header ExportHeader
+	if err := reader.next(&header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, header, ErrUnsupportedExport
+		}
+		return nil, header, err
+	}
+
+	if header.Format != ExportFormat {
+		return nil, header, ErrUnsupportedExport
+	}
+
+	if header.Version < 1 || header.Version > CurrentExportVersion {
+		return nil, header, fmt.Errorf("%w: version %d", ErrUnsupportedExport, header.Version)
+	}
+
+	return reader, header, nil
+}
+
+// Next returns the next signature, or io.EOF after the last one.
+func (reader *ExportReader) Next() (ExportedSignature, error) {
+	var signature ExportedSignature
+	err := reader.next(&signature)
+
+	return signature, err
+}
+
+// Blank lines are skipped, anything else must be a JSON object.
+func (reader *ExportReader) next(value any) error {
+	for reader.scanner.Scan() {
+		reader.line++
+		if len(reader.scanner.Bytes()) == 0 {
+			continue
+		}
+
+		if err := json.Unmarshal(reader.scanner.Bytes(), value); err != nil {
+			return fmt.Errorf("line %d: %w", reader.line, err)
+		}
+		return nil
+	}
+
+	if err := reader.scanner.Err(); err != nil {
+		return err
+	}
+
+	return io.EOF
+}
+
+// ToDomain returns the exported signature as the auditor expects it.
+func (signature ExportedSignature) ToDomain() domain.Signature {
+	// the auditor checks with the algorithm of the device, not this one
+	algorithm, _ := crypto.ParseSignatureAlgorithm(signature.Algorithm)
+
+	return domain.Signature{
+		UUID:              signature.UUID,
+		DeviceUUID:        signature.DeviceUUID,
+		Counter:           signature.Counter,
+		SignedData:        signature.SignedData,
+		Signature:         signature.Signature,
+		PreviousSignature: signature.PreviousSignature,
+		Algorithm:         algorithm,
+		KeyVersion:        signature.KeyVersion,
+		CreatedAt:         signature.CreatedAt,
+		Metadata:          signature.Metadata,
+	}
+}
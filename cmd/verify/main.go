@@ -0,0 +1,198 @@
+// The verify command checks an exported device chain offline, without access to
+// the signing service: every signature must verify with the public key of the
+// device and embed its counter and the signature before it, following the same
+// rules the service signs with (see the chain package).
+//
+// Chains are exported with GET /api/v0/device/{deviceId}/chain.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chuckiihub/signing-service/chain"
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+const usage = `usage:
+  verify [-public-key FILE] [-format text|json] [CHAIN_FILE]
+
+Reads the chain from CHAIN_FILE, or from the standard input when it is missing
+or "-". The public key of the export is used unless -public-key gives the one
+you got from a trusted source, which is what an auditor should do.
+
+Exits with 0 when the chain is intact, 1 when it is broken and 2 when it could
+not be checked at all.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	publicKeyFile := flags.String("public-key", "", "PEM public key of the device, instead of the one in the export")
+	format := flags.String("format", "text", "report format, text or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if (*format != "text" && *format != "json") || flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	input := stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(stderr, "verify:", err)
+			return 2
+		}
+		defer file.Close()
+		input = file
+	}
+
+	var publicKey []byte
+	if *publicKeyFile != "" {
+		var err error
+		if publicKey, err = os.ReadFile(*publicKeyFile); err != nil {
+			fmt.Fprintln(stderr, "verify:", err)
+			return 2
+		}
+	}
+
+	report, err := verify(input, publicKey)
+	if err != nil {
+		fmt.Fprintln(stderr, "verify:", err)
+		return 2
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		report.print(stdout)
+	}
+
+	if !report.Audit.Intact {
+		return 1
+	}
+
+	return 0
+}
+
+type report struct {
+	Label     string `json:"label"`
+	Algorithm string `json:"algorithm"`
+	// Set when the key given with -public-key is not the one in the export.
+	KeyMismatch bool              `json:"keyMismatch,omitempty"`
+	Audit       domain.ChainAudit `json:"audit"`
+}
+
+// Checks every signature of the export. Errors are for exports that cannot be
+// read or keys that cannot be used, a tampered chain is reported, not an error.
+func verify(input io.Reader, publicKey []byte) (*report, error) {
+	reader, header, err := chain.NewExportReader(input)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := crypto.ParseSignatureAlgorithm(header.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCrypto, err := crypto.NewCrypto(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &report{Label: header.Label, Algorithm: header.Algorithm}
+	if publicKey == nil {
+		publicKey = []byte(header.PublicKey)
+	} else {
+		result.KeyMismatch = strings.TrimSpace(string(publicKey)) != strings.TrimSpace(header.PublicKey)
+	}
+
+	// the key is checked before walking the chain, so a wrong key is an error
+	// and not every signature reported as invalid
+	if _, err := crypto.CreateVerifier(algorithm, publicKey); err != nil {
+		return nil, fmt.Errorf("invalid %s public key: %w", header.Algorithm, err)
+	}
+
+	auditor := chain.NewAuditor(header.DeviceUUID, header.DeviceCounter, func(ctx context.Context, signedData []byte, signature []byte) (bool, error) {
+		valid, err := deviceCrypto.VerifyWithPublicKey(ctx, signedData, signature, publicKey)
+		return err == nil && valid, nil
+	})
+
+	for {
+		signature, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := auditor.Check(context.Background(), signature.ToDomain()); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Audit = auditor.Finish(header.LastSignature, time.Now().UTC())
+	return result, nil
+}
+
+func (report *report) print(w io.Writer) {
+	audit := report.Audit
+
+	fmt.Fprintf(w, "Device:     %s %q (%s)\n", audit.DeviceUUID, report.Label, report.Algorithm)
+	fmt.Fprintf(w, "Signatures: %d checked, device counter %d\n", audit.SignaturesChecked, audit.DeviceCounter)
+	if report.KeyMismatch {
+		fmt.Fprintln(w, "Warning:    the given public key is not the one in the export, verified with the given one")
+	}
+
+	if audit.Intact {
+		fmt.Fprintln(w, "Result:     chain intact")
+		return
+	}
+
+	fmt.Fprintf(w, "Result:     chain BROKEN at counter %d: %s\n", audit.FirstBreak.Counter, audit.FirstBreak.Detail)
+
+	if len(audit.Gaps) > 0 {
+		gaps := make([]string, 0, len(audit.Gaps))
+		for _, gap := range audit.Gaps {
+			gaps = append(gaps, fmt.Sprintf("%d-%d", gap.From, gap.To))
+		}
+		fmt.Fprintf(w, "Gaps:       %s\n", strings.Join(gaps, ", "))
+	}
+
+	if len(audit.Duplicates) > 0 {
+		duplicates := make([]string, 0, len(audit.Duplicates))
+		for _, counter := range audit.Duplicates {
+			duplicates = append(duplicates, fmt.Sprint(counter))
+		}
+		fmt.Fprintf(w, "Duplicates: %s\n", strings.Join(duplicates, ", "))
+	}
+
+	fmt.Fprintln(w, "Issues:")
+	for _, issue := range audit.Issues {
+		fmt.Fprintf(w, "  %6d  %-17s  %s", issue.Counter, issue.Kind, issue.Detail)
+		if issue.SignatureUUID != "" {
+			fmt.Fprintf(w, " (%s)", issue.SignatureUUID)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/chuckiihub/signing-service/service"
+)
+
+// Exports the chain of a new device with a few signatures, as the API does.
+func exportTestChain(t *testing.T, algorithm crypto.SignatureAlgorithm) []string {
+	devicePersistence := persistence.NewVolatileDeviceRepository()
+	lockService := service.NewVolatileLockService(time.Second)
+	pageLimits := service.PageLimits{Default: 10, Max: 10}
+	deviceService := service.NewDeviceService(devicePersistence, lockService, pageLimits, nil)
+	signatureService := service.NewSignatureService(devicePersistence, persistence.NewVolatileSignatureRepository(), lockService, pageLimits, nil)
+
+	device, err := deviceService.Create(context.Background(), algorithm, "register 1")
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+
+	for _, data := range []string{"first", "second_with_underscores", "third"} {
+		if _, err := signatureService.Sign(context.Background(), device.UUID, data, nil); err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+	}
+
+	var export bytes.Buffer
+	if err := signatureService.ExportChain(context.Background(), device.UUID, &export); err != nil {
+		t.Fatalf("Failed to export the chain: %v", err)
+	}
+
+	return strings.Split(strings.TrimSpace(export.String()), "\n")
+}
+
+func runVerify(t *testing.T, lines []string, args ...string) (int, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(strings.Join(lines, "\n")), &stdout, &stderr)
+
+	return code, stdout.String() + stderr.String()
+}
+
+func TestVerifyIntactChain(t *testing.T) {
+	for _, algorithm := range []crypto.SignatureAlgorithm{crypto.SignatureAlgorithmRSA, crypto.SignatureAlgorithmECC} {
+		lines := exportTestChain(t, algorithm)
+
+		code, output := runVerify(t, lines)
+		if code != 0 || !strings.Contains(output, "chain intact") || !strings.Contains(output, "3 checked") {
+			t.Fatalf("%v chain should be intact, got %d:\n%s", algorithm, code, output)
+		}
+	}
+}
+
+func TestVerifyTamperedChain(t *testing.T) {
+	lines := exportTestChain(t, crypto.SignatureAlgorithmECC)
+	// the second signature is removed from the export
+	tampered := []string{lines[0], lines[1], lines[3]}
+
+	code, output := runVerify(t, tampered, "-format", "json")
+	if code != 1 {
+		t.Fatalf("expected exit code 1, got %d:\n%s", code, output)
+	}
+
+	var result report
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("output is not a JSON report: %v\n%s", err, output)
+	}
+
+	if result.Audit.Intact || result.Audit.FirstBreak.Kind != domain.ChainIssueGap || result.Audit.FirstBreak.Counter != 2 {
+		t.Fatalf("expected a gap at counter 2, got %+v", result.Audit.FirstBreak)
+	}
+}
+
+func TestVerifyWithAnotherPublicKey(t *testing.T) {
+	lines := exportTestChain(t, crypto.SignatureAlgorithmECC)
+	otherHeader := exportTestChain(t, crypto.SignatureAlgorithmECC)[0]
+
+	var header struct {
+		PublicKey string `json:"publicKey"`
+	}
+	json.Unmarshal([]byte(otherHeader), &header)
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	os.WriteFile(keyFile, []byte(header.PublicKey), 0600)
+
+	code, output := runVerify(t, lines, "-public-key", keyFile)
+	if code != 1 || !strings.Contains(output, "Warning") || !strings.Contains(output, "invalid_signature") {
+		t.Fatalf("signatures should not verify with another key, got %d:\n%s", code, output)
+	}
+}
+
+func TestVerifyUnreadableInput(t *testing.T) {
+	code, output := runVerify(t, []string{`{"format":"something else"}`})
+	if code != 2 || !strings.Contains(output, "not a supported chain export") {
+		t.Fatalf("expected exit code 2, got %d:\n%s", code, output)
+	}
+}
@@ -2,7 +2,13 @@ package config
 
 import (
 	"log/slog"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chuckiihub/signing-service/tlsconfig"
 )
 
 // I've moved this here as when the service grows it might handle configuration
@@ -13,7 +19,45 @@ import (
 const (
 	DefaultListenAddress = ":8081"
 	DefaultLogLevel      = slog.LevelInfo
-	ListPageSize         = 2
+	DefaultListPageSize  = 20
+	MaxListPageSize      = 100
+	DefaultHotRetention  = 30 // days
+	RetentionInterval    = time.Hour
+	LockLeaseTTL         = 10 * time.Second
+	LockWaitTimeout      = 5 * time.Second
+	AuditCheckpointEvery = time.Minute
+	AuthMaxFailures      = 10
+	AuthFailureWindow    = time.Minute
+
+	// Signatures a WebSocket signing session keeps until the register
+	// acknowledges them, and for how long after it disconnects.
+	SigningSessionWindow = 100
+	SigningSessionTTL    = 5 * time.Minute
+
+	// Events a subscriber of /api/v0/events may fall behind before it is
+	// dropped, and the device events kept in memory to resume from.
+	EventSubscriberBuffer = 256
+	RecentDeviceEvents    = 1000
+
+	// Events waiting to become webhook deliveries, and how deliveries are
+	// attempted: the wait between attempts doubles up to the max.
+	WebhookQueueSize      = 10000
+	WebhookTimeout        = 10 * time.Second
+	WebhookMaxAttempts    = 10
+	WebhookInitialBackoff = 5 * time.Second
+	WebhookMaxBackoff     = time.Hour
+
+	// How long the Idempotency-Key of a signature request is remembered.
+	DefaultIdempotencyWindow = 24 * time.Hour
+
+	// The gRPC server runs next to the HTTP one, on its own port.
+	DefaultGRPCListenAddress = ":8082"
+
+	SigningModeLock          = "lock"
+	SigningModeOptimistic    = "optimistic"
+	OptimisticSignAttempts   = 10
+	OptimisticInitialBackoff = time.Millisecond
+	OptimisticMaxBackoff     = 50 * time.Millisecond
 )
 
 // tries to fetch listen address from environment variable, if not found, returns default
@@ -25,6 +69,15 @@ func GetListenAddress(defaultAddress string) string {
 	return listenAddress
 }
 
+// Same as GetListenAddress, for the gRPC server.
+func GetGRPCListenAddress(defaultAddress string) string {
+	listenAddress := os.Getenv("SIGNING_SERVICE_GRPC_LISTEN_ADDRESS")
+	if listenAddress == "" {
+		return defaultAddress
+	}
+	return listenAddress
+}
+
 // tries to fetch log level from environment variable, if not found, returns default
 func GetLogLevel() slog.Level {
 	logLevel := os.Getenv("SIGNING_SERVICE_LOG_LEVEL")
@@ -42,3 +95,135 @@ func GetLogLevel() slog.Level {
 		return DefaultLogLevel
 	}
 }
+
+// tries to fetch the maximum page size clients can ask for from environment variable,
+// if not found or invalid, returns default
+func GetMaxListPageSize(defaultSize int) int {
+	size, err := strconv.Atoi(os.Getenv("SIGNING_SERVICE_MAX_PAGE_SIZE"))
+	if err != nil || size < 1 {
+		return defaultSize
+	}
+	return size
+}
+
+// fetches the passphrase used to encrypt the private keys in backups from
+// environment variable, empty means keys are not encrypted
+func GetBackupPassphrase() string {
+	return os.Getenv("SIGNING_SERVICE_BACKUP_PASSPHRASE")
+}
+
+// tries to fetch the directory where old signatures are archived from environment
+// variable, if not found, signatures are kept in memory forever
+func GetArchiveDirectory() string {
+	return os.Getenv("SIGNING_SERVICE_ARCHIVE_DIR")
+}
+
+// tries to fetch for how many days signatures are kept in the hot storage before
+// being archived from environment variable, if not found or invalid, returns default
+func GetHotRetentionDays(defaultDays int) int {
+	days, err := strconv.Atoi(os.Getenv("SIGNING_SERVICE_HOT_RETENTION_DAYS"))
+	if err != nil || days < 0 {
+		return defaultDays
+	}
+	return days
+}
+
+// tries to fetch the address of the Redis server used to lock devices across
+// replicas from environment variable, if not found, locks are kept in memory
+func GetRedisAddress() string {
+	return os.Getenv("SIGNING_SERVICE_REDIS_ADDRESS")
+}
+
+// tries to fetch how devices are protected while signing from environment
+// variable: "lock" (default) uses the lock service, "optimistic" retries
+// conflicting signatures using compare-and-swap on the device instead.
+func GetSigningMode() string {
+	if os.Getenv("SIGNING_SERVICE_SIGNING_MODE") == SigningModeOptimistic {
+		return SigningModeOptimistic
+	}
+
+	return SigningModeLock
+}
+
+// tries to fetch for how long the idempotency keys of signature requests are
+// remembered (e.g. "24h") from environment variable, if not found or invalid,
+// returns default
+func GetIdempotencyWindow(defaultWindow time.Duration) time.Duration {
+	window, err := time.ParseDuration(os.Getenv("SIGNING_SERVICE_IDEMPOTENCY_WINDOW"))
+	if err != nil || window <= 0 {
+		return defaultWindow
+	}
+	return window
+}
+
+// tries to fetch the private networks webhook endpoints may be in (comma
+// separated CIDRs or addresses, e.g. "127.0.0.1/32,10.1.2.0/24") from
+// environment variable, if not found only public addresses are allowed.
+// Invalid entries are logged and skipped.
+func GetWebhookAllowedNetworks() []netip.Prefix {
+	networks := make([]netip.Prefix, 0)
+	for _, entry := range strings.Split(os.Getenv("SIGNING_SERVICE_WEBHOOK_ALLOWED_NETWORKS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		network, err := netip.ParsePrefix(entry)
+		if err != nil {
+			address, addressErr := netip.ParseAddr(entry)
+			if addressErr != nil {
+				slog.Warn("ignoring an invalid webhook allowed network", "network", entry, "error", err.Error())
+				continue
+			}
+			network = netip.PrefixFrom(address, address.BitLen())
+		}
+
+		networks = append(networks, network.Masked())
+	}
+
+	return networks
+}
+
+// tries to fetch the file with the ECC private key (PEM, as the keys of the
+// devices) that signs the audit log checkpoints from environment variable, if
+// not found a new key is generated on every start
+func GetAuditKeyFile() string {
+	return os.Getenv("SIGNING_SERVICE_AUDIT_KEY_FILE")
+}
+
+// tries to fetch the API key the service starts with, which holds every scope,
+// from environment variable, if not found a new one is generated and logged
+func GetBootstrapAPIKey() string {
+	return os.Getenv("SIGNING_SERVICE_BOOTSTRAP_API_KEY")
+}
+
+// fetches the API key the CLI commands call the service with from environment
+// variable
+func GetAPIKey() string {
+	return os.Getenv("SIGNING_SERVICE_API_KEY")
+}
+
+// tries to fetch the JSON file with the tenants and their limits from
+// environment variable, if not found there is only the default tenant
+func GetTenantsFile() string {
+	return os.Getenv("SIGNING_SERVICE_TENANTS_FILE")
+}
+
+// tries to fetch the JSON file with the roles API keys can be given from
+// environment variable, if not found the default roles are used
+func GetAccessPolicyFile() string {
+	return os.Getenv("SIGNING_SERVICE_ACCESS_POLICY_FILE")
+}
+
+// fetches the TLS configuration from environment variables, without
+// SIGNING_SERVICE_TLS_CERT_FILE the server listens on plain HTTP
+func GetTLSConfig() tlsconfig.Config {
+	return tlsconfig.Config{
+		CertFile:     os.Getenv("SIGNING_SERVICE_TLS_CERT_FILE"),
+		KeyFile:      os.Getenv("SIGNING_SERVICE_TLS_KEY_FILE"),
+		MinVersion:   os.Getenv("SIGNING_SERVICE_TLS_MIN_VERSION"),
+		CipherPolicy: os.Getenv("SIGNING_SERVICE_TLS_CIPHER_POLICY"),
+		ClientCAFile: os.Getenv("SIGNING_SERVICE_TLS_CLIENT_CA_FILE"),
+		ClientAuth:   os.Getenv("SIGNING_SERVICE_TLS_CLIENT_AUTH"),
+	}
+}
@@ -1,5 +1,7 @@
 package crypto
 
+import "errors"
+
 type SignatureAlgorithm int
 
 const (
@@ -21,3 +23,15 @@ func (s SignatureAlgorithm) String() string {
 func GetSupportedAlgorithms() []string {
 	return []string{SignatureAlgorithmECC.String(), SignatureAlgorithmRSA.String()}
 }
+
+// Parses the name of an algorithm as returned by String.
+func ParseSignatureAlgorithm(name string) (SignatureAlgorithm, error) {
+	switch name {
+	case SignatureAlgorithmRSA.String():
+		return SignatureAlgorithmRSA, nil
+	case SignatureAlgorithmECC.String():
+		return SignatureAlgorithmECC, nil
+	default:
+		return -1, errors.New("algorithm not supported")
+	}
+}
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"errors"
@@ -25,10 +26,14 @@ type KeyGenerator interface {
 // This interface combines the functionality 2 interfaces: KeyGenerator and Signer.
 // The challenge wanted me to code a Signer interface so I've decided to add a Generator and implement
 // the Signer interfaces separated (and not to create just one Crypto interface that implements both).
+// Generating and signing are CPU bound and can't be interrupted halfway, so the context is
+// checked before starting. Marshal and Unmarshal only encode, there is nothing to cancel.
 type Crypto interface {
-	GenerateKeyPair() (KeyPair, error)
-	Sign(dataToBeSigned []byte, privateKey []byte) ([]byte, error)
-	Verify(dataToBeSigned []byte, signature []byte, privateKey []byte) (bool, error)
+	GenerateKeyPair(ctx context.Context) (KeyPair, error)
+	Sign(ctx context.Context, dataToBeSigned []byte, privateKey []byte) ([]byte, error)
+	Verify(ctx context.Context, dataToBeSigned []byte, signature []byte, privateKey []byte) (bool, error)
+	// Same as Verify but only needs the public key.
+	VerifyWithPublicKey(ctx context.Context, dataToBeSigned []byte, signature []byte, publicKey []byte) (bool, error)
 	Marshal(keyPair KeyPair) ([]byte, []byte, error)
 	Unmarshal(privateKey []byte) (KeyPair, error)
 }
@@ -46,7 +51,11 @@ func NewCrypto(algorithm SignatureAlgorithm) (Crypto, error) {
 
 type RSACrypto struct{}
 
-func (c *RSACrypto) GenerateKeyPair() (KeyPair, error) {
+func (c *RSACrypto) GenerateKeyPair(ctx context.Context) (KeyPair, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	key, err := rsa.GenerateKey(rand.Reader, 512)
 	if err != nil {
 		return nil, err
@@ -58,7 +67,11 @@ func (c *RSACrypto) GenerateKeyPair() (KeyPair, error) {
 	}, nil
 }
 
-func (c *RSACrypto) Verify(dataToBeSigned []byte, signature []byte, publicKey []byte) (bool, error) {
+func (c *RSACrypto) Verify(ctx context.Context, dataToBeSigned []byte, signature []byte, publicKey []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	signer, err := CreateSigner(SignatureAlgorithmRSA, publicKey)
 	if err != nil {
 		return false, err
@@ -67,7 +80,24 @@ func (c *RSACrypto) Verify(dataToBeSigned []byte, signature []byte, publicKey []
 	return signer.Verify(dataToBeSigned, signature), nil
 }
 
-func (c *RSACrypto) Sign(dataToBeSigned []byte, privateKey []byte) ([]byte, error) {
+func (c *RSACrypto) VerifyWithPublicKey(ctx context.Context, dataToBeSigned []byte, signature []byte, publicKey []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	verifier, err := CreateVerifier(SignatureAlgorithmRSA, publicKey)
+	if err != nil {
+		return false, err
+	}
+
+	return verifier.Verify(dataToBeSigned, signature), nil
+}
+
+func (c *RSACrypto) Sign(ctx context.Context, dataToBeSigned []byte, privateKey []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	signer, err := CreateSigner(SignatureAlgorithmRSA, privateKey)
 	if err != nil {
 		return nil, err
@@ -96,7 +126,11 @@ func (c *RSACrypto) Unmarshal(privateKey []byte) (KeyPair, error) {
 
 type ECCCrypto struct{}
 
-func (c *ECCCrypto) GenerateKeyPair() (KeyPair, error) {
+func (c *ECCCrypto) GenerateKeyPair(ctx context.Context) (KeyPair, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	generator, err := NewKeyGenerator(SignatureAlgorithmECC)
 	if err != nil {
 		return nil, err
@@ -105,7 +139,11 @@ func (c *ECCCrypto) GenerateKeyPair() (KeyPair, error) {
 	return generator.Generate()
 }
 
-func (c *ECCCrypto) Verify(dataToBeSigned []byte, signature []byte, privateKey []byte) (bool, error) {
+func (c *ECCCrypto) Verify(ctx context.Context, dataToBeSigned []byte, signature []byte, privateKey []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	signer, err := CreateSigner(SignatureAlgorithmECC, privateKey)
 	if err != nil {
 		return false, err
@@ -114,7 +152,24 @@ func (c *ECCCrypto) Verify(dataToBeSigned []byte, signature []byte, privateKey [
 	return signer.Verify(dataToBeSigned, signature), nil
 }
 
-func (c *ECCCrypto) Sign(dataToBeSigned []byte, privateKey []byte) ([]byte, error) {
+func (c *ECCCrypto) VerifyWithPublicKey(ctx context.Context, dataToBeSigned []byte, signature []byte, publicKey []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	verifier, err := CreateVerifier(SignatureAlgorithmECC, publicKey)
+	if err != nil {
+		return false, err
+	}
+
+	return verifier.Verify(dataToBeSigned, signature), nil
+}
+
+func (c *ECCCrypto) Sign(ctx context.Context, dataToBeSigned []byte, privateKey []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	signer, err := CreateSigner(SignatureAlgorithmECC, privateKey)
 	if err != nil {
 		return nil, err
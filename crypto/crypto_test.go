@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"context"
 	"testing"
 )
 
@@ -14,7 +15,7 @@ func createCryptoAndKeyPair(t *testing.T, algorithm SignatureAlgorithm) (Crypto,
 		t.Fatalf("Failed to create RSA crypto: %v", err)
 	}
 
-	keyPair, err := crypto.GenerateKeyPair()
+	keyPair, err := crypto.GenerateKeyPair(context.Background())
 	if err != nil {
 		t.Fatalf("Failed to generate RSA key pair: %v", err)
 	}
@@ -68,12 +69,12 @@ func TestRSASignatureCreationAndValidation(t *testing.T) {
 		}
 
 		data := []byte(TEST_DATA)
-		signature, err := crypto.Sign(data, privateKey)
+		signature, err := crypto.Sign(context.Background(), data, privateKey)
 		if err != nil {
 			t.Fatalf("Failed to create RSA signature: %v", err)
 		}
 
-		valid, err := crypto.Verify(data, signature, privateKey)
+		valid, err := crypto.Verify(context.Background(), data, signature, privateKey)
 
 		if !valid || err != nil {
 			t.Errorf("%v signature verification failed %v", algorithm, err)
@@ -91,7 +92,7 @@ func TestRSASignatureCreationAndValidationFailureWithTamperedData(t *testing.T)
 			t.Fatalf("Failed to create %v crypto: %v", algorithm, err)
 		}
 
-		keyPair, err := crypto.GenerateKeyPair()
+		keyPair, err := crypto.GenerateKeyPair(context.Background())
 		if err != nil {
 			t.Fatalf("Failed to generate %v key pair: %v", algorithm, err)
 		}
@@ -101,12 +102,12 @@ func TestRSASignatureCreationAndValidationFailureWithTamperedData(t *testing.T)
 			t.Fatalf("Failed to marshal %v key pair: %v", algorithm, err)
 		}
 
-		signature, err := crypto.Sign([]byte(TEST_DATA), privateKey)
+		signature, err := crypto.Sign(context.Background(), []byte(TEST_DATA), privateKey)
 		if err != nil {
 			t.Fatalf("Failed to create %v signature: %v", algorithm, err)
 		}
 
-		valid, err := crypto.Verify([]byte(TAMPERED_DATA), signature, publicKey)
+		valid, err := crypto.Verify(context.Background(), []byte(TAMPERED_DATA), signature, publicKey)
 
 		if valid || err == nil {
 			t.Errorf("%v signature verification should have failed with tampered data", algorithm)
@@ -114,6 +115,39 @@ func TestRSASignatureCreationAndValidationFailureWithTamperedData(t *testing.T)
 	}
 }
 
+func TestSignatureValidationWithPublicKey(t *testing.T) {
+	const TEST_DATA = "The Beatles Are Great, specially the White Album"
+	const TAMPERED_DATA = "The Beatles Are Not Great, specially Rubber Soul."
+
+	for _, algorithm := range supportedAlgorithms() {
+		crypto, keyPair := createCryptoAndKeyPair(t, algorithm)
+
+		publicKey, privateKey, err := crypto.Marshal(keyPair)
+		if err != nil {
+			t.Fatalf("Failed to marshal %v key pair: %v", algorithm, err)
+		}
+
+		signature, err := crypto.Sign(context.Background(), []byte(TEST_DATA), privateKey)
+		if err != nil {
+			t.Fatalf("Failed to create %v signature: %v", algorithm, err)
+		}
+
+		valid, err := crypto.VerifyWithPublicKey(context.Background(), []byte(TEST_DATA), signature, publicKey)
+		if !valid || err != nil {
+			t.Errorf("%v signature verification with the public key failed %v", algorithm, err)
+		}
+
+		valid, err = crypto.VerifyWithPublicKey(context.Background(), []byte(TAMPERED_DATA), signature, publicKey)
+		if valid || err != nil {
+			t.Errorf("%v signature verification with the public key should have failed with tampered data", algorithm)
+		}
+
+		if _, err := crypto.VerifyWithPublicKey(context.Background(), []byte(TEST_DATA), signature, []byte("not a key")); err == nil {
+			t.Errorf("%v verification should fail with an invalid public key", algorithm)
+		}
+	}
+}
+
 func TestRSAMarshalUnmarshal(t *testing.T) {
 	crypto, keyPair := createCryptoAndKeyPair(t, SignatureAlgorithmRSA)
 	rsaKeyPair, ok := keyPair.(*RSAKeyPair)
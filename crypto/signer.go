@@ -39,6 +39,36 @@ func CreateSigner(t SignatureAlgorithm, privateKey []byte) (Signer, error) {
 	}
 }
 
+// Verifier only checks signatures, it can be created from the public key alone.
+type Verifier interface {
+	Verify(dataToBeSigned []byte, signature []byte) bool
+}
+
+// Creates a Verifier from an encoded public key, so signatures can be checked
+// by someone who does not hold the private key, like an auditor.
+func CreateVerifier(t SignatureAlgorithm, publicKey []byte) (Verifier, error) {
+	switch t {
+	case SignatureAlgorithmECC:
+		keyPair, err := NewECCMarshaler().DecodePublic(publicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewECCSigner(*keyPair), nil
+	case SignatureAlgorithmRSA:
+		marshaller := NewRSAMarshaler()
+
+		keyPair, err := marshaller.UnmarshalPublic(publicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewRSASigner(*keyPair), nil
+	default:
+		return nil, errors.New(`signature algorithm ` + strconv.Itoa(int(t)) + `not implemented.`)
+	}
+}
+
 type RSASigner struct {
 	keyPair RSAKeyPair
 }
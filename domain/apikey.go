@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// Scopes an API key can be granted, directly or through its roles. Every
+// service call requires one of them.
+const (
+	ScopeDeviceCreate = "device:create"
+	ScopeDeviceRead   = "device:read"
+	// Devices read without it come without their private key.
+	ScopeDeviceKeys    = "device:keys"
+	ScopeDeviceUpdate  = "device:update"
+	ScopeSign          = "sign"
+	ScopeVerify        = "verify"
+	ScopeSignatureRead = "signature:read"
+	// The audit log of the tenant.
+	ScopeAuditRead = "audit:read"
+	// The API keys of the tenant.
+	ScopeAdmin = "admin"
+	// The webhook endpoints of the tenant and their deliveries.
+	ScopeWebhooks = "webhooks"
+	// The whole service, across tenants: backups, the audit log and the API
+	// keys of every tenant.
+	ScopeOperator = "operator"
+)
+
+var Scopes = []string{
+	ScopeDeviceCreate,
+	ScopeDeviceRead,
+	ScopeDeviceKeys,
+	ScopeDeviceUpdate,
+	ScopeSign,
+	ScopeVerify,
+	ScopeSignatureRead,
+	ScopeAuditRead,
+	ScopeAdmin,
+	ScopeWebhooks,
+	ScopeOperator,
+}
+
+// APIKey is a credential clients authenticate with. Only the hash of the
+// secret is kept, the secret itself is handed out once, when the key is
+// created.
+type APIKey struct {
+	ID   string
+	Name string
+	// Requests made with the key only see the data of this tenant.
+	TenantID   string
+	SecretHash string
+	Scopes     []string
+	// Names of the roles of the key, they grant the scopes the access policy
+	// gives them on top of Scopes.
+	Roles []string
+	// When not empty the key can only be used with these devices.
+	Devices   []string
+	CreatedAt time.Time
+	CreatedBy string
+	// nil when the key does not expire.
+	ExpiresAt *time.Time
+	RevokedAt *time.Time
+	RevokedBy string
+}
+
+func (key *APIKey) HasScope(scope string) bool {
+	return slices.Contains(key.Scopes, scope)
+}
+
+func (key *APIKey) IsExpired(now time.Time) bool {
+	return key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)
+}
+
+func (key *APIKey) IsRevoked() bool {
+	return key.RevokedAt != nil
+}
@@ -0,0 +1,48 @@
+package domain
+
+import "time"
+
+type ChainIssueKind string
+
+const (
+	// The signature does not verify with the key of the device.
+	ChainIssueInvalidSignature ChainIssueKind = "invalid_signature"
+	// The counter embedded in the signed data is not the one of the signature.
+	ChainIssueCounterMismatch ChainIssueKind = "counter_mismatch"
+	// The signed data does not embed the previous signature of the chain.
+	ChainIssueBrokenLink ChainIssueKind = "broken_link"
+	// Signatures are missing between two counters (or after the last one).
+	ChainIssueGap ChainIssueKind = "gap"
+	// More than one signature was stored with the same counter.
+	ChainIssueDuplicate ChainIssueKind = "duplicate"
+)
+
+type ChainIssue struct {
+	Kind    ChainIssueKind `json:"kind"`
+	Counter int            `json:"counter"`
+	// Empty for gaps, there is no signature to point to.
+	SignatureUUID string `json:"signatureId,omitempty"`
+	Detail        string `json:"detail"`
+}
+
+// Counters missing in the chain, both ends included.
+type CounterGap struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// Result of walking the whole chain of a device. The chain is intact when
+// there are no issues; FirstBreak is the issue with the lowest counter, from
+// where on the chain cannot be trusted.
+type ChainAudit struct {
+	DeviceUUID        string       `json:"deviceId"`
+	Intact            bool         `json:"intact"`
+	DeviceCounter     int          `json:"deviceCounter"`
+	SignaturesChecked int          `json:"signaturesChecked"`
+	FirstBreak        *ChainIssue  `json:"firstBreak,omitempty"`
+	Gaps              []CounterGap `json:"gaps"`
+	// Counters stored more than once.
+	Duplicates []int        `json:"duplicates"`
+	Issues     []ChainIssue `json:"issues"`
+	AuditedAt  time.Time    `json:"auditedAt"`
+}
@@ -0,0 +1,74 @@
+package domain
+
+import "time"
+
+// Administrative actions recorded in the audit log.
+const (
+	AuditActionDeviceCreate       = "device.create"
+	AuditActionDeviceUpdate       = "device.update"
+	AuditActionDeviceStateChange  = "device.state_change"
+	AuditActionDeviceDecommission = "device.decommission"
+	// Devices are returned with their private key, so reading them is a key export.
+	AuditActionDeviceKeyRead  = "device.key_read"
+	AuditActionDeviceKeysList = "device.keys_list"
+	AuditActionBackupExport   = "backup.export"
+	AuditActionBackupImport   = "backup.import"
+	AuditActionAPIKeyCreate   = "apikey.create"
+	AuditActionAPIKeyRevoke   = "apikey.revoke"
+	AuditActionWebhookCreate  = "webhook.create"
+	AuditActionWebhookDelete  = "webhook.delete"
+	AuditActionWebhookReplay  = "webhook.replay"
+)
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// An entry of the audit log. Events are chained: Hash covers every other field,
+// PreviousHash included, so changing, removing or reordering an event breaks
+// every hash after it.
+type AuditEvent struct {
+	Sequence uint64    `json:"sequence"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	// Empty for actions not made on behalf of a tenant, e.g. on startup.
+	TenantID  string `json:"tenantId,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	Action    string `json:"action"`
+	// Device the action was done on, empty for actions on the whole service.
+	DeviceUUID string            `json:"deviceId,omitempty"`
+	Outcome    string            `json:"outcome"`
+	Details    map[string]string `json:"details,omitempty"`
+
+	PreviousHash string `json:"previousHash"`
+	Hash         string `json:"hash"`
+}
+
+// A checkpoint signs the hash of the log up to Sequence with the key of the
+// service, so the log cannot be rewritten, not even as a whole, without the key.
+type AuditCheckpoint struct {
+	Sequence  uint64    `json:"sequence"`
+	Hash      string    `json:"hash"`
+	SignedAt  time.Time `json:"signedAt"`
+	KeyID     string    `json:"keyId"`
+	Signature string    `json:"signature"`
+}
+
+// Result of checking the hashes and checkpoints of the whole log.
+type AuditLogVerification struct {
+	Intact             bool   `json:"intact"`
+	EventsChecked      int    `json:"eventsChecked"`
+	CheckpointsChecked int    `json:"checkpointsChecked"`
+	LastSequence       uint64 `json:"lastSequence"`
+	// Last event covered by a valid checkpoint, later ones are only chained.
+	SignedUpTo uint64 `json:"signedUpTo"`
+	// Where the log stops being trustworthy, if it does.
+	FirstBreak *AuditLogBreak `json:"firstBreak,omitempty"`
+	VerifiedAt time.Time      `json:"verifiedAt"`
+}
+
+type AuditLogBreak struct {
+	Sequence uint64 `json:"sequence"`
+	Detail   string `json:"detail"`
+}
@@ -1,15 +1,59 @@
 package domain
 
 import (
+	"time"
+
 	"github.com/chuckiihub/signing-service/crypto"
 )
 
+type DeviceState string
+
+const (
+	DeviceStateActive    DeviceState = "active"
+	DeviceStateSuspended DeviceState = "suspended"
+	// Decommissioned devices never sign again, the last signature of their
+	// chain is the closing one.
+	DeviceStateDecommissioned DeviceState = "decommissioned"
+)
+
 type Device struct {
 	UUID             string                    `json:"uuid"`
+	TenantID         string                    `json:"tenantId,omitempty"`
 	Label            string                    `json:"label"`
 	SignatureCounter int                       `json:"signatureCounter"`
 	Algorithm        crypto.SignatureAlgorithm `json:"algorithm"`
+	KeyVersion       int                       `json:"keyVersion"`
 	PublicKey        []byte                    `json:"publicKey"`
 	PrivateKey       []byte                    `json:"privateKey"`
 	LastSignature    string                    `json:"lastSignature"`
+	LastSignedAt     time.Time                 `json:"lastSignedAt"`
+	CreatedAt        time.Time                 `json:"createdAt"`
+	// Token of the last lock used to modify the device, see service.LockService.
+	FencingToken uint64 `json:"fencingToken"`
+	// Incremented by the persistence on every save, used for compare-and-swap.
+	Version uint64 `json:"version"`
+
+	// Free form data of the device, e.g. the store or the register number.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Incremented on every change made to the device through the API (label,
+	// tags or state) but not when signing. Clients use it as ETag.
+	Revision uint64 `json:"revision"`
+
+	State          DeviceState `json:"state"`
+	StateReason    string      `json:"stateReason,omitempty"`
+	StateChangedAt time.Time   `json:"stateChangedAt"`
+	StateChangedBy string      `json:"stateChangedBy"`
+}
+
+func (device *Device) Tenant() string {
+	return TenantOrDefault(device.TenantID)
+}
+
+// Devices stored before lifecycle states existed have no state and are active.
+func (device *Device) CurrentState() DeviceState {
+	if device.State == "" {
+		return DeviceStateActive
+	}
+
+	return device.State
 }
@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventSignatureCreated EventType = "signature.created"
+	EventDeviceCreated    EventType = "device.created"
+	// The label or tags changed.
+	EventDeviceUpdated EventType = "device.updated"
+	// Suspended, activated or decommissioned.
+	EventDeviceStateChanged EventType = "device.state_changed"
+	// An audit found the chain of a device broken.
+	EventChainIntegrityFailed EventType = "chain.integrity_failed"
+)
+
+var EventTypes = []EventType{
+	EventSignatureCreated,
+	EventDeviceCreated,
+	EventDeviceUpdated,
+	EventDeviceStateChanged,
+	EventChainIntegrityFailed,
+}
+
+// Something that happened to a device or its chain. Devices are sent without
+// their private key.
+type Event struct {
+	// Starts with the time of the event, so events can be resumed after one
+	// of them, see EventAfter.
+	ID         string      `json:"id"`
+	Type       EventType   `json:"type"`
+	TenantID   string      `json:"tenantId"`
+	DeviceUUID string      `json:"deviceId"`
+	Time       time.Time   `json:"time"`
+	Signature  *Signature  `json:"signature,omitempty"`
+	Device     *Device     `json:"device,omitempty"`
+	ChainAudit *ChainAudit `json:"chainAudit,omitempty"`
+}
+
+func NewSignatureEvent(signature Signature) Event {
+	return Event{
+		ID:         newEventID(signature.CreatedAt, signature.UUID),
+		Type:       EventSignatureCreated,
+		TenantID:   signature.Tenant(),
+		DeviceUUID: signature.DeviceUUID,
+		Time:       signature.CreatedAt,
+		Signature:  &signature,
+	}
+}
+
+func NewDeviceEvent(eventType EventType, device Device, at time.Time) Event {
+	device.PrivateKey = nil
+
+	return Event{
+		ID:         newEventID(at, device.UUID+"-"+strconv.FormatUint(device.Revision, 10)),
+		Type:       eventType,
+		TenantID:   device.Tenant(),
+		DeviceUUID: device.UUID,
+		Time:       at,
+		Device:     &device,
+	}
+}
+
+func NewChainIntegrityEvent(device Device, audit ChainAudit) Event {
+	return Event{
+		ID:         newEventID(audit.AuditedAt, "audit-"+device.UUID),
+		Type:       EventChainIntegrityFailed,
+		TenantID:   device.Tenant(),
+		DeviceUUID: device.UUID,
+		Time:       audit.AuditedAt,
+		ChainAudit: &audit,
+	}
+}
+
+func newEventID(at time.Time, subject string) string {
+	return strconv.FormatInt(at.UnixNano(), 10) + "-" + subject
+}
+
+var ErrInvalidEventID = errors.New("not an event ID")
+
+// Returns the time an event ID was made at.
+func ParseEventID(id string) (time.Time, error) {
+	nanos, _, found := strings.Cut(id, "-")
+	if !found {
+		return time.Time{}, ErrInvalidEventID
+	}
+
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidEventID
+	}
+
+	return time.Unix(0, unixNano).UTC(), nil
+}
+
+// Events are ordered by time and then by ID.
+func EventAfter(event Event, at time.Time, id string) bool {
+	if !event.Time.Equal(at) {
+		return event.Time.After(at)
+	}
+
+	return event.ID > id
+}
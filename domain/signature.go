@@ -1,8 +1,34 @@
 package domain
 
+import (
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+)
+
 type Signature struct {
 	UUID       string `json:"uuid"`
 	DeviceUUID string `json:"deviceId"`
+	// Same as the one of the device, kept so signatures can be scoped on their own.
+	TenantID string `json:"tenantId,omitempty"`
+	// Value of the device signature counter used to sign.
+	Counter    int    `json:"counter"`
 	SignedData string `json:"signedData"`
 	Signature  string `json:"signature"`
+	// Last signature of the device before this one (the one embedded in SignedData).
+	PreviousSignature string                    `json:"previousSignature"`
+	Algorithm         crypto.SignatureAlgorithm `json:"algorithm"`
+	KeyVersion        int                       `json:"keyVersion"`
+	// Server time of the signature. It never goes backwards inside a device chain.
+	CreatedAt time.Time `json:"createdAt"`
+	// Optional data supplied by the client, like a transaction or register ID.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Idempotency-Key of the request that signed, if it had one, and the hash
+	// of its data and metadata, which retries with the key must match.
+	IdempotencyKey         string `json:"idempotencyKey,omitempty"`
+	IdempotencyFingerprint string `json:"idempotencyFingerprint,omitempty"`
+}
+
+func (signature *Signature) Tenant() string {
+	return TenantOrDefault(signature.TenantID)
 }
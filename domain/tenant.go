@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"slices"
+
+	"github.com/chuckiihub/signing-service/crypto"
+)
+
+// Every device, signature and API key belongs to a tenant. The default one
+// always exists, data stored before tenants existed belongs to it.
+const DefaultTenantID = "default"
+
+// Tenant is an organization using the service, along with its limits. Zero
+// values mean "no limit".
+type Tenant struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Names of the algorithms its devices can use, e.g. "ECC". Empty allows all.
+	AllowedAlgorithms []string `json:"allowedAlgorithms,omitempty"`
+	// Decommissioned devices count too, they still hold their chain.
+	MaxDevices          int `json:"maxDevices,omitempty"`
+	SignaturesPerMinute int `json:"signaturesPerMinute,omitempty"`
+}
+
+func (tenant *Tenant) AllowsAlgorithm(algorithm crypto.SignatureAlgorithm) bool {
+	return len(tenant.AllowedAlgorithms) == 0 || slices.Contains(tenant.AllowedAlgorithms, algorithm.String())
+}
+
+// Owner tenant of data stored before tenants existed, or created outside of
+// any tenant.
+func TenantOrDefault(tenantID string) string {
+	if tenantID == "" {
+		return DefaultTenantID
+	}
+
+	return tenantID
+}
@@ -0,0 +1,66 @@
+package domain
+
+import "time"
+
+// WebhookEndpoint is a URL of a tenant the events are posted to. The secret
+// signs the deliveries so the receiver can tell they come from the service.
+type WebhookEndpoint struct {
+	ID          string
+	TenantID    string
+	URL         string
+	Secret      string
+	Description string
+	// Empty means every type.
+	Types     []EventType
+	CreatedAt time.Time
+	CreatedBy string
+}
+
+func (endpoint *WebhookEndpoint) Wants(eventType EventType) bool {
+	if len(endpoint.Types) == 0 {
+		return true
+	}
+
+	for _, wanted := range endpoint.Types {
+		if wanted == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "delivered"
+	// Every attempt failed, it stays in the dead letters until replayed.
+	WebhookDeliveryFailed WebhookDeliveryStatus = "failed"
+)
+
+// One event sent to one endpoint, with every attempt made.
+type WebhookDelivery struct {
+	ID         string
+	EndpointID string
+	TenantID   string
+	EventID    string
+	EventType  EventType
+	// The body posted, kept as it was so replays send the same.
+	Payload       []byte
+	Status        WebhookDeliveryStatus
+	Attempts      []WebhookAttempt
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	DeliveredAt   *time.Time
+	// The delivery this one replays.
+	ReplayOf string
+}
+
+type WebhookAttempt struct {
+	At time.Time
+	// 0 when no response came back.
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+}
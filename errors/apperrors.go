@@ -1,19 +1,134 @@
 package apperrors
 
+import (
+	"errors"
+	"strings"
+)
+
 // Did not use the standard http error codes as I did not wanted to
 // import the package unnecesarily.
 const (
-	InternalError = 500
-	NotFound      = 404
-	BadRequest    = 400
+	Unavailable          = 503
+	UnprocessableEntity  = 422
+	PreconditionRequired = 428
+	PreconditionFailed   = 412
+	InternalError        = 500
+	TooManyRequests      = 429
+	NotFound             = 404
+	Forbidden            = 403
+	Unauthorized         = 401
+	Conflict             = 409
+	BadRequest           = 400
+)
+
+// Machine readable codes of the errors, clients branch on them instead of on
+// the messages so they never change once released. Errors wrapped without a
+// code get the generic one of their status.
+const (
+	CodeBadRequest           = "bad_request"
+	CodeInvalidBody          = "invalid_body"
+	CodeInvalidParams        = "invalid_params"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidAPIKey        = "invalid_api_key"
+	CodeForbidden            = "forbidden"
+	CodeMissingScope         = "missing_scope"
+	CodeDeviceNotAssigned    = "device_not_assigned"
+	CodeCertificateRequired  = "certificate_required"
+	CodeTenantLimitReached   = "tenant_limit_reached"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeDeviceNotFound       = "device_not_found"
+	CodeSignatureNotFound    = "signature_not_found"
+	CodeAPIKeyNotFound       = "api_key_not_found"
+	CodeWebhookNotFound      = "webhook_not_found"
+	CodeDeliveryNotFound     = "webhook_delivery_not_found"
+	CodeConflict             = "conflict"
+	CodeDeviceNotActive      = "device_not_active"
+	CodeDeviceDecommissioned = "device_decommissioned"
+	CodeDeviceBusy           = "device_busy"
+	CodeAlreadyExists        = "already_exists"
+	CodeDeliveryPending      = "webhook_delivery_pending"
+	CodeWebhookDeleted       = "webhook_deleted"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeIdempotencyInFlight  = "idempotency_key_in_flight"
+	CodeRevisionMismatch     = "revision_mismatch"
+	CodeRevisionRequired     = "revision_required"
+	CodeRateLimited          = "rate_limited"
+	CodeTooManyUnacked       = "too_many_unacknowledged"
+	CodeUnavailable          = "unavailable"
+	CodeInternalError        = "internal_error"
 )
 
+// One short summary per code, the same for every error with it.
+var titles = map[string]string{
+	CodeBadRequest:           "Bad request",
+	CodeInvalidBody:          "The request body is not valid JSON",
+	CodeInvalidParams:        "The request has invalid parameters",
+	CodeUnauthorized:         "An API key is required",
+	CodeInvalidAPIKey:        "Invalid API key",
+	CodeForbidden:            "Forbidden",
+	CodeMissingScope:         "The API key lacks a scope",
+	CodeDeviceNotAssigned:    "The API key can't use the device",
+	CodeCertificateRequired:  "A client certificate bound to the device is required",
+	CodeTenantLimitReached:   "The tenant reached one of its limits",
+	CodeNotFound:             "Not found",
+	CodeMethodNotAllowed:     "Method not allowed",
+	CodeDeviceNotFound:       "Device not found",
+	CodeSignatureNotFound:    "Signature not found",
+	CodeAPIKeyNotFound:       "API key not found",
+	CodeWebhookNotFound:      "Webhook not found",
+	CodeDeliveryNotFound:     "Webhook delivery not found",
+	CodeConflict:             "Conflict",
+	CodeDeviceNotActive:      "The device is not active",
+	CodeDeviceDecommissioned: "The device is decommissioned",
+	CodeDeviceBusy:           "The device is busy with other requests",
+	CodeAlreadyExists:        "Already exists",
+	CodeDeliveryPending:      "The webhook delivery is still being attempted",
+	CodeWebhookDeleted:       "The webhook of the delivery was deleted",
+	CodeIdempotencyKeyReused: "The idempotency key was used with different data",
+	CodeIdempotencyInFlight:  "A request with the idempotency key is in progress",
+	CodeRevisionMismatch:     "The resource changed since it was read",
+	CodeRevisionRequired:     "The revision of the resource is required",
+	CodeRateLimited:          "Too many requests",
+	CodeTooManyUnacked:       "Too many unacknowledged signatures",
+	CodeUnavailable:          "Service unavailable",
+	CodeInternalError:        "Internal error",
+}
+
+// The code of the errors wrapped with only a status.
+var statusCodes = map[int]string{
+	BadRequest:           CodeBadRequest,
+	Unauthorized:         CodeUnauthorized,
+	Forbidden:            CodeForbidden,
+	NotFound:             CodeNotFound,
+	Conflict:             CodeConflict,
+	PreconditionFailed:   CodeRevisionMismatch,
+	UnprocessableEntity:  CodeBadRequest,
+	PreconditionRequired: CodeRevisionRequired,
+	TooManyRequests:      CodeRateLimited,
+	Unavailable:          CodeUnavailable,
+	InternalError:        CodeInternalError,
+}
+
 type AppError struct {
 	Type int
 
+	// Left empty they come from Type and Err, see ErrorCode, ErrorTitle and
+	// ErrorDetail.
+	Code   string
+	Title  string
+	Detail string
+	// The fields of the request that are wrong, with why.
+	InvalidParams []InvalidParam
+
 	Err error
 }
 
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
 func (e AppError) Error() string {
 	return e.Err.Error()
 }
@@ -25,6 +140,60 @@ func WrapError(err error, code int) AppError {
 	}
 }
 
-func (e *AppError) Unwrap() error {
+// Same as WrapError, with a code more precise than the one of the status.
+func WrapCodedError(err error, status int, code string) AppError {
+	return AppError{
+		Type: status,
+		Code: code,
+		Err:  err,
+	}
+}
+
+// A 400 listing the parameters that are wrong.
+func NewInvalidParamsError(params ...InvalidParam) AppError {
+	reasons := make([]string, 0, len(params))
+	for _, param := range params {
+		reasons = append(reasons, param.Name+" "+param.Reason)
+	}
+
+	return AppError{
+		Type:          BadRequest,
+		Code:          CodeInvalidParams,
+		InvalidParams: params,
+		Err:           errors.New(strings.Join(reasons, "; ")),
+	}
+}
+
+func (e AppError) ErrorCode() string {
+	if e.Code != "" {
+		return e.Code
+	}
+
+	if code, found := statusCodes[e.Type]; found {
+		return code
+	}
+
+	return CodeInternalError
+}
+
+func (e AppError) ErrorTitle() string {
+	if e.Title != "" {
+		return e.Title
+	}
+
+	return titles[e.ErrorCode()]
+}
+
+// What went wrong this time. Server errors don't tell, their message is
+// about the internals.
+func (e AppError) ErrorDetail() string {
+	if e.Detail != "" || e.Type >= InternalError {
+		return e.Detail
+	}
+
+	return e.Err.Error()
+}
+
+func (e AppError) Unwrap() error {
 	return e.Err
 }
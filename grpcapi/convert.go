@@ -0,0 +1,194 @@
+package grpcapi
+
+import (
+	"time"
+
+	"github.com/chuckiihub/signing-service/api/dto"
+	"github.com/chuckiihub/signing-service/api/validation"
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/grpcapi/signingpb"
+	"github.com/chuckiihub/signing-service/persistence"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Conversions between the protobuf messages and the domain. They follow the
+// ones of api/dto, the JSON responses and these messages carry the same fields.
+
+func newDevice(device *domain.Device) *signingpb.Device {
+	return &signingpb.Device{
+		Uuid:             device.UUID,
+		TenantId:         device.Tenant(),
+		Label:            device.Label,
+		Algorithm:        newAlgorithm(device.Algorithm),
+		PublicKey:        string(device.PublicKey),
+		PrivateKey:       string(device.PrivateKey),
+		Tags:             device.Tags,
+		State:            newDeviceState(device.CurrentState()),
+		StateReason:      device.StateReason,
+		StateChangedAt:   newTimestamp(device.StateChangedAt),
+		StateChangedBy:   device.StateChangedBy,
+		Revision:         device.Revision,
+		SignatureCounter: int64(device.SignatureCounter),
+		CreatedAt:        newTimestamp(device.CreatedAt),
+	}
+}
+
+func newSignature(signature *domain.Signature) *signingpb.Signature {
+	return &signingpb.Signature{
+		Uuid:              signature.UUID,
+		DeviceId:          signature.DeviceUUID,
+		Counter:           int64(signature.Counter),
+		SignedData:        signature.SignedData,
+		Signature:         signature.Signature,
+		PreviousSignature: signature.PreviousSignature,
+		Algorithm:         newAlgorithm(signature.Algorithm),
+		KeyVersion:        int64(signature.KeyVersion),
+		CreatedAt:         newTimestamp(signature.CreatedAt),
+		Metadata:          signature.Metadata,
+	}
+}
+
+func newChainAudit(audit *domain.ChainAudit) *signingpb.ChainAudit {
+	response := &signingpb.ChainAudit{
+		DeviceId:          audit.DeviceUUID,
+		Intact:            audit.Intact,
+		DeviceCounter:     int64(audit.DeviceCounter),
+		SignaturesChecked: int64(audit.SignaturesChecked),
+		AuditedAt:         newTimestamp(audit.AuditedAt),
+	}
+
+	if audit.FirstBreak != nil {
+		response.FirstBreak = newChainIssue(*audit.FirstBreak)
+	}
+
+	for _, gap := range audit.Gaps {
+		response.Gaps = append(response.Gaps, &signingpb.CounterGap{From: int64(gap.From), To: int64(gap.To)})
+	}
+
+	for _, counter := range audit.Duplicates {
+		response.Duplicates = append(response.Duplicates, int64(counter))
+	}
+
+	for _, issue := range audit.Issues {
+		response.Issues = append(response.Issues, newChainIssue(issue))
+	}
+
+	return response
+}
+
+func newChainIssue(issue domain.ChainIssue) *signingpb.ChainIssue {
+	return &signingpb.ChainIssue{
+		Kind:        string(issue.Kind),
+		Counter:     int64(issue.Counter),
+		SignatureId: issue.SignatureUUID,
+		Detail:      issue.Detail,
+	}
+}
+
+func newPageInfo[T any](page persistence.Page[T]) *signingpb.PageInfo {
+	info := &signingpb.PageInfo{NextCursor: page.NextCursor, HasMore: page.HasMore, Partial: page.Partial}
+	if page.Total != nil {
+		total := int64(*page.Total)
+		info.Total = &total
+	}
+
+	return info
+}
+
+func newAlgorithm(algorithm crypto.SignatureAlgorithm) signingpb.Algorithm {
+	switch algorithm {
+	case crypto.SignatureAlgorithmRSA:
+		return signingpb.Algorithm_ALGORITHM_RSA
+	case crypto.SignatureAlgorithmECC:
+		return signingpb.Algorithm_ALGORITHM_ECC
+	default:
+		return signingpb.Algorithm_ALGORITHM_UNSPECIFIED
+	}
+}
+
+// The names the HTTP API uses, so the same validation rules apply. Unspecified
+// is an empty string, which means "not given".
+func algorithmName(algorithm signingpb.Algorithm) string {
+	switch algorithm {
+	case signingpb.Algorithm_ALGORITHM_RSA:
+		return crypto.SignatureAlgorithmRSA.String()
+	case signingpb.Algorithm_ALGORITHM_ECC:
+		return crypto.SignatureAlgorithmECC.String()
+	default:
+		return ""
+	}
+}
+
+var deviceStates = map[domain.DeviceState]signingpb.DeviceState{
+	domain.DeviceStateActive:         signingpb.DeviceState_DEVICE_STATE_ACTIVE,
+	domain.DeviceStateSuspended:      signingpb.DeviceState_DEVICE_STATE_SUSPENDED,
+	domain.DeviceStateDecommissioned: signingpb.DeviceState_DEVICE_STATE_DECOMMISSIONED,
+}
+
+func newDeviceState(state domain.DeviceState) signingpb.DeviceState {
+	return deviceStates[state]
+}
+
+func deviceStateName(state signingpb.DeviceState) string {
+	for name, value := range deviceStates {
+		if value == state {
+			return string(name)
+		}
+	}
+
+	return ""
+}
+
+func orderName(order signingpb.SortOrder) string {
+	if order == signingpb.SortOrder_SORT_ORDER_DESCENDING {
+		return "desc"
+	}
+
+	return "asc"
+}
+
+// Zero times are left out, as in the JSON responses of a never changed state.
+func newTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
+
+func fromTimestamp(timestamp *timestamppb.Timestamp) time.Time {
+	if timestamp == nil {
+		return time.Time{}
+	}
+
+	return timestamp.AsTime()
+}
+
+func parsePageRequest(page *signingpb.PageRequest) (persistence.PageRequest, error) {
+	pageRequest := dto.PageRequest{
+		Cursor:       page.GetCursor(),
+		Limit:        int(page.GetLimit()),
+		IncludeTotal: page.GetIncludeTotal(),
+	}
+
+	if err := validate(pageRequest); err != nil {
+		return persistence.PageRequest{}, err
+	}
+
+	return persistence.PageRequest{
+		Cursor:       pageRequest.Cursor,
+		Limit:        pageRequest.Limit,
+		IncludeTotal: pageRequest.IncludeTotal,
+	}, nil
+}
+
+// Validates the request with the rules of its HTTP counterpart.
+func validate(request any) error {
+	validator := validation.NewRequestValidator()
+	if err := validator.Validate(request); err != nil {
+		return toStatus(validator.Error(err))
+	}
+
+	return nil
+}
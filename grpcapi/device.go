@@ -0,0 +1,154 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/chuckiihub/signing-service/api/dto"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/grpcapi/signingpb"
+	"github.com/chuckiihub/signing-service/service"
+)
+
+type deviceServer struct {
+	signingpb.UnimplementedDeviceServiceServer
+	deviceService service.DeviceService
+}
+
+func (server *deviceServer) CreateDevice(ctx context.Context, request *signingpb.CreateDeviceRequest) (*signingpb.Device, error) {
+	creationRequest := dto.DeviceCreationRequest{
+		Label:     request.GetLabel(),
+		Algorithm: algorithmName(request.GetAlgorithm()),
+	}
+	if err := validate(creationRequest); err != nil {
+		return nil, err
+	}
+
+	// already validated
+	algorithm, _ := creationRequest.GetSignatureAlgorithm()
+
+	device, err := server.deviceService.Create(ctx, algorithm, creationRequest.Label)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return newDevice(device), nil
+}
+
+func (server *deviceServer) GetDevice(ctx context.Context, request *signingpb.GetDeviceRequest) (*signingpb.Device, error) {
+	if request.GetUuid() == "" {
+		return nil, invalidParam("uuid", "is required")
+	}
+
+	device, err := server.deviceService.Get(ctx, request.GetUuid())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	if device == nil {
+		return nil, toStatus(apperrors.WrapCodedError(errors.New("device not found"), apperrors.NotFound, apperrors.CodeDeviceNotFound))
+	}
+
+	return newDevice(device), nil
+}
+
+func (server *deviceServer) ListDevices(ctx context.Context, request *signingpb.ListDevicesRequest) (*signingpb.ListDevicesResponse, error) {
+	searchRequest := dto.DeviceSearchRequest{
+		Algorithm:   algorithmName(request.GetAlgorithm()),
+		State:       deviceStateName(request.GetState()),
+		Label:       request.GetLabel(),
+		Tags:        request.GetTags(),
+		CreatedFrom: fromTimestamp(request.GetCreatedFrom()),
+		CreatedTo:   fromTimestamp(request.GetCreatedTo()),
+		Order:       orderName(request.GetOrder()),
+	}
+
+	switch request.GetSort() {
+	case signingpb.DeviceSort_DEVICE_SORT_LABEL:
+		searchRequest.Sort = "label"
+	case signingpb.DeviceSort_DEVICE_SORT_COUNTER:
+		searchRequest.Sort = "counter"
+	}
+
+	if request.CounterFrom != nil {
+		counterFrom := int(request.GetCounterFrom())
+		searchRequest.CounterFrom = &counterFrom
+	}
+
+	if request.CounterTo != nil {
+		counterTo := int(request.GetCounterTo())
+		searchRequest.CounterTo = &counterTo
+	}
+
+	if err := validate(searchRequest); err != nil {
+		return nil, err
+	}
+
+	pageRequest, err := parsePageRequest(request.GetPage())
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := server.deviceService.Search(ctx, dto.NewDeviceQuery(searchRequest), pageRequest)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	response := &signingpb.ListDevicesResponse{Page: newPageInfo(devices)}
+	for i := range devices.Items {
+		response.Items = append(response.Items, newDevice(&devices.Items[i]))
+	}
+
+	return response, nil
+}
+
+// The revision replaces the If-Match header of the HTTP API.
+func (server *deviceServer) UpdateDevice(ctx context.Context, request *signingpb.UpdateDeviceRequest) (*signingpb.Device, error) {
+	updateRequest := dto.DeviceUpdateRequest{Label: request.Label}
+
+	if len(request.GetSetTags()) > 0 || len(request.GetRemoveTags()) > 0 {
+		updateRequest.Tags = make(map[string]*string, len(request.GetSetTags())+len(request.GetRemoveTags()))
+		for key, value := range request.GetSetTags() {
+			updateRequest.Tags[key] = &value
+		}
+		for _, key := range request.GetRemoveTags() {
+			updateRequest.Tags[key] = nil
+		}
+	}
+
+	if err := validate(updateRequest); err != nil {
+		return nil, err
+	}
+
+	device, err := server.deviceService.Update(ctx, request.GetUuid(), service.DeviceChanges{
+		Label: updateRequest.Label,
+		Tags:  updateRequest.Tags,
+	}, request.GetExpectedRevision())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return newDevice(device), nil
+}
+
+func (server *deviceServer) SuspendDevice(ctx context.Context, request *signingpb.ChangeDeviceStateRequest) (*signingpb.Device, error) {
+	return server.changeState(ctx, request, domain.DeviceStateSuspended)
+}
+
+func (server *deviceServer) ActivateDevice(ctx context.Context, request *signingpb.ChangeDeviceStateRequest) (*signingpb.Device, error) {
+	return server.changeState(ctx, request, domain.DeviceStateActive)
+}
+
+func (server *deviceServer) changeState(ctx context.Context, request *signingpb.ChangeDeviceStateRequest, state domain.DeviceState) (*signingpb.Device, error) {
+	if err := validate(dto.DeviceStateChangeRequest{Reason: request.GetReason()}); err != nil {
+		return nil, err
+	}
+
+	device, err := server.deviceService.ChangeState(ctx, request.GetUuid(), state, request.GetReason())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return newDevice(device), nil
+}
@@ -0,0 +1,92 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/chuckiihub/signing-service/api/dto"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// The services wrap their errors with the HTTP status they mean, these are the
+// gRPC codes closest to them.
+var statusCodes = map[int]codes.Code{
+	apperrors.BadRequest:           codes.InvalidArgument,
+	apperrors.Unauthorized:         codes.Unauthenticated,
+	apperrors.Forbidden:            codes.PermissionDenied,
+	apperrors.NotFound:             codes.NotFound,
+	apperrors.Conflict:             codes.FailedPrecondition,
+	apperrors.UnprocessableEntity:  codes.InvalidArgument,
+	apperrors.PreconditionFailed:   codes.FailedPrecondition,
+	apperrors.PreconditionRequired: codes.FailedPrecondition,
+	apperrors.TooManyRequests:      codes.ResourceExhausted,
+	apperrors.Unavailable:          codes.Unavailable,
+	apperrors.InternalError:        codes.Internal,
+}
+
+// The domain of the ErrorInfo details, whose reason is the code of the error
+// as in the problems of the HTTP API.
+const errorDomain = "signing-service"
+
+// Same as api.WriteAppError, for gRPC. The code of the error goes in an
+// ErrorInfo detail, the invalid parameters in a BadRequest one.
+func toStatus(err error) error {
+	problem := dto.NewProblem(err)
+
+	code := codes.Internal
+	if mapped, found := statusCodes[problem.Status]; found {
+		code = mapped
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+
+	if code == codes.Internal {
+		slog.Error("Unhandled internal error", "error", err.Error())
+	}
+
+	message := problem.Detail
+	if message == "" {
+		message = problem.Title
+	}
+
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: problem.Code, Domain: errorDomain}}
+
+	if len(problem.InvalidParams) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, param := range problem.InvalidParams {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: param.Name, Description: param.Reason})
+		}
+		details = append(details, badRequest)
+	}
+
+	// the client can try again later
+	var rateLimit *service.RateLimitError
+	if errors.As(err, &rateLimit) {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(rateLimit.RetryAfter)})
+	}
+
+	result := status.New(code, message)
+	if detailed, detailsErr := result.WithDetails(details...); detailsErr == nil {
+		result = detailed
+	}
+
+	return result.Err()
+}
+
+// For the parameters checked by hand, the validator ones come from
+// RequestValidator.Error.
+func invalidParam(name string, reason string) error {
+	return toStatus(apperrors.NewInvalidParamsError(apperrors.InvalidParam{Name: name, Reason: reason}))
+}
@@ -0,0 +1,123 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/requestid"
+	"github.com/chuckiihub/signing-service/tlsconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Metadata keys, the same as the HTTP headers (gRPC lowercases them).
+const (
+	requestIDKey      = "x-request-id"
+	apiKeyKey         = "x-api-key"
+	authorizationKey  = "authorization"
+	idempotencyKeyKey = "idempotency-key"
+)
+
+func (s *Server) unaryInterceptor(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.newRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, request)
+}
+
+func (s *Server) streamInterceptor(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.newRequestContext(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(server, &requestStream{ServerStream: stream, ctx: ctx})
+}
+
+// Does what the middlewares of the HTTP server do: sets the request ID, passes
+// the client certificate on and authenticates the API key. Every RPC needs a key.
+func (s *Server) newRequestContext(ctx context.Context) (context.Context, error) {
+	incoming, _ := metadata.FromIncomingContext(ctx)
+
+	requestID := firstValue(incoming, requestIDKey)
+	if !requestid.IsValid(requestID) {
+		requestID = requestid.New()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, requestID))
+	ctx = requestid.NewContext(ctx, requestID)
+
+	client := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		client = clientAddress(p.Addr)
+
+		// only verified certificates count, the TLS listener checks them against the client CA
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+			certificate := tlsInfo.State.VerifiedChains[0][0]
+			ctx = identity.NewCertificateContext(ctx, identity.Certificate{
+				Subject: certificate.Subject.String(),
+				Devices: tlsconfig.BoundDevices(certificate),
+			})
+		}
+	}
+
+	secret := apiKeyFromMetadata(incoming)
+	if secret == "" {
+		return nil, toStatus(apperrors.WrapError(errors.New("send the API key in the authorization or x-api-key metadata"), apperrors.Unauthorized))
+	}
+
+	caller, err := s.apiKeyService.Authenticate(ctx, client, secret)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return identity.NewContext(ctx, caller), nil
+}
+
+func apiKeyFromMetadata(incoming metadata.MD) string {
+	if key := firstValue(incoming, apiKeyKey); key != "" {
+		return key
+	}
+
+	scheme, token, found := strings.Cut(firstValue(incoming, authorizationKey), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	return ""
+}
+
+func firstValue(incoming metadata.MD, key string) string {
+	if values := incoming.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// Failed attempts are counted per address, as they are over HTTP.
+func clientAddress(address net.Addr) string {
+	host, _, err := net.SplitHostPort(address.String())
+	if err != nil {
+		return address.String()
+	}
+
+	return host
+}
+
+// Streams carry their context, this one replaces it with the authenticated one.
+type requestStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *requestStream) Context() context.Context {
+	return stream.ctx
+}
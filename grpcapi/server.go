@@ -0,0 +1,65 @@
+package grpcapi
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/chuckiihub/signing-service/grpcapi/signingpb"
+	"github.com/chuckiihub/signing-service/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Server is the gRPC transport, it runs alongside the HTTP one and calls the
+// same services. Like the HTTP handlers, the functions here only translate
+// requests and responses, the services do the rest.
+type Server struct {
+	listenAddress    string
+	deviceService    service.DeviceService
+	signatureService service.SignatureService
+	apiKeyService    service.APIKeyService
+	// nil to listen without TLS
+	tlsConfig *tls.Config
+}
+
+func NewServer(
+	listenAddress string,
+	deviceService service.DeviceService,
+	signatureService service.SignatureService,
+	apiKeyService service.APIKeyService,
+	tlsConfig *tls.Config,
+) *Server {
+	return &Server{
+		listenAddress:    listenAddress,
+		deviceService:    deviceService,
+		signatureService: signatureService,
+		apiKeyService:    apiKeyService,
+		tlsConfig:        tlsConfig,
+	}
+}
+
+// Registers the services on a new gRPC server, the listener is up to the caller.
+func (s *Server) NewGRPCServer() *grpc.Server {
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+	}
+	if s.tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(s.tlsConfig)))
+	}
+
+	server := grpc.NewServer(options...)
+	signingpb.RegisterDeviceServiceServer(server, &deviceServer{deviceService: s.deviceService})
+	signingpb.RegisterSignatureServiceServer(server, &signatureServer{signatureService: s.signatureService})
+
+	return server
+}
+
+func (s *Server) Run() error {
+	listener, err := net.Listen("tcp", s.listenAddress)
+	if err != nil {
+		return err
+	}
+
+	return s.NewGRPCServer().Serve(listener)
+}
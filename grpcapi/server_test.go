@@ -0,0 +1,262 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/grpcapi/signingpb"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/chuckiihub/signing-service/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type testClients struct {
+	devices    signingpb.DeviceServiceClient
+	signatures signingpb.SignatureServiceClient
+	keys       service.APIKeyService
+}
+
+// Serves the same stack of services as main, over an in memory connection.
+func newTestServer(t *testing.T) testClients {
+	tenants, err := persistence.NewVolatileTenantRepository(nil)
+	require.NoError(t, err)
+
+	devicePersistence := persistence.NewVolatileDeviceRepository()
+	lockService := service.NewVolatileLockService(time.Second)
+	pageLimits := service.PageLimits{Default: 10, Max: 10}
+
+	deviceService := service.NewAuthorizedDeviceService(service.NewTenantDeviceService(service.NewDeviceService(devicePersistence, lockService, pageLimits, nil), tenants))
+	signaturePersistence := persistence.NewVolatileSignatureRepository()
+	signatureService := service.NewAuthorizedSignatureService(service.NewIdempotentSignatureService(service.NewTenantSignatureService(service.NewSignatureService(devicePersistence, signaturePersistence, lockService, pageLimits, nil), tenants), signaturePersistence, time.Hour))
+	apiKeyService := service.NewAPIKeyService(persistence.NewVolatileAPIKeyRepository(), tenants, service.DefaultAccessPolicy(), pageLimits, 3, time.Minute)
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewServer("", deviceService, signatureService, apiKeyService, nil).NewGRPCServer()
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	connection, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { connection.Close() })
+
+	return testClients{
+		devices:    signingpb.NewDeviceServiceClient(connection),
+		signatures: signingpb.NewSignatureServiceClient(connection),
+		keys:       apiKeyService,
+	}
+}
+
+// Creates a key with the grant and returns a context sending it.
+func (clients testClients) keyContext(t *testing.T, name string, grant service.APIKeyGrant) context.Context {
+	_, secret, err := clients.keys.Create(context.Background(), name, "", grant, nil)
+	require.NoError(t, err)
+
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+secret)
+}
+
+func assertCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	assert.Equal(t, code, status.Code(err), "unexpected status: %v", err)
+}
+
+func receiveCounters(t *testing.T, stream grpc.ServerStreamingClient[signingpb.Signature]) []int64 {
+	var counters []int64
+	for {
+		signature, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return counters
+		}
+		require.NoError(t, err)
+		counters = append(counters, signature.Counter)
+	}
+}
+
+func TestServer_IdempotentSigning(t *testing.T) {
+	clients := newTestServer(t)
+	admin := clients.keyContext(t, "admin", service.APIKeyGrant{Roles: []string{service.RoleAdmin}})
+
+	device, err := clients.devices.CreateDevice(admin, &signingpb.CreateDeviceRequest{Algorithm: signingpb.Algorithm_ALGORITHM_ECC, Label: "till"})
+	require.NoError(t, err)
+
+	signer := clients.keyContext(t, "signer", service.APIKeyGrant{Roles: []string{service.RoleSigner}, Devices: []string{device.Uuid}})
+	keyed := metadata.AppendToOutgoingContext(signer, "idempotency-key", "receipt-1")
+	first, err := clients.signatures.Sign(keyed, &signingpb.SignRequest{DeviceId: device.Uuid, Data: "receipt"})
+	require.NoError(t, err)
+	retry, err := clients.signatures.Sign(keyed, &signingpb.SignRequest{DeviceId: device.Uuid, Data: "receipt"})
+	require.NoError(t, err)
+	assert.Equal(t, first.Uuid, retry.Uuid)
+
+	_, err = clients.signatures.Sign(keyed, &signingpb.SignRequest{DeviceId: device.Uuid, Data: "other receipt"})
+	assertCode(t, err, codes.InvalidArgument)
+
+	// every item of a batch has its own key, a retried batch signs nothing again
+	batch := metadata.AppendToOutgoingContext(signer, "idempotency-key", "batch-1")
+	request := &signingpb.SignStreamRequest{DeviceId: device.Uuid, Items: []*signingpb.SignItem{{Data: "a"}, {Data: "b"}}}
+	for range 2 {
+		stream, err := clients.signatures.SignStream(batch, request)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{2, 3}, receiveCounters(t, stream))
+	}
+
+	// the keys of the items are keys too, `/1` has to fit in the longest one
+	longest := strings.Repeat("k", service.MaxIdempotencyKeyLength-len("/1"))
+	stream, err := clients.signatures.SignStream(metadata.AppendToOutgoingContext(signer, "idempotency-key", longest+"k"), request)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assertCode(t, err, codes.InvalidArgument)
+
+	stream, err = clients.signatures.SignStream(metadata.AppendToOutgoingContext(signer, "idempotency-key", longest), request)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4, 5}, receiveCounters(t, stream))
+}
+
+func TestServer_SignsAndStreamsSignatures(t *testing.T) {
+	clients := newTestServer(t)
+	admin := clients.keyContext(t, "admin", service.APIKeyGrant{Roles: []string{service.RoleAdmin}})
+
+	var header metadata.MD
+	device, err := clients.devices.CreateDevice(admin, &signingpb.CreateDeviceRequest{
+		Algorithm: signingpb.Algorithm_ALGORITHM_ECC,
+		Label:     "till",
+	}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, signingpb.DeviceState_DEVICE_STATE_ACTIVE, device.State)
+	assert.NotEmpty(t, header.Get("x-request-id"))
+
+	signer := clients.keyContext(t, "signer", service.APIKeyGrant{Roles: []string{service.RoleSigner}, Devices: []string{device.Uuid}})
+
+	first, err := clients.signatures.Sign(signer, &signingpb.SignRequest{DeviceId: device.Uuid, Data: "first"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), first.Counter)
+
+	stream, err := clients.signatures.SignStream(signer, &signingpb.SignStreamRequest{
+		DeviceId: device.Uuid,
+		Items: []*signingpb.SignItem{
+			{Data: "second"},
+			{Data: "third", Metadata: map[string]string{"register": "1"}},
+		},
+	})
+	require.NoError(t, err)
+
+	previous := first
+	for {
+		signature, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+
+		assert.Equal(t, previous.Counter+1, signature.Counter)
+		assert.Equal(t, previous.Signature, signature.PreviousSignature)
+		previous = signature
+	}
+	assert.Equal(t, int64(3), previous.Counter)
+	assert.Equal(t, "1", previous.Metadata["register"])
+
+	verified, err := clients.signatures.Verify(admin, &signingpb.VerifyRequest{
+		DeviceId:   device.Uuid,
+		SignedData: previous.SignedData,
+		Signature:  previous.Signature,
+	})
+	require.NoError(t, err)
+	assert.True(t, verified.Valid)
+
+	audit, err := clients.signatures.AuditChain(admin, &signingpb.AuditChainRequest{DeviceId: device.Uuid})
+	require.NoError(t, err)
+	assert.True(t, audit.Intact)
+	assert.Equal(t, int64(3), audit.SignaturesChecked)
+}
+
+func TestServer_MapsErrorsToStatusCodes(t *testing.T) {
+	clients := newTestServer(t)
+	admin := clients.keyContext(t, "admin", service.APIKeyGrant{Roles: []string{service.RoleAdmin}})
+	viewer := clients.keyContext(t, "viewer", service.APIKeyGrant{Roles: []string{service.RoleViewer}})
+
+	_, err := clients.devices.ListDevices(context.Background(), &signingpb.ListDevicesRequest{})
+	assertCode(t, err, codes.Unauthenticated)
+
+	_, err = clients.devices.ListDevices(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "wrong"), &signingpb.ListDevicesRequest{})
+	assertCode(t, err, codes.Unauthenticated)
+
+	_, err = clients.devices.CreateDevice(admin, &signingpb.CreateDeviceRequest{Label: "no algorithm"})
+	assertCode(t, err, codes.InvalidArgument)
+
+	_, err = clients.devices.CreateDevice(viewer, &signingpb.CreateDeviceRequest{Algorithm: signingpb.Algorithm_ALGORITHM_ECC, Label: "till"})
+	assertCode(t, err, codes.PermissionDenied)
+
+	_, err = clients.devices.GetDevice(admin, &signingpb.GetDeviceRequest{Uuid: "missing"})
+	assertCode(t, err, codes.NotFound)
+
+	device, err := clients.devices.CreateDevice(admin, &signingpb.CreateDeviceRequest{Algorithm: signingpb.Algorithm_ALGORITHM_ECC, Label: "till"})
+	require.NoError(t, err)
+
+	label := "renamed"
+	_, err = clients.devices.UpdateDevice(admin, &signingpb.UpdateDeviceRequest{Uuid: device.Uuid, ExpectedRevision: device.Revision + 1, Label: &label})
+	assertCode(t, err, codes.FailedPrecondition)
+
+	_, err = clients.devices.SuspendDevice(admin, &signingpb.ChangeDeviceStateRequest{Uuid: device.Uuid, Reason: "closed"})
+	require.NoError(t, err)
+
+	signer := clients.keyContext(t, "signer", service.APIKeyGrant{Roles: []string{service.RoleSigner}, Devices: []string{device.Uuid}})
+	_, err = clients.signatures.Sign(signer, &signingpb.SignRequest{DeviceId: device.Uuid, Data: "data"})
+	assertCode(t, err, codes.FailedPrecondition)
+
+	// nothing is signed when one of the items is invalid
+	stream, err := clients.signatures.SignStream(signer, &signingpb.SignStreamRequest{
+		DeviceId: device.Uuid,
+		Items:    []*signingpb.SignItem{{Data: "data"}, {Data: ""}},
+	})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assertCode(t, err, codes.InvalidArgument)
+}
+
+func TestToStatus_SendsWhenToRetry(t *testing.T) {
+	err := toStatus(apperrors.WrapError(&service.RateLimitError{Reason: "too many attempts", RetryAfter: 30 * time.Second}, apperrors.TooManyRequests))
+
+	converted := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, converted.Code())
+	require.Len(t, converted.Details(), 2)
+	assert.Equal(t, apperrors.CodeRateLimited, converted.Details()[0].(*errdetails.ErrorInfo).Reason)
+	assert.Equal(t, 30*time.Second, converted.Details()[1].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(toStatus(context.DeadlineExceeded)))
+
+	// internal messages stay in the logs
+	internal := status.Convert(toStatus(errors.New("unexpected")))
+	assert.Equal(t, codes.Internal, internal.Code())
+	assert.NotContains(t, internal.Message(), "unexpected")
+}
+
+func TestToStatus_ListsTheInvalidParams(t *testing.T) {
+	clients := newTestServer(t)
+	admin := clients.keyContext(t, "admin", service.APIKeyGrant{Roles: []string{service.RoleAdmin}})
+
+	_, err := clients.devices.CreateDevice(admin, &signingpb.CreateDeviceRequest{Algorithm: signingpb.Algorithm_ALGORITHM_ECC})
+	converted := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, converted.Code())
+	require.Len(t, converted.Details(), 2)
+	assert.Equal(t, apperrors.CodeInvalidParams, converted.Details()[0].(*errdetails.ErrorInfo).Reason)
+
+	violations := converted.Details()[1].(*errdetails.BadRequest).FieldViolations
+	require.Len(t, violations, 1)
+	assert.Equal(t, "label", violations[0].Field)
+	assert.Equal(t, "is required", violations[0].Description)
+}
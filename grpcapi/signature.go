@@ -0,0 +1,271 @@
+package grpcapi
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chuckiihub/signing-service/api/dto"
+	"github.com/chuckiihub/signing-service/api/validation"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/grpcapi/signingpb"
+	"github.com/chuckiihub/signing-service/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Most items a single SignStream call can carry.
+const maxSignStreamItems = 1000
+
+// Size of the chunks ExportChain sends.
+const exportChunkSize = 64 * 1024
+
+type signatureServer struct {
+	signingpb.UnimplementedSignatureServiceServer
+	signatureService service.SignatureService
+}
+
+func (server *signatureServer) Sign(ctx context.Context, request *signingpb.SignRequest) (*signingpb.Signature, error) {
+	if err := validateSignItem(request.GetData(), request.GetMetadata()); err != nil {
+		return nil, err
+	}
+
+	idempotencyKey, err := idempotencyKeyFromMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if idempotencyKey != "" {
+		ctx = service.WithIdempotencyKey(ctx, idempotencyKey)
+	}
+
+	signature, err := server.signatureService.Sign(ctx, request.GetDeviceId(), request.GetData(), request.GetMetadata())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return newSignature(signature), nil
+}
+
+// Every item is validated before signing the first one, so a bad item does not
+// leave the chain with half of the batch. After that the items are signed one
+// by one, each signature is sent as soon as it is in the chain.
+//
+// With an idempotency key each item gets its own, `<key>/<index>`, so a call
+// retried after an error signs only the items that were not signed yet.
+func (server *signatureServer) SignStream(request *signingpb.SignStreamRequest, stream grpc.ServerStreamingServer[signingpb.Signature]) error {
+	items := request.GetItems()
+	if len(items) == 0 {
+		return invalidParam("items", "must have at least one item")
+	}
+
+	if len(items) > maxSignStreamItems {
+		return invalidParam("items", fmt.Sprintf("must have at most %d items", maxSignStreamItems))
+	}
+
+	validator := validation.NewRequestValidator()
+	var invalidParams []apperrors.InvalidParam
+	for i, item := range items {
+		err := validator.Validate(dto.SignatureCreateRequest{Data: item.GetData(), Metadata: item.GetMetadata()})
+		if err == nil {
+			continue
+		}
+
+		for _, param := range validator.GetInvalidParams(err) {
+			param.Name = fmt.Sprintf("items[%d].%s", i, param.Name)
+			invalidParams = append(invalidParams, param)
+		}
+	}
+	if len(invalidParams) > 0 {
+		return toStatus(apperrors.NewInvalidParamsError(invalidParams...))
+	}
+
+	ctx := stream.Context()
+	idempotencyKey, err := idempotencyKeyFromMetadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the key of the last item is the longest one
+	if idempotencyKey != "" && !service.ValidIdempotencyKey(itemIdempotencyKey(idempotencyKey, len(items)-1)) {
+		return invalidParam(idempotencyKeyKey, fmt.Sprintf("is too long for %d items, with the /<index> of each item it must fit in %d characters", len(items), service.MaxIdempotencyKeyLength))
+	}
+
+	for i, item := range items {
+		// the client went away, no need to keep signing for it
+		if err := ctx.Err(); err != nil {
+			return toStatus(err)
+		}
+
+		itemCtx := ctx
+		if idempotencyKey != "" {
+			itemCtx = service.WithIdempotencyKey(ctx, itemIdempotencyKey(idempotencyKey, i))
+		}
+
+		signature, err := server.signatureService.Sign(itemCtx, request.GetDeviceId(), item.GetData(), item.GetMetadata())
+		if err != nil {
+			return toStatus(err)
+		}
+
+		if err := stream.Send(newSignature(signature)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func itemIdempotencyKey(key string, index int) string {
+	return fmt.Sprintf("%s/%d", key, index)
+}
+
+func idempotencyKeyFromMetadata(ctx context.Context) (string, error) {
+	incoming, _ := metadata.FromIncomingContext(ctx)
+
+	key := firstValue(incoming, idempotencyKeyKey)
+	if key != "" && !service.ValidIdempotencyKey(key) {
+		return "", invalidParam(idempotencyKeyKey, "must be 1 to 255 printable ASCII characters")
+	}
+
+	return key, nil
+}
+
+func validateSignItem(data string, metadata map[string]string) error {
+	return validate(dto.SignatureCreateRequest{Data: data, Metadata: metadata})
+}
+
+func (server *signatureServer) Verify(ctx context.Context, request *signingpb.VerifyRequest) (*signingpb.VerifyResponse, error) {
+	if err := validate(dto.SignatureVerifyRequest{SignedData: request.GetSignedData(), Signature: request.GetSignature()}); err != nil {
+		return nil, err
+	}
+
+	// only a signature that does not match is reported as invalid, errors like
+	// a missing device are errors
+	valid, err := server.signatureService.Verify(ctx, request.GetDeviceId(), request.GetSignedData(), request.GetSignature())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &signingpb.VerifyResponse{Valid: valid}, nil
+}
+
+func (server *signatureServer) GetSignature(ctx context.Context, request *signingpb.GetSignatureRequest) (*signingpb.Signature, error) {
+	if request.GetUuid() == "" {
+		return nil, invalidParam("uuid", "is required")
+	}
+
+	signature, err := server.signatureService.Get(ctx, request.GetUuid())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	if signature == nil {
+		return nil, toStatus(apperrors.WrapCodedError(errors.New("signature not found"), apperrors.NotFound, apperrors.CodeSignatureNotFound))
+	}
+
+	return newSignature(signature), nil
+}
+
+func (server *signatureServer) ListSignatures(ctx context.Context, request *signingpb.ListSignaturesRequest) (*signingpb.ListSignaturesResponse, error) {
+	pageRequest, err := parsePageRequest(request.GetPage())
+	if err != nil {
+		return nil, err
+	}
+
+	signatures, err := server.signatureService.List(ctx, pageRequest)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	response := &signingpb.ListSignaturesResponse{Page: newPageInfo(signatures)}
+	for i := range signatures.Items {
+		response.Items = append(response.Items, newSignature(&signatures.Items[i]))
+	}
+
+	return response, nil
+}
+
+func (server *signatureServer) ListDeviceSignatures(ctx context.Context, request *signingpb.ListDeviceSignaturesRequest) (*signingpb.ListSignaturesResponse, error) {
+	listRequest := dto.DeviceSignaturesRequest{
+		Order:       orderName(request.GetOrder()),
+		CounterFrom: int(request.GetCounterFrom()),
+		CounterTo:   int(request.GetCounterTo()),
+		From:        fromTimestamp(request.GetCreatedFrom()),
+		To:          fromTimestamp(request.GetCreatedTo()),
+	}
+	if err := validate(listRequest); err != nil {
+		return nil, err
+	}
+
+	pageRequest, err := parsePageRequest(request.GetPage())
+	if err != nil {
+		return nil, err
+	}
+
+	signatures, err := server.signatureService.ListByDevice(ctx, request.GetDeviceId(), dto.NewSignatureQuery(listRequest), pageRequest)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	response := &signingpb.ListSignaturesResponse{Page: newPageInfo(signatures)}
+	for i := range signatures.Items {
+		response.Items = append(response.Items, newSignature(&signatures.Items[i]))
+	}
+
+	return response, nil
+}
+
+func (server *signatureServer) DecommissionDevice(ctx context.Context, request *signingpb.ChangeDeviceStateRequest) (*signingpb.DecommissionDeviceResponse, error) {
+	if err := validate(dto.DeviceStateChangeRequest{Reason: request.GetReason()}); err != nil {
+		return nil, err
+	}
+
+	device, closingSignature, err := server.signatureService.Decommission(ctx, request.GetUuid(), request.GetReason())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &signingpb.DecommissionDeviceResponse{
+		Device:           newDevice(device),
+		ClosingSignature: newSignature(closingSignature),
+	}, nil
+}
+
+func (server *signatureServer) AuditChain(ctx context.Context, request *signingpb.AuditChainRequest) (*signingpb.ChainAudit, error) {
+	audit, err := server.signatureService.Audit(ctx, request.GetDeviceId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return newChainAudit(audit), nil
+}
+
+// Unlike the HTTP download the export is streamed as it is written: a failure
+// ends the stream with an error status, which clients can't mistake for the
+// end of the chain.
+func (server *signatureServer) ExportChain(request *signingpb.ExportChainRequest, stream grpc.ServerStreamingServer[signingpb.ChainChunk]) error {
+	chunks := bufio.NewWriterSize(chunkWriter{stream: stream}, exportChunkSize)
+
+	if err := server.signatureService.ExportChain(stream.Context(), request.GetDeviceId(), chunks); err != nil {
+		return toStatus(err)
+	}
+
+	if err := chunks.Flush(); err != nil {
+		return toStatus(err)
+	}
+
+	return nil
+}
+
+type chunkWriter struct {
+	stream grpc.ServerStreamingServer[signingpb.ChainChunk]
+}
+
+func (writer chunkWriter) Write(data []byte) (int, error) {
+	// the buffer is reused by the caller, the message is sent before returning
+	if err := writer.stream.Send(&signingpb.ChainChunk{Data: data}); err != nil {
+		return 0, fmt.Errorf("failed to send the chain: %w", err)
+	}
+
+	return len(data), nil
+}
@@ -0,0 +1,752 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v3.5.1-go
+// source: signing/v0/signing.proto
+
+// gRPC version of the /api/v0 HTTP API. Both transports call the same
+// services, so scopes, tenants, limits and the audit log work the same way.
+//
+// Requests are authenticated with the API key in the `authorization`
+// (`Bearer <key>`) or `x-api-key` metadata, and may carry an `x-request-id`.
+
+package signingpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	DeviceService_CreateDevice_FullMethodName   = "/signing.v0.DeviceService/CreateDevice"
+	DeviceService_GetDevice_FullMethodName      = "/signing.v0.DeviceService/GetDevice"
+	DeviceService_ListDevices_FullMethodName    = "/signing.v0.DeviceService/ListDevices"
+	DeviceService_UpdateDevice_FullMethodName   = "/signing.v0.DeviceService/UpdateDevice"
+	DeviceService_SuspendDevice_FullMethodName  = "/signing.v0.DeviceService/SuspendDevice"
+	DeviceService_ActivateDevice_FullMethodName = "/signing.v0.DeviceService/ActivateDevice"
+)
+
+// DeviceServiceClient is the client API for DeviceService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DeviceServiceClient interface {
+	CreateDevice(ctx context.Context, in *CreateDeviceRequest, opts ...grpc.CallOption) (*Device, error)
+	// NOT_FOUND when the device does not exist in the tenant of the key.
+	GetDevice(ctx context.Context, in *GetDeviceRequest, opts ...grpc.CallOption) (*Device, error)
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	// Changes the label or tags if the revision of the device still is the expected one.
+	UpdateDevice(ctx context.Context, in *UpdateDeviceRequest, opts ...grpc.CallOption) (*Device, error)
+	SuspendDevice(ctx context.Context, in *ChangeDeviceStateRequest, opts ...grpc.CallOption) (*Device, error)
+	ActivateDevice(ctx context.Context, in *ChangeDeviceStateRequest, opts ...grpc.CallOption) (*Device, error)
+}
+
+type deviceServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDeviceServiceClient(cc grpc.ClientConnInterface) DeviceServiceClient {
+	return &deviceServiceClient{cc}
+}
+
+func (c *deviceServiceClient) CreateDevice(ctx context.Context, in *CreateDeviceRequest, opts ...grpc.CallOption) (*Device, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Device)
+	err := c.cc.Invoke(ctx, DeviceService_CreateDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deviceServiceClient) GetDevice(ctx context.Context, in *GetDeviceRequest, opts ...grpc.CallOption) (*Device, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Device)
+	err := c.cc.Invoke(ctx, DeviceService_GetDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deviceServiceClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, DeviceService_ListDevices_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deviceServiceClient) UpdateDevice(ctx context.Context, in *UpdateDeviceRequest, opts ...grpc.CallOption) (*Device, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Device)
+	err := c.cc.Invoke(ctx, DeviceService_UpdateDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deviceServiceClient) SuspendDevice(ctx context.Context, in *ChangeDeviceStateRequest, opts ...grpc.CallOption) (*Device, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Device)
+	err := c.cc.Invoke(ctx, DeviceService_SuspendDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deviceServiceClient) ActivateDevice(ctx context.Context, in *ChangeDeviceStateRequest, opts ...grpc.CallOption) (*Device, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Device)
+	err := c.cc.Invoke(ctx, DeviceService_ActivateDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeviceServiceServer is the server API for DeviceService service.
+// All implementations must embed UnimplementedDeviceServiceServer
+// for forward compatibility.
+type DeviceServiceServer interface {
+	CreateDevice(context.Context, *CreateDeviceRequest) (*Device, error)
+	// NOT_FOUND when the device does not exist in the tenant of the key.
+	GetDevice(context.Context, *GetDeviceRequest) (*Device, error)
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	// Changes the label or tags if the revision of the device still is the expected one.
+	UpdateDevice(context.Context, *UpdateDeviceRequest) (*Device, error)
+	SuspendDevice(context.Context, *ChangeDeviceStateRequest) (*Device, error)
+	ActivateDevice(context.Context, *ChangeDeviceStateRequest) (*Device, error)
+	mustEmbedUnimplementedDeviceServiceServer()
+}
+
+// UnimplementedDeviceServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedDeviceServiceServer struct{}
+
+func (UnimplementedDeviceServiceServer) CreateDevice(context.Context, *CreateDeviceRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateDevice not implemented")
+}
+func (UnimplementedDeviceServiceServer) GetDevice(context.Context, *GetDeviceRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDevice not implemented")
+}
+func (UnimplementedDeviceServiceServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedDeviceServiceServer) UpdateDevice(context.Context, *UpdateDeviceRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateDevice not implemented")
+}
+func (UnimplementedDeviceServiceServer) SuspendDevice(context.Context, *ChangeDeviceStateRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SuspendDevice not implemented")
+}
+func (UnimplementedDeviceServiceServer) ActivateDevice(context.Context, *ChangeDeviceStateRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ActivateDevice not implemented")
+}
+func (UnimplementedDeviceServiceServer) mustEmbedUnimplementedDeviceServiceServer() {}
+func (UnimplementedDeviceServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeDeviceServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DeviceServiceServer will
+// result in compilation errors.
+type UnsafeDeviceServiceServer interface {
+	mustEmbedUnimplementedDeviceServiceServer()
+}
+
+func RegisterDeviceServiceServer(s grpc.ServiceRegistrar, srv DeviceServiceServer) {
+	// If the following call pancis, it indicates UnimplementedDeviceServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&DeviceService_ServiceDesc, srv)
+}
+
+func _DeviceService_CreateDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateDeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).CreateDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeviceService_CreateDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).CreateDevice(ctx, req.(*CreateDeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeviceService_GetDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).GetDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeviceService_GetDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).GetDevice(ctx, req.(*GetDeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeviceService_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeviceService_ListDevices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeviceService_UpdateDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateDeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).UpdateDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeviceService_UpdateDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).UpdateDevice(ctx, req.(*UpdateDeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeviceService_SuspendDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangeDeviceStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).SuspendDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeviceService_SuspendDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).SuspendDevice(ctx, req.(*ChangeDeviceStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeviceService_ActivateDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangeDeviceStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).ActivateDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeviceService_ActivateDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).ActivateDevice(ctx, req.(*ChangeDeviceStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// DeviceService_ServiceDesc is the grpc.ServiceDesc for DeviceService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DeviceService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "signing.v0.DeviceService",
+	HandlerType: (*DeviceServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateDevice",
+			Handler:    _DeviceService_CreateDevice_Handler,
+		},
+		{
+			MethodName: "GetDevice",
+			Handler:    _DeviceService_GetDevice_Handler,
+		},
+		{
+			MethodName: "ListDevices",
+			Handler:    _DeviceService_ListDevices_Handler,
+		},
+		{
+			MethodName: "UpdateDevice",
+			Handler:    _DeviceService_UpdateDevice_Handler,
+		},
+		{
+			MethodName: "SuspendDevice",
+			Handler:    _DeviceService_SuspendDevice_Handler,
+		},
+		{
+			MethodName: "ActivateDevice",
+			Handler:    _DeviceService_ActivateDevice_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "signing/v0/signing.proto",
+}
+
+const (
+	SignatureService_Sign_FullMethodName                 = "/signing.v0.SignatureService/Sign"
+	SignatureService_SignStream_FullMethodName           = "/signing.v0.SignatureService/SignStream"
+	SignatureService_Verify_FullMethodName               = "/signing.v0.SignatureService/Verify"
+	SignatureService_GetSignature_FullMethodName         = "/signing.v0.SignatureService/GetSignature"
+	SignatureService_ListSignatures_FullMethodName       = "/signing.v0.SignatureService/ListSignatures"
+	SignatureService_ListDeviceSignatures_FullMethodName = "/signing.v0.SignatureService/ListDeviceSignatures"
+	SignatureService_DecommissionDevice_FullMethodName   = "/signing.v0.SignatureService/DecommissionDevice"
+	SignatureService_AuditChain_FullMethodName           = "/signing.v0.SignatureService/AuditChain"
+	SignatureService_ExportChain_FullMethodName          = "/signing.v0.SignatureService/ExportChain"
+)
+
+// SignatureServiceClient is the client API for SignatureService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SignatureServiceClient interface {
+	Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*Signature, error)
+	// Signs every item of the request in order with the same device and streams
+	// the signatures back as they are made. On error the stream ends with its
+	// status, the signatures already sent are part of the chain. With an
+	// idempotency-key in the metadata, item i (from 0) is signed with the key
+	// "<key>/<i>", so retrying the whole request only signs the items that were
+	// not signed yet.
+	SignStream(ctx context.Context, in *SignStreamRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Signature], error)
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+	GetSignature(ctx context.Context, in *GetSignatureRequest, opts ...grpc.CallOption) (*Signature, error)
+	ListSignatures(ctx context.Context, in *ListSignaturesRequest, opts ...grpc.CallOption) (*ListSignaturesResponse, error)
+	ListDeviceSignatures(ctx context.Context, in *ListDeviceSignaturesRequest, opts ...grpc.CallOption) (*ListSignaturesResponse, error)
+	// Closes the chain of the device with a last signature, it never signs again.
+	DecommissionDevice(ctx context.Context, in *ChangeDeviceStateRequest, opts ...grpc.CallOption) (*DecommissionDeviceResponse, error)
+	// Walks the whole chain of the device re-verifying every signature and link.
+	AuditChain(ctx context.Context, in *AuditChainRequest, opts ...grpc.CallOption) (*ChainAudit, error)
+	// The JSON lines export of the HTTP API, to be checked offline with cmd/verify.
+	ExportChain(ctx context.Context, in *ExportChainRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChainChunk], error)
+}
+
+type signatureServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSignatureServiceClient(cc grpc.ClientConnInterface) SignatureServiceClient {
+	return &signatureServiceClient{cc}
+}
+
+func (c *signatureServiceClient) Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*Signature, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Signature)
+	err := c.cc.Invoke(ctx, SignatureService_Sign_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signatureServiceClient) SignStream(ctx context.Context, in *SignStreamRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Signature], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SignatureService_ServiceDesc.Streams[0], SignatureService_SignStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SignStreamRequest, Signature]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignatureService_SignStreamClient = grpc.ServerStreamingClient[Signature]
+
+func (c *signatureServiceClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, SignatureService_Verify_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signatureServiceClient) GetSignature(ctx context.Context, in *GetSignatureRequest, opts ...grpc.CallOption) (*Signature, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Signature)
+	err := c.cc.Invoke(ctx, SignatureService_GetSignature_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signatureServiceClient) ListSignatures(ctx context.Context, in *ListSignaturesRequest, opts ...grpc.CallOption) (*ListSignaturesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSignaturesResponse)
+	err := c.cc.Invoke(ctx, SignatureService_ListSignatures_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signatureServiceClient) ListDeviceSignatures(ctx context.Context, in *ListDeviceSignaturesRequest, opts ...grpc.CallOption) (*ListSignaturesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSignaturesResponse)
+	err := c.cc.Invoke(ctx, SignatureService_ListDeviceSignatures_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signatureServiceClient) DecommissionDevice(ctx context.Context, in *ChangeDeviceStateRequest, opts ...grpc.CallOption) (*DecommissionDeviceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DecommissionDeviceResponse)
+	err := c.cc.Invoke(ctx, SignatureService_DecommissionDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signatureServiceClient) AuditChain(ctx context.Context, in *AuditChainRequest, opts ...grpc.CallOption) (*ChainAudit, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ChainAudit)
+	err := c.cc.Invoke(ctx, SignatureService_AuditChain_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signatureServiceClient) ExportChain(ctx context.Context, in *ExportChainRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChainChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SignatureService_ServiceDesc.Streams[1], SignatureService_ExportChain_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExportChainRequest, ChainChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignatureService_ExportChainClient = grpc.ServerStreamingClient[ChainChunk]
+
+// SignatureServiceServer is the server API for SignatureService service.
+// All implementations must embed UnimplementedSignatureServiceServer
+// for forward compatibility.
+type SignatureServiceServer interface {
+	Sign(context.Context, *SignRequest) (*Signature, error)
+	// Signs every item of the request in order with the same device and streams
+	// the signatures back as they are made. On error the stream ends with its
+	// status, the signatures already sent are part of the chain. With an
+	// idempotency-key in the metadata, item i (from 0) is signed with the key
+	// "<key>/<i>", so retrying the whole request only signs the items that were
+	// not signed yet.
+	SignStream(*SignStreamRequest, grpc.ServerStreamingServer[Signature]) error
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	GetSignature(context.Context, *GetSignatureRequest) (*Signature, error)
+	ListSignatures(context.Context, *ListSignaturesRequest) (*ListSignaturesResponse, error)
+	ListDeviceSignatures(context.Context, *ListDeviceSignaturesRequest) (*ListSignaturesResponse, error)
+	// Closes the chain of the device with a last signature, it never signs again.
+	DecommissionDevice(context.Context, *ChangeDeviceStateRequest) (*DecommissionDeviceResponse, error)
+	// Walks the whole chain of the device re-verifying every signature and link.
+	AuditChain(context.Context, *AuditChainRequest) (*ChainAudit, error)
+	// The JSON lines export of the HTTP API, to be checked offline with cmd/verify.
+	ExportChain(*ExportChainRequest, grpc.ServerStreamingServer[ChainChunk]) error
+	mustEmbedUnimplementedSignatureServiceServer()
+}
+
+// UnimplementedSignatureServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSignatureServiceServer struct{}
+
+func (UnimplementedSignatureServiceServer) Sign(context.Context, *SignRequest) (*Signature, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Sign not implemented")
+}
+func (UnimplementedSignatureServiceServer) SignStream(*SignStreamRequest, grpc.ServerStreamingServer[Signature]) error {
+	return status.Errorf(codes.Unimplemented, "method SignStream not implemented")
+}
+func (UnimplementedSignatureServiceServer) Verify(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedSignatureServiceServer) GetSignature(context.Context, *GetSignatureRequest) (*Signature, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSignature not implemented")
+}
+func (UnimplementedSignatureServiceServer) ListSignatures(context.Context, *ListSignaturesRequest) (*ListSignaturesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSignatures not implemented")
+}
+func (UnimplementedSignatureServiceServer) ListDeviceSignatures(context.Context, *ListDeviceSignaturesRequest) (*ListSignaturesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDeviceSignatures not implemented")
+}
+func (UnimplementedSignatureServiceServer) DecommissionDevice(context.Context, *ChangeDeviceStateRequest) (*DecommissionDeviceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DecommissionDevice not implemented")
+}
+func (UnimplementedSignatureServiceServer) AuditChain(context.Context, *AuditChainRequest) (*ChainAudit, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AuditChain not implemented")
+}
+func (UnimplementedSignatureServiceServer) ExportChain(*ExportChainRequest, grpc.ServerStreamingServer[ChainChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method ExportChain not implemented")
+}
+func (UnimplementedSignatureServiceServer) mustEmbedUnimplementedSignatureServiceServer() {}
+func (UnimplementedSignatureServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeSignatureServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SignatureServiceServer will
+// result in compilation errors.
+type UnsafeSignatureServiceServer interface {
+	mustEmbedUnimplementedSignatureServiceServer()
+}
+
+func RegisterSignatureServiceServer(s grpc.ServiceRegistrar, srv SignatureServiceServer) {
+	// If the following call pancis, it indicates UnimplementedSignatureServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SignatureService_ServiceDesc, srv)
+}
+
+func _SignatureService_Sign_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignatureServiceServer).Sign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SignatureService_Sign_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignatureServiceServer).Sign(ctx, req.(*SignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SignatureService_SignStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SignStreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SignatureServiceServer).SignStream(m, &grpc.GenericServerStream[SignStreamRequest, Signature]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignatureService_SignStreamServer = grpc.ServerStreamingServer[Signature]
+
+func _SignatureService_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignatureServiceServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SignatureService_Verify_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignatureServiceServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SignatureService_GetSignature_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSignatureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignatureServiceServer).GetSignature(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SignatureService_GetSignature_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignatureServiceServer).GetSignature(ctx, req.(*GetSignatureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SignatureService_ListSignatures_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSignaturesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignatureServiceServer).ListSignatures(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SignatureService_ListSignatures_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignatureServiceServer).ListSignatures(ctx, req.(*ListSignaturesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SignatureService_ListDeviceSignatures_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDeviceSignaturesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignatureServiceServer).ListDeviceSignatures(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SignatureService_ListDeviceSignatures_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignatureServiceServer).ListDeviceSignatures(ctx, req.(*ListDeviceSignaturesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SignatureService_DecommissionDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangeDeviceStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignatureServiceServer).DecommissionDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SignatureService_DecommissionDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignatureServiceServer).DecommissionDevice(ctx, req.(*ChangeDeviceStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SignatureService_AuditChain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuditChainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignatureServiceServer).AuditChain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SignatureService_AuditChain_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignatureServiceServer).AuditChain(ctx, req.(*AuditChainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SignatureService_ExportChain_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportChainRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SignatureServiceServer).ExportChain(m, &grpc.GenericServerStream[ExportChainRequest, ChainChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignatureService_ExportChainServer = grpc.ServerStreamingServer[ChainChunk]
+
+// SignatureService_ServiceDesc is the grpc.ServiceDesc for SignatureService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SignatureService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "signing.v0.SignatureService",
+	HandlerType: (*SignatureServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Sign",
+			Handler:    _SignatureService_Sign_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _SignatureService_Verify_Handler,
+		},
+		{
+			MethodName: "GetSignature",
+			Handler:    _SignatureService_GetSignature_Handler,
+		},
+		{
+			MethodName: "ListSignatures",
+			Handler:    _SignatureService_ListSignatures_Handler,
+		},
+		{
+			MethodName: "ListDeviceSignatures",
+			Handler:    _SignatureService_ListDeviceSignatures_Handler,
+		},
+		{
+			MethodName: "DecommissionDevice",
+			Handler:    _SignatureService_DecommissionDevice_Handler,
+		},
+		{
+			MethodName: "AuditChain",
+			Handler:    _SignatureService_AuditChain_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SignStream",
+			Handler:       _SignatureService_SignStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ExportChain",
+			Handler:       _SignatureService_ExportChain_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "signing/v0/signing.proto",
+}
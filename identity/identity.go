@@ -0,0 +1,73 @@
+package identity
+
+import (
+	"context"
+	"slices"
+)
+
+// Name used when a request does not say who is making it.
+const Anonymous = "anonymous"
+
+// Identity is who is making a request. The services read it from the context
+// to record who changed what, the transport layer is in charge of setting it.
+type Identity struct {
+	Name string
+	// The API key the request was authenticated with, empty for calls that
+	// did not come through the API (e.g. a restore on startup).
+	KeyID string
+	// Scopes of the key, including the ones granted by its roles.
+	Scopes []string
+	// When not empty the request can only use these devices.
+	Devices []string
+	// Services only show and change the data of this tenant. It is empty
+	// for calls not made on behalf of a tenant, which see every tenant.
+	TenantID string
+}
+
+func (identity Identity) IsAuthenticated() bool {
+	return identity.KeyID != ""
+}
+
+func (identity Identity) HasScope(scope string) bool {
+	return slices.Contains(identity.Scopes, scope)
+}
+
+func (identity Identity) CanUseDevice(deviceId string) bool {
+	return len(identity.Devices) == 0 || slices.Contains(identity.Devices, deviceId)
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// Returns the identity of the request, or the anonymous one if none was set.
+func FromContext(ctx context.Context) Identity {
+	identity, ok := ctx.Value(contextKey{}).(Identity)
+	if !ok || identity.Name == "" {
+		return Identity{Name: Anonymous}
+	}
+
+	return identity
+}
+
+// Certificate is the client certificate a request was made with, over mutual
+// TLS. It is set by the transport along with the identity of the API key.
+type Certificate struct {
+	Subject string
+	// UUIDs of the devices the certificate is bound to.
+	Devices []string
+}
+
+type certificateContextKey struct{}
+
+func NewCertificateContext(ctx context.Context, certificate Certificate) context.Context {
+	return context.WithValue(ctx, certificateContextKey{}, certificate)
+}
+
+// The second value is false when the request came without a client certificate.
+func CertificateFromContext(ctx context.Context) (Certificate, bool) {
+	certificate, ok := ctx.Value(certificateContextKey{}).(Certificate)
+	return certificate, ok
+}
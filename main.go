@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/chuckiihub/signing-service/api"
 	"github.com/chuckiihub/signing-service/config"
+	"github.com/chuckiihub/signing-service/grpcapi"
+	"github.com/chuckiihub/signing-service/identity"
 	"github.com/chuckiihub/signing-service/persistence"
 	"github.com/chuckiihub/signing-service/service"
+	"github.com/chuckiihub/signing-service/tlsconfig"
+	"github.com/redis/go-redis/v9"
 )
 
 func configureLogging() {
@@ -18,15 +29,145 @@ func configureLogging() {
 func main() {
 	configureLogging()
 
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		os.Exit(runBackupCommand(os.Args[2:]))
+	}
+
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	restoreFile := flags.String("restore", "", "backup archive to restore before starting the server")
+	flags.Parse(os.Args[1:])
+
 	devicePersistence := persistence.NewVolatileDeviceRepository()
-	signaturePersistence := persistence.NewVolatileSignatureRepository()
-	lockService := service.NewVolatileLockService()
 
-	deviceService := service.NewDeviceService(devicePersistence, config.ListPageSize)
-	signatureService := service.NewSignatureService(devicePersistence, signaturePersistence, lockService, config.ListPageSize)
+	var lockService service.LockService = service.NewVolatileLockService(config.LockWaitTimeout)
+	if redisAddress := config.GetRedisAddress(); redisAddress != "" {
+		redisClient := redis.NewClient(&redis.Options{Addr: redisAddress})
+		lockService = service.NewRedisLockService(redisClient, config.LockLeaseTTL, config.LockWaitTimeout)
+
+		// devicePersistence is in memory, the lock and its fencing tokens only
+		// protect the chains once it is shared by the replicas
+		slog.Warn("Redis locks are used with in-memory devices: every replica has its own devices and chains, so running more than one replica is not safe", "redisAddress", redisAddress)
+	}
+
+	idempotencyWindow := config.GetIdempotencyWindow(config.DefaultIdempotencyWindow)
+
+	var signaturePersistence persistence.SignaturePersistance = persistence.NewVolatileSignatureRepository()
+	if archiveDirectory := config.GetArchiveDirectory(); archiveDirectory != "" {
+		hotSignatures := persistence.NewVolatileSignatureRepository()
+		archive, err := persistence.NewFileSignatureArchive(archiveDirectory)
+		if err != nil {
+			slog.Error("could not open signature archive", "directory", archiveDirectory, "error", err.Error())
+			os.Exit(1)
+		}
+
+		signaturePersistence = persistence.NewSignatureTieredRepository(hotSignatures, archive)
+
+		hotRetention := time.Duration(config.GetHotRetentionDays(config.DefaultHotRetention)) * 24 * time.Hour
+		if idempotencyWindow > hotRetention {
+			// idempotency keys are only looked up in the hot storage
+			slog.Warn("idempotency keys are forgotten once their signature is archived, before the end of the window", "window", idempotencyWindow, "hotRetention", hotRetention)
+		}
+		retentionService := service.NewRetentionService(hotSignatures, archive, hotRetention)
+		go retentionService.Run(context.Background(), config.RetentionInterval)
+	}
+
+	pageLimits := service.PageLimits{
+		Default: config.DefaultListPageSize,
+		Max:     config.GetMaxListPageSize(config.MaxListPageSize),
+	}
+
+	// the services publish what they change, for the /api/v0/events subscribers
+	// and the webhooks
+	eventBus := service.NewEventBus(config.EventSubscriberBuffer, config.RecentDeviceEvents)
+	webhookService := service.NewWebhookService(persistence.NewVolatileWebhookRepository(), pageLimits, service.RetryPolicy{
+		MaxAttempts:    config.WebhookMaxAttempts,
+		InitialBackoff: config.WebhookInitialBackoff,
+		MaxBackoff:     config.WebhookMaxBackoff,
+	}, config.WebhookQueueSize, config.WebhookTimeout, api.EncodeEvent, config.GetWebhookAllowedNetworks())
+	go webhookService.Run(context.Background())
+	events := service.EventPublishers{eventBus, webhookService}
+
+	deviceService := service.NewDeviceService(devicePersistence, lockService, pageLimits, events)
+	signatureService := service.NewSignatureService(devicePersistence, signaturePersistence, lockService, pageLimits, events)
+	if config.GetSigningMode() == config.SigningModeOptimistic {
+		retryPolicy := service.RetryPolicy{
+			MaxAttempts:    config.OptimisticSignAttempts,
+			InitialBackoff: config.OptimisticInitialBackoff,
+			MaxBackoff:     config.OptimisticMaxBackoff,
+		}
+		deviceService = service.NewOptimisticDeviceService(devicePersistence, pageLimits, retryPolicy, events)
+		signatureService = service.NewOptimisticSignatureService(devicePersistence, signaturePersistence, pageLimits, retryPolicy, events)
+	}
+	backupService := service.NewBackupService(devicePersistence, signaturePersistence)
+
+	auditLogService, err := newAuditLogService(pageLimits)
+	if err != nil {
+		slog.Error("could not start the audit log", "error", err.Error())
+		os.Exit(1)
+	}
+	go auditLogService.Run(context.Background(), config.AuditCheckpointEvery)
+
+	tenants, err := loadTenants()
+	if err != nil {
+		slog.Error("could not load the tenants", "error", err.Error())
+		os.Exit(1)
+	}
+
+	tlsConfig := config.GetTLSConfig()
+
+	accessPolicy, err := loadAccessPolicy()
+	if err != nil {
+		slog.Error("could not load the access policy", "error", err.Error())
+		os.Exit(1)
+	}
+
+	apiKeyService := service.NewAPIKeyService(persistence.NewVolatileAPIKeyRepository(), tenants, accessPolicy, pageLimits, config.AuthMaxFailures, config.AuthFailureWindow)
+	if err := bootstrapAPIKey(apiKeyService); err != nil {
+		slog.Error("could not create the bootstrap API key", "error", err.Error())
+		os.Exit(1)
+	}
+
+	// Calls are authorized before the tenant limits are applied, and denied
+	// ones are audited too.
+	deviceService = service.NewAuditedDeviceService(service.NewAuthorizedDeviceService(service.NewTenantDeviceService(deviceService, tenants)), auditLogService)
+	// Retries with an idempotency key are answered before the tenant limits,
+	// they don't sign anything.
+	signatureService = service.NewIdempotentSignatureService(service.NewTenantSignatureService(signatureService, tenants), signaturePersistence, idempotencyWindow)
+	if tlsConfig.MutualTLS() {
+		signatureService = service.NewCertificateBoundSignatureService(signatureService)
+	}
+	signatureService = service.NewAuditedSignatureService(service.NewAuthorizedSignatureService(signatureService), auditLogService)
+	backupService = service.NewAuditedBackupService(service.NewAuthorizedBackupService(backupService), auditLogService)
+	auditedAPIKeyService := service.NewAuditedAPIKeyService(service.NewAuthorizedAPIKeyService(apiKeyService), auditLogService)
+	auditedWebhookService := service.NewAuditedWebhookService(service.NewAuthorizedWebhookService(webhookService), auditLogService)
+
+	if *restoreFile != "" {
+		if err := restoreBackupFile(backupService, *restoreFile); err != nil {
+			slog.Error("could not restore backup", "file", *restoreFile, "error", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	serverTLS, err := newServerTLS(tlsConfig)
+	if err != nil {
+		slog.Error("could not configure TLS", "error", err.Error())
+		os.Exit(1)
+	}
+
+	// both transports share the services, so the limits, tenants and audit log are the same
+	grpcListenAddress := config.GetGRPCListenAddress(config.DefaultGRPCListenAddress)
+	grpcServer := grpcapi.NewServer(grpcListenAddress, deviceService, signatureService, auditedAPIKeyService, serverTLS)
+	go func() {
+		if err := grpcServer.Run(); err != nil {
+			slog.Error("could not start gRPC server", "port", grpcListenAddress, "error", err.Error())
+			os.Exit(1)
+		}
+	}()
 
 	listenAddress := config.GetListenAddress(config.DefaultListenAddress)
-	server := api.NewServer(listenAddress, deviceService, signatureService)
+	signingSessionService := service.NewSigningSessionService(signatureService, config.SigningSessionWindow, config.SigningSessionTTL)
+	eventService := service.NewAuthorizedEventService(service.NewEventService(eventBus, devicePersistence, signaturePersistence))
+	server := api.NewServer(listenAddress, deviceService, signatureService, backupService, service.NewAuthorizedAuditLogService(auditLogService), auditedAPIKeyService, signingSessionService, eventService, auditedWebhookService, serverTLS)
 
 	if err := server.Run(); err != nil {
 		slog.Error("could not start server", "port", listenAddress, "error", err.Error())
@@ -34,3 +175,90 @@ func main() {
 		slog.Info("server started", "port", listenAddress)
 	}
 }
+
+// The checkpoints of the audit log are signed with the key from
+// SIGNING_SERVICE_AUDIT_KEY_FILE. Without it a key is generated, which is
+// enough to detect tampering while the service runs, as is the log itself.
+func newAuditLogService(pageLimits service.PageLimits) (*service.AuditLogServiceImplementation, error) {
+	var privateKey []byte
+	var err error
+
+	if keyFile := config.GetAuditKeyFile(); keyFile != "" {
+		privateKey, err = os.ReadFile(keyFile)
+	} else {
+		slog.Warn("no audit log key configured, generating one")
+		privateKey, err = service.GenerateAuditLogKey(context.Background())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return service.NewAuditLogService(persistence.NewVolatileAuditLogRepository(), privateKey, pageLimits)
+}
+
+// Without a tenants file there is only the default tenant, with no limits.
+func loadTenants() (*persistence.VolatileTenantRepository, error) {
+	if tenantsFile := config.GetTenantsFile(); tenantsFile != "" {
+		return persistence.LoadTenantsFile(tenantsFile)
+	}
+
+	return persistence.NewVolatileTenantRepository(nil)
+}
+
+// Without a certificate the server listens on plain HTTP. The certificates are
+// read again on SIGHUP, so they can be renewed without downtime.
+func newServerTLS(tlsConfig tlsconfig.Config) (*tls.Config, error) {
+	if tlsConfig.CertFile == "" {
+		if tlsConfig.MutualTLS() {
+			return nil, errors.New("mutual TLS needs a server certificate")
+		}
+		return nil, nil
+	}
+
+	reloader, err := tlsconfig.NewReloader(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := reloader.Reload(); err != nil {
+				slog.Error("could not reload the TLS certificates, keeping the current ones", "error", err.Error())
+				continue
+			}
+			slog.Info("reloaded the TLS certificates")
+		}
+	}()
+
+	return reloader.TLSConfig(), nil
+}
+
+// Without an access policy file the default roles are used.
+func loadAccessPolicy() (*service.AccessPolicy, error) {
+	if policyFile := config.GetAccessPolicyFile(); policyFile != "" {
+		return service.LoadAccessPolicyFile(policyFile)
+	}
+
+	return service.DefaultAccessPolicy(), nil
+}
+
+// Keys live in memory like everything else, so the service needs a key to start
+// with. Without SIGNING_SERVICE_BOOTSTRAP_API_KEY one is generated and logged,
+// which is only fine for development.
+func bootstrapAPIKey(apiKeyService *service.APIKeyServiceImplementation) error {
+	ctx := identity.NewContext(context.Background(), identity.Identity{Name: "startup"})
+	configuredKey := config.GetBootstrapAPIKey()
+
+	key, secret, err := apiKeyService.Bootstrap(ctx, configuredKey)
+	if err != nil {
+		return err
+	}
+
+	if configuredKey == "" {
+		slog.Warn("no bootstrap API key configured, generated one with every scope", "keyId", key.ID, "key", secret)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+func TestAPIKeyIDsAndNamesAreUnique(t *testing.T) {
+	repository := NewVolatileAPIKeyRepository()
+
+	if err := repository.Create(context.Background(), &domain.APIKey{ID: "a", Name: "till"}); err != nil {
+		t.Fatalf("Error while creating %v", err)
+	}
+
+	if err := repository.Create(context.Background(), &domain.APIKey{ID: "a", Name: "other"}); !errors.Is(err, ErrDuplicateAPIKey) {
+		t.Fatalf("Expected a duplicate ID error, got %v", err)
+	}
+
+	if err := repository.Create(context.Background(), &domain.APIKey{ID: "b", Name: "till"}); !errors.Is(err, ErrDuplicateAPIKey) {
+		t.Fatalf("Expected a duplicate name error, got %v", err)
+	}
+}
+
+func TestAPIKeyUpdateDoesNotShareState(t *testing.T) {
+	repository := NewVolatileAPIKeyRepository()
+	key := &domain.APIKey{ID: "a", Name: "till", Scopes: []string{domain.ScopeSign}}
+	repository.Create(context.Background(), key)
+
+	// changing the caller's copy must not change the stored key
+	key.Scopes[0] = domain.ScopeAdmin
+
+	stored, _ := repository.FindByID(context.Background(), "a")
+	if stored.Scopes[0] != domain.ScopeSign {
+		t.Fatalf("The stored key was changed through the caller's copy: %v", stored.Scopes)
+	}
+
+	revokedAt := time.Now()
+	stored.RevokedAt = &revokedAt
+	if err := repository.Update(context.Background(), stored); err != nil {
+		t.Fatalf("Error while updating %v", err)
+	}
+
+	page, err := repository.List(context.Background(), AllTenants, PageRequest{Limit: 10})
+	if err != nil || len(page.Items) != 1 || !page.Items[0].IsRevoked() {
+		t.Fatalf("Unexpected listing %+v, %v", page, err)
+	}
+
+	if err := repository.Update(context.Background(), &domain.APIKey{ID: "missing"}); err == nil {
+		t.Fatalf("Updating a missing key should fail")
+	}
+}
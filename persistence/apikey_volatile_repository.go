@@ -0,0 +1,113 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+// Keys are never removed, revoked ones are kept so they can still be listed,
+// so the position in the slice works as a stable cursor.
+type VolatileAPIKeyRepository struct {
+	keys      []domain.APIKey
+	idIndex   map[string]int
+	nameIndex map[string]int
+	rwMutex   sync.RWMutex
+}
+
+func (repository *VolatileAPIKeyRepository) Create(ctx context.Context, key *domain.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repository.rwMutex.Lock()
+	defer repository.rwMutex.Unlock()
+
+	_, idTaken := repository.idIndex[key.ID]
+	_, nameTaken := repository.nameIndex[key.Name]
+	if idTaken || nameTaken {
+		return ErrDuplicateAPIKey
+	}
+
+	repository.keys = append(repository.keys, cloneAPIKey(key))
+	repository.idIndex[key.ID] = len(repository.keys) - 1
+	repository.nameIndex[key.Name] = len(repository.keys) - 1
+
+	return nil
+}
+
+func (repository *VolatileAPIKeyRepository) Update(ctx context.Context, key *domain.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repository.rwMutex.Lock()
+	defer repository.rwMutex.Unlock()
+
+	position, exists := repository.idIndex[key.ID]
+	if !exists {
+		return fmt.Errorf("API key %s does not exist", key.ID)
+	}
+
+	if repository.keys[position].Name != key.Name {
+		return fmt.Errorf("API keys cannot be renamed")
+	}
+
+	repository.keys[position] = cloneAPIKey(key)
+	return nil
+}
+
+func (repository *VolatileAPIKeyRepository) FindByID(ctx context.Context, id string) (*domain.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	repository.rwMutex.RLock()
+	defer repository.rwMutex.RUnlock()
+
+	if position, exists := repository.idIndex[id]; exists {
+		key := cloneAPIKey(&repository.keys[position])
+		return &key, nil
+	}
+
+	return nil, nil
+}
+
+func (repository *VolatileAPIKeyRepository) List(ctx context.Context, tenantID string, pageRequest PageRequest) (Page[domain.APIKey], error) {
+	if err := ctx.Err(); err != nil {
+		return Page[domain.APIKey]{}, err
+	}
+
+	repository.rwMutex.RLock()
+	defer repository.rwMutex.RUnlock()
+
+	page, err := paginateLogMatching(repository.keys, 0, pageRequest, func(key *domain.APIKey) bool {
+		return inTenant(tenantID, key.TenantID)
+	})
+	for i := range page.Items {
+		page.Items[i] = cloneAPIKey(&page.Items[i])
+	}
+
+	return page, err
+}
+
+// The slices and times are pointers into the stored key otherwise.
+func cloneAPIKey(key *domain.APIKey) domain.APIKey {
+	deepCopy := *key
+	deepCopy.Scopes = slices.Clone(key.Scopes)
+	deepCopy.Roles = slices.Clone(key.Roles)
+	deepCopy.Devices = slices.Clone(key.Devices)
+	if key.ExpiresAt != nil {
+		expiresAt := *key.ExpiresAt
+		deepCopy.ExpiresAt = &expiresAt
+	}
+	if key.RevokedAt != nil {
+		revokedAt := *key.RevokedAt
+		deepCopy.RevokedAt = &revokedAt
+	}
+
+	return deepCopy
+}
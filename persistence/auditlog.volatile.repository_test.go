@@ -0,0 +1,70 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+func TestAuditLogOnlyAppendsTheNextEvent(t *testing.T) {
+	repository := NewVolatileAuditLogRepository()
+
+	if err := repository.Append(context.Background(), domain.AuditEvent{Sequence: 2}); !errors.Is(err, ErrAuditSequenceConflict) {
+		t.Fatalf("Expected a sequence conflict, got %v", err)
+	}
+
+	if err := repository.Append(context.Background(), domain.AuditEvent{Sequence: 1, Hash: "first"}); err != nil {
+		t.Fatalf("Error while appending %v", err)
+	}
+
+	if err := repository.Append(context.Background(), domain.AuditEvent{Sequence: 1}); !errors.Is(err, ErrAuditSequenceConflict) {
+		t.Fatalf("An event cannot be replaced, got %v", err)
+	}
+
+	last, _ := repository.Last(context.Background())
+	if last.Sequence != 1 || last.Hash != "first" {
+		t.Fatalf("Unexpected last event %+v", last)
+	}
+}
+
+func TestAuditLogListWithFiltersAndPagination(t *testing.T) {
+	repository := NewVolatileAuditLogRepository()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, actor := range []string{"alice", "bob", "alice", "alice", "bob"} {
+		repository.Append(context.Background(), domain.AuditEvent{
+			Sequence: uint64(i + 1),
+			Actor:    actor,
+			Time:     start.Add(time.Duration(i) * time.Hour),
+		})
+	}
+
+	sequences := make([]uint64, 0)
+	pageRequest := PageRequest{Limit: 2, IncludeTotal: true}
+	query := AuditEventQuery{Actor: "alice", From: start.Add(time.Hour)}
+	for {
+		page, err := repository.List(context.Background(), query, pageRequest)
+		if err != nil {
+			t.Fatalf("Error while listing %v", err)
+		}
+
+		if *page.Total != 2 {
+			t.Fatalf("Expected a total of 2, got %d", *page.Total)
+		}
+
+		for _, event := range page.Items {
+			sequences = append(sequences, event.Sequence)
+		}
+
+		if !page.HasMore {
+			break
+		}
+		pageRequest.Cursor = page.NextCursor
+	}
+
+	if len(sequences) != 2 || sequences[0] != 3 || sequences[1] != 4 {
+		t.Fatalf("Expected events 3 and 4, got %v", sequences)
+	}
+}
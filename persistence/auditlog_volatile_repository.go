@@ -0,0 +1,123 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+// Events are kept in a slice in sequence order, sequences start at 1 so the
+// event with sequence n is at n-1. Filtered listings scan the events after the
+// cursor, which is the sequence of the last event returned.
+type VolatileAuditLogRepository struct {
+	events      []domain.AuditEvent
+	checkpoints []domain.AuditCheckpoint
+	rwLock      sync.RWMutex
+}
+
+func (repository *VolatileAuditLogRepository) Append(ctx context.Context, event domain.AuditEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repository.rwLock.Lock()
+	defer repository.rwLock.Unlock()
+
+	if event.Sequence != uint64(len(repository.events))+1 {
+		return fmt.Errorf("%w: got %d after %d", ErrAuditSequenceConflict, event.Sequence, len(repository.events))
+	}
+
+	// the details map would be shared with the caller otherwise
+	event.Details = maps.Clone(event.Details)
+	repository.events = append(repository.events, event)
+
+	return nil
+}
+
+func (repository *VolatileAuditLogRepository) Last(ctx context.Context) (*domain.AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	repository.rwLock.RLock()
+	defer repository.rwLock.RUnlock()
+
+	if len(repository.events) == 0 {
+		return nil, nil
+	}
+
+	last := repository.events[len(repository.events)-1]
+	last.Details = maps.Clone(last.Details)
+	return &last, nil
+}
+
+func (repository *VolatileAuditLogRepository) List(ctx context.Context, query AuditEventQuery, pageRequest PageRequest) (Page[domain.AuditEvent], error) {
+	if err := ctx.Err(); err != nil {
+		return Page[domain.AuditEvent]{}, err
+	}
+
+	repository.rwLock.RLock()
+	defer repository.rwLock.RUnlock()
+
+	cursorSequence, err := validatePageRequest(pageRequest)
+	if err != nil {
+		return Page[domain.AuditEvent]{}, err
+	}
+
+	page := Page[domain.AuditEvent]{Items: make([]domain.AuditEvent, 0, pageRequest.Limit)}
+	if pageRequest.IncludeTotal {
+		total := 0
+		for i := range repository.events {
+			if query.Matches(&repository.events[i]) {
+				total++
+			}
+		}
+		page.Total = &total
+	}
+
+	for i := min(cursorSequence, len(repository.events)); i < len(repository.events); i++ {
+		event := &repository.events[i]
+		if !query.Matches(event) {
+			continue
+		}
+
+		if len(page.Items) == pageRequest.Limit {
+			page.HasMore = true
+			page.NextCursor = encodeCursor(int(page.Items[len(page.Items)-1].Sequence))
+			break
+		}
+
+		deepCopy := *event
+		deepCopy.Details = maps.Clone(event.Details)
+		page.Items = append(page.Items, deepCopy)
+	}
+
+	return page, nil
+}
+
+func (repository *VolatileAuditLogRepository) SaveCheckpoint(ctx context.Context, checkpoint domain.AuditCheckpoint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repository.rwLock.Lock()
+	defer repository.rwLock.Unlock()
+
+	repository.checkpoints = append(repository.checkpoints, checkpoint)
+	return nil
+}
+
+func (repository *VolatileAuditLogRepository) Checkpoints(ctx context.Context) ([]domain.AuditCheckpoint, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	repository.rwLock.RLock()
+	defer repository.rwLock.RUnlock()
+
+	return slices.Clone(repository.checkpoints), nil
+}
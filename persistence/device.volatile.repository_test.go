@@ -1,6 +1,8 @@
 package persistence
 
 import (
+	"context"
+	"slices"
 	"strconv"
 	"testing"
 
@@ -15,7 +17,7 @@ func TestSaveWithoutUUID(t *testing.T) {
 		Algorithm: crypto.SignatureAlgorithmRSA,
 	}
 
-	savedDevice, err := memoryStorage.Save(&device)
+	savedDevice, err := memoryStorage.Save(context.Background(), &device)
 
 	if err != nil {
 		t.Fatalf(`Error while saving %v`, err)
@@ -37,8 +39,8 @@ func TestSaveAndGet(t *testing.T) {
 		Algorithm: crypto.SignatureAlgorithmRSA,
 	}
 
-	savedDevice, _ := memoryStorage.Save(device)
-	device, err := memoryStorage.FindByUUID(savedDevice.UUID)
+	savedDevice, _ := memoryStorage.Save(context.Background(), device)
+	device, err := memoryStorage.FindByUUID(context.Background(), AllTenants, savedDevice.UUID)
 
 	if err != nil {
 		t.Fatal("Error while recovering device")
@@ -55,15 +57,15 @@ func TestThatRepositoryDoesNotReturnPointersToInternalStorageStruct(t *testing.T
 
 	memoryStorage := NewVolatileDeviceRepository()
 
-	savedDevice, _ := memoryStorage.Save(&domain.Device{
+	savedDevice, _ := memoryStorage.Save(context.Background(), &domain.Device{
 		Label:     LABEL,
 		Algorithm: crypto.SignatureAlgorithmRSA,
 	})
 
-	modifiedDevice, _ := memoryStorage.FindByUUID(savedDevice.UUID)
+	modifiedDevice, _ := memoryStorage.FindByUUID(context.Background(), AllTenants, savedDevice.UUID)
 	modifiedDevice.Label = MODIFIED_LABEL
 
-	deviceInStorage, _ := memoryStorage.FindByUUID(savedDevice.UUID)
+	deviceInStorage, _ := memoryStorage.FindByUUID(context.Background(), AllTenants, savedDevice.UUID)
 
 	if deviceInStorage.Label == modifiedDevice.Label {
 		t.Fatal("Modifying objects retrieved by in memory repository modifies objects in internal storage media")
@@ -73,65 +75,275 @@ func TestThatRepositoryDoesNotReturnPointersToInternalStorageStruct(t *testing.T
 func TestListDevicesWithPagination(t *testing.T) {
 	memoryStorage := NewVolatileDeviceRepository()
 
-	// Create and save 15 devices
+	// Create and save 3 devices
 	for i := 1; i <= 3; i++ {
 		device := &domain.Device{
 			Label:     "test-device-" + strconv.Itoa(i),
 			Algorithm: crypto.SignatureAlgorithmRSA,
 		}
-		_, err := memoryStorage.Save(device)
+		_, err := memoryStorage.Save(context.Background(), device)
 		if err != nil {
 			t.Fatalf("Error while saving device %d: %v", i, err)
 		}
 	}
 
-	// List devices with page size 2 and check the results
-	pageSize := 2
-	for page := 1; page < 3; page++ {
-		devices, err := memoryStorage.List(page, pageSize)
+	// List devices with limit 2 and follow the cursor
+	pageRequest := PageRequest{Limit: 2, IncludeTotal: true}
+	for pageNumber := 1; pageNumber < 3; pageNumber++ {
+		page, err := memoryStorage.List(context.Background(), AllTenants, pageRequest)
 		if err != nil {
-			t.Fatalf("Error while listing devices on page %d: %v", page, err)
+			t.Fatalf("Error while listing devices on page %d: %v", pageNumber, err)
 		}
 
-		expectedSize := pageSize
-		if page == 2 {
+		expectedSize := pageRequest.Limit
+		if pageNumber == 2 {
 			expectedSize = 1
 		}
 
-		if len(devices) != expectedSize {
-			t.Fatalf("Expected %d devices on page %d, but got %d", expectedSize, page, len(devices))
+		if len(page.Items) != expectedSize {
+			t.Fatalf("Expected %d devices on page %d, but got %d", expectedSize, pageNumber, len(page.Items))
 		}
 
-		for i, device := range devices {
-			expectedLabel := "test-device-" + strconv.Itoa((page-1)*pageSize+i+1)
+		if page.HasMore != (pageNumber == 1) {
+			t.Fatalf("Unexpected hasMore %v on page %d", page.HasMore, pageNumber)
+		}
+
+		if page.Total == nil || *page.Total != 3 {
+			t.Fatalf("Expected a total of 3 devices on page %d", pageNumber)
+		}
+
+		for i, device := range page.Items {
+			expectedLabel := "test-device-" + strconv.Itoa((pageNumber-1)*2+i+1)
 			if device.Label != expectedLabel {
 				t.Fatalf("Expected device label %s, but got %s", expectedLabel, device.Label)
 			}
 		}
+
+		pageRequest.Cursor = page.NextCursor
+	}
+}
+
+func TestListDevicesWithInvalidCursor(t *testing.T) {
+	memoryStorage := NewVolatileDeviceRepository()
+
+	_, err := memoryStorage.List(context.Background(), AllTenants, PageRequest{Cursor: "not a cursor", Limit: 10})
+	if err != ErrInvalidCursor {
+		t.Fatalf("Expected ErrInvalidCursor, but got %v", err)
 	}
 }
 
 func TestListDevicesPaginationOnEmptyPage(t *testing.T) {
 	memoryStorage := NewVolatileDeviceRepository()
 
-	// Create and save 15 devices
+	// Create and save 3 devices
 	for i := 1; i <= 3; i++ {
 		device := &domain.Device{
 			Label:     "test-device-" + strconv.Itoa(i),
 			Algorithm: crypto.SignatureAlgorithmRSA,
 		}
-		_, err := memoryStorage.Save(device)
+		_, err := memoryStorage.Save(context.Background(), device)
 		if err != nil {
 			t.Fatalf("Error while saving device %d: %v", i, err)
 		}
 	}
 
-	devices, err := memoryStorage.List(20, 10)
+	page, err := memoryStorage.List(context.Background(), AllTenants, PageRequest{Cursor: encodeCursor(20), Limit: 10})
 	if err != nil {
 		t.Fatal("Not expecting error when listing with empty page")
 	}
 
-	if len(devices) != 0 {
-		t.Fatal("Expected empty list when listing with invalid page")
+	if len(page.Items) != 0 || page.HasMore {
+		t.Fatal("Expected empty list when listing past the last device")
+	}
+}
+
+func TestSaveRejectsStaleFencingTokens(t *testing.T) {
+	memoryStorage := NewVolatileDeviceRepository()
+
+	savedDevice, _ := memoryStorage.Save(context.Background(), &domain.Device{Label: "test-device", FencingToken: 2})
+
+	staleDevice := *savedDevice
+	staleDevice.FencingToken = 1
+	if _, err := memoryStorage.Save(context.Background(), &staleDevice); err != ErrStaleFencingToken {
+		t.Fatalf("Expected ErrStaleFencingToken, got %v", err)
+	}
+
+	newerDevice := *savedDevice
+	newerDevice.FencingToken = 3
+	if _, err := memoryStorage.Save(context.Background(), &newerDevice); err != nil {
+		t.Fatalf("Not expecting error when saving with a newer token: %v", err)
+	}
+}
+
+func TestSaveIfVersionRejectsConcurrentChanges(t *testing.T) {
+	memoryStorage := NewVolatileDeviceRepository()
+
+	savedDevice, _ := memoryStorage.Save(context.Background(), &domain.Device{Label: "test-device"})
+	if savedDevice.Version != 1 {
+		t.Fatalf("Expected version 1 for a new device, got %d", savedDevice.Version)
+	}
+
+	first := *savedDevice
+	second := *savedDevice
+
+	first.SignatureCounter = 1
+	if _, err := memoryStorage.SaveIfVersion(context.Background(), &first, 1); err != nil {
+		t.Fatalf("Not expecting error when saving the expected version: %v", err)
+	}
+
+	second.SignatureCounter = 1
+	if _, err := memoryStorage.SaveIfVersion(context.Background(), &second, 1); err != ErrVersionConflict {
+		t.Fatalf("Expected ErrVersionConflict, got %v", err)
+	}
+
+	stored, _ := memoryStorage.FindByUUID(context.Background(), AllTenants, savedDevice.UUID)
+	if stored.Version != 2 || stored.SignatureCounter != 1 {
+		t.Fatalf("Expected version 2 with counter 1, got version %d with counter %d", stored.Version, stored.SignatureCounter)
+	}
+}
+
+func TestCancelledContextIsHonored(t *testing.T) {
+	memoryStorage := NewVolatileDeviceRepository()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := memoryStorage.Save(ctx, &domain.Device{Label: "test-device"}); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled when saving, got %v", err)
+	}
+
+	if _, err := memoryStorage.List(ctx, AllTenants, PageRequest{Limit: 10}); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled when listing, got %v", err)
+	}
+
+	page, _ := memoryStorage.List(context.Background(), AllTenants, PageRequest{Limit: 10})
+	if len(page.Items) != 0 {
+		t.Fatal("Expected no device saved with a cancelled context")
+	}
+}
+
+func TestSearchDevicesWithFilters(t *testing.T) {
+	memoryStorage := NewVolatileDeviceRepository()
+	rsa := crypto.SignatureAlgorithmRSA
+	ten := 10
+
+	devices := []domain.Device{
+		{Label: "Store Front", Algorithm: crypto.SignatureAlgorithmRSA, SignatureCounter: 20, Tags: map[string]string{"store": "42"}},
+		{Label: "store back", Algorithm: crypto.SignatureAlgorithmECC, SignatureCounter: 5, Tags: map[string]string{"store": "42"}},
+		{Label: "warehouse", Algorithm: crypto.SignatureAlgorithmRSA, SignatureCounter: 15, State: domain.DeviceStateSuspended},
+	}
+	for i := range devices {
+		memoryStorage.Save(context.Background(), &devices[i])
+	}
+
+	cases := []struct {
+		name     string
+		query    DeviceQuery
+		expected []string
+	}{
+		{"no filters", DeviceQuery{}, []string{"Store Front", "store back", "warehouse"}},
+		{"algorithm", DeviceQuery{Algorithm: &rsa}, []string{"Store Front", "warehouse"}},
+		{"state", DeviceQuery{State: domain.DeviceStateSuspended}, []string{"warehouse"}},
+		{"active includes devices without state", DeviceQuery{State: domain.DeviceStateActive}, []string{"Store Front", "store back"}},
+		{"label ignores case", DeviceQuery{LabelContains: "STORE"}, []string{"Store Front", "store back"}},
+		{"tag value", DeviceQuery{Tags: map[string]string{"store": "42"}}, []string{"Store Front", "store back"}},
+		{"tag key only", DeviceQuery{Tags: map[string]string{"store": ""}}, []string{"Store Front", "store back"}},
+		{"tag with other value", DeviceQuery{Tags: map[string]string{"store": "7"}}, []string{}},
+		{"counter range", DeviceQuery{CounterFrom: &ten}, []string{"Store Front", "warehouse"}},
+		{"combined", DeviceQuery{Algorithm: &rsa, CounterTo: &ten}, []string{}},
+	}
+
+	for _, testCase := range cases {
+		page, err := memoryStorage.Search(context.Background(), testCase.query, PageRequest{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", testCase.name, err)
+		}
+
+		labels := make([]string, 0, len(page.Items))
+		for _, device := range page.Items {
+			labels = append(labels, device.Label)
+		}
+
+		if !slices.Equal(labels, testCase.expected) {
+			t.Fatalf("%s: expected %v, got %v", testCase.name, testCase.expected, labels)
+		}
+	}
+}
+
+func TestSearchDevicesSortedWithPagination(t *testing.T) {
+	memoryStorage := NewVolatileDeviceRepository()
+	for _, counter := range []int{3, 1, 4, 1, 5} {
+		memoryStorage.Save(context.Background(), &domain.Device{
+			Label:            "device-" + strconv.Itoa(counter),
+			Algorithm:        crypto.SignatureAlgorithmRSA,
+			SignatureCounter: counter,
+		})
+	}
+
+	cases := []struct {
+		name     string
+		query    DeviceQuery
+		expected []int
+	}{
+		{"creation", DeviceQuery{}, []int{3, 1, 4, 1, 5}},
+		{"creation desc", DeviceQuery{Order: OrderDescending}, []int{5, 1, 4, 1, 3}},
+		{"counter", DeviceQuery{SortBy: SortDevicesBySignatureCounter}, []int{1, 1, 3, 4, 5}},
+		{"counter desc", DeviceQuery{SortBy: SortDevicesBySignatureCounter, Order: OrderDescending}, []int{5, 4, 3, 1, 1}},
+		{"label desc", DeviceQuery{SortBy: SortDevicesByLabel, Order: OrderDescending}, []int{5, 4, 3, 1, 1}},
+	}
+
+	for _, testCase := range cases {
+		counters := make([]int, 0)
+		pageRequest := PageRequest{Limit: 2}
+
+		for {
+			page, err := memoryStorage.Search(context.Background(), testCase.query, pageRequest)
+			if err != nil {
+				t.Fatalf("%s: unexpected error %v", testCase.name, err)
+			}
+
+			for _, device := range page.Items {
+				counters = append(counters, device.SignatureCounter)
+			}
+
+			if !page.HasMore {
+				break
+			}
+			pageRequest.Cursor = page.NextCursor
+		}
+
+		if !slices.Equal(counters, testCase.expected) {
+			t.Fatalf("%s: expected %v, got %v", testCase.name, testCase.expected, counters)
+		}
+	}
+}
+
+func TestDevicesAreScopedToTheirTenant(t *testing.T) {
+	memoryStorage := NewVolatileDeviceRepository()
+	memoryStorage.Save(context.Background(), &domain.Device{UUID: "acme-device", TenantID: "acme"})
+	memoryStorage.Save(context.Background(), &domain.Device{UUID: "globex-device", TenantID: "globex"})
+	// stored before tenants existed
+	memoryStorage.Save(context.Background(), &domain.Device{UUID: "old-device"})
+
+	if device, _ := memoryStorage.FindByUUID(context.Background(), "globex", "acme-device"); device != nil {
+		t.Fatalf("Found the device of another tenant")
+	}
+
+	if device, _ := memoryStorage.FindByUUID(context.Background(), domain.DefaultTenantID, "old-device"); device == nil {
+		t.Fatalf("Devices without tenant belong to the default one")
+	}
+
+	page, err := memoryStorage.List(context.Background(), "acme", PageRequest{Limit: 10, IncludeTotal: true})
+	if err != nil || len(page.Items) != 1 || page.Items[0].UUID != "acme-device" || *page.Total != 1 {
+		t.Fatalf("Unexpected listing of the tenant %+v, %v", page, err)
+	}
+
+	page, _ = memoryStorage.List(context.Background(), AllTenants, PageRequest{Limit: 10})
+	if len(page.Items) != 3 {
+		t.Fatalf("Expected every device, got %d", len(page.Items))
+	}
+
+	page, _ = memoryStorage.Search(context.Background(), DeviceQuery{TenantID: "globex"}, PageRequest{Limit: 10})
+	if len(page.Items) != 1 || page.Items[0].UUID != "globex-device" {
+		t.Fatalf("Unexpected search of the tenant %+v", page.Items)
 	}
 }
@@ -1,7 +1,9 @@
 package persistence
 
 import (
-	"errors"
+	"context"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/chuckiihub/signing-service/domain"
@@ -21,17 +23,52 @@ type VolatileDeviceRepository struct {
 	rwMutex   sync.RWMutex
 }
 
-func (repository *VolatileDeviceRepository) Save(device *domain.Device) (*domain.Device, error) {
+func (repository *VolatileDeviceRepository) Save(ctx context.Context, device *domain.Device) (*domain.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	repository.rwMutex.Lock()
 	defer repository.rwMutex.Unlock()
 
+	return repository.save(device)
+}
+
+// Check and write happen under the same lock, which is all a compare-and-swap needs.
+func (repository *VolatileDeviceRepository) SaveIfVersion(ctx context.Context, device *domain.Device, expectedVersion uint64) (*domain.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	repository.rwMutex.Lock()
+	defer repository.rwMutex.Unlock()
+
+	var storedVersion uint64
+	if index, exists := repository.uuidIndex[device.UUID]; exists {
+		storedVersion = repository.devices[index].Version
+	}
+
+	if storedVersion != expectedVersion {
+		return nil, ErrVersionConflict
+	}
+
+	return repository.save(device)
+}
+
+// Must be called holding the write lock.
+func (repository *VolatileDeviceRepository) save(device *domain.Device) (*domain.Device, error) {
 	if device.UUID == "" {
 		device.UUID = uuid.NewString()
 	}
 
 	if index, exists := repository.uuidIndex[device.UUID]; exists {
+		if repository.devices[index].FencingToken > device.FencingToken {
+			return nil, ErrStaleFencingToken
+		}
+		device.Version = repository.devices[index].Version + 1
 		repository.devices[index] = *device
 	} else {
+		device.Version = 1
 		repository.devices = append(repository.devices, *device)
 		repository.uuidIndex[device.UUID] = len(repository.devices) - 1
 	}
@@ -39,11 +76,15 @@ func (repository *VolatileDeviceRepository) Save(device *domain.Device) (*domain
 	return device, nil
 }
 
-func (repository *VolatileDeviceRepository) FindByUUID(UUID string) (*domain.Device, error) {
+func (repository *VolatileDeviceRepository) FindByUUID(ctx context.Context, tenantID string, UUID string) (*domain.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	repository.rwMutex.RLock()
 	defer repository.rwMutex.RUnlock()
 
-	if deviceIndex, exists := repository.uuidIndex[UUID]; exists {
+	if deviceIndex, exists := repository.uuidIndex[UUID]; exists && inTenant(tenantID, repository.devices[deviceIndex].Tenant()) {
 		deepCopy := repository.devices[deviceIndex]
 		return &deepCopy, nil
 	}
@@ -51,38 +92,123 @@ func (repository *VolatileDeviceRepository) FindByUUID(UUID string) (*domain.Dev
 	return nil, nil
 }
 
-func (repository *VolatileDeviceRepository) List(page int, batchSize int) ([]domain.Device, error) {
+// Devices are never removed nor moved inside the slice, so the position in
+// the slice works as a stable cursor.
+func (repository *VolatileDeviceRepository) List(ctx context.Context, tenantID string, pageRequest PageRequest) (Page[domain.Device], error) {
+	if err := ctx.Err(); err != nil {
+		return Page[domain.Device]{}, err
+	}
+
+	repository.rwMutex.RLock()
+	defer repository.rwMutex.RUnlock()
+
+	if tenantID == AllTenants {
+		return paginateLog(repository.devices, 0, pageRequest)
+	}
+
+	return paginateLogMatching(repository.devices, 0, pageRequest, func(device *domain.Device) bool {
+		return device.Tenant() == tenantID
+	})
+}
+
+// Without indexes every device is checked against the query. Sorted by
+// creation the cursor is a position in the slice, like in List. Sorted by
+// label or counter it is an offset in the result, as those fields change, so
+// a device modified while paging might show up twice or be skipped.
+func (repository *VolatileDeviceRepository) Search(ctx context.Context, query DeviceQuery, pageRequest PageRequest) (Page[domain.Device], error) {
+	if err := ctx.Err(); err != nil {
+		return Page[domain.Device]{}, err
+	}
+
 	repository.rwMutex.RLock()
 	defer repository.rwMutex.RUnlock()
-	devices := make([]domain.Device, 0, batchSize)
 
-	if batchSize < 1 {
-		return nil, errors.New("batch size cannot be less than 1")
+	cursor, err := validatePageRequest(pageRequest)
+	if err != nil {
+		return Page[domain.Device]{}, err
+	}
+
+	matches := make([]int, 0)
+	for position := range repository.devices {
+		if query.Matches(&repository.devices[position]) {
+			matches = append(matches, position)
+		}
+	}
+
+	page := Page[domain.Device]{Items: make([]domain.Device, 0, pageRequest.Limit)}
+	if pageRequest.IncludeTotal {
+		total := len(matches)
+		page.Total = &total
 	}
 
-	if page < 1 {
-		return nil, errors.New("page cannot be less than 1")
+	if query.SortBy == SortDevicesByCreation {
+		return repository.pageByPosition(page, matches, query.Order, cursor, pageRequest.Limit), nil
 	}
 
-	lowerLimit := (page - 1) * batchSize
-	higherLimit := page * batchSize
+	slices.SortStableFunc(matches, func(a, b int) int {
+		comparison := compareDevices(&repository.devices[a], &repository.devices[b], query.SortBy)
+		if query.Order == OrderDescending {
+			return -comparison
+		}
+		return comparison
+	})
+
+	start := min(cursor, len(matches))
+	end := min(start+pageRequest.Limit, len(matches))
+	for _, position := range matches[start:end] {
+		page.Items = append(page.Items, repository.devices[position])
+	}
+
+	if end < len(matches) {
+		page.HasMore = true
+		page.NextCursor = encodeCursor(end)
+	}
 
-	if lowerLimit > len(repository.devices) {
-		return devices, nil
+	return page, nil
+}
+
+// The cursor is the position after the last device returned, 0 being the start.
+func (repository *VolatileDeviceRepository) pageByPosition(page Page[domain.Device], matches []int, order SortOrder, cursor int, limit int) Page[domain.Device] {
+	if order == OrderDescending {
+		slices.Reverse(matches)
 	}
 
-	if higherLimit > len(repository.devices) {
-		higherLimit = len(repository.devices)
+	remaining := matches
+	if cursor > 0 {
+		last := cursor - 1
+		start := slices.IndexFunc(matches, func(position int) bool {
+			if order == OrderDescending {
+				return position < last
+			}
+			return position > last
+		})
+		if start < 0 {
+			return page
+		}
+		remaining = matches[start:]
 	}
 
-	for i := lowerLimit; i < higherLimit; i++ {
-		deepCopy := repository.devices[i]
-		devices = append(devices, deepCopy)
+	end := min(limit, len(remaining))
+	for _, position := range remaining[:end] {
+		page.Items = append(page.Items, repository.devices[position])
+	}
+
+	if end < len(remaining) {
+		page.HasMore = true
+		page.NextCursor = encodeCursor(remaining[end-1] + 1)
+	}
+
+	return page
+}
+
+func compareDevices(a *domain.Device, b *domain.Device, sortBy DeviceSortField) int {
+	if sortBy == SortDevicesByLabel {
+		return strings.Compare(strings.ToLower(a.Label), strings.ToLower(b.Label))
 	}
 
-	return devices, nil
+	return a.SignatureCounter - b.SignatureCounter
 }
 
-func (repository *VolatileDeviceRepository) CheckHealth() domain.PersistenceHealth {
+func (repository *VolatileDeviceRepository) CheckHealth(ctx context.Context) domain.PersistenceHealth {
 	return domain.PersistenceHealth{Status: domain.HealthStatusPass}
 }
@@ -0,0 +1,148 @@
+package persistence
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageRequest asks a repository for the items that come after Cursor.
+// An empty Cursor starts from the beginning.
+type PageRequest struct {
+	Cursor       string
+	Limit        int
+	IncludeTotal bool
+}
+
+// Page is a chunk of a listing plus what the client needs to fetch the next one.
+// Total is only filled when the PageRequest asked for it, as counting might be
+// expensive on some backends. Partial is set by storages whose listing leaves
+// out some of what they hold, e.g. archived signatures, Total included.
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+	HasMore    bool
+	Total      *int
+	Partial    bool
+}
+
+// Cursors are opaque to clients. Right now they hold a position in the storage
+// (an append position or a signature counter) which, unlike an offset, does
+// not shift when new items are appended while a client is paging.
+func encodeCursor(position int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(position)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	position, err := strconv.Atoi(string(decoded))
+	if err != nil || position < 0 {
+		return 0, ErrInvalidCursor
+	}
+
+	return position, nil
+}
+
+func validatePageRequest(pageRequest PageRequest) (int, error) {
+	if pageRequest.Limit < 1 {
+		return 0, errors.New("limit cannot be less than 1")
+	}
+
+	return decodeCursor(pageRequest.Cursor)
+}
+
+// paginateLog pages through an append-only log where the position of an item
+// never changes, so the cursor is just the position of the next item. The log
+// might have been truncated from the beginning, so items[0] is at position base.
+func paginateLog[T any](items []T, base int, pageRequest PageRequest) (Page[T], error) {
+	position, err := validatePageRequest(pageRequest)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	page := Page[T]{Items: make([]T, 0, pageRequest.Limit)}
+	if pageRequest.IncludeTotal {
+		total := len(items)
+		page.Total = &total
+	}
+
+	start := max(position-base, 0)
+	if start >= len(items) {
+		return page, nil
+	}
+
+	end := min(start+pageRequest.Limit, len(items))
+	page.Items = append(page.Items, items[start:end]...)
+
+	if end < len(items) {
+		page.HasMore = true
+		page.NextCursor = encodeCursor(base + end)
+	}
+
+	return page, nil
+}
+
+// Same as paginateLog, but only with the items passing matches. Every item
+// after the cursor may have to be checked to fill the page.
+func paginateLogMatching[T any](items []T, base int, pageRequest PageRequest, matches func(*T) bool) (Page[T], error) {
+	position, err := validatePageRequest(pageRequest)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	page := Page[T]{Items: make([]T, 0, pageRequest.Limit)}
+	if pageRequest.IncludeTotal {
+		total := 0
+		for i := range items {
+			if matches(&items[i]) {
+				total++
+			}
+		}
+		page.Total = &total
+	}
+
+	for i := max(position-base, 0); i < len(items); i++ {
+		if !matches(&items[i]) {
+			continue
+		}
+
+		if len(page.Items) == pageRequest.Limit {
+			page.HasMore = true
+			page.NextCursor = encodeCursor(base + i)
+			break
+		}
+
+		page.Items = append(page.Items, items[i])
+	}
+
+	return page, nil
+}
+
+// The chains of the devices are paged using the counter of the last signature
+// returned as cursor, which narrows down the counter range of the query. It
+// returns false if nothing can be left after the cursor.
+func narrowByCounterCursor(query SignatureQuery, cursorCounter int) (SignatureQuery, bool) {
+	if cursorCounter == 0 {
+		return query, true
+	}
+
+	if query.Order == OrderDescending {
+		if query.CounterTo == 0 || query.CounterTo >= cursorCounter {
+			query.CounterTo = cursorCounter - 1
+		}
+		return query, query.CounterTo >= 1
+	}
+
+	query.CounterFrom = max(query.CounterFrom, cursorCounter+1)
+	return query, true
+}
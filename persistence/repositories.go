@@ -1,14 +1,49 @@
 package persistence
 
 import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
 	"github.com/chuckiihub/signing-service/domain"
 )
 
+// Returned when saving a device with a fencing token older than the stored one,
+// which means the lock used to modify it expired and someone else took it.
+var ErrStaleFencingToken = errors.New("stale fencing token, the device lock was lost")
+
+// Returned by conditional saves when the device was modified since it was read.
+var ErrVersionConflict = errors.New("the device was modified concurrently")
+
+// Used as tenant ID by the callers that work across tenants, e.g. backups.
+// Requests made on behalf of a tenant never use it.
+const AllTenants = ""
+
+func inTenant(tenantID string, ownerTenantID string) bool {
+	return tenantID == AllTenants || tenantID == ownerTenantID
+}
+
+// Every lookup is scoped to a tenant, devices of other tenants are not found.
+//
+// Save has to reject devices with a FencingToken lower than the stored one
+// with ErrStaleFencingToken. Every save increments the Version of the device.
 type DevicePersistance interface {
-	Save(device *domain.Device) (*domain.Device, error)
-	FindByUUID(UUID string) (*domain.Device, error)
-	List(page int, pageSize int) ([]domain.Device, error)
-	CheckHealth() domain.PersistenceHealth
+	Save(ctx context.Context, device *domain.Device) (*domain.Device, error)
+	FindByUUID(ctx context.Context, tenantID string, UUID string) (*domain.Device, error)
+	List(ctx context.Context, tenantID string, pageRequest PageRequest) (Page[domain.Device], error)
+	// Returns the devices matching every filter of the query.
+	Search(ctx context.Context, query DeviceQuery, pageRequest PageRequest) (Page[domain.Device], error)
+	CheckHealth(ctx context.Context) domain.PersistenceHealth
+}
+
+// Backends supporting conditional writes can sign without a lock service:
+// SaveIfVersion only saves the device when the stored Version still is the
+// expected one, otherwise it returns ErrVersionConflict.
+type VersionedDevicePersistance interface {
+	DevicePersistance
+	SaveIfVersion(ctx context.Context, device *domain.Device, expectedVersion uint64) (*domain.Device, error)
 }
 
 func NewVolatileDeviceRepository() *VolatileDeviceRepository {
@@ -18,20 +53,264 @@ func NewVolatileDeviceRepository() *VolatileDeviceRepository {
 	}
 }
 
+type SortOrder int
+
+const (
+	OrderAscending SortOrder = iota
+	OrderDescending
+)
+
+type DeviceSortField int
+
+const (
+	SortDevicesByCreation DeviceSortField = iota
+	SortDevicesByLabel
+	SortDevicesBySignatureCounter
+)
+
+// DeviceQuery filters devices, zero values mean "no filter". Backends with
+// indexes should use them for the filters they can, Matches tells whether a
+// device passes all of them.
+type DeviceQuery struct {
+	TenantID  string
+	Algorithm *crypto.SignatureAlgorithm
+	State     domain.DeviceState
+	// Case insensitive substring of the label.
+	LabelContains string
+	// Every tag must be present with the same value, an empty value only
+	// requires the tag to be present.
+	Tags map[string]string
+	// Inclusive creation time bounds.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Inclusive signature counter bounds, pointers as 0 is a valid bound.
+	CounterFrom *int
+	CounterTo   *int
+	SortBy      DeviceSortField
+	Order       SortOrder
+}
+
+func (query DeviceQuery) Matches(device *domain.Device) bool {
+	if !inTenant(query.TenantID, device.Tenant()) {
+		return false
+	}
+
+	if query.Algorithm != nil && device.Algorithm != *query.Algorithm {
+		return false
+	}
+
+	if query.State != "" && device.CurrentState() != query.State {
+		return false
+	}
+
+	if query.LabelContains != "" && !strings.Contains(strings.ToLower(device.Label), strings.ToLower(query.LabelContains)) {
+		return false
+	}
+
+	for key, value := range query.Tags {
+		tag, ok := device.Tags[key]
+		if !ok || (value != "" && tag != value) {
+			return false
+		}
+	}
+
+	if !query.CreatedFrom.IsZero() && device.CreatedAt.Before(query.CreatedFrom) {
+		return false
+	}
+
+	if !query.CreatedTo.IsZero() && device.CreatedAt.After(query.CreatedTo) {
+		return false
+	}
+
+	if query.CounterFrom != nil && device.SignatureCounter < *query.CounterFrom {
+		return false
+	}
+
+	if query.CounterTo != nil && device.SignatureCounter > *query.CounterTo {
+		return false
+	}
+
+	return true
+}
+
+// SignatureQuery narrows down the signatures of a single device. Zero values
+// mean "no bound", so an empty query (apart from the DeviceUUID) returns the
+// whole chain of the device ordered by counter.
+type SignatureQuery struct {
+	DeviceUUID string
+	// Inclusive counter bounds.
+	CounterFrom int
+	CounterTo   int
+	// Inclusive creation time bounds.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Order       SortOrder
+}
+
+// Like devices, signatures are scoped to a tenant. The chain of a device is
+// only read once the device was found in the tenant, so FindByDevice is not.
 type SignaturePersistance interface {
-	List(offset int, pageSize int) ([]domain.Signature, error)
-	Save(signature *domain.Signature) (*domain.Signature, error)
-	FindByUUID(uuid string) (*domain.Signature, error)
-	CheckHealth() domain.PersistenceHealth
+	List(ctx context.Context, tenantID string, pageRequest PageRequest) (Page[domain.Signature], error)
+	FindByDevice(ctx context.Context, query SignatureQuery, pageRequest PageRequest) (Page[domain.Signature], error)
+	Save(ctx context.Context, signature *domain.Signature) (*domain.Signature, error)
+	FindByUUID(ctx context.Context, tenantID string, uuid string) (*domain.Signature, error)
+	// Returns the last signature of the device made with the Idempotency-Key,
+	// nil when there is none. Backends should index the key, it is looked up
+	// on every sign request carrying one.
+	FindByIdempotencyKey(ctx context.Context, tenantID string, deviceUUID string, key string) (*domain.Signature, error)
+	CheckHealth(ctx context.Context) domain.PersistenceHealth
+}
+
+// Hot signature storages that can hand their oldest signatures over to a
+// SignatureArchive and then forget about them.
+type EvictableSignaturePersistance interface {
+	SignaturePersistance
+	OldestCreatedBefore(ctx context.Context, cutoff time.Time, limit int) ([]domain.Signature, error)
+	Evict(ctx context.Context, signatures []domain.Signature) error
+}
+
+// Long term storage for signatures that are not needed in the hot storage anymore.
+// Archived signatures are never modified.
+type SignatureArchive interface {
+	// Signatures already archived are skipped, so a batch can be appended again.
+	Append(ctx context.Context, signatures []domain.Signature) error
+	FindByUUID(ctx context.Context, uuid string) (*domain.Signature, error)
+	// Pages through the archived signatures of a device like
+	// SignaturePersistance.FindByDevice, the cursor being the counter of the
+	// last signature returned. Only what is needed to fill the page is read.
+	FindByDevice(ctx context.Context, query SignatureQuery, pageRequest PageRequest) (Page[domain.Signature], error)
+	// Counts the archived signatures of a device matching the query, from the
+	// indexes as much as possible.
+	CountByDevice(ctx context.Context, query SignatureQuery) (int, error)
+	CheckHealth(ctx context.Context) domain.PersistenceHealth
 }
 
 func NewVolatileSignatureRepository() *SignatureVolatileRepository {
 	return &SignatureVolatileRepository{
 		signatureIndexMap: make(map[string]int, 0),
+		deviceIndex:       make(map[string][]int, 0),
+		idempotencyIndex:  make(map[idempotencyIndexKey]int, 0),
 		signatures:        make([]domain.Signature, 0),
 	}
 }
 
+// Returned when appending an audit event that does not follow the last one.
+var ErrAuditSequenceConflict = errors.New("audit event does not follow the last one")
+
+// The audit log is append-only: there is no way to change or remove events.
+// Append only accepts the event right after the last one (Sequence last+1), so
+// two writers can never fork the hash chain.
+type AuditLogPersistance interface {
+	Append(ctx context.Context, event domain.AuditEvent) error
+	// Returns the last event, nil when the log is empty.
+	Last(ctx context.Context) (*domain.AuditEvent, error)
+	// Returns the events matching the query in sequence order.
+	List(ctx context.Context, query AuditEventQuery, pageRequest PageRequest) (Page[domain.AuditEvent], error)
+	SaveCheckpoint(ctx context.Context, checkpoint domain.AuditCheckpoint) error
+	// Returns every checkpoint in sequence order.
+	Checkpoints(ctx context.Context) ([]domain.AuditCheckpoint, error)
+}
+
+// AuditEventQuery filters audit events, zero values mean "no filter".
+type AuditEventQuery struct {
+	TenantID   string
+	Actor      string
+	Action     string
+	DeviceUUID string
+	RequestID  string
+	// Inclusive time bounds.
+	From time.Time
+	To   time.Time
+}
+
+func (query AuditEventQuery) Matches(event *domain.AuditEvent) bool {
+	return (query.TenantID == "" || event.TenantID == query.TenantID) &&
+		(query.Actor == "" || event.Actor == query.Actor) &&
+		(query.Action == "" || event.Action == query.Action) &&
+		(query.DeviceUUID == "" || event.DeviceUUID == query.DeviceUUID) &&
+		(query.RequestID == "" || event.RequestID == query.RequestID) &&
+		(query.From.IsZero() || !event.Time.Before(query.From)) &&
+		(query.To.IsZero() || !event.Time.After(query.To))
+}
+
+func NewVolatileAuditLogRepository() *VolatileAuditLogRepository {
+	return &VolatileAuditLogRepository{
+		events:      make([]domain.AuditEvent, 0),
+		checkpoints: make([]domain.AuditCheckpoint, 0),
+	}
+}
+
+// Returned when creating an API key with the ID or the name of an existing one.
+var ErrDuplicateAPIKey = errors.New("an API key with the same ID or name already exists")
+
+// API keys are never deleted, revoking one is an Update.
+type APIKeyPersistance interface {
+	// Names are unique, they are what the audit log records as actor.
+	Create(ctx context.Context, key *domain.APIKey) error
+	Update(ctx context.Context, key *domain.APIKey) error
+	// Returns nil when there is no key with the ID.
+	FindByID(ctx context.Context, id string) (*domain.APIKey, error)
+	// Returns the keys of the tenant in creation order.
+	List(ctx context.Context, tenantID string, pageRequest PageRequest) (Page[domain.APIKey], error)
+}
+
+// Tenants are configured, not created through the API, so this is read only.
+type TenantPersistance interface {
+	// Returns nil when there is no tenant with the ID.
+	FindByID(ctx context.Context, id string) (*domain.Tenant, error)
+	List(ctx context.Context) ([]domain.Tenant, error)
+}
+
+func NewVolatileAPIKeyRepository() *VolatileAPIKeyRepository {
+	return &VolatileAPIKeyRepository{
+		keys:      make([]domain.APIKey, 0),
+		idIndex:   make(map[string]int),
+		nameIndex: make(map[string]int),
+	}
+}
+
+// Webhook endpoints are deleted, their deliveries are kept as history.
+type WebhookPersistance interface {
+	CreateEndpoint(ctx context.Context, endpoint *domain.WebhookEndpoint) error
+	// Returns false when there is no endpoint with the ID in the tenant.
+	DeleteEndpoint(ctx context.Context, tenantID string, id string) (bool, error)
+	// Returns nil when there is no endpoint with the ID in the tenant.
+	FindEndpoint(ctx context.Context, tenantID string, id string) (*domain.WebhookEndpoint, error)
+	// Returns the endpoints of the tenant in creation order.
+	ListEndpoints(ctx context.Context, tenantID string, pageRequest PageRequest) (Page[domain.WebhookEndpoint], error)
+	// Returns every endpoint of the tenant that wants the type of event.
+	FindSubscribedEndpoints(ctx context.Context, tenantID string, eventType domain.EventType) ([]domain.WebhookEndpoint, error)
+
+	// Creates the delivery or replaces the one with the same ID.
+	SaveDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error
+	// Returns nil when there is no delivery with the ID in the tenant.
+	FindDelivery(ctx context.Context, tenantID string, id string) (*domain.WebhookDelivery, error)
+	// Returns the deliveries matching the query in creation order.
+	ListDeliveries(ctx context.Context, query WebhookDeliveryQuery, pageRequest PageRequest) (Page[domain.WebhookDelivery], error)
+	// Returns up to limit pending deliveries due at the time, oldest first.
+	DueDeliveries(ctx context.Context, at time.Time, limit int) ([]domain.WebhookDelivery, error)
+}
+
+// WebhookDeliveryQuery filters deliveries, zero values mean "no filter".
+type WebhookDeliveryQuery struct {
+	TenantID   string
+	EndpointID string
+	Status     domain.WebhookDeliveryStatus
+}
+
+func (query WebhookDeliveryQuery) Matches(delivery *domain.WebhookDelivery) bool {
+	return inTenant(query.TenantID, delivery.TenantID) &&
+		(query.EndpointID == "" || delivery.EndpointID == query.EndpointID) &&
+		(query.Status == "" || delivery.Status == query.Status)
+}
+
+func NewVolatileWebhookRepository() *VolatileWebhookRepository {
+	return &VolatileWebhookRepository{
+		endpointIndex: make(map[string]int),
+		deliveryIndex: make(map[string]int),
+	}
+}
+
 type PersistenceHealthCheck interface {
-	CheckHealth() domain.PersistenceHealth
+	CheckHealth(ctx context.Context) domain.PersistenceHealth
 }
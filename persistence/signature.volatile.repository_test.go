@@ -1,8 +1,10 @@
 package persistence
 
 import (
+	"context"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/chuckiihub/signing-service/domain"
 	"github.com/google/uuid"
@@ -17,8 +19,8 @@ func TestVolatileSignatureSaveAndGet(t *testing.T) {
 		DeviceUUID: "device-uuid",
 	}
 
-	testSignature, _ = memoryStorage.Save(testSignature)
-	retrievedSignature, err := memoryStorage.FindByUUID(testSignature.UUID)
+	testSignature, _ = memoryStorage.Save(context.Background(), testSignature)
+	retrievedSignature, err := memoryStorage.FindByUUID(context.Background(), AllTenants, testSignature.UUID)
 
 	if err != nil {
 		t.Fatal("Error while recovering Signature")
@@ -36,17 +38,17 @@ func TestThatSignatureVolatileRepositoryDoesNotReturnPointersToInternalStorageSt
 
 	memoryStorage := NewVolatileSignatureRepository()
 
-	savedSignature, _ := memoryStorage.Save(&domain.Signature{
+	savedSignature, _ := memoryStorage.Save(context.Background(), &domain.Signature{
 		UUID:       uuid,
 		SignedData: "signedData",
 		Signature:  SIGNATURE,
 		DeviceUUID: "device-uuid",
 	})
 
-	modifiedSignature, _ := memoryStorage.FindByUUID(savedSignature.UUID)
+	modifiedSignature, _ := memoryStorage.FindByUUID(context.Background(), AllTenants, savedSignature.UUID)
 	modifiedSignature.SignedData = MODIFIED_SIGNATURE
 
-	SignatureInStorage, _ := memoryStorage.FindByUUID(savedSignature.UUID)
+	SignatureInStorage, _ := memoryStorage.FindByUUID(context.Background(), AllTenants, savedSignature.UUID)
 
 	if SignatureInStorage.SignedData == modifiedSignature.SignedData {
 		t.Fatal("Modifying objects retrieved by in memory repository modifies objects in internal storage media")
@@ -63,60 +65,238 @@ func TestListSignaturesWithPagination(t *testing.T) {
 			Signature:  "test-signature-" + strconv.Itoa(i),
 			DeviceUUID: "test-device-uuid-" + strconv.Itoa(i),
 		}
-		_, err := memoryStorage.Save(signature)
+		_, err := memoryStorage.Save(context.Background(), signature)
 		if err != nil {
 			t.Fatalf("Error while saving signature %d: %v", i, err)
 		}
 	}
 
-	// List signatures with page size 2 and check the results
-	pageSize := 2
-	for page := 1; page < 3; page++ {
-		signatures, err := memoryStorage.List(page, pageSize)
+	// List signatures with limit 2 and follow the cursor
+	pageRequest := PageRequest{Limit: 2}
+	for pageNumber := 1; pageNumber < 3; pageNumber++ {
+		page, err := memoryStorage.List(context.Background(), AllTenants, pageRequest)
 		if err != nil {
-			t.Fatalf("Error while listing signatures on page %d: %v", page, err)
+			t.Fatalf("Error while listing signatures on page %d: %v", pageNumber, err)
 		}
 
-		expectedSize := pageSize
-		if page == 2 {
+		expectedSize := pageRequest.Limit
+		if pageNumber == 2 {
 			expectedSize = 1
 		}
 
-		if len(signatures) != expectedSize {
-			t.Fatalf("Expected %d signatures on page %d, but got %d", expectedSize, page, len(signatures))
+		if len(page.Items) != expectedSize {
+			t.Fatalf("Expected %d signatures on page %d, but got %d", expectedSize, pageNumber, len(page.Items))
 		}
 
-		for i, signature := range signatures {
-			expectedDeviceUUID := "test-device-uuid-" + strconv.Itoa((page-1)*pageSize+i+1)
+		if page.Total != nil {
+			t.Fatal("Total should only be filled when asked for")
+		}
+
+		for i, signature := range page.Items {
+			expectedDeviceUUID := "test-device-uuid-" + strconv.Itoa((pageNumber-1)*2+i+1)
 			if signature.DeviceUUID != expectedDeviceUUID {
 				t.Fatalf("Expected device UUID %s, but got %s", expectedDeviceUUID, signature.DeviceUUID)
 			}
 		}
+
+		pageRequest.Cursor = page.NextCursor
+	}
+}
+
+func TestListSignaturesPagesAreStableWhileAppending(t *testing.T) {
+	memoryStorage := NewVolatileSignatureRepository()
+	saveDeviceChain(t, memoryStorage, "device-a", 4, time.Now())
+
+	firstPage, _ := memoryStorage.List(context.Background(), AllTenants, PageRequest{Limit: 2})
+
+	// new signatures arriving between two pages should not shift the next page
+	saveDeviceChain(t, memoryStorage, "device-b", 4, time.Now())
+
+	secondPage, err := memoryStorage.List(context.Background(), AllTenants, PageRequest{Cursor: firstPage.NextCursor, Limit: 2})
+	if err != nil {
+		t.Fatalf("Not expecting error when listing: %v", err)
+	}
+
+	if secondPage.Items[0].DeviceUUID != "device-a" || secondPage.Items[0].Counter != 3 {
+		t.Fatalf("Expected the second page to continue the first one, got %v", secondPage.Items[0])
+	}
+
+	if !secondPage.HasMore {
+		t.Fatal("Expected more signatures after the second page")
 	}
 }
 
 func TestListSignaturesPaginationOnEmptyPage(t *testing.T) {
 	memoryStorage := NewVolatileSignatureRepository()
 
-	// Create and save 15 devices
+	// Create and save 3 signatures
 	for i := 1; i <= 3; i++ {
 		signature := &domain.Signature{
 			SignedData: "test-signedData-" + strconv.Itoa(i),
 			Signature:  "test-signature-" + strconv.Itoa(i),
 			DeviceUUID: "test-device-uuid-" + strconv.Itoa(i),
 		}
-		_, err := memoryStorage.Save(signature)
+		_, err := memoryStorage.Save(context.Background(), signature)
 		if err != nil {
 			t.Fatalf("Error while saving signature %d: %v", i, err)
 		}
 	}
 
-	signatures, err := memoryStorage.List(20, 10)
+	page, err := memoryStorage.List(context.Background(), AllTenants, PageRequest{Cursor: encodeCursor(20), Limit: 10})
 	if err != nil {
 		t.Fatal("Not expecting error when listing with empty page")
 	}
 
-	if len(signatures) != 0 {
-		t.Fatal("Expected empty list when listing with invalid page")
+	if len(page.Items) != 0 {
+		t.Fatal("Expected empty list when listing past the last signature")
+	}
+}
+
+func saveDeviceChain(t *testing.T, memoryStorage *SignatureVolatileRepository, deviceUUID string, size int, start time.Time) {
+	for i := 1; i <= size; i++ {
+		_, err := memoryStorage.Save(context.Background(), &domain.Signature{
+			UUID:       uuid.NewString(),
+			DeviceUUID: deviceUUID,
+			Counter:    i,
+			SignedData: "test-signedData-" + strconv.Itoa(i),
+			Signature:  deviceUUID + "-signature-" + strconv.Itoa(i),
+			CreatedAt:  start.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("Error while saving signature %d: %v", i, err)
+		}
+	}
+}
+
+func TestFindSignaturesByDeviceOnlyReturnsTheDeviceChain(t *testing.T) {
+	memoryStorage := NewVolatileSignatureRepository()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	saveDeviceChain(t, memoryStorage, "device-a", 5, start)
+	saveDeviceChain(t, memoryStorage, "device-b", 3, start)
+
+	page, err := memoryStorage.FindByDevice(context.Background(), SignatureQuery{DeviceUUID: "device-b"}, PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatalf("Not expecting error when listing by device: %v", err)
+	}
+
+	signatures := page.Items
+	if len(signatures) != 3 {
+		t.Fatalf("Expected 3 signatures, but got %d", len(signatures))
+	}
+
+	for i, signature := range signatures {
+		if signature.DeviceUUID != "device-b" || signature.Counter != i+1 {
+			t.Fatalf("Unexpected signature %s with counter %d at position %d", signature.DeviceUUID, signature.Counter, i)
+		}
+	}
+}
+
+func TestFindSignaturesByDeviceWithCounterRangeAndDescendingOrder(t *testing.T) {
+	memoryStorage := NewVolatileSignatureRepository()
+	saveDeviceChain(t, memoryStorage, "device-a", 10, time.Now())
+
+	query := SignatureQuery{DeviceUUID: "device-a", CounterFrom: 3, CounterTo: 7, Order: OrderDescending}
+
+	page, err := memoryStorage.FindByDevice(context.Background(), query, PageRequest{Limit: 3, IncludeTotal: true})
+	if err != nil {
+		t.Fatalf("Not expecting error when listing by device: %v", err)
+	}
+
+	expectedCounters := []int{7, 6, 5}
+	if len(page.Items) != len(expectedCounters) {
+		t.Fatalf("Expected %d signatures, but got %d", len(expectedCounters), len(page.Items))
+	}
+
+	for i, signature := range page.Items {
+		if signature.Counter != expectedCounters[i] {
+			t.Fatalf("Expected counter %d, but got %d", expectedCounters[i], signature.Counter)
+		}
+	}
+
+	if page.Total == nil || *page.Total != 5 {
+		t.Fatal("Expected a total of 5 signatures in the counter range")
+	}
+
+	page, _ = memoryStorage.FindByDevice(context.Background(), query, PageRequest{Cursor: page.NextCursor, Limit: 3})
+	if len(page.Items) != 2 || page.Items[0].Counter != 4 || page.Items[1].Counter != 3 || page.HasMore {
+		t.Fatalf("Unexpected second page %v", page.Items)
+	}
+}
+
+func TestFindSignaturesByDeviceWithTimeRange(t *testing.T) {
+	memoryStorage := NewVolatileSignatureRepository()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	saveDeviceChain(t, memoryStorage, "device-a", 10, start)
+
+	query := SignatureQuery{
+		DeviceUUID:  "device-a",
+		CreatedFrom: start.Add(2 * time.Minute),
+		CreatedTo:   start.Add(4 * time.Minute),
+	}
+
+	page, err := memoryStorage.FindByDevice(context.Background(), query, PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatalf("Not expecting error when listing by device: %v", err)
+	}
+
+	if len(page.Items) != 3 || page.Items[0].Counter != 2 || page.Items[2].Counter != 4 {
+		t.Fatalf("Unexpected signatures for time range %v", page.Items)
+	}
+}
+
+func TestSignaturesListedByTenantWithPagination(t *testing.T) {
+	memoryStorage := NewVolatileSignatureRepository()
+	for i, tenantID := range []string{"acme", "globex", "acme", "acme", "globex"} {
+		memoryStorage.Save(context.Background(), &domain.Signature{UUID: strconv.Itoa(i), DeviceUUID: tenantID, TenantID: tenantID, Counter: i})
+	}
+
+	firstPage, err := memoryStorage.List(context.Background(), "acme", PageRequest{Limit: 2})
+	if err != nil || len(firstPage.Items) != 2 || !firstPage.HasMore {
+		t.Fatalf("Unexpected first page %+v, %v", firstPage, err)
+	}
+
+	secondPage, _ := memoryStorage.List(context.Background(), "acme", PageRequest{Cursor: firstPage.NextCursor, Limit: 2})
+	if len(secondPage.Items) != 1 || secondPage.Items[0].UUID != "3" || secondPage.HasMore {
+		t.Fatalf("Unexpected second page %+v", secondPage)
+	}
+
+	if signature, _ := memoryStorage.FindByUUID(context.Background(), "globex", "0"); signature != nil {
+		t.Fatalf("Found the signature of another tenant")
+	}
+}
+
+func TestFindSignatureByIdempotencyKey(t *testing.T) {
+	memoryStorage := NewVolatileSignatureRepository()
+	ctx := context.Background()
+
+	save := func(counter int, key string) domain.Signature {
+		signature := domain.Signature{UUID: uuid.NewString(), DeviceUUID: "till", TenantID: "acme", Counter: counter, Signature: uuid.NewString(), IdempotencyKey: key}
+		memoryStorage.Save(ctx, &signature)
+		return signature
+	}
+
+	first := save(1, "receipt-1")
+	save(2, "")
+	reused := save(3, "receipt-1")
+
+	found, err := memoryStorage.FindByIdempotencyKey(ctx, "acme", "till", "receipt-1")
+	if err != nil || found == nil || found.UUID != reused.UUID {
+		t.Fatalf("expected the last signature with the key, got %v, %v", found, err)
+	}
+
+	for _, lookup := range [][3]string{{"other", "till", "receipt-1"}, {"acme", "other-till", "receipt-1"}, {"acme", "till", "receipt-2"}} {
+		if found, _ := memoryStorage.FindByIdempotencyKey(ctx, lookup[0], lookup[1], lookup[2]); found != nil {
+			t.Fatalf("%v found %v", lookup, found)
+		}
+	}
+
+	// evicting the first one keeps the key of the later signature
+	if err := memoryStorage.Evict(ctx, []domain.Signature{first}); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := memoryStorage.FindByIdempotencyKey(ctx, AllTenants, "till", "receipt-1"); found == nil || found.UUID != reused.UUID {
+		t.Fatalf("the key was lost when evicting an older signature, got %v", found)
 	}
 }
@@ -0,0 +1,500 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+// The file archive keeps archived signatures in immutable, gzipped JSON lines
+// segment files. Next to every segment there is a small index file (a filter
+// of its uuids, and counter/time ranges and counts per device) which is what
+// we keep in memory, so a signature is found by reading only the segments
+// that may contain it, and totals are counted without reading any but the
+// segments at the ends of the range.
+//
+// Segments are checksummed in their index and checked every time they are read.
+const (
+	segmentFilePattern = "segment-%08d.jsonl.gz"
+	segmentIndexSuffix = ".index.json"
+	// Indexes of older versions are rebuilt from their segment when loaded.
+	segmentIndexVersion = 2
+)
+
+type FileSignatureArchive struct {
+	directory string
+	segments  []segmentIndex
+	// Segments holding signatures of each device, in counter order as the
+	// oldest signatures are archived first.
+	deviceIndex map[string][]int
+	rwLock      sync.RWMutex
+}
+
+type segmentIndex struct {
+	Version  int                      `json:"version"`
+	File     string                   `json:"file"`
+	Checksum string                   `json:"checksum"`
+	UUIDs    uuidFilter               `json:"uuidFilter"`
+	Devices  map[string]segmentDevice `json:"devices"`
+}
+
+// Range of the chain of a device inside a segment.
+type segmentDevice struct {
+	FirstCounter   int       `json:"firstCounter"`
+	LastCounter    int       `json:"lastCounter"`
+	Count          int       `json:"count"`
+	FirstCreatedAt time.Time `json:"firstCreatedAt"`
+	LastCreatedAt  time.Time `json:"lastCreatedAt"`
+}
+
+// NewFileSignatureArchive opens (or creates) an archive in directory and loads
+// the index of every segment. A segment without index, e.g. after a crash
+// between writing both files, gets its index rebuilt.
+func NewFileSignatureArchive(directory string) (*FileSignatureArchive, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+
+	archive := &FileSignatureArchive{
+		directory:   directory,
+		segments:    make([]segmentIndex, 0),
+		deviceIndex: make(map[string][]int),
+	}
+
+	files, err := filepath.Glob(filepath.Join(directory, "segment-*.jsonl.gz"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		index, err := archive.loadSegmentIndex(filepath.Base(file))
+		if err != nil {
+			return nil, fmt.Errorf("could not load archive segment %s: %w", file, err)
+		}
+		archive.addSegment(index)
+	}
+
+	return archive, nil
+}
+
+func (archive *FileSignatureArchive) loadSegmentIndex(file string) (segmentIndex, error) {
+	var index segmentIndex
+
+	indexBytes, err := os.ReadFile(filepath.Join(archive.directory, file+segmentIndexSuffix))
+	if err == nil {
+		if err := json.Unmarshal(indexBytes, &index); err != nil || index.Version == segmentIndexVersion {
+			return index, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return index, err
+	}
+
+	content, err := os.ReadFile(filepath.Join(archive.directory, file))
+	if err != nil {
+		return index, err
+	}
+
+	signatures, err := decodeSegment(content)
+	if err != nil {
+		return index, err
+	}
+
+	index = buildSegmentIndex(file, content, signatures)
+	return index, archive.writeSegmentIndex(index)
+}
+
+func (archive *FileSignatureArchive) writeSegmentIndex(index segmentIndex) error {
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	return archive.writeFile(index.File+segmentIndexSuffix, indexBytes)
+}
+
+func (archive *FileSignatureArchive) addSegment(index segmentIndex) {
+	archive.segments = append(archive.segments, index)
+	segment := len(archive.segments) - 1
+
+	for deviceUUID := range index.Devices {
+		archive.deviceIndex[deviceUUID] = append(archive.deviceIndex[deviceUUID], segment)
+	}
+}
+
+// Append writes the signatures as a new segment. It only returns once both the
+// segment and its index are on disk.
+//
+// Chains are archived oldest first, so signatures up to the last archived
+// counter of their device are already there and skipped: a batch appended
+// again, e.g. after its eviction from the hot storage failed, adds nothing.
+func (archive *FileSignatureArchive) Append(ctx context.Context, signatures []domain.Signature) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	archive.rwLock.Lock()
+	defer archive.rwLock.Unlock()
+
+	signatures = slices.DeleteFunc(slices.Clone(signatures), func(signature domain.Signature) bool {
+		return signature.Counter <= archive.lastArchivedCounter(signature.DeviceUUID)
+	})
+
+	if len(signatures) == 0 {
+		return nil
+	}
+
+	var content bytes.Buffer
+	gzipWriter := gzip.NewWriter(&content)
+	encoder := json.NewEncoder(gzipWriter)
+	for i := range signatures {
+		if err := encoder.Encode(&signatures[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+
+	file := fmt.Sprintf(segmentFilePattern, len(archive.segments)+1)
+	index := buildSegmentIndex(file, content.Bytes(), signatures)
+
+	if err := archive.writeFile(file, content.Bytes()); err != nil {
+		return err
+	}
+
+	if err := archive.writeSegmentIndex(index); err != nil {
+		return err
+	}
+
+	archive.addSegment(index)
+	return nil
+}
+
+// 0 when nothing of the device is archived.
+func (archive *FileSignatureArchive) lastArchivedCounter(deviceUUID string) int {
+	segments := archive.deviceIndex[deviceUUID]
+	if len(segments) == 0 {
+		return 0
+	}
+
+	return archive.segments[segments[len(segments)-1]].Devices[deviceUUID].LastCounter
+}
+
+func (archive *FileSignatureArchive) FindByUUID(ctx context.Context, uuid string) (*domain.Signature, error) {
+	archive.rwLock.RLock()
+	defer archive.rwLock.RUnlock()
+
+	hash := hashUUID(uuid)
+	for segment := range archive.segments {
+		if !archive.segments[segment].UUIDs.mayContain(hash) {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		signatures, err := archive.readSegment(segment)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, signature := range signatures {
+			if signature.UUID == uuid {
+				return &signature, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// Pages through the segments of the device in the order of the query and
+// stops reading them once the page is full.
+func (archive *FileSignatureArchive) FindByDevice(ctx context.Context, query SignatureQuery, pageRequest PageRequest) (Page[domain.Signature], error) {
+	cursorCounter, err := validatePageRequest(pageRequest)
+	if err != nil {
+		return Page[domain.Signature]{}, err
+	}
+
+	archive.rwLock.RLock()
+	defer archive.rwLock.RUnlock()
+
+	page := Page[domain.Signature]{Items: make([]domain.Signature, 0, pageRequest.Limit)}
+	if pageRequest.IncludeTotal {
+		total, err := archive.countByDevice(ctx, query)
+		if err != nil {
+			return page, err
+		}
+		page.Total = &total
+	}
+
+	query, remaining := narrowByCounterCursor(query, cursorCounter)
+	if !remaining {
+		return page, nil
+	}
+
+	segments := slices.Clone(archive.deviceIndex[query.DeviceUUID])
+	if query.Order == OrderDescending {
+		slices.Reverse(segments)
+	}
+
+	for _, segment := range segments {
+		if !segmentOverlaps(archive.segments[segment].Devices[query.DeviceUUID], query) {
+			continue
+		}
+
+		// reading segments is the slow part, stop as soon as nobody waits for the result
+		if err := ctx.Err(); err != nil {
+			return page, err
+		}
+
+		signatures, err := archive.readSegment(segment)
+		if err != nil {
+			return page, err
+		}
+
+		matches := slices.DeleteFunc(signatures, func(signature domain.Signature) bool {
+			return !matchesDeviceQuery(query, &signature)
+		})
+		sort.SliceStable(matches, func(i, j int) bool {
+			if query.Order == OrderDescending {
+				return matches[i].Counter > matches[j].Counter
+			}
+			return matches[i].Counter < matches[j].Counter
+		})
+
+		for _, signature := range matches {
+			if len(page.Items) == pageRequest.Limit {
+				page.HasMore = true
+				page.NextCursor = encodeCursor(page.Items[len(page.Items)-1].Counter)
+				return page, nil
+			}
+			page.Items = append(page.Items, signature)
+		}
+	}
+
+	return page, nil
+}
+
+func (archive *FileSignatureArchive) CountByDevice(ctx context.Context, query SignatureQuery) (int, error) {
+	archive.rwLock.RLock()
+	defer archive.rwLock.RUnlock()
+
+	return archive.countByDevice(ctx, query)
+}
+
+// Segments fully inside the query are counted from their index, only the ones
+// it cuts through are read.
+func (archive *FileSignatureArchive) countByDevice(ctx context.Context, query SignatureQuery) (int, error) {
+	total := 0
+	for _, segment := range archive.deviceIndex[query.DeviceUUID] {
+		device := archive.segments[segment].Devices[query.DeviceUUID]
+
+		switch {
+		case !segmentOverlaps(device, query):
+		case segmentWithin(device, query):
+			total += device.Count
+		default:
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+
+			signatures, err := archive.readSegment(segment)
+			if err != nil {
+				return 0, err
+			}
+
+			for i := range signatures {
+				if matchesDeviceQuery(query, &signatures[i]) {
+					total++
+				}
+			}
+		}
+	}
+
+	return total, nil
+}
+
+func matchesDeviceQuery(query SignatureQuery, signature *domain.Signature) bool {
+	return signature.DeviceUUID == query.DeviceUUID && isInCounterRange(query, signature) && isInCreationRange(query, signature)
+}
+
+func segmentWithin(device segmentDevice, query SignatureQuery) bool {
+	if query.CounterFrom > 0 && device.FirstCounter < query.CounterFrom {
+		return false
+	}
+
+	if query.CounterTo > 0 && device.LastCounter > query.CounterTo {
+		return false
+	}
+
+	if !query.CreatedFrom.IsZero() && device.FirstCreatedAt.Before(query.CreatedFrom) {
+		return false
+	}
+
+	return query.CreatedTo.IsZero() || !device.LastCreatedAt.After(query.CreatedTo)
+}
+
+func segmentOverlaps(device segmentDevice, query SignatureQuery) bool {
+	if query.CounterFrom > 0 && device.LastCounter < query.CounterFrom {
+		return false
+	}
+
+	if query.CounterTo > 0 && device.FirstCounter > query.CounterTo {
+		return false
+	}
+
+	if !query.CreatedFrom.IsZero() && device.LastCreatedAt.Before(query.CreatedFrom) {
+		return false
+	}
+
+	if !query.CreatedTo.IsZero() && device.FirstCreatedAt.After(query.CreatedTo) {
+		return false
+	}
+
+	return true
+}
+
+func isInCounterRange(query SignatureQuery, signature *domain.Signature) bool {
+	if query.CounterFrom > 0 && signature.Counter < query.CounterFrom {
+		return false
+	}
+
+	return query.CounterTo == 0 || signature.Counter <= query.CounterTo
+}
+
+func (archive *FileSignatureArchive) readSegment(segment int) ([]domain.Signature, error) {
+	index := archive.segments[segment]
+
+	content, err := os.ReadFile(filepath.Join(archive.directory, index.File))
+	if err != nil {
+		return nil, err
+	}
+
+	if segmentChecksum(content) != index.Checksum {
+		return nil, errors.New("archive segment " + index.File + " does not match its checksum")
+	}
+
+	return decodeSegment(content)
+}
+
+func (archive *FileSignatureArchive) CheckHealth(ctx context.Context) domain.PersistenceHealth {
+	if info, err := os.Stat(archive.directory); err != nil || !info.IsDir() {
+		return domain.PersistenceHealth{Status: domain.HealthStatusFailed}
+	}
+
+	return domain.PersistenceHealth{Status: domain.HealthStatusPass}
+}
+
+// Files are written to a temporary file and renamed, so a crash never leaves a
+// half written segment behind.
+func (archive *FileSignatureArchive) writeFile(name string, content []byte) error {
+	temporary, err := os.CreateTemp(archive.directory, name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+
+	if _, err := temporary.Write(content); err != nil {
+		temporary.Close()
+		return err
+	}
+
+	if err := temporary.Sync(); err != nil {
+		temporary.Close()
+		return err
+	}
+
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary.Name(), filepath.Join(archive.directory, name))
+}
+
+func decodeSegment(content []byte) ([]domain.Signature, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+
+	signatures := make([]domain.Signature, 0)
+	scanner := bufio.NewScanner(gzipReader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var signature domain.Signature
+		if err := json.Unmarshal([]byte(line), &signature); err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, signature)
+	}
+
+	return signatures, scanner.Err()
+}
+
+func buildSegmentIndex(file string, content []byte, signatures []domain.Signature) segmentIndex {
+	index := segmentIndex{
+		Version:  segmentIndexVersion,
+		File:     file,
+		Checksum: segmentChecksum(content),
+		UUIDs:    newUUIDFilter(len(signatures)),
+		Devices:  make(map[string]segmentDevice),
+	}
+
+	for _, signature := range signatures {
+		index.UUIDs.add(hashUUID(signature.UUID))
+
+		device, exists := index.Devices[signature.DeviceUUID]
+		if !exists {
+			device = segmentDevice{
+				FirstCounter:   signature.Counter,
+				LastCounter:    signature.Counter,
+				FirstCreatedAt: signature.CreatedAt,
+				LastCreatedAt:  signature.CreatedAt,
+			}
+		}
+
+		device.Count++
+		device.FirstCounter = min(device.FirstCounter, signature.Counter)
+		device.LastCounter = max(device.LastCounter, signature.Counter)
+		if signature.CreatedAt.Before(device.FirstCreatedAt) {
+			device.FirstCreatedAt = signature.CreatedAt
+		}
+		if signature.CreatedAt.After(device.LastCreatedAt) {
+			device.LastCreatedAt = signature.CreatedAt
+		}
+
+		index.Devices[signature.DeviceUUID] = device
+	}
+
+	return index
+}
+
+func segmentChecksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
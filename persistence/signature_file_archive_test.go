@@ -0,0 +1,286 @@
+package persistence
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+func testChain(deviceUUID string, from int, to int, start time.Time) []domain.Signature {
+	signatures := make([]domain.Signature, 0)
+	for i := from; i <= to; i++ {
+		signatures = append(signatures, domain.Signature{
+			UUID:       deviceUUID + "-" + strconv.Itoa(i),
+			DeviceUUID: deviceUUID,
+			Counter:    i,
+			Signature:  deviceUUID + "-signature-" + strconv.Itoa(i),
+			CreatedAt:  start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	return signatures
+}
+
+func TestFileArchiveAppendAndFind(t *testing.T) {
+	directory := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	archive, err := NewFileSignatureArchive(directory)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	archive.Append(context.Background(), append(testChain("device-a", 1, 3, start), testChain("device-b", 1, 2, start)...))
+	archive.Append(context.Background(), testChain("device-a", 4, 6, start))
+
+	signature, err := archive.FindByUUID(context.Background(), "device-a-5")
+	if err != nil || signature == nil || signature.Counter != 5 {
+		t.Fatalf("Expected to find signature device-a-5, got %v (%v)", signature, err)
+	}
+
+	page, err := archive.FindByDevice(context.Background(), SignatureQuery{DeviceUUID: "device-a", CounterFrom: 2, CounterTo: 5}, PageRequest{Limit: 10, IncludeTotal: true})
+	if err != nil {
+		t.Fatalf("Failed to find by device: %v", err)
+	}
+
+	if len(page.Items) != 4 || page.Items[0].Counter != 2 || page.Items[3].Counter != 5 || page.HasMore || *page.Total != 4 {
+		t.Fatalf("Unexpected signatures %v", page.Items)
+	}
+
+	// reopening loads the segment indexes from disk
+	reopened, err := NewFileSignatureArchive(directory)
+	if err != nil {
+		t.Fatalf("Failed to reopen archive: %v", err)
+	}
+
+	page, _ = reopened.FindByDevice(context.Background(), SignatureQuery{DeviceUUID: "device-a"}, PageRequest{Limit: 10})
+	if len(page.Items) != 6 {
+		t.Fatalf("Expected 6 signatures after reopening, got %d", len(page.Items))
+	}
+
+	if signature, _ := reopened.FindByUUID(context.Background(), "device-c-1"); signature != nil {
+		t.Fatalf("Expected no signature of an unknown device, got %v", signature)
+	}
+}
+
+func TestFileArchivePagesWithoutReadingEverySegment(t *testing.T) {
+	directory := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	archive, _ := NewFileSignatureArchive(directory)
+	archive.Append(context.Background(), testChain("device-a", 1, 3, start))
+	archive.Append(context.Background(), testChain("device-a", 4, 6, start))
+	archive.Append(context.Background(), testChain("device-a", 7, 9, start))
+
+	// anything reading the middle segment fails
+	os.WriteFile(filepath.Join(directory, "segment-00000002.jsonl.gz"), []byte("tampered"), 0600)
+
+	page, err := archive.FindByDevice(context.Background(), SignatureQuery{DeviceUUID: "device-a"}, PageRequest{Limit: 2})
+	if err != nil || len(page.Items) != 2 || page.Items[1].Counter != 2 || !page.HasMore {
+		t.Fatalf("Unexpected first page %v (%v)", page.Items, err)
+	}
+
+	page, err = archive.FindByDevice(context.Background(), SignatureQuery{DeviceUUID: "device-a", Order: OrderDescending}, PageRequest{Limit: 2})
+	if err != nil || len(page.Items) != 2 || page.Items[0].Counter != 9 || page.Items[1].Counter != 8 {
+		t.Fatalf("Unexpected descending page %v (%v)", page.Items, err)
+	}
+
+	// whole segments are counted from their index, only the ones at the ends
+	// of the range are read
+	for query, expected := range map[SignatureQuery]int{
+		{DeviceUUID: "device-a"}:                               9,
+		{DeviceUUID: "device-a", CounterFrom: 2, CounterTo: 7}: 6,
+		{DeviceUUID: "device-a", CounterFrom: 8}:               2,
+	} {
+		total, err := archive.CountByDevice(context.Background(), query)
+		if err != nil || total != expected {
+			t.Fatalf("Expected %d signatures for %+v, got %d (%v)", expected, query, total, err)
+		}
+	}
+}
+
+func TestFileArchiveRebuildsIndexesOfOlderVersions(t *testing.T) {
+	directory := t.TempDir()
+
+	archive, _ := NewFileSignatureArchive(directory)
+	archive.Append(context.Background(), testChain("device-a", 1, 3, time.Now()))
+
+	indexFile := filepath.Join(directory, "segment-00000001.jsonl.gz"+segmentIndexSuffix)
+	os.WriteFile(indexFile, []byte(`{"file":"segment-00000001.jsonl.gz","uuids":["device-a-1"]}`), 0600)
+
+	reopened, err := NewFileSignatureArchive(directory)
+	if err != nil {
+		t.Fatalf("Failed to reopen archive: %v", err)
+	}
+
+	if total, _ := reopened.CountByDevice(context.Background(), SignatureQuery{DeviceUUID: "device-a"}); total != 3 {
+		t.Fatalf("Expected the index to be rebuilt with the counts, got %d", total)
+	}
+}
+
+func TestFileArchiveRebuildsMissingIndexes(t *testing.T) {
+	directory := t.TempDir()
+
+	archive, _ := NewFileSignatureArchive(directory)
+	archive.Append(context.Background(), testChain("device-a", 1, 3, time.Now()))
+
+	os.Remove(filepath.Join(directory, "segment-00000001.jsonl.gz"+segmentIndexSuffix))
+
+	reopened, err := NewFileSignatureArchive(directory)
+	if err != nil {
+		t.Fatalf("Failed to reopen archive: %v", err)
+	}
+
+	if signature, _ := reopened.FindByUUID(context.Background(), "device-a-2"); signature == nil {
+		t.Fatal("Expected the index to be rebuilt from the segment")
+	}
+}
+
+func TestFileArchiveDetectsTamperedSegments(t *testing.T) {
+	directory := t.TempDir()
+
+	archive, _ := NewFileSignatureArchive(directory)
+	archive.Append(context.Background(), testChain("device-a", 1, 3, time.Now()))
+
+	segment := filepath.Join(directory, "segment-00000001.jsonl.gz")
+	content, _ := os.ReadFile(segment)
+	content[len(content)-1] ^= 0xFF
+	os.WriteFile(segment, content, 0600)
+
+	if _, err := archive.FindByUUID(context.Background(), "device-a-2"); err == nil {
+		t.Fatal("Expected a tampered segment to be detected")
+	}
+}
+
+func TestEvictOldestSignatures(t *testing.T) {
+	memoryStorage := NewVolatileSignatureRepository()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	saveDeviceChain(t, memoryStorage, "device-a", 5, start)
+
+	firstPage, _ := memoryStorage.List(context.Background(), AllTenants, PageRequest{Limit: 3})
+
+	oldest, _ := memoryStorage.OldestCreatedBefore(context.Background(), start.Add(3*time.Minute), 10)
+	if len(oldest) != 2 {
+		t.Fatalf("Expected the 2 oldest signatures, got %d", len(oldest))
+	}
+
+	if err := memoryStorage.Evict(context.Background(), oldest[1:]); err == nil {
+		t.Fatal("Expected an error when evicting signatures which are not the oldest")
+	}
+
+	if err := memoryStorage.Evict(context.Background(), oldest); err != nil {
+		t.Fatalf("Failed to evict: %v", err)
+	}
+
+	if signature, _ := memoryStorage.FindByUUID(context.Background(), AllTenants, oldest[0].UUID); signature != nil {
+		t.Fatal("Evicted signature is still in storage")
+	}
+
+	// cursors given before the eviction still point to the same signature
+	secondPage, _ := memoryStorage.List(context.Background(), AllTenants, PageRequest{Cursor: firstPage.NextCursor, Limit: 3})
+	if len(secondPage.Items) != 2 || secondPage.Items[0].Counter != 4 {
+		t.Fatalf("Unexpected page after eviction %v", secondPage.Items)
+	}
+
+	page, _ := memoryStorage.FindByDevice(context.Background(), SignatureQuery{DeviceUUID: "device-a"}, PageRequest{Limit: 10})
+	if len(page.Items) != 3 || page.Items[0].Counter != 3 {
+		t.Fatalf("Unexpected device chain after eviction %v", page.Items)
+	}
+}
+
+func TestTieredRepositoryReadsTheChainAcrossTheArchiveBoundary(t *testing.T) {
+	hot := NewVolatileSignatureRepository()
+	archive, _ := NewFileSignatureArchive(t.TempDir())
+	repository := NewSignatureTieredRepository(hot, archive)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	saveDeviceChain(t, hot, "device-a", 6, start)
+
+	oldest, _ := hot.OldestCreatedBefore(context.Background(), start.Add(4*time.Minute), 10)
+	archive.Append(context.Background(), oldest)
+	hot.Evict(context.Background(), oldest)
+
+	if signature, _ := repository.FindByUUID(context.Background(), AllTenants, oldest[0].UUID); signature == nil {
+		t.Fatal("Expected archived signatures to be found by UUID")
+	}
+
+	counters := make([]int, 0)
+	pageRequest := PageRequest{Limit: 2, IncludeTotal: true}
+	for {
+		page, err := repository.FindByDevice(context.Background(), SignatureQuery{DeviceUUID: "device-a"}, pageRequest)
+		if err != nil {
+			t.Fatalf("Failed to find by device: %v", err)
+		}
+
+		if page.Total == nil || *page.Total != 6 {
+			t.Fatalf("Expected a total of 6, got %v", page.Total)
+		}
+
+		for _, signature := range page.Items {
+			counters = append(counters, signature.Counter)
+		}
+
+		if !page.HasMore {
+			break
+		}
+		pageRequest.Cursor = page.NextCursor
+	}
+
+	for i, counter := range counters {
+		if counter != i+1 {
+			t.Fatalf("Expected the whole chain in order, got %v", counters)
+		}
+	}
+
+	page, _ := repository.FindByDevice(context.Background(), SignatureQuery{DeviceUUID: "device-a", Order: OrderDescending}, PageRequest{Limit: 4})
+	if page.Items[0].Counter != 6 || page.Items[3].Counter != 3 || !page.HasMore {
+		t.Fatalf("Unexpected descending page %v", page.Items)
+	}
+
+	// the listing of every signature leaves the archive out, and says so
+	listed, _ := repository.List(context.Background(), AllTenants, PageRequest{Limit: 10, IncludeTotal: true})
+	if len(listed.Items) != 3 || *listed.Total != 3 || !listed.Partial {
+		t.Fatalf("Expected a partial listing of the hot signatures, got %v", listed.Items)
+	}
+}
+
+func TestTieredRepositorySkipsABatchBeingArchived(t *testing.T) {
+	hot := NewVolatileSignatureRepository()
+	archive, _ := NewFileSignatureArchive(t.TempDir())
+	repository := NewSignatureTieredRepository(hot, archive)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	saveDeviceChain(t, hot, "device-a", 5, start)
+
+	// archived but not evicted yet, in both storages
+	oldest, _ := hot.OldestCreatedBefore(context.Background(), start.Add(4*time.Minute), 10)
+	archive.Append(context.Background(), oldest)
+
+	for _, order := range []SortOrder{OrderAscending, OrderDescending} {
+		counters := make([]int, 0)
+		pageRequest := PageRequest{Limit: 2}
+		for {
+			page, err := repository.FindByDevice(context.Background(), SignatureQuery{DeviceUUID: "device-a", Order: order}, pageRequest)
+			if err != nil {
+				t.Fatalf("Failed to find by device: %v", err)
+			}
+
+			for _, signature := range page.Items {
+				counters = append(counters, signature.Counter)
+			}
+
+			if !page.HasMore {
+				break
+			}
+			pageRequest.Cursor = page.NextCursor
+		}
+
+		if len(counters) != 5 {
+			t.Fatalf("Expected every signature once in order %v, got %v", order, counters)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package persistence
+
+import (
+	"context"
+
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+// The tiered repository puts a hot storage (recent signatures, the only one
+// written to) in front of an archive. Signatures are moved from one to the
+// other by the retention service, so the whole chain of a device is found
+// by reading the archive first and then the hot storage.
+type SignatureTieredRepository struct {
+	hot     EvictableSignaturePersistance
+	archive SignatureArchive
+}
+
+func NewSignatureTieredRepository(hot EvictableSignaturePersistance, archive SignatureArchive) *SignatureTieredRepository {
+	return &SignatureTieredRepository{hot: hot, archive: archive}
+}
+
+// Listing every signature of the system only goes through the hot storage, and
+// says so in the page: archived signatures are reachable by UUID and through
+// the device chains. Merging the archive in would mean reading every segment
+// of every tenant.
+func (repository *SignatureTieredRepository) List(ctx context.Context, tenantID string, pageRequest PageRequest) (Page[domain.Signature], error) {
+	page, err := repository.hot.List(ctx, tenantID, pageRequest)
+	page.Partial = true
+	return page, err
+}
+
+func (repository *SignatureTieredRepository) Save(ctx context.Context, signature *domain.Signature) (*domain.Signature, error) {
+	return repository.hot.Save(ctx, signature)
+}
+
+func (repository *SignatureTieredRepository) FindByUUID(ctx context.Context, tenantID string, uuid string) (*domain.Signature, error) {
+	signature, err := repository.hot.FindByUUID(ctx, tenantID, uuid)
+	if err != nil || signature != nil {
+		return signature, err
+	}
+
+	signature, err = repository.archive.FindByUUID(ctx, uuid)
+	if err != nil || signature == nil || !inTenant(tenantID, signature.Tenant()) {
+		return nil, err
+	}
+
+	return signature, nil
+}
+
+// Only the hot storage is looked at: keys are remembered for a window of
+// hours, long gone when the signatures are archived.
+func (repository *SignatureTieredRepository) FindByIdempotencyKey(ctx context.Context, tenantID string, deviceUUID string, key string) (*domain.Signature, error) {
+	return repository.hot.FindByIdempotencyKey(ctx, tenantID, deviceUUID, key)
+}
+
+// Archived signatures always have lower counters than the ones in the hot
+// storage, so the page is read from the tier coming first in the requested
+// order and only filled up from the other one when that one runs out.
+func (repository *SignatureTieredRepository) FindByDevice(ctx context.Context, query SignatureQuery, pageRequest PageRequest) (Page[domain.Signature], error) {
+	cursorCounter, err := validatePageRequest(pageRequest)
+	if err != nil {
+		return Page[domain.Signature]{}, err
+	}
+
+	page := Page[domain.Signature]{Items: make([]domain.Signature, 0, pageRequest.Limit)}
+
+	narrowedQuery, remaining := narrowByCounterCursor(query, cursorCounter)
+	if remaining {
+		first, second := repository.archive.FindByDevice, repository.hot.FindByDevice
+		if query.Order == OrderDescending {
+			first, second = second, first
+		}
+
+		// one more than needed tells us if there is another page
+		firstPage, err := first(ctx, narrowedQuery, PageRequest{Limit: pageRequest.Limit + 1})
+		if err != nil {
+			return page, err
+		}
+		items, hasMore := firstPage.Items, firstPage.HasMore
+
+		if !hasMore && len(items) <= pageRequest.Limit {
+			// The other tier goes on after the last signature of this one,
+			// which also skips a batch being archived, in both tiers meanwhile.
+			secondQuery := narrowedQuery
+			if len(items) > 0 {
+				secondQuery, remaining = narrowByCounterCursor(narrowedQuery, items[len(items)-1].Counter)
+			}
+
+			if remaining {
+				secondPage, err := second(ctx, secondQuery, PageRequest{Limit: pageRequest.Limit + 1 - len(items)})
+				if err != nil {
+					return page, err
+				}
+				items, hasMore = append(items, secondPage.Items...), secondPage.HasMore
+			}
+		}
+
+		page.HasMore = len(items) > pageRequest.Limit || hasMore
+		page.Items = append(page.Items, items[:min(pageRequest.Limit, len(items))]...)
+		if page.HasMore && len(page.Items) > 0 {
+			page.NextCursor = encodeCursor(page.Items[len(page.Items)-1].Counter)
+		}
+	}
+
+	if pageRequest.IncludeTotal {
+		total, err := repository.countDeviceMatches(ctx, query)
+		if err != nil {
+			return page, err
+		}
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+// The archive counts from its indexes. A batch being archived is counted twice
+// until it is evicted from the hot storage.
+func (repository *SignatureTieredRepository) countDeviceMatches(ctx context.Context, query SignatureQuery) (int, error) {
+	archived, err := repository.archive.CountByDevice(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	hot, err := repository.hot.FindByDevice(ctx, query, PageRequest{Limit: 1, IncludeTotal: true})
+	if err != nil {
+		return 0, err
+	}
+
+	return archived + *hot.Total, nil
+}
+
+func (repository *SignatureTieredRepository) CheckHealth(ctx context.Context) domain.PersistenceHealth {
+	if archiveHealth := repository.archive.CheckHealth(ctx); archiveHealth.Status != domain.HealthStatusPass {
+		return archiveHealth
+	}
+
+	return repository.hot.CheckHealth(ctx)
+}
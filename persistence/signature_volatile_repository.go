@@ -1,8 +1,13 @@
 package persistence
 
 import (
+	"context"
 	"errors"
+	"maps"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/chuckiihub/signing-service/domain"
 )
@@ -13,47 +18,147 @@ import (
 // Another way of doing this would be to use a sync.Map.
 
 // The signatureIndexMap is used as an index to the slice of signatures.
-// The lock is used when I am adding new signatures so I can update the index.
+// The deviceIndex keeps, for every device, the positions of its signatures
+// in the slice ordered by counter, so a device chain can be read without
+// scanning the signatures of every other device.
+// The idempotencyIndex points to the last signature of a device made with an
+// Idempotency-Key.
+// The lock is used when I am adding new signatures so I can update the indexes.
+//
+// The oldest signatures can be evicted (see Evict) once they are archived. The
+// indexes hold absolute positions, so evictedCount has to be subtracted to get
+// the index inside the slice.
 type SignatureVolatileRepository struct {
 	signatureIndexMap map[string]int
+	deviceIndex       map[string][]int
+	idempotencyIndex  map[idempotencyIndexKey]int
 	signatures        []domain.Signature
+	evictedCount      int
 	rwLock            sync.RWMutex
 }
 
-func (repository *SignatureVolatileRepository) List(page int, pageSize int) ([]domain.Signature, error) {
+type idempotencyIndexKey struct {
+	deviceUUID string
+	key        string
+}
+
+func (repository *SignatureVolatileRepository) at(position int) *domain.Signature {
+	return &repository.signatures[position-repository.evictedCount]
+}
+
+// Signatures are only appended (or evicted from the beginning), so the position
+// in the log works as a stable cursor even if new signatures are saved while a
+// client is paging.
+func (repository *SignatureVolatileRepository) List(ctx context.Context, tenantID string, pageRequest PageRequest) (Page[domain.Signature], error) {
+	if err := ctx.Err(); err != nil {
+		return Page[domain.Signature]{}, err
+	}
+
+	repository.rwLock.RLock()
+	defer repository.rwLock.RUnlock()
+
+	if tenantID == AllTenants {
+		return paginateLog(repository.signatures, repository.evictedCount, pageRequest)
+	}
+
+	return paginateLogMatching(repository.signatures, repository.evictedCount, pageRequest, func(signature *domain.Signature) bool {
+		return signature.Tenant() == tenantID
+	})
+}
+
+// FindByDevice returns a page of the signatures of query.DeviceUUID. The cursor
+// is the counter of the last signature returned, so pages are stable while new
+// signatures are appended to the chain.
+func (repository *SignatureVolatileRepository) FindByDevice(ctx context.Context, query SignatureQuery, pageRequest PageRequest) (Page[domain.Signature], error) {
+	if err := ctx.Err(); err != nil {
+		return Page[domain.Signature]{}, err
+	}
+
 	repository.rwLock.RLock()
 	defer repository.rwLock.RUnlock()
 
-	if pageSize < 1 {
-		return nil, errors.New("batch size cannot be less than 1")
+	cursorCounter, err := validatePageRequest(pageRequest)
+	if err != nil {
+		return Page[domain.Signature]{}, err
 	}
 
-	if page < 0 {
-		return nil, errors.New("page cannot be less than 0")
+	page := Page[domain.Signature]{Items: make([]domain.Signature, 0, pageRequest.Limit)}
+	if pageRequest.IncludeTotal {
+		total := len(repository.findDeviceMatches(query))
+		page.Total = &total
 	}
 
-	signatures := make([]domain.Signature, 0, pageSize)
+	query, remaining := narrowByCounterCursor(query, cursorCounter)
+	if !remaining {
+		return page, nil
+	}
 
-	lowerLimit := (page - 1) * pageSize
-	higherLimit := (page) * pageSize
+	matches := repository.findDeviceMatches(query)
+	if query.Order == OrderDescending {
+		slices.Reverse(matches)
+	}
 
-	if lowerLimit > len(repository.signatures) {
-		return signatures, nil
+	end := min(pageRequest.Limit, len(matches))
+	for _, position := range matches[:end] {
+		deepCopy := *repository.at(position)
+		page.Items = append(page.Items, deepCopy)
 	}
 
-	if higherLimit > len(repository.signatures) {
-		higherLimit = len(repository.signatures)
+	if end < len(matches) {
+		page.HasMore = true
+		page.NextCursor = encodeCursor(page.Items[end-1].Counter)
 	}
 
+	return page, nil
+}
+
+// Returns the positions of the signatures matching the query ordered by counter.
+// Counter bounds are resolved with a binary search over the device index; the
+// creation time bounds are applied on the remaining range.
+func (repository *SignatureVolatileRepository) findDeviceMatches(query SignatureQuery) []int {
+	positions := repository.deviceIndex[query.DeviceUUID]
+
+	lowerLimit := 0
+	if query.CounterFrom > 0 {
+		lowerLimit = sort.Search(len(positions), func(i int) bool {
+			return repository.at(positions[i]).Counter >= query.CounterFrom
+		})
+	}
+
+	higherLimit := len(positions)
+	if query.CounterTo > 0 {
+		higherLimit = sort.Search(len(positions), func(i int) bool {
+			return repository.at(positions[i]).Counter > query.CounterTo
+		})
+	}
+
+	matches := make([]int, 0)
 	for i := lowerLimit; i < higherLimit; i++ {
-		deepCopy := repository.signatures[i]
-		signatures = append(signatures, deepCopy)
+		if isInCreationRange(query, repository.at(positions[i])) {
+			matches = append(matches, positions[i])
+		}
 	}
 
-	return signatures, nil
+	return matches
 }
 
-func (repository *SignatureVolatileRepository) Save(signature *domain.Signature) (*domain.Signature, error) {
+func isInCreationRange(query SignatureQuery, signature *domain.Signature) bool {
+	if !query.CreatedFrom.IsZero() && signature.CreatedAt.Before(query.CreatedFrom) {
+		return false
+	}
+
+	if !query.CreatedTo.IsZero() && signature.CreatedAt.After(query.CreatedTo) {
+		return false
+	}
+
+	return true
+}
+
+func (repository *SignatureVolatileRepository) Save(ctx context.Context, signature *domain.Signature) (*domain.Signature, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	repository.rwLock.Lock()
 	defer repository.rwLock.Unlock()
 
@@ -61,24 +166,135 @@ func (repository *SignatureVolatileRepository) Save(signature *domain.Signature)
 		return signature, errors.New("signature already exists in storage")
 	}
 
-	repository.signatures = append(repository.signatures, *signature)
-	repository.signatureIndexMap[signature.UUID] = len(repository.signatures) - 1
+	// the metadata map would be shared with the caller otherwise
+	stored := *signature
+	stored.Metadata = maps.Clone(signature.Metadata)
+
+	repository.signatures = append(repository.signatures, stored)
+	position := repository.evictedCount + len(repository.signatures) - 1
+	repository.signatureIndexMap[signature.UUID] = position
+
+	// Signatures are normally saved in counter order, so this is an append
+	// except for the rare case of an out of order save.
+	devicePositions := repository.deviceIndex[signature.DeviceUUID]
+	index := sort.Search(len(devicePositions), func(i int) bool {
+		return repository.at(devicePositions[i]).Counter > signature.Counter
+	})
+	repository.deviceIndex[signature.DeviceUUID] = slices.Insert(devicePositions, index, position)
+
+	if signature.IdempotencyKey != "" {
+		repository.idempotencyIndex[idempotencyIndexKey{signature.DeviceUUID, signature.IdempotencyKey}] = position
+	}
 
 	return signature, nil
 }
 
-func (repository *SignatureVolatileRepository) FindByUUID(uuid string) (*domain.Signature, error) {
+func (repository *SignatureVolatileRepository) FindByUUID(ctx context.Context, tenantID string, uuid string) (*domain.Signature, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	repository.rwLock.RLock()
+	defer repository.rwLock.RUnlock()
+
+	if position, exists := repository.signatureIndexMap[uuid]; exists && inTenant(tenantID, repository.at(position).Tenant()) {
+		deepCopy := *repository.at(position)
+		return &deepCopy, nil
+	}
+
+	return nil, nil
+}
+
+func (repository *SignatureVolatileRepository) FindByIdempotencyKey(ctx context.Context, tenantID string, deviceUUID string, key string) (*domain.Signature, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	repository.rwLock.RLock()
 	defer repository.rwLock.RUnlock()
 
-	if index, exists := repository.signatureIndexMap[uuid]; exists {
-		deepCopy := repository.signatures[index]
+	if position, exists := repository.idempotencyIndex[idempotencyIndexKey{deviceUUID, key}]; exists && inTenant(tenantID, repository.at(position).Tenant()) {
+		deepCopy := *repository.at(position)
 		return &deepCopy, nil
 	}
 
 	return nil, nil
 }
 
-func (repository *SignatureVolatileRepository) CheckHealth() domain.PersistenceHealth {
+// OldestCreatedBefore returns, in storage order, up to limit of the oldest
+// signatures created before cutoff. It stops at the first newer signature, so
+// what it returns can always be evicted as a whole.
+func (repository *SignatureVolatileRepository) OldestCreatedBefore(ctx context.Context, cutoff time.Time, limit int) ([]domain.Signature, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	repository.rwLock.RLock()
+	defer repository.rwLock.RUnlock()
+
+	signatures := make([]domain.Signature, 0)
+	for _, signature := range repository.signatures {
+		if len(signatures) >= limit || !signature.CreatedAt.Before(cutoff) {
+			break
+		}
+		signatures = append(signatures, signature)
+	}
+
+	return signatures, nil
+}
+
+// Evict removes signatures returned by OldestCreatedBefore from memory. They
+// have to be the oldest signatures in storage order, so the positions of the
+// remaining ones (and the cursors given to clients) do not change.
+func (repository *SignatureVolatileRepository) Evict(ctx context.Context, signatures []domain.Signature) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repository.rwLock.Lock()
+	defer repository.rwLock.Unlock()
+
+	if len(signatures) > len(repository.signatures) {
+		return errors.New("cannot evict more signatures than stored")
+	}
+
+	for i, signature := range signatures {
+		if repository.signatures[i].UUID != signature.UUID {
+			return errors.New("only the oldest signatures can be evicted")
+		}
+	}
+
+	affectedDevices := make(map[string]bool)
+	for i, signature := range signatures {
+		delete(repository.signatureIndexMap, signature.UUID)
+		affectedDevices[signature.DeviceUUID] = true
+
+		// unless a later signature reused the key
+		key := idempotencyIndexKey{signature.DeviceUUID, signature.IdempotencyKey}
+		if position, exists := repository.idempotencyIndex[key]; exists && position == repository.evictedCount+i {
+			delete(repository.idempotencyIndex, key)
+		}
+	}
+
+	repository.evictedCount += len(signatures)
+	// cloning releases the memory of the evicted signatures
+	repository.signatures = slices.Clone(repository.signatures[len(signatures):])
+
+	for deviceUUID := range affectedDevices {
+		positions := slices.DeleteFunc(repository.deviceIndex[deviceUUID], func(position int) bool {
+			return position < repository.evictedCount
+		})
+
+		if len(positions) == 0 {
+			delete(repository.deviceIndex, deviceUUID)
+		} else {
+			repository.deviceIndex[deviceUUID] = positions
+		}
+	}
+
+	return nil
+}
+
+func (repository *SignatureVolatileRepository) CheckHealth(ctx context.Context) domain.PersistenceHealth {
 	return domain.PersistenceHealth{Status: domain.HealthStatusPass}
 }
@@ -0,0 +1,98 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+// Tenants are loaded once and never change while the service runs.
+type VolatileTenantRepository struct {
+	tenants []domain.Tenant
+}
+
+// The default tenant is added when the list does not configure it.
+func NewVolatileTenantRepository(tenants []domain.Tenant) (*VolatileTenantRepository, error) {
+	seen := make(map[string]bool, len(tenants))
+	for _, tenant := range tenants {
+		if tenant.ID == "" {
+			return nil, errors.New("every tenant needs an id")
+		}
+
+		if seen[tenant.ID] {
+			return nil, fmt.Errorf("tenant %q is configured twice", tenant.ID)
+		}
+		seen[tenant.ID] = true
+
+		for _, algorithm := range tenant.AllowedAlgorithms {
+			if _, err := crypto.ParseSignatureAlgorithm(algorithm); err != nil {
+				return nil, fmt.Errorf("tenant %q: %w", tenant.ID, err)
+			}
+		}
+
+		if tenant.MaxDevices < 0 || tenant.SignaturesPerMinute < 0 {
+			return nil, fmt.Errorf("tenant %q: limits cannot be negative", tenant.ID)
+		}
+	}
+
+	tenants = slices.Clone(tenants)
+	if !seen[domain.DefaultTenantID] {
+		tenants = append([]domain.Tenant{{ID: domain.DefaultTenantID, Name: "Default"}}, tenants...)
+	}
+
+	return &VolatileTenantRepository{tenants: tenants}, nil
+}
+
+// Reads the tenants from a JSON file like
+//
+//	{"tenants": [{"id": "acme", "name": "ACME", "allowedAlgorithms": ["ECC"], "maxDevices": 100, "signaturesPerMinute": 600}]}
+func LoadTenantsFile(path string) (*VolatileTenantRepository, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Tenants []domain.Tenant `json:"tenants"`
+	}
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid tenants file %s: %w", path, err)
+	}
+
+	return NewVolatileTenantRepository(file.Tenants)
+}
+
+func (repository *VolatileTenantRepository) FindByID(ctx context.Context, id string) (*domain.Tenant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, tenant := range repository.tenants {
+		if tenant.ID == id {
+			tenant.AllowedAlgorithms = slices.Clone(tenant.AllowedAlgorithms)
+			return &tenant, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (repository *VolatileTenantRepository) List(ctx context.Context) ([]domain.Tenant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tenants := make([]domain.Tenant, 0, len(repository.tenants))
+	for _, tenant := range repository.tenants {
+		tenant.AllowedAlgorithms = slices.Clone(tenant.AllowedAlgorithms)
+		tenants = append(tenants, tenant)
+	}
+
+	return tenants, nil
+}
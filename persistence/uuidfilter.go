@@ -0,0 +1,56 @@
+package persistence
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Bloom filter of the UUIDs in an archive segment. It takes about 10 bits per
+// UUID instead of the UUIDs themselves, and says a segment may have a UUID it
+// doesn't about 1% of the time, which only costs reading that segment.
+type uuidFilter []byte
+
+const (
+	uuidFilterBitsPerItem = 10
+	uuidFilterHashes      = 7
+)
+
+// The two halves of a hash of the UUID, the positions of the filter are
+// derived from them (double hashing). A lookup hashes once for every segment.
+type uuidHash struct {
+	h1 uint64
+	h2 uint64
+}
+
+func hashUUID(uuid string) uuidHash {
+	sum := sha256.Sum256([]byte(uuid))
+	return uuidHash{h1: binary.BigEndian.Uint64(sum[:8]), h2: binary.BigEndian.Uint64(sum[8:16])}
+}
+
+func newUUIDFilter(count int) uuidFilter {
+	return make(uuidFilter, max(1, (count*uuidFilterBitsPerItem+7)/8))
+}
+
+func (filter uuidFilter) add(hash uuidHash) {
+	bits := uint64(len(filter)) * 8
+	for i := uint64(0); i < uuidFilterHashes; i++ {
+		bit := (hash.h1 + i*hash.h2) % bits
+		filter[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (filter uuidFilter) mayContain(hash uuidHash) bool {
+	if len(filter) == 0 {
+		return false
+	}
+
+	bits := uint64(len(filter)) * 8
+	for i := uint64(0); i < uuidFilterHashes; i++ {
+		bit := (hash.h1 + i*hash.h2) % bits
+		if filter[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
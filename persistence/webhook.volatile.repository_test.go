@@ -0,0 +1,64 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+func TestWebhookDeletedEndpointsAreNotFound(t *testing.T) {
+	repository := NewVolatileWebhookRepository()
+	ctx := context.Background()
+
+	repository.CreateEndpoint(ctx, &domain.WebhookEndpoint{ID: "a", TenantID: "acme"})
+	repository.CreateEndpoint(ctx, &domain.WebhookEndpoint{ID: "b", TenantID: "acme", Types: []domain.EventType{domain.EventSignatureCreated}})
+
+	if endpoint, _ := repository.FindEndpoint(ctx, "other", "a"); endpoint != nil {
+		t.Fatalf("Found the endpoint of another tenant")
+	}
+
+	if deleted, err := repository.DeleteEndpoint(ctx, "acme", "a"); !deleted || err != nil {
+		t.Fatalf("Expected the endpoint to be deleted, got %v %v", deleted, err)
+	}
+
+	if endpoint, _ := repository.FindEndpoint(ctx, "acme", "a"); endpoint != nil {
+		t.Fatalf("Found a deleted endpoint")
+	}
+
+	page, _ := repository.ListEndpoints(ctx, "acme", PageRequest{Limit: 10})
+	if len(page.Items) != 1 || page.Items[0].ID != "b" {
+		t.Fatalf("Expected only the endpoint left, got %v", page.Items)
+	}
+
+	subscribed, _ := repository.FindSubscribedEndpoints(ctx, "acme", domain.EventDeviceCreated)
+	if len(subscribed) != 0 {
+		t.Fatalf("Expected no endpoint for device events, got %v", subscribed)
+	}
+}
+
+func TestWebhookDueDeliveriesOldestFirst(t *testing.T) {
+	repository := NewVolatileWebhookRepository()
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	repository.SaveDelivery(ctx, &domain.WebhookDelivery{ID: "later", Status: domain.WebhookDeliveryPending, NextAttemptAt: now})
+	repository.SaveDelivery(ctx, &domain.WebhookDelivery{ID: "first", Status: domain.WebhookDeliveryPending, NextAttemptAt: now.Add(-time.Minute)})
+	repository.SaveDelivery(ctx, &domain.WebhookDelivery{ID: "future", Status: domain.WebhookDeliveryPending, NextAttemptAt: now.Add(time.Minute)})
+	repository.SaveDelivery(ctx, &domain.WebhookDelivery{ID: "done", Status: domain.WebhookDeliveryDelivered, NextAttemptAt: now})
+
+	due, _ := repository.DueDeliveries(ctx, now, 10)
+	if len(due) != 2 || due[0].ID != "first" || due[1].ID != "later" {
+		t.Fatalf("Expected the two pending deliveries due, oldest first, got %v", due)
+	}
+
+	// saving again replaces the delivery instead of adding one
+	due[0].Status = domain.WebhookDeliveryDelivered
+	repository.SaveDelivery(ctx, &due[0])
+
+	due, _ = repository.DueDeliveries(ctx, now, 10)
+	if len(due) != 1 || due[0].ID != "later" {
+		t.Fatalf("Expected only the other delivery due, got %v", due)
+	}
+}
@@ -0,0 +1,224 @@
+package persistence
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+)
+
+// Deleted endpoints stay in the slice, marked, so positions work as stable
+// cursors like in the other repositories. Deliveries are never removed.
+type VolatileWebhookRepository struct {
+	endpoints     []volatileWebhookEndpoint
+	endpointIndex map[string]int
+	deliveries    []domain.WebhookDelivery
+	deliveryIndex map[string]int
+	rwMutex       sync.RWMutex
+}
+
+type volatileWebhookEndpoint struct {
+	endpoint domain.WebhookEndpoint
+	deleted  bool
+}
+
+func (repository *VolatileWebhookRepository) CreateEndpoint(ctx context.Context, endpoint *domain.WebhookEndpoint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repository.rwMutex.Lock()
+	defer repository.rwMutex.Unlock()
+
+	repository.endpoints = append(repository.endpoints, volatileWebhookEndpoint{endpoint: cloneWebhookEndpoint(endpoint)})
+	repository.endpointIndex[endpoint.ID] = len(repository.endpoints) - 1
+
+	return nil
+}
+
+func (repository *VolatileWebhookRepository) DeleteEndpoint(ctx context.Context, tenantID string, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	repository.rwMutex.Lock()
+	defer repository.rwMutex.Unlock()
+
+	position, found := repository.findEndpoint(tenantID, id)
+	if !found {
+		return false, nil
+	}
+
+	repository.endpoints[position].deleted = true
+	delete(repository.endpointIndex, id)
+
+	return true, nil
+}
+
+func (repository *VolatileWebhookRepository) FindEndpoint(ctx context.Context, tenantID string, id string) (*domain.WebhookEndpoint, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	repository.rwMutex.RLock()
+	defer repository.rwMutex.RUnlock()
+
+	if position, found := repository.findEndpoint(tenantID, id); found {
+		endpoint := cloneWebhookEndpoint(&repository.endpoints[position].endpoint)
+		return &endpoint, nil
+	}
+
+	return nil, nil
+}
+
+func (repository *VolatileWebhookRepository) ListEndpoints(ctx context.Context, tenantID string, pageRequest PageRequest) (Page[domain.WebhookEndpoint], error) {
+	if err := ctx.Err(); err != nil {
+		return Page[domain.WebhookEndpoint]{}, err
+	}
+
+	repository.rwMutex.RLock()
+	defer repository.rwMutex.RUnlock()
+
+	stored, err := paginateLogMatching(repository.endpoints, 0, pageRequest, func(stored *volatileWebhookEndpoint) bool {
+		return !stored.deleted && inTenant(tenantID, stored.endpoint.TenantID)
+	})
+	if err != nil {
+		return Page[domain.WebhookEndpoint]{}, err
+	}
+
+	page := Page[domain.WebhookEndpoint]{
+		Items:      make([]domain.WebhookEndpoint, 0, len(stored.Items)),
+		NextCursor: stored.NextCursor,
+		HasMore:    stored.HasMore,
+		Total:      stored.Total,
+	}
+	for i := range stored.Items {
+		page.Items = append(page.Items, cloneWebhookEndpoint(&stored.Items[i].endpoint))
+	}
+
+	return page, nil
+}
+
+func (repository *VolatileWebhookRepository) FindSubscribedEndpoints(ctx context.Context, tenantID string, eventType domain.EventType) ([]domain.WebhookEndpoint, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	repository.rwMutex.RLock()
+	defer repository.rwMutex.RUnlock()
+
+	var endpoints []domain.WebhookEndpoint
+	for i := range repository.endpoints {
+		stored := &repository.endpoints[i]
+		if !stored.deleted && stored.endpoint.TenantID == tenantID && stored.endpoint.Wants(eventType) {
+			endpoints = append(endpoints, cloneWebhookEndpoint(&stored.endpoint))
+		}
+	}
+
+	return endpoints, nil
+}
+
+func (repository *VolatileWebhookRepository) SaveDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repository.rwMutex.Lock()
+	defer repository.rwMutex.Unlock()
+
+	if position, exists := repository.deliveryIndex[delivery.ID]; exists {
+		repository.deliveries[position] = cloneWebhookDelivery(delivery)
+		return nil
+	}
+
+	repository.deliveries = append(repository.deliveries, cloneWebhookDelivery(delivery))
+	repository.deliveryIndex[delivery.ID] = len(repository.deliveries) - 1
+
+	return nil
+}
+
+func (repository *VolatileWebhookRepository) FindDelivery(ctx context.Context, tenantID string, id string) (*domain.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	repository.rwMutex.RLock()
+	defer repository.rwMutex.RUnlock()
+
+	if position, exists := repository.deliveryIndex[id]; exists && inTenant(tenantID, repository.deliveries[position].TenantID) {
+		delivery := cloneWebhookDelivery(&repository.deliveries[position])
+		return &delivery, nil
+	}
+
+	return nil, nil
+}
+
+func (repository *VolatileWebhookRepository) ListDeliveries(ctx context.Context, query WebhookDeliveryQuery, pageRequest PageRequest) (Page[domain.WebhookDelivery], error) {
+	if err := ctx.Err(); err != nil {
+		return Page[domain.WebhookDelivery]{}, err
+	}
+
+	repository.rwMutex.RLock()
+	defer repository.rwMutex.RUnlock()
+
+	page, err := paginateLogMatching(repository.deliveries, 0, pageRequest, query.Matches)
+	for i := range page.Items {
+		page.Items[i] = cloneWebhookDelivery(&page.Items[i])
+	}
+
+	return page, err
+}
+
+func (repository *VolatileWebhookRepository) DueDeliveries(ctx context.Context, at time.Time, limit int) ([]domain.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	repository.rwMutex.RLock()
+	defer repository.rwMutex.RUnlock()
+
+	var due []domain.WebhookDelivery
+	for i := range repository.deliveries {
+		delivery := &repository.deliveries[i]
+		if delivery.Status == domain.WebhookDeliveryPending && !delivery.NextAttemptAt.After(at) {
+			due = append(due, cloneWebhookDelivery(delivery))
+		}
+	}
+
+	slices.SortStableFunc(due, func(a domain.WebhookDelivery, b domain.WebhookDelivery) int {
+		return a.NextAttemptAt.Compare(b.NextAttemptAt)
+	})
+
+	return due[:min(limit, len(due))], nil
+}
+
+// Only endpoints that are not deleted are found.
+func (repository *VolatileWebhookRepository) findEndpoint(tenantID string, id string) (int, bool) {
+	position, exists := repository.endpointIndex[id]
+	if !exists || !inTenant(tenantID, repository.endpoints[position].endpoint.TenantID) {
+		return 0, false
+	}
+
+	return position, true
+}
+
+func cloneWebhookEndpoint(endpoint *domain.WebhookEndpoint) domain.WebhookEndpoint {
+	deepCopy := *endpoint
+	deepCopy.Types = slices.Clone(endpoint.Types)
+
+	return deepCopy
+}
+
+func cloneWebhookDelivery(delivery *domain.WebhookDelivery) domain.WebhookDelivery {
+	deepCopy := *delivery
+	deepCopy.Payload = slices.Clone(delivery.Payload)
+	deepCopy.Attempts = slices.Clone(delivery.Attempts)
+	if delivery.DeliveredAt != nil {
+		deliveredAt := *delivery.DeliveredAt
+		deepCopy.DeliveredAt = &deliveredAt
+	}
+
+	return deepCopy
+}
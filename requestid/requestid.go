@@ -0,0 +1,44 @@
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// The request ID ties together everything done for one request: logs, audit
+// events and the answer to the client. The transport layer sets it, taking the
+// one of the client when given.
+
+type contextKey struct{}
+
+func New() string {
+	return uuid.NewString()
+}
+
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+// Returns the request ID, empty when the call did not come from a request,
+// like the background jobs.
+func FromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(contextKey{}).(string)
+	return requestID
+}
+
+// Request IDs sent by clients end up in logs and in the audit log, only short
+// printable ones are taken.
+func IsValid(requestID string) bool {
+	if requestID == "" || len(requestID) > 128 {
+		return false
+	}
+
+	for _, character := range requestID {
+		if character < '!' || character > '~' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/identity"
+)
+
+// Roles every policy starts with, unless a policy file says otherwise.
+const (
+	RoleAdmin   = "admin"
+	RoleSigner  = "signer"
+	RoleAuditor = "auditor"
+	RoleViewer  = "viewer"
+)
+
+// Role is a named set of scopes, so keys don't have to list them one by one.
+type Role struct {
+	Scopes []string `json:"scopes"`
+	// Keys with the role must be assigned the devices they can use.
+	AssignedDevicesOnly bool `json:"assignedDevicesOnly"`
+}
+
+// AccessPolicy says what the roles of the API keys grant. Roles are resolved
+// when a key authenticates, so a changed policy applies to every key from
+// then on, without touching the keys.
+type AccessPolicy struct {
+	roles map[string]Role
+}
+
+// Admins run the devices and the keys of their tenant, signers only sign with
+// the devices assigned to them, auditors read the signatures and the audit log
+// and viewers can look but not touch.
+func DefaultAccessPolicy() *AccessPolicy {
+	return &AccessPolicy{roles: map[string]Role{
+		RoleAdmin: {Scopes: []string{
+			domain.ScopeDeviceCreate,
+			domain.ScopeDeviceRead,
+			domain.ScopeDeviceKeys,
+			domain.ScopeDeviceUpdate,
+			domain.ScopeSignatureRead,
+			domain.ScopeVerify,
+			domain.ScopeAuditRead,
+			domain.ScopeAdmin,
+			domain.ScopeWebhooks,
+		}},
+		RoleSigner: {Scopes: []string{domain.ScopeSign}, AssignedDevicesOnly: true},
+		RoleAuditor: {Scopes: []string{
+			domain.ScopeDeviceRead,
+			domain.ScopeSignatureRead,
+			domain.ScopeVerify,
+			domain.ScopeAuditRead,
+		}},
+		RoleViewer: {Scopes: []string{domain.ScopeDeviceRead, domain.ScopeSignatureRead}},
+	}}
+}
+
+func NewAccessPolicy(roles map[string]Role) (*AccessPolicy, error) {
+	for name, role := range roles {
+		if name == "" {
+			return nil, fmt.Errorf("roles need a name")
+		}
+
+		for _, scope := range role.Scopes {
+			if !slices.Contains(domain.Scopes, scope) {
+				return nil, fmt.Errorf("role %s has the unknown scope %q", name, scope)
+			}
+		}
+	}
+
+	return &AccessPolicy{roles: roles}, nil
+}
+
+// The file looks like `{"roles": {"signer": {"scopes": ["sign"], "assignedDevicesOnly": true}}}`
+// and replaces the default roles.
+func LoadAccessPolicyFile(path string) (*AccessPolicy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Roles map[string]Role `json:"roles"`
+	}
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("could not parse the access policy file: %w", err)
+	}
+
+	return NewAccessPolicy(file.Roles)
+}
+
+func (policy *AccessPolicy) Role(name string) (Role, bool) {
+	role, found := policy.roles[name]
+	return role, found
+}
+
+// Identity requests made with the key act as. Roles that are not in the policy
+// anymore grant nothing.
+func (policy *AccessPolicy) Identity(key *domain.APIKey) identity.Identity {
+	scopes := slices.Clone(key.Scopes)
+	for _, name := range key.Roles {
+		scopes = append(scopes, policy.roles[name].Scopes...)
+	}
+
+	return identity.Identity{
+		Name:     key.Name,
+		KeyID:    key.ID,
+		Scopes:   slices.Compact(slices.Sorted(slices.Values(scopes))),
+		Devices:  key.Devices,
+		TenantID: key.TenantID,
+	}
+}
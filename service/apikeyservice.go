@@ -0,0 +1,340 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// API keys look like `ssk_<id>.<secret>`. The ID is used to find the key and
+// is not secret, it shows up in listings and logs.
+const apiKeyPrefix = "ssk_"
+
+// Name of the key created on startup, see Bootstrap.
+const BootstrapAPIKeyName = "bootstrap"
+
+// Every bad key counts as a failure, no matter if the ID exists, so clients
+// can't tell which IDs are valid either.
+var errInvalidAPIKey = apperrors.WrapCodedError(errors.New("invalid, expired or revoked API key"), apperrors.Unauthorized, apperrors.CodeInvalidAPIKey)
+
+// What a new API key can do.
+type APIKeyGrant struct {
+	Scopes []string
+	// Roles of the access policy.
+	Roles []string
+	// Devices the key is restricted to, required by some roles (see Role).
+	Devices []string
+}
+
+type APIKeyService interface {
+	// Returns the new key and its secret, which is not stored anywhere and
+	// can't be recovered. A nil expiresAt means the key does not expire and an
+	// empty tenantID means the tenant of the caller.
+	Create(ctx context.Context, name string, tenantID string, grant APIKeyGrant, expiresAt *time.Time) (*domain.APIKey, string, error)
+	Get(ctx context.Context, id string) (*domain.APIKey, error)
+	List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.APIKey], error)
+	// Revoked keys stay listed but can't authenticate anymore.
+	Revoke(ctx context.Context, id string) (*domain.APIKey, error)
+	// Checks the secret sent by a client, identified by its address so failed
+	// attempts can be rate limited, and returns who the requests act as.
+	Authenticate(ctx context.Context, client string, secret string) (identity.Identity, error)
+}
+
+// Keys are managed by the admins of each tenant, only operators can see and
+// create the keys of other tenants, see checkGrant for the scopes they can grant.
+type APIKeyServiceImplementation struct {
+	persistence persistence.APIKeyPersistance
+	tenants     persistence.TenantPersistance
+	policy      *AccessPolicy
+	pageLimits  PageLimits
+	failures    *windowLimiter
+	maxFailures int
+	clock       Clock
+}
+
+// Clients get maxFailures failed attempts every failureWindow.
+func NewAPIKeyService(persistence persistence.APIKeyPersistance, tenants persistence.TenantPersistance, policy *AccessPolicy, pageLimits PageLimits, maxFailures int, failureWindow time.Duration) *APIKeyServiceImplementation {
+	return &APIKeyServiceImplementation{
+		persistence: persistence,
+		tenants:     tenants,
+		policy:      policy,
+		pageLimits:  pageLimits,
+		failures:    newWindowLimiter(failureWindow),
+		maxFailures: maxFailures,
+		clock:       NewMonotonicClock(),
+	}
+}
+
+func (service *APIKeyServiceImplementation) Create(ctx context.Context, name string, tenantID string, grant APIKeyGrant, expiresAt *time.Time) (*domain.APIKey, string, error) {
+	id, err := randomString(8)
+	if err != nil {
+		return nil, "", apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, "", apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return service.create(ctx, name, tenantID, id, secret, grant, expiresAt)
+}
+
+// There is no way to call the API without a key, so the service starts with one
+// of the default tenant holding every scope. The key comes from the configuration so it survives
+// restarts, when it is empty one is generated and returned.
+func (service *APIKeyServiceImplementation) Bootstrap(ctx context.Context, key string) (*domain.APIKey, string, error) {
+	if key == "" {
+		return service.Create(ctx, BootstrapAPIKeyName, domain.DefaultTenantID, APIKeyGrant{Scopes: domain.Scopes}, nil)
+	}
+
+	id, secret, ok := parseAPIKey(key)
+	if !ok {
+		return nil, "", fmt.Errorf("the bootstrap API key must look like %s<id>.<secret>", apiKeyPrefix)
+	}
+
+	return service.create(ctx, BootstrapAPIKeyName, domain.DefaultTenantID, id, secret, APIKeyGrant{Scopes: domain.Scopes}, nil)
+}
+
+func (service *APIKeyServiceImplementation) create(ctx context.Context, name string, tenantID string, id string, secret string, grant APIKeyGrant, expiresAt *time.Time) (*domain.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", apperrors.WrapError(errors.New("API keys need a name"), apperrors.BadRequest)
+	}
+
+	if len(grant.Scopes) == 0 && len(grant.Roles) == 0 {
+		return nil, "", apperrors.WrapError(errors.New("API keys need at least one scope or role"), apperrors.BadRequest)
+	}
+
+	caller := identity.FromContext(ctx)
+	if err := service.checkGrant(caller, grant); err != nil {
+		return nil, "", err
+	}
+
+	if tenantID == "" {
+		tenantID = domain.TenantOrDefault(caller.TenantID)
+	}
+
+	if tenantID != visibleTenant(caller) && visibleTenant(caller) != persistence.AllTenants {
+		return nil, "", apperrors.WrapError(errors.New("only operators can create keys for other tenants"), apperrors.Forbidden)
+	}
+
+	tenant, err := service.tenants.FindByID(ctx, tenantID)
+	if err != nil {
+		return nil, "", apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	if tenant == nil {
+		return nil, "", apperrors.WrapError(fmt.Errorf("unknown tenant %q", tenantID), apperrors.BadRequest)
+	}
+
+	now := service.clock.Now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, "", apperrors.WrapError(errors.New("expiresAt must be in the future"), apperrors.BadRequest)
+	}
+
+	key := &domain.APIKey{
+		ID:         id,
+		Name:       name,
+		TenantID:   tenantID,
+		SecretHash: hashAPIKeySecret(secret),
+		Scopes:     sortedSet(grant.Scopes),
+		Roles:      sortedSet(grant.Roles),
+		Devices:    sortedSet(grant.Devices),
+		CreatedAt:  now,
+		CreatedBy:  identity.FromContext(ctx).Name,
+		ExpiresAt:  expiresAt,
+	}
+
+	if err := service.persistence.Create(ctx, key); err != nil {
+		if errors.Is(err, persistence.ErrDuplicateAPIKey) {
+			return nil, "", apperrors.WrapCodedError(fmt.Errorf("there already is an API key named %q", name), apperrors.Conflict, apperrors.CodeAlreadyExists)
+		}
+		return nil, "", apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return key, apiKeyPrefix + id + "." + secret, nil
+}
+
+// Keys of other tenants are not found, unless the caller is an operator.
+func (service *APIKeyServiceImplementation) Get(ctx context.Context, id string) (*domain.APIKey, error) {
+	key, err := service.persistence.FindByID(ctx, id)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	if tenantID := visibleTenant(identity.FromContext(ctx)); key != nil && tenantID != persistence.AllTenants && key.TenantID != tenantID {
+		return nil, nil
+	}
+
+	return key, nil
+}
+
+func (service *APIKeyServiceImplementation) List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.APIKey], error) {
+	keys, err := service.persistence.List(ctx, visibleTenant(identity.FromContext(ctx)), service.pageLimits.apply(pageRequest))
+	if err != nil {
+		return persistence.Page[domain.APIKey]{}, wrapListError(err)
+	}
+
+	return keys, nil
+}
+
+func (service *APIKeyServiceImplementation) Revoke(ctx context.Context, id string) (*domain.APIKey, error) {
+	key, err := service.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		return nil, apperrors.WrapCodedError(errors.New("API key not found"), apperrors.NotFound, apperrors.CodeAPIKeyNotFound)
+	}
+
+	// revoking twice keeps the first revocation
+	if key.IsRevoked() {
+		return key, nil
+	}
+
+	revokedAt := service.clock.Now()
+	key.RevokedAt = &revokedAt
+	key.RevokedBy = identity.FromContext(ctx).Name
+
+	if err := service.persistence.Update(ctx, key); err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return key, nil
+}
+
+func (service *APIKeyServiceImplementation) Authenticate(ctx context.Context, client string, secret string) (identity.Identity, error) {
+	now := service.clock.Now()
+	if retryAfter, blocked := service.failures.exceeded(client, service.maxFailures, now); blocked {
+		return identity.Identity{}, apperrors.WrapError(&RateLimitError{Reason: "too many failed authentication attempts", RetryAfter: retryAfter}, apperrors.TooManyRequests)
+	}
+
+	key, err := service.findKey(ctx, secret)
+	if err != nil {
+		return identity.Identity{}, err
+	}
+
+	if key == nil || key.IsRevoked() || key.IsExpired(now) {
+		service.failures.add(client, now)
+
+		keyId := ""
+		if key != nil {
+			keyId = key.ID
+		}
+		slog.Warn("failed authentication attempt", "client", client, "keyId", keyId)
+
+		return identity.Identity{}, errInvalidAPIKey
+	}
+
+	return service.policy.Identity(key), nil
+}
+
+// Admins can grant any scope of their tenant, directly or through roles, as
+// handing out signer keys is their job. Operator is the exception, it reaches
+// every tenant, and keys without the admin scope can only grant what they hold.
+func (service *APIKeyServiceImplementation) checkGrant(caller identity.Identity, grant APIKeyGrant) error {
+	scopes := grant.Scopes
+	assignedDevicesOnly := false
+
+	for _, name := range grant.Roles {
+		role, found := service.policy.Role(name)
+		if !found {
+			return apperrors.WrapError(fmt.Errorf("unknown role %q", name), apperrors.BadRequest)
+		}
+
+		scopes = append(slices.Clip(scopes), role.Scopes...)
+		assignedDevicesOnly = assignedDevicesOnly || role.AssignedDevicesOnly
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(domain.Scopes, scope) {
+			return apperrors.WrapError(fmt.Errorf("unknown scope %q", scope), apperrors.BadRequest)
+		}
+
+		canGrant := caller.HasScope(scope) || (caller.HasScope(domain.ScopeAdmin) && scope != domain.ScopeOperator)
+		if caller.IsAuthenticated() && !canGrant {
+			return apperrors.WrapError(fmt.Errorf("cannot grant the %s scope without holding it", scope), apperrors.Forbidden)
+		}
+	}
+
+	if assignedDevicesOnly && len(grant.Devices) == 0 {
+		return apperrors.WrapError(fmt.Errorf("keys with the roles %s must be assigned devices", strings.Join(grant.Roles, ", ")), apperrors.BadRequest)
+	}
+
+	return nil
+}
+
+// Returns nil when the secret does not match any key.
+func (service *APIKeyServiceImplementation) findKey(ctx context.Context, secret string) (*domain.APIKey, error) {
+	id, secret, ok := parseAPIKey(secret)
+	if !ok {
+		return nil, nil
+	}
+
+	key, err := service.persistence.FindByID(ctx, id)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.SecretHash), []byte(hashAPIKeySecret(secret))) != 1 {
+		return nil, nil
+	}
+
+	return key, nil
+}
+
+// Operators, and calls not made through the API, see the keys of every tenant.
+func visibleTenant(caller identity.Identity) string {
+	if !caller.IsAuthenticated() || caller.HasScope(domain.ScopeOperator) {
+		return persistence.AllTenants
+	}
+
+	return caller.TenantID
+}
+
+// Never nil, so keys without scopes list them as empty.
+func sortedSet(values []string) []string {
+	sorted := append([]string{}, values...)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+func parseAPIKey(key string) (string, string, bool) {
+	id, secret, found := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), ".")
+	if !strings.HasPrefix(key, apiKeyPrefix) || !found || id == "" || secret == "" {
+		return "", "", false
+	}
+
+	return id, secret, true
+}
+
+// Secrets are 32 random bytes, too long to be guessed, so a plain SHA-256 is
+// enough and keeps authentication cheap. Passwords would need a slow hash.
+func hashAPIKeySecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomString(length int) (string, error) {
+	random := make([]byte, length)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+// Clock that stays where the test puts it.
+type stoppedClock struct {
+	now time.Time
+}
+
+func (clock *stoppedClock) Now() time.Time {
+	return clock.now
+}
+
+func newTestAPIKeyService() (*APIKeyServiceImplementation, *stoppedClock) {
+	clock := &stoppedClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	tenants, _ := persistence.NewVolatileTenantRepository([]domain.Tenant{{ID: "acme"}})
+	apiKeyService := NewAPIKeyService(persistence.NewVolatileAPIKeyRepository(), tenants, DefaultAccessPolicy(), PageLimits{Default: 10, Max: 10}, 3, time.Minute)
+	apiKeyService.clock = clock
+
+	return apiKeyService, clock
+}
+
+func assertAppErrorType(t *testing.T, err error, expected int) {
+	var appErr apperrors.AppError
+	if assert.True(t, errors.As(err, &appErr), "expected an AppError, got %v", err) {
+		assert.Equal(t, expected, appErr.Type)
+	}
+}
+
+func assertAppErrorCode(t *testing.T, err error, expected string) {
+	var appErr apperrors.AppError
+	if assert.True(t, errors.As(err, &appErr), "expected an AppError, got %v", err) {
+		assert.Equal(t, expected, appErr.ErrorCode())
+	}
+}
+
+func TestAPIKeyService_CreateAndAuthenticate(t *testing.T) {
+	apiKeyService, _ := newTestAPIKeyService()
+	ctx := identity.NewContext(context.Background(), identity.Identity{Name: "operator"})
+
+	key, secret, err := apiKeyService.Create(ctx, "till-42", "", APIKeyGrant{Scopes: []string{domain.ScopeVerify, domain.ScopeSign, domain.ScopeSign}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{domain.ScopeSign, domain.ScopeVerify}, key.Scopes)
+	assert.Equal(t, "operator", key.CreatedBy)
+	assert.NotContains(t, key.SecretHash, secret)
+
+	authenticated, err := apiKeyService.Authenticate(context.Background(), "10.0.0.1", secret)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, authenticated.KeyID)
+	assert.True(t, authenticated.HasScope(domain.ScopeSign))
+	assert.False(t, authenticated.HasScope(domain.ScopeAdmin))
+
+	_, err = apiKeyService.Authenticate(context.Background(), "10.0.0.1", secret+"x")
+	assertAppErrorType(t, err, http.StatusUnauthorized)
+
+	_, _, err = apiKeyService.Create(ctx, "till-42", "", APIKeyGrant{Scopes: []string{domain.ScopeSign}}, nil)
+	assertAppErrorType(t, err, http.StatusConflict)
+
+	_, _, err = apiKeyService.Create(ctx, "till-43", "", APIKeyGrant{Scopes: []string{"sign", "everything"}}, nil)
+	assertAppErrorType(t, err, http.StatusBadRequest)
+}
+
+func TestAPIKeyService_RevokedAndExpiredKeysDoNotAuthenticate(t *testing.T) {
+	apiKeyService, clock := newTestAPIKeyService()
+
+	expiresAt := clock.now.Add(time.Hour)
+	expiring, expiringSecret, err := apiKeyService.Create(context.Background(), "temporary", "", APIKeyGrant{Scopes: []string{domain.ScopeVerify}}, &expiresAt)
+	assert.NoError(t, err)
+
+	revoked, revokedSecret, err := apiKeyService.Create(context.Background(), "leaked", "", APIKeyGrant{Scopes: []string{domain.ScopeVerify}}, nil)
+	assert.NoError(t, err)
+
+	ctx := identity.NewContext(context.Background(), identity.Identity{Name: "security"})
+	revoked, err = apiKeyService.Revoke(ctx, revoked.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "security", revoked.RevokedBy)
+
+	_, err = apiKeyService.Authenticate(context.Background(), "10.0.0.1", revokedSecret)
+	assertAppErrorType(t, err, http.StatusUnauthorized)
+
+	_, err = apiKeyService.Authenticate(context.Background(), "10.0.0.1", expiringSecret)
+	assert.NoError(t, err)
+
+	clock.now = expiresAt
+	_, err = apiKeyService.Authenticate(context.Background(), "10.0.0.1", expiringSecret)
+	assertAppErrorType(t, err, http.StatusUnauthorized)
+
+	// revoked keys are still listed
+	keys, err := apiKeyService.List(context.Background(), persistence.PageRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, keys.Items, 2)
+	assert.Equal(t, expiring.ID, keys.Items[0].ID)
+	assert.True(t, keys.Items[1].IsRevoked())
+
+	_, _, err = apiKeyService.Create(context.Background(), "already-expired", "", APIKeyGrant{Scopes: []string{domain.ScopeVerify}}, &expiresAt)
+	assertAppErrorType(t, err, http.StatusBadRequest)
+
+	_, err = apiKeyService.Revoke(context.Background(), "missing")
+	assertAppErrorType(t, err, http.StatusNotFound)
+}
+
+func TestAPIKeyService_RateLimitsFailedAttempts(t *testing.T) {
+	apiKeyService, clock := newTestAPIKeyService()
+	_, secret, err := apiKeyService.Create(context.Background(), "till", "", APIKeyGrant{Scopes: []string{domain.ScopeSign}}, nil)
+	assert.NoError(t, err)
+
+	for range 3 {
+		_, err := apiKeyService.Authenticate(context.Background(), "10.0.0.1", "ssk_guess.guess")
+		assertAppErrorType(t, err, http.StatusUnauthorized)
+	}
+
+	// even the right key is rejected once the client is blocked
+	_, err = apiKeyService.Authenticate(context.Background(), "10.0.0.1", secret)
+	assertAppErrorType(t, err, http.StatusTooManyRequests)
+
+	var tooManyFailures *RateLimitError
+	if assert.True(t, errors.As(err, &tooManyFailures)) {
+		assert.Equal(t, time.Minute, tooManyFailures.RetryAfter)
+	}
+
+	// other clients are not affected
+	_, err = apiKeyService.Authenticate(context.Background(), "10.0.0.2", secret)
+	assert.NoError(t, err)
+
+	clock.now = clock.now.Add(time.Minute)
+	_, err = apiKeyService.Authenticate(context.Background(), "10.0.0.1", secret)
+	assert.NoError(t, err)
+}
+
+func TestAPIKeyService_Bootstrap(t *testing.T) {
+	apiKeyService, _ := newTestAPIKeyService()
+
+	_, _, err := apiKeyService.Bootstrap(context.Background(), "not-a-key")
+	assert.Error(t, err)
+
+	key, secret, err := apiKeyService.Bootstrap(context.Background(), "ssk_admin.correct-horse-battery-staple")
+	assert.NoError(t, err)
+	assert.Equal(t, "ssk_admin.correct-horse-battery-staple", secret)
+	assert.ElementsMatch(t, domain.Scopes, key.Scopes)
+
+	authenticated, err := apiKeyService.Authenticate(context.Background(), "10.0.0.1", secret)
+	assert.NoError(t, err)
+	assert.Equal(t, BootstrapAPIKeyName, authenticated.Name)
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// The audited services wrap the real ones and record an event in the audit
+// log for every administrative call, successful or not. Signing is not an
+// administrative action and is already recorded in the chains.
+//
+// The event is recorded after the call, if the log cannot be written the call
+// is not undone, the failure is logged instead (see AuditLogService.Record).
+
+type auditedDeviceService struct {
+	DeviceService
+	auditLog AuditLogService
+}
+
+func NewAuditedDeviceService(deviceService DeviceService, auditLog AuditLogService) DeviceService {
+	return &auditedDeviceService{DeviceService: deviceService, auditLog: auditLog}
+}
+
+func (service *auditedDeviceService) Create(ctx context.Context, algorithm crypto.SignatureAlgorithm, label string) (*domain.Device, error) {
+	device, err := service.DeviceService.Create(ctx, algorithm, label)
+
+	deviceId := ""
+	if device != nil {
+		deviceId = device.UUID
+	}
+	service.auditLog.Record(ctx, domain.AuditActionDeviceCreate, deviceId, err, map[string]string{
+		"algorithm": algorithm.String(),
+		"label":     label,
+	})
+
+	return device, err
+}
+
+// Devices are returned with their private key, if the caller may see it.
+func (service *auditedDeviceService) Get(ctx context.Context, uuid string) (*domain.Device, error) {
+	device, err := service.DeviceService.Get(ctx, uuid)
+	if (device != nil && device.PrivateKey != nil) || err != nil {
+		service.auditLog.Record(ctx, domain.AuditActionDeviceKeyRead, uuid, err, nil)
+	}
+
+	return device, err
+}
+
+func (service *auditedDeviceService) List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.Device], error) {
+	devices, err := service.DeviceService.List(ctx, pageRequest)
+	service.recordKeysListed(ctx, devices, err)
+
+	return devices, err
+}
+
+func (service *auditedDeviceService) Search(ctx context.Context, query persistence.DeviceQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.Device], error) {
+	devices, err := service.DeviceService.Search(ctx, query, pageRequest)
+	service.recordKeysListed(ctx, devices, err)
+
+	return devices, err
+}
+
+// Listings return the private keys of every device in the page, the event says which.
+func (service *auditedDeviceService) recordKeysListed(ctx context.Context, devices persistence.Page[domain.Device], err error) {
+	deviceIds := make([]string, 0, len(devices.Items))
+	for _, device := range devices.Items {
+		if device.PrivateKey != nil {
+			deviceIds = append(deviceIds, device.UUID)
+		}
+	}
+
+	// keys the caller can't see were not read
+	if err == nil && len(deviceIds) == 0 && len(devices.Items) > 0 {
+		return
+	}
+
+	service.auditLog.Record(ctx, domain.AuditActionDeviceKeysList, "", err, map[string]string{
+		"count":   strconv.Itoa(len(deviceIds)),
+		"devices": strings.Join(deviceIds, ","),
+	})
+}
+
+func (service *auditedDeviceService) ChangeState(ctx context.Context, uuid string, state domain.DeviceState, reason string) (*domain.Device, error) {
+	device, err := service.DeviceService.ChangeState(ctx, uuid, state, reason)
+	service.auditLog.Record(ctx, domain.AuditActionDeviceStateChange, uuid, err, map[string]string{
+		"state":  string(state),
+		"reason": reason,
+	})
+
+	return device, err
+}
+
+func (service *auditedDeviceService) Update(ctx context.Context, uuid string, changes DeviceChanges, expectedRevision uint64) (*domain.Device, error) {
+	device, err := service.DeviceService.Update(ctx, uuid, changes, expectedRevision)
+
+	details := map[string]string{"expectedRevision": "*"}
+	if expectedRevision != AnyRevision {
+		details["expectedRevision"] = strconv.FormatUint(expectedRevision, 10)
+	}
+	if changes.Label != nil {
+		details["label"] = *changes.Label
+	}
+	if len(changes.Tags) > 0 {
+		tags := make([]string, 0, len(changes.Tags))
+		for key, value := range changes.Tags {
+			if value == nil {
+				tags = append(tags, key+" removed")
+			} else {
+				tags = append(tags, key+"="+*value)
+			}
+		}
+		slices.Sort(tags)
+		details["tags"] = strings.Join(tags, ", ")
+	}
+	service.auditLog.Record(ctx, domain.AuditActionDeviceUpdate, uuid, err, details)
+
+	return device, err
+}
+
+type auditedSignatureService struct {
+	SignatureService
+	auditLog AuditLogService
+}
+
+func NewAuditedSignatureService(signatureService SignatureService, auditLog AuditLogService) SignatureService {
+	return &auditedSignatureService{SignatureService: signatureService, auditLog: auditLog}
+}
+
+func (service *auditedSignatureService) Decommission(ctx context.Context, deviceId string, reason string) (*domain.Device, *domain.Signature, error) {
+	device, closingSignature, err := service.SignatureService.Decommission(ctx, deviceId, reason)
+
+	details := map[string]string{"reason": reason}
+	if closingSignature != nil {
+		details["closingSignature"] = closingSignature.UUID
+	}
+	service.auditLog.Record(ctx, domain.AuditActionDeviceDecommission, deviceId, err, details)
+
+	return device, closingSignature, err
+}
+
+type auditedBackupService struct {
+	BackupService
+	auditLog AuditLogService
+}
+
+func NewAuditedBackupService(backupService BackupService, auditLog AuditLogService) BackupService {
+	return &auditedBackupService{BackupService: backupService, auditLog: auditLog}
+}
+
+// Backups hold every private key.
+func (service *auditedBackupService) Export(ctx context.Context, w io.Writer, passphrase string) error {
+	err := service.BackupService.Export(ctx, w, passphrase)
+	service.auditLog.Record(ctx, domain.AuditActionBackupExport, "", err, map[string]string{
+		"keysEncrypted": strconv.FormatBool(passphrase != ""),
+	})
+
+	return err
+}
+
+func (service *auditedBackupService) Import(ctx context.Context, r io.Reader, passphrase string) (*BackupSummary, error) {
+	summary, err := service.BackupService.Import(ctx, r, passphrase)
+
+	details := map[string]string{}
+	if summary != nil {
+		details["devices"] = strconv.Itoa(summary.Devices)
+		details["signatures"] = strconv.Itoa(summary.Signatures)
+	}
+	service.auditLog.Record(ctx, domain.AuditActionBackupImport, "", err, details)
+
+	return summary, err
+}
+
+type auditedAPIKeyService struct {
+	APIKeyService
+	auditLog AuditLogService
+}
+
+func NewAuditedAPIKeyService(apiKeyService APIKeyService, auditLog AuditLogService) APIKeyService {
+	return &auditedAPIKeyService{APIKeyService: apiKeyService, auditLog: auditLog}
+}
+
+func (service *auditedAPIKeyService) Create(ctx context.Context, name string, tenantID string, grant APIKeyGrant, expiresAt *time.Time) (*domain.APIKey, string, error) {
+	key, secret, err := service.APIKeyService.Create(ctx, name, tenantID, grant, expiresAt)
+
+	details := map[string]string{
+		"name":   name,
+		"scopes": strings.Join(grant.Scopes, ","),
+	}
+	if len(grant.Roles) > 0 {
+		details["roles"] = strings.Join(grant.Roles, ",")
+	}
+	if len(grant.Devices) > 0 {
+		details["devices"] = strings.Join(grant.Devices, ",")
+	}
+	if tenantID != "" {
+		details["tenant"] = tenantID
+	}
+	if key != nil {
+		details["keyId"] = key.ID
+	}
+	if expiresAt != nil {
+		details["expiresAt"] = expiresAt.UTC().Format(time.RFC3339)
+	}
+	service.auditLog.Record(ctx, domain.AuditActionAPIKeyCreate, "", err, details)
+
+	return key, secret, err
+}
+
+func (service *auditedAPIKeyService) Revoke(ctx context.Context, id string) (*domain.APIKey, error) {
+	key, err := service.APIKeyService.Revoke(ctx, id)
+	service.auditLog.Record(ctx, domain.AuditActionAPIKeyRevoke, "", err, map[string]string{"keyId": id})
+
+	return key, err
+}
+
+type auditedWebhookService struct {
+	WebhookService
+	auditLog AuditLogService
+}
+
+func NewAuditedWebhookService(webhookService WebhookService, auditLog AuditLogService) WebhookService {
+	return &auditedWebhookService{WebhookService: webhookService, auditLog: auditLog}
+}
+
+func (service *auditedWebhookService) Create(ctx context.Context, endpointURL string, types []domain.EventType, description string) (*domain.WebhookEndpoint, error) {
+	endpoint, err := service.WebhookService.Create(ctx, endpointURL, types, description)
+
+	eventTypes := make([]string, 0, len(types))
+	for _, eventType := range types {
+		eventTypes = append(eventTypes, string(eventType))
+	}
+	details := map[string]string{
+		"url":   endpointURL,
+		"types": strings.Join(eventTypes, ","),
+	}
+	if endpoint != nil {
+		details["webhookId"] = endpoint.ID
+	}
+	service.auditLog.Record(ctx, domain.AuditActionWebhookCreate, "", err, details)
+
+	return endpoint, err
+}
+
+func (service *auditedWebhookService) Delete(ctx context.Context, id string) (*domain.WebhookEndpoint, error) {
+	endpoint, err := service.WebhookService.Delete(ctx, id)
+	service.auditLog.Record(ctx, domain.AuditActionWebhookDelete, "", err, map[string]string{"webhookId": id})
+
+	return endpoint, err
+}
+
+func (service *auditedWebhookService) Replay(ctx context.Context, deliveryId string) (*domain.WebhookDelivery, error) {
+	delivery, err := service.WebhookService.Replay(ctx, deliveryId)
+
+	details := map[string]string{"deliveryId": deliveryId}
+	if delivery != nil {
+		details["replayId"] = delivery.ID
+	}
+	service.auditLog.Record(ctx, domain.AuditActionWebhookReplay, "", err, details)
+
+	return delivery, err
+}
@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/chuckiihub/signing-service/requestid"
+)
+
+// Events read from the persistence at once while verifying the log.
+const auditLogBatchSize = 500
+
+// The audit log records who did what to the devices and keys. Events are
+// chained with hashes and the head of the chain is signed from time to time
+// with the key of the service (a checkpoint), so the log is tamper-evident:
+// events can't be changed or dropped without breaking the chain, and the chain
+// can't be rebuilt past a checkpoint without the key.
+type AuditLogService interface {
+	// Appends an event for the action, taking the actor and the request ID
+	// from the context. A failed action (err != nil) is recorded as such.
+	Record(ctx context.Context, action string, deviceId string, err error, details map[string]string) error
+	List(ctx context.Context, query persistence.AuditEventQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.AuditEvent], error)
+	// Signs the head of the log, if it moved since the last checkpoint.
+	Checkpoint(ctx context.Context) (*domain.AuditCheckpoint, error)
+	Checkpoints(ctx context.Context) ([]domain.AuditCheckpoint, error)
+	// Re-computes every hash and checks every checkpoint.
+	Verify(ctx context.Context) (*domain.AuditLogVerification, error)
+	// PEM public key the checkpoints can be verified with, and its ID.
+	PublicKey() (string, string)
+}
+
+type AuditLogServiceImplementation struct {
+	persistence persistence.AuditLogPersistance
+	crypto      crypto.Crypto
+	privateKey  []byte
+	publicKey   []byte
+	keyID       string
+	pageLimits  PageLimits
+	clock       Clock
+	// Appends are serialized so every event chains to the one before it.
+	mutex sync.Mutex
+}
+
+// The checkpoints are signed with the given ECC private key, encoded as the
+// keys of the devices are (see crypto.Crypto.Marshal).
+func NewAuditLogService(persistence persistence.AuditLogPersistance, privateKey []byte, pageLimits PageLimits) (*AuditLogServiceImplementation, error) {
+	eccCrypto, err := crypto.NewCrypto(crypto.SignatureAlgorithmECC)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPair, err := eccCrypto.Unmarshal(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit log key: %w", err)
+	}
+
+	publicKey, _, err := eccCrypto.Marshal(keyPair)
+	if err != nil {
+		return nil, err
+	}
+
+	keyHash := sha256.Sum256(publicKey)
+
+	return &AuditLogServiceImplementation{
+		persistence: persistence,
+		crypto:      eccCrypto,
+		privateKey:  privateKey,
+		publicKey:   publicKey,
+		keyID:       hex.EncodeToString(keyHash[:8]),
+		pageLimits:  pageLimits,
+		clock:       NewMonotonicClock(),
+	}, nil
+}
+
+// Generates a key for NewAuditLogService. Checkpoints signed with it can only be
+// verified while the service runs, as the key is not kept anywhere.
+func GenerateAuditLogKey(ctx context.Context) ([]byte, error) {
+	eccCrypto, err := crypto.NewCrypto(crypto.SignatureAlgorithmECC)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPair, err := eccCrypto.GenerateKeyPair(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, privateKey, err := eccCrypto.Marshal(keyPair)
+	return privateKey, err
+}
+
+func (auditLog *AuditLogServiceImplementation) Record(ctx context.Context, action string, deviceId string, actionErr error, details map[string]string) error {
+	event := domain.AuditEvent{
+		// Stored times may lose precision on other backends, which must not
+		// change the hash.
+		Time:       auditLog.clock.Now().UTC().Truncate(time.Microsecond),
+		Actor:      identity.FromContext(ctx).Name,
+		TenantID:   identity.FromContext(ctx).TenantID,
+		RequestID:  requestid.FromContext(ctx),
+		Action:     action,
+		DeviceUUID: deviceId,
+		Outcome:    domain.AuditOutcomeSuccess,
+		Details:    details,
+	}
+
+	if actionErr != nil {
+		event.Outcome = domain.AuditOutcomeFailure
+		event.Details = make(map[string]string, len(details)+1)
+		for key, value := range details {
+			event.Details[key] = value
+		}
+		event.Details["error"] = actionErr.Error()
+	}
+
+	// The action already happened, the client going away must not leave it
+	// out of the log.
+	ctx = context.WithoutCancel(ctx)
+
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	last, err := auditLog.persistence.Last(ctx)
+	if err != nil {
+		return auditLog.recordFailed(event, err)
+	}
+
+	event.Sequence = 1
+	if last != nil {
+		event.Sequence = last.Sequence + 1
+		event.PreviousHash = last.Hash
+	}
+	event.Hash = auditEventHash(event)
+
+	if err := auditLog.persistence.Append(ctx, event); err != nil {
+		return auditLog.recordFailed(event, err)
+	}
+
+	return nil
+}
+
+// Losing an event must at least be loud.
+func (auditLog *AuditLogServiceImplementation) recordFailed(event domain.AuditEvent, err error) error {
+	slog.Error("could not record audit event", "action", event.Action, "actor", event.Actor, "requestId", event.RequestID, "error", err.Error())
+	return apperrors.WrapError(err, apperrors.InternalError)
+}
+
+// The hash covers the JSON of the event without its own hash. Maps are encoded
+// with sorted keys, so the encoding is stable.
+func auditEventHash(event domain.AuditEvent) string {
+	event.Hash = ""
+	encoded, _ := json.Marshal(event)
+	hash := sha256.Sum256(encoded)
+
+	return hex.EncodeToString(hash[:])
+}
+
+func checkpointSignedData(sequence uint64, hash string) string {
+	return fmt.Sprintf("%d_%s", sequence, hash)
+}
+
+func (auditLog *AuditLogServiceImplementation) List(ctx context.Context, query persistence.AuditEventQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.AuditEvent], error) {
+	if !query.From.IsZero() && !query.To.IsZero() && query.From.After(query.To) {
+		return persistence.Page[domain.AuditEvent]{}, apperrors.WrapError(errors.New("from cannot be after to"), apperrors.BadRequest)
+	}
+
+	events, err := auditLog.persistence.List(ctx, query, auditLog.pageLimits.apply(pageRequest))
+	if err != nil {
+		return persistence.Page[domain.AuditEvent]{}, wrapListError(err)
+	}
+
+	return events, nil
+}
+
+func (auditLog *AuditLogServiceImplementation) Checkpoint(ctx context.Context) (*domain.AuditCheckpoint, error) {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	last, err := auditLog.persistence.Last(ctx)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	checkpoints, err := auditLog.persistence.Checkpoints(ctx)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	if last == nil || (len(checkpoints) > 0 && checkpoints[len(checkpoints)-1].Sequence == last.Sequence) {
+		return nil, nil
+	}
+
+	signature, err := auditLog.crypto.Sign(ctx, []byte(checkpointSignedData(last.Sequence, last.Hash)), auditLog.privateKey)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	checkpoint := domain.AuditCheckpoint{
+		Sequence:  last.Sequence,
+		Hash:      last.Hash,
+		SignedAt:  auditLog.clock.Now().UTC(),
+		KeyID:     auditLog.keyID,
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	}
+
+	if err := auditLog.persistence.SaveCheckpoint(ctx, checkpoint); err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return &checkpoint, nil
+}
+
+func (auditLog *AuditLogServiceImplementation) Checkpoints(ctx context.Context) ([]domain.AuditCheckpoint, error) {
+	checkpoints, err := auditLog.persistence.Checkpoints(ctx)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return checkpoints, nil
+}
+
+func (auditLog *AuditLogServiceImplementation) PublicKey() (string, string) {
+	return string(auditLog.publicKey), auditLog.keyID
+}
+
+// Walks the whole log re-computing the hashes, then checks that every
+// checkpoint signs the hash the log has at its sequence. The first problem
+// found is reported, nothing after it can be trusted.
+func (auditLog *AuditLogServiceImplementation) Verify(ctx context.Context) (*domain.AuditLogVerification, error) {
+	verification := &domain.AuditLogVerification{}
+	hashes := make([]string, 0)
+	previousHash := ""
+
+	pageRequest := persistence.PageRequest{Limit: auditLogBatchSize}
+	for {
+		page, err := auditLog.persistence.List(ctx, persistence.AuditEventQuery{}, pageRequest)
+		if err != nil {
+			return nil, wrapListError(err)
+		}
+
+		for _, event := range page.Items {
+			verification.EventsChecked++
+			verification.LastSequence = event.Sequence
+
+			switch {
+			case event.Sequence != uint64(len(hashes))+1:
+				verification.FirstBreak = &domain.AuditLogBreak{Sequence: event.Sequence, Detail: fmt.Sprintf("expected event %d", len(hashes)+1)}
+			case event.PreviousHash != previousHash:
+				verification.FirstBreak = &domain.AuditLogBreak{Sequence: event.Sequence, Detail: "previous hash does not match the event before"}
+			case auditEventHash(event) != event.Hash:
+				verification.FirstBreak = &domain.AuditLogBreak{Sequence: event.Sequence, Detail: "hash does not match the content of the event"}
+			}
+
+			if verification.FirstBreak != nil {
+				return auditLog.finishVerification(verification), nil
+			}
+
+			hashes = append(hashes, event.Hash)
+			previousHash = event.Hash
+		}
+
+		if !page.HasMore {
+			break
+		}
+		pageRequest.Cursor = page.NextCursor
+	}
+
+	checkpoints, err := auditLog.persistence.Checkpoints(ctx)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	for _, checkpoint := range checkpoints {
+		verification.CheckpointsChecked++
+
+		if checkpoint.Sequence < 1 || checkpoint.Sequence > uint64(len(hashes)) || hashes[checkpoint.Sequence-1] != checkpoint.Hash {
+			verification.FirstBreak = &domain.AuditLogBreak{Sequence: checkpoint.Sequence, Detail: "checkpoint does not match the hash of the log"}
+			break
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+		valid := false
+		if err == nil {
+			valid, err = auditLog.crypto.VerifyWithPublicKey(ctx, []byte(checkpointSignedData(checkpoint.Sequence, checkpoint.Hash)), signature, auditLog.publicKey)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, apperrors.WrapError(ctxErr, apperrors.Unavailable)
+			}
+		}
+
+		if err != nil || !valid {
+			verification.FirstBreak = &domain.AuditLogBreak{Sequence: checkpoint.Sequence, Detail: "checkpoint signature does not verify with key " + auditLog.keyID}
+			break
+		}
+
+		verification.SignedUpTo = max(verification.SignedUpTo, checkpoint.Sequence)
+	}
+
+	return auditLog.finishVerification(verification), nil
+}
+
+func (auditLog *AuditLogServiceImplementation) finishVerification(verification *domain.AuditLogVerification) *domain.AuditLogVerification {
+	verification.Intact = verification.FirstBreak == nil
+	verification.VerifiedAt = auditLog.clock.Now().UTC()
+
+	return verification
+}
+
+// Run signs a checkpoint every interval until the context is done, and a last
+// one on the way out.
+func (auditLog *AuditLogServiceImplementation) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			auditLog.checkpointAndLog(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+			auditLog.checkpointAndLog(ctx)
+		}
+	}
+}
+
+func (auditLog *AuditLogServiceImplementation) checkpointAndLog(ctx context.Context) {
+	checkpoint, err := auditLog.Checkpoint(ctx)
+	if err != nil {
+		slog.Error("could not sign audit log checkpoint", "error", err.Error())
+	} else if checkpoint != nil {
+		slog.Info("audit log checkpoint signed", "sequence", checkpoint.Sequence, "keyId", checkpoint.KeyID)
+	}
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/chuckiihub/signing-service/requestid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAuditLogService(t *testing.T, auditPersistence persistence.AuditLogPersistance) *AuditLogServiceImplementation {
+	key, err := GenerateAuditLogKey(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	auditLog, err := NewAuditLogService(auditPersistence, key, PageLimits{Default: 10, Max: 10})
+	if err != nil {
+		t.Fatalf("Failed to create the audit log: %v", err)
+	}
+
+	return auditLog
+}
+
+// Audit log storage an attacker has access to.
+type tamperedAuditLog struct {
+	*persistence.VolatileAuditLogRepository
+	tamper func(event *domain.AuditEvent)
+}
+
+func (repository *tamperedAuditLog) List(ctx context.Context, query persistence.AuditEventQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.AuditEvent], error) {
+	page, err := repository.VolatileAuditLogRepository.List(ctx, query, pageRequest)
+	for i := range page.Items {
+		repository.tamper(&page.Items[i])
+	}
+
+	return page, err
+}
+
+func TestAuditLogService_RecordsChainedEvents(t *testing.T) {
+	auditLog := newTestAuditLogService(t, persistence.NewVolatileAuditLogRepository())
+	ctx := identity.NewContext(context.Background(), identity.Identity{Name: "operator"})
+	ctx = requestid.NewContext(ctx, "request-1")
+
+	assert.NoError(t, auditLog.Record(ctx, domain.AuditActionDeviceCreate, "device-1", nil, map[string]string{"label": "till"}))
+	assert.NoError(t, auditLog.Record(ctx, domain.AuditActionDeviceUpdate, "device-1", errors.New("revision mismatch"), nil))
+
+	events, err := auditLog.List(context.Background(), persistence.AuditEventQuery{}, persistence.PageRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, events.Items, 2)
+
+	first, second := events.Items[0], events.Items[1]
+	assert.Equal(t, uint64(1), first.Sequence)
+	assert.Equal(t, "operator", first.Actor)
+	assert.Equal(t, "request-1", first.RequestID)
+	assert.Equal(t, domain.AuditOutcomeSuccess, first.Outcome)
+	assert.Equal(t, "", first.PreviousHash)
+	assert.Equal(t, first.Hash, second.PreviousHash)
+	assert.Equal(t, domain.AuditOutcomeFailure, second.Outcome)
+	assert.Equal(t, "revision mismatch", second.Details["error"])
+
+	verification, err := auditLog.Verify(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, verification.Intact)
+	assert.Equal(t, 2, verification.EventsChecked)
+}
+
+func TestAuditLogService_CheckpointsSignTheHead(t *testing.T) {
+	auditLog := newTestAuditLogService(t, persistence.NewVolatileAuditLogRepository())
+
+	checkpoint, err := auditLog.Checkpoint(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, checkpoint, "nothing to sign in an empty log")
+
+	auditLog.Record(context.Background(), domain.AuditActionBackupExport, "", nil, nil)
+	checkpoint, err = auditLog.Checkpoint(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), checkpoint.Sequence)
+
+	checkpoint, err = auditLog.Checkpoint(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, checkpoint, "the head did not move")
+
+	auditLog.Record(context.Background(), domain.AuditActionBackupImport, "", nil, nil)
+
+	verification, err := auditLog.Verify(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, verification.Intact)
+	assert.Equal(t, 1, verification.CheckpointsChecked)
+	assert.Equal(t, uint64(1), verification.SignedUpTo)
+	assert.Equal(t, uint64(2), verification.LastSequence)
+}
+
+func TestAuditLogService_VerifyDetectsTampering(t *testing.T) {
+	previousHash := ""
+	cases := map[string]struct {
+		tamper     func(event *domain.AuditEvent)
+		breakingAt string
+	}{
+		"changed actor": {func(event *domain.AuditEvent) {
+			if event.Sequence == 2 {
+				event.Actor = "someone else"
+			}
+		}, "hash does not match the content of the event"},
+		// rewriting every hash is not enough, the checkpoint does not match anymore
+		"rewritten chain": {func(event *domain.AuditEvent) {
+			event.Actor = "someone else"
+			event.PreviousHash = previousHash
+			event.Hash = auditEventHash(*event)
+			previousHash = event.Hash
+		}, "checkpoint does not match the hash of the log"},
+	}
+
+	for name, testCase := range cases {
+		repository := &tamperedAuditLog{VolatileAuditLogRepository: persistence.NewVolatileAuditLogRepository(), tamper: func(*domain.AuditEvent) {}}
+		auditLog := newTestAuditLogService(t, repository)
+		for i := 0; i < 3; i++ {
+			auditLog.Record(context.Background(), domain.AuditActionDeviceKeyRead, "device-1", nil, nil)
+		}
+		auditLog.Checkpoint(context.Background())
+
+		repository.tamper = testCase.tamper
+		verification, err := auditLog.Verify(context.Background())
+		assert.NoError(t, err, name)
+		assert.False(t, verification.Intact, name)
+		assert.Equal(t, testCase.breakingAt, verification.FirstBreak.Detail, name)
+	}
+}
+
+func TestAuditedServices_RecordAdministrativeCalls(t *testing.T) {
+	auditLog := newTestAuditLogService(t, persistence.NewVolatileAuditLogRepository())
+	devicePersistence := persistence.NewVolatileDeviceRepository()
+	lockService := NewVolatileLockService(time.Second)
+	pageLimits := PageLimits{Default: 10, Max: 10}
+	deviceService := NewAuditedDeviceService(NewDeviceService(devicePersistence, lockService, pageLimits, nil), auditLog)
+	signatureService := NewAuditedSignatureService(NewSignatureService(devicePersistence, persistence.NewVolatileSignatureRepository(), lockService, pageLimits, nil), auditLog)
+	ctx := identity.NewContext(context.Background(), identity.Identity{Name: "operator"})
+
+	device, err := deviceService.Create(ctx, crypto.SignatureAlgorithmECC, "till")
+	assert.NoError(t, err)
+	_, err = signatureService.Sign(ctx, device.UUID, "not an administrative action", nil)
+	assert.NoError(t, err)
+	_, err = deviceService.Update(ctx, device.UUID, DeviceChanges{}, 42)
+	assert.Error(t, err)
+	_, err = deviceService.Get(ctx, device.UUID)
+	assert.NoError(t, err)
+	_, _, err = signatureService.Decommission(ctx, device.UUID, "retired")
+	assert.NoError(t, err)
+
+	events, err := auditLog.List(context.Background(), persistence.AuditEventQuery{DeviceUUID: device.UUID}, persistence.PageRequest{})
+	assert.NoError(t, err)
+
+	actions := make([]string, 0)
+	for _, event := range events.Items {
+		assert.Equal(t, "operator", event.Actor)
+		actions = append(actions, event.Action+" "+event.Outcome)
+	}
+	assert.Equal(t, []string{
+		"device.create success",
+		"device.update failure",
+		"device.key_read success",
+		"device.decommission success",
+	}, actions)
+}
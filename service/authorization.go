@@ -0,0 +1,485 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// The authorized services wrap the real ones and check that the caller has the
+// scope each call needs, whatever the transport. Calls not made through an API
+// key, like a restore on startup or the retention job, are not checked.
+//
+// Keys restricted to some devices can only make calls about those devices,
+// listings and calls not about a device are out of reach for them.
+
+// Checks the caller holds the scope, for calls not about a single device.
+func authorize(ctx context.Context, scope string) error {
+	caller := identity.FromContext(ctx)
+	if !caller.IsAuthenticated() {
+		return nil
+	}
+
+	if !caller.HasScope(scope) {
+		return errMissingScope(scope)
+	}
+
+	if len(caller.Devices) > 0 {
+		return apperrors.WrapCodedError(fmt.Errorf("the API key is restricted to its devices and can't use %s here", scope), apperrors.Forbidden, apperrors.CodeDeviceNotAssigned)
+	}
+
+	return nil
+}
+
+// Checks the caller holds the scope and can use the device.
+func authorizeDevice(ctx context.Context, scope string, deviceId string) error {
+	caller := identity.FromContext(ctx)
+	if !caller.IsAuthenticated() {
+		return nil
+	}
+
+	if !caller.HasScope(scope) {
+		return errMissingScope(scope)
+	}
+
+	if !caller.CanUseDevice(deviceId) {
+		return apperrors.WrapCodedError(fmt.Errorf("the API key is not assigned device %s", deviceId), apperrors.Forbidden, apperrors.CodeDeviceNotAssigned)
+	}
+
+	return nil
+}
+
+func errMissingScope(scope string) error {
+	return apperrors.WrapCodedError(fmt.Errorf("the API key lacks the %s scope", scope), apperrors.Forbidden, apperrors.CodeMissingScope)
+}
+
+type authorizedDeviceService struct {
+	DeviceService
+}
+
+func NewAuthorizedDeviceService(deviceService DeviceService) DeviceService {
+	return &authorizedDeviceService{DeviceService: deviceService}
+}
+
+func (service *authorizedDeviceService) Create(ctx context.Context, algorithm crypto.SignatureAlgorithm, label string) (*domain.Device, error) {
+	if err := authorize(ctx, domain.ScopeDeviceCreate); err != nil {
+		return nil, err
+	}
+
+	return service.DeviceService.Create(ctx, algorithm, label)
+}
+
+func (service *authorizedDeviceService) Get(ctx context.Context, uuid string) (*domain.Device, error) {
+	if err := authorizeDevice(ctx, domain.ScopeDeviceRead, uuid); err != nil {
+		return nil, err
+	}
+
+	device, err := service.DeviceService.Get(ctx, uuid)
+	if err != nil || device == nil {
+		return device, err
+	}
+
+	return withoutHiddenKey(ctx, device), nil
+}
+
+func (service *authorizedDeviceService) List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.Device], error) {
+	if err := authorize(ctx, domain.ScopeDeviceRead); err != nil {
+		return persistence.Page[domain.Device]{}, err
+	}
+
+	devices, err := service.DeviceService.List(ctx, pageRequest)
+	return withoutHiddenKeys(ctx, devices), err
+}
+
+func (service *authorizedDeviceService) Search(ctx context.Context, query persistence.DeviceQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.Device], error) {
+	if err := authorize(ctx, domain.ScopeDeviceRead); err != nil {
+		return persistence.Page[domain.Device]{}, err
+	}
+
+	devices, err := service.DeviceService.Search(ctx, query, pageRequest)
+	return withoutHiddenKeys(ctx, devices), err
+}
+
+func (service *authorizedDeviceService) ChangeState(ctx context.Context, uuid string, state domain.DeviceState, reason string) (*domain.Device, error) {
+	if err := authorizeDevice(ctx, domain.ScopeDeviceUpdate, uuid); err != nil {
+		return nil, err
+	}
+
+	device, err := service.DeviceService.ChangeState(ctx, uuid, state, reason)
+	if err != nil || device == nil {
+		return device, err
+	}
+
+	return withoutHiddenKey(ctx, device), nil
+}
+
+func (service *authorizedDeviceService) Update(ctx context.Context, uuid string, changes DeviceChanges, expectedRevision uint64) (*domain.Device, error) {
+	if err := authorizeDevice(ctx, domain.ScopeDeviceUpdate, uuid); err != nil {
+		return nil, err
+	}
+
+	device, err := service.DeviceService.Update(ctx, uuid, changes, expectedRevision)
+	if err != nil || device == nil {
+		return device, err
+	}
+
+	return withoutHiddenKey(ctx, device), nil
+}
+
+// Private keys are only returned to callers holding device:keys, the rest
+// get a copy of the device without it.
+func withoutHiddenKey(ctx context.Context, device *domain.Device) *domain.Device {
+	caller := identity.FromContext(ctx)
+	if !caller.IsAuthenticated() || caller.HasScope(domain.ScopeDeviceKeys) {
+		return device
+	}
+
+	withoutKey := *device
+	withoutKey.PrivateKey = nil
+	return &withoutKey
+}
+
+func withoutHiddenKeys(ctx context.Context, devices persistence.Page[domain.Device]) persistence.Page[domain.Device] {
+	for i := range devices.Items {
+		devices.Items[i] = *withoutHiddenKey(ctx, &devices.Items[i])
+	}
+
+	return devices
+}
+
+type authorizedSignatureService struct {
+	SignatureService
+}
+
+func NewAuthorizedSignatureService(signatureService SignatureService) SignatureService {
+	return &authorizedSignatureService{SignatureService: signatureService}
+}
+
+func (service *authorizedSignatureService) Sign(ctx context.Context, deviceId string, dataToBeSigned string, metadata map[string]string) (*domain.Signature, error) {
+	if err := authorizeDevice(ctx, domain.ScopeSign, deviceId); err != nil {
+		return nil, err
+	}
+
+	return service.SignatureService.Sign(ctx, deviceId, dataToBeSigned, metadata)
+}
+
+func (service *authorizedSignatureService) Verify(ctx context.Context, deviceId string, dataToBeSigned string, signature string) (bool, error) {
+	if err := authorizeDevice(ctx, domain.ScopeVerify, deviceId); err != nil {
+		return false, err
+	}
+
+	return service.SignatureService.Verify(ctx, deviceId, dataToBeSigned, signature)
+}
+
+// The device of the signature is only known once it is found.
+func (service *authorizedSignatureService) Get(ctx context.Context, uuid string) (*domain.Signature, error) {
+	if caller := identity.FromContext(ctx); caller.IsAuthenticated() && !caller.HasScope(domain.ScopeSignatureRead) {
+		return nil, errMissingScope(domain.ScopeSignatureRead)
+	}
+
+	signature, err := service.SignatureService.Get(ctx, uuid)
+	if err != nil || signature == nil {
+		return signature, err
+	}
+
+	if err := authorizeDevice(ctx, domain.ScopeSignatureRead, signature.DeviceUUID); err != nil {
+		return nil, err
+	}
+
+	return signature, nil
+}
+
+func (service *authorizedSignatureService) List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.Signature], error) {
+	if err := authorize(ctx, domain.ScopeSignatureRead); err != nil {
+		return persistence.Page[domain.Signature]{}, err
+	}
+
+	return service.SignatureService.List(ctx, pageRequest)
+}
+
+func (service *authorizedSignatureService) ListByDevice(ctx context.Context, deviceId string, query persistence.SignatureQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.Signature], error) {
+	if err := authorizeDevice(ctx, domain.ScopeSignatureRead, deviceId); err != nil {
+		return persistence.Page[domain.Signature]{}, err
+	}
+
+	return service.SignatureService.ListByDevice(ctx, deviceId, query, pageRequest)
+}
+
+func (service *authorizedSignatureService) Decommission(ctx context.Context, deviceId string, reason string) (*domain.Device, *domain.Signature, error) {
+	if err := authorizeDevice(ctx, domain.ScopeDeviceUpdate, deviceId); err != nil {
+		return nil, nil, err
+	}
+
+	device, closingSignature, err := service.SignatureService.Decommission(ctx, deviceId, reason)
+	if device != nil {
+		device = withoutHiddenKey(ctx, device)
+	}
+
+	return device, closingSignature, err
+}
+
+func (service *authorizedSignatureService) Audit(ctx context.Context, deviceId string) (*domain.ChainAudit, error) {
+	if err := authorizeDevice(ctx, domain.ScopeSignatureRead, deviceId); err != nil {
+		return nil, err
+	}
+
+	return service.SignatureService.Audit(ctx, deviceId)
+}
+
+func (service *authorizedSignatureService) ExportChain(ctx context.Context, deviceId string, w io.Writer) error {
+	if err := authorizeDevice(ctx, domain.ScopeSignatureRead, deviceId); err != nil {
+		return err
+	}
+
+	return service.SignatureService.ExportChain(ctx, deviceId, w)
+}
+
+type authorizedBackupService struct {
+	BackupService
+}
+
+// Backups span every tenant, they are for operators.
+func NewAuthorizedBackupService(backupService BackupService) BackupService {
+	return &authorizedBackupService{BackupService: backupService}
+}
+
+func (service *authorizedBackupService) Export(ctx context.Context, w io.Writer, passphrase string) error {
+	if err := authorize(ctx, domain.ScopeOperator); err != nil {
+		return err
+	}
+
+	return service.BackupService.Export(ctx, w, passphrase)
+}
+
+func (service *authorizedBackupService) Import(ctx context.Context, r io.Reader, passphrase string) (*BackupSummary, error) {
+	if err := authorize(ctx, domain.ScopeOperator); err != nil {
+		return nil, err
+	}
+
+	return service.BackupService.Import(ctx, r, passphrase)
+}
+
+type authorizedAuditLogService struct {
+	AuditLogService
+}
+
+// Auditors read the events of their tenant. The checkpoints and the
+// verification cover the whole log, they are for operators.
+func NewAuthorizedAuditLogService(auditLogService AuditLogService) AuditLogService {
+	return &authorizedAuditLogService{AuditLogService: auditLogService}
+}
+
+func (service *authorizedAuditLogService) List(ctx context.Context, query persistence.AuditEventQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.AuditEvent], error) {
+	if err := authorize(ctx, domain.ScopeOperator); err != nil {
+		if err := authorize(ctx, domain.ScopeAuditRead); err != nil {
+			return persistence.Page[domain.AuditEvent]{}, err
+		}
+
+		query.TenantID = identity.FromContext(ctx).TenantID
+	}
+
+	return service.AuditLogService.List(ctx, query, pageRequest)
+}
+
+func (service *authorizedAuditLogService) Checkpoint(ctx context.Context) (*domain.AuditCheckpoint, error) {
+	if err := authorize(ctx, domain.ScopeOperator); err != nil {
+		return nil, err
+	}
+
+	return service.AuditLogService.Checkpoint(ctx)
+}
+
+func (service *authorizedAuditLogService) Checkpoints(ctx context.Context) ([]domain.AuditCheckpoint, error) {
+	if err := authorize(ctx, domain.ScopeOperator); err != nil {
+		return nil, err
+	}
+
+	return service.AuditLogService.Checkpoints(ctx)
+}
+
+func (service *authorizedAuditLogService) Verify(ctx context.Context) (*domain.AuditLogVerification, error) {
+	if err := authorize(ctx, domain.ScopeOperator); err != nil {
+		return nil, err
+	}
+
+	return service.AuditLogService.Verify(ctx)
+}
+
+type authorizedAPIKeyService struct {
+	APIKeyService
+}
+
+// Authentication itself is open to anyone, it is how callers get a scope.
+func NewAuthorizedAPIKeyService(apiKeyService APIKeyService) APIKeyService {
+	return &authorizedAPIKeyService{APIKeyService: apiKeyService}
+}
+
+func (service *authorizedAPIKeyService) Create(ctx context.Context, name string, tenantID string, grant APIKeyGrant, expiresAt *time.Time) (*domain.APIKey, string, error) {
+	if err := authorize(ctx, domain.ScopeAdmin); err != nil {
+		return nil, "", err
+	}
+
+	return service.APIKeyService.Create(ctx, name, tenantID, grant, expiresAt)
+}
+
+func (service *authorizedAPIKeyService) Get(ctx context.Context, id string) (*domain.APIKey, error) {
+	if err := authorize(ctx, domain.ScopeAdmin); err != nil {
+		return nil, err
+	}
+
+	return service.APIKeyService.Get(ctx, id)
+}
+
+func (service *authorizedAPIKeyService) List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.APIKey], error) {
+	if err := authorize(ctx, domain.ScopeAdmin); err != nil {
+		return persistence.Page[domain.APIKey]{}, err
+	}
+
+	return service.APIKeyService.List(ctx, pageRequest)
+}
+
+func (service *authorizedAPIKeyService) Revoke(ctx context.Context, id string) (*domain.APIKey, error) {
+	if err := authorize(ctx, domain.ScopeAdmin); err != nil {
+		return nil, err
+	}
+
+	return service.APIKeyService.Revoke(ctx, id)
+}
+
+type authorizedEventService struct {
+	EventService
+}
+
+// Events are read with the scope of what they carry, see eventScope. Without
+// types the subscription gets the ones the caller may read.
+func NewAuthorizedEventService(eventService EventService) EventService {
+	return &authorizedEventService{EventService: eventService}
+}
+
+func (service *authorizedEventService) Subscribe(ctx context.Context, filter EventFilter, lastEventID string) (*EventSubscription, []domain.Event, error) {
+	authorizeType := func(eventType domain.EventType) error {
+		scope := eventScope(eventType)
+		if filter.DeviceUUID != "" {
+			return authorizeDevice(ctx, scope, filter.DeviceUUID)
+		}
+		return authorize(ctx, scope)
+	}
+
+	if len(filter.Types) > 0 {
+		for _, eventType := range filter.Types {
+			if err := authorizeType(eventType); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		return service.EventService.Subscribe(ctx, filter, lastEventID)
+	}
+
+	var firstErr error
+	for _, eventType := range domain.EventTypes {
+		if err := authorizeType(eventType); err != nil {
+			firstErr = cmp.Or(firstErr, err)
+			continue
+		}
+		filter.Types = append(filter.Types, eventType)
+	}
+	if len(filter.Types) == 0 {
+		return nil, nil, firstErr
+	}
+
+	return service.EventService.Subscribe(ctx, filter, lastEventID)
+}
+
+type authorizedWebhookService struct {
+	WebhookService
+}
+
+// Endpoints are managed with the webhooks scope. Creating one also needs the
+// scopes to read the events it gets, see eventScope.
+func NewAuthorizedWebhookService(webhookService WebhookService) WebhookService {
+	return &authorizedWebhookService{WebhookService: webhookService}
+}
+
+func (service *authorizedWebhookService) Create(ctx context.Context, endpointURL string, types []domain.EventType, description string) (*domain.WebhookEndpoint, error) {
+	if err := authorize(ctx, domain.ScopeWebhooks); err != nil {
+		return nil, err
+	}
+
+	wanted := types
+	if len(wanted) == 0 {
+		wanted = domain.EventTypes
+	}
+	for _, eventType := range wanted {
+		if err := authorize(ctx, eventScope(eventType)); err != nil {
+			return nil, err
+		}
+	}
+
+	return service.WebhookService.Create(ctx, endpointURL, types, description)
+}
+
+func (service *authorizedWebhookService) Get(ctx context.Context, id string) (*domain.WebhookEndpoint, error) {
+	if err := authorize(ctx, domain.ScopeWebhooks); err != nil {
+		return nil, err
+	}
+
+	return service.WebhookService.Get(ctx, id)
+}
+
+func (service *authorizedWebhookService) List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.WebhookEndpoint], error) {
+	if err := authorize(ctx, domain.ScopeWebhooks); err != nil {
+		return persistence.Page[domain.WebhookEndpoint]{}, err
+	}
+
+	return service.WebhookService.List(ctx, pageRequest)
+}
+
+func (service *authorizedWebhookService) Delete(ctx context.Context, id string) (*domain.WebhookEndpoint, error) {
+	if err := authorize(ctx, domain.ScopeWebhooks); err != nil {
+		return nil, err
+	}
+
+	return service.WebhookService.Delete(ctx, id)
+}
+
+func (service *authorizedWebhookService) Deliveries(ctx context.Context, endpointId string, pageRequest persistence.PageRequest) (persistence.Page[domain.WebhookDelivery], error) {
+	if err := authorize(ctx, domain.ScopeWebhooks); err != nil {
+		return persistence.Page[domain.WebhookDelivery]{}, err
+	}
+
+	return service.WebhookService.Deliveries(ctx, endpointId, pageRequest)
+}
+
+func (service *authorizedWebhookService) DeadLetters(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.WebhookDelivery], error) {
+	if err := authorize(ctx, domain.ScopeWebhooks); err != nil {
+		return persistence.Page[domain.WebhookDelivery]{}, err
+	}
+
+	return service.WebhookService.DeadLetters(ctx, pageRequest)
+}
+
+func (service *authorizedWebhookService) Replay(ctx context.Context, deliveryId string) (*domain.WebhookDelivery, error) {
+	if err := authorize(ctx, domain.ScopeWebhooks); err != nil {
+		return nil, err
+	}
+
+	return service.WebhookService.Replay(ctx, deliveryId)
+}
+
+// Signature and chain events need signature:read, device events device:read.
+func eventScope(eventType domain.EventType) string {
+	switch eventType {
+	case domain.EventSignatureCreated, domain.EventChainIntegrityFailed:
+		return domain.ScopeSignatureRead
+	default:
+		return domain.ScopeDeviceRead
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAuthorizedServices() (DeviceService, SignatureService) {
+	devicePersistence := persistence.NewVolatileDeviceRepository()
+	lockService := NewVolatileLockService(time.Second)
+	pageLimits := PageLimits{Default: 10, Max: 10}
+
+	deviceService := NewAuthorizedDeviceService(NewDeviceService(devicePersistence, lockService, pageLimits, nil))
+	signatureService := NewAuthorizedSignatureService(NewSignatureService(devicePersistence, persistence.NewVolatileSignatureRepository(), lockService, pageLimits, nil))
+
+	return deviceService, signatureService
+}
+
+// Context of a request made with a key of the default tenant holding the roles.
+func roleContext(roles []string, devices ...string) context.Context {
+	key := &domain.APIKey{ID: "key", Name: "key", TenantID: domain.DefaultTenantID, Roles: roles, Devices: devices}
+	return identity.NewContext(context.Background(), DefaultAccessPolicy().Identity(key))
+}
+
+func TestAuthorization_SignersOnlySignWithTheirDevices(t *testing.T) {
+	deviceService, signatureService := newTestAuthorizedServices()
+	admin := roleContext([]string{RoleAdmin})
+
+	assigned, err := deviceService.Create(admin, crypto.SignatureAlgorithmECC, "till-1")
+	assert.NoError(t, err)
+	other, err := deviceService.Create(admin, crypto.SignatureAlgorithmECC, "till-2")
+	assert.NoError(t, err)
+
+	// admins manage devices, signing is not their job
+	_, err = signatureService.Sign(admin, assigned.UUID, "data", nil)
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	signer := roleContext([]string{RoleSigner}, assigned.UUID)
+	_, err = signatureService.Sign(signer, assigned.UUID, "data", nil)
+	assert.NoError(t, err)
+
+	_, err = signatureService.Sign(signer, other.UUID, "data", nil)
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	_, err = deviceService.Get(signer, assigned.UUID)
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	_, err = deviceService.ChangeState(signer, assigned.UUID, domain.DeviceStateSuspended, "")
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	// restricted keys can't list even with the scope, the listing spans every device
+	restrictedViewer := roleContext([]string{RoleViewer}, assigned.UUID)
+	_, err = deviceService.List(restrictedViewer, persistence.PageRequest{})
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	_, err = deviceService.Get(restrictedViewer, assigned.UUID)
+	assert.NoError(t, err)
+}
+
+func TestAuthorization_PrivateKeysNeedTheirScope(t *testing.T) {
+	deviceService, signatureService := newTestAuthorizedServices()
+
+	device, err := deviceService.Create(roleContext([]string{RoleAdmin}), crypto.SignatureAlgorithmECC, "till")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, device.PrivateKey)
+
+	viewer := roleContext([]string{RoleViewer})
+	found, err := deviceService.Get(viewer, device.UUID)
+	assert.NoError(t, err)
+	assert.Nil(t, found.PrivateKey)
+	assert.Equal(t, device.PublicKey, found.PublicKey)
+
+	devices, err := deviceService.List(viewer, persistence.PageRequest{})
+	assert.NoError(t, err)
+	assert.Nil(t, devices.Items[0].PrivateKey)
+
+	_, err = deviceService.Create(viewer, crypto.SignatureAlgorithmECC, "till")
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	_, err = signatureService.Verify(viewer, device.UUID, "data", "signature")
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	// calls not made with a key, like a restore, are not checked
+	found, err = deviceService.Get(context.Background(), device.UUID)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, found.PrivateKey)
+}
+
+func TestAuthorization_AuditorsReadTheAuditLogOfTheirTenant(t *testing.T) {
+	auditLog := NewAuthorizedAuditLogService(newTestAuditLogService(t, persistence.NewVolatileAuditLogRepository()))
+	auditLog.Record(tenantContext("acme"), domain.AuditActionDeviceCreate, "device-1", nil, nil)
+	auditLog.Record(tenantContext("globex"), domain.AuditActionDeviceCreate, "device-2", nil, nil)
+
+	auditor := identity.NewContext(context.Background(), DefaultAccessPolicy().Identity(&domain.APIKey{ID: "auditor", Name: "auditor", TenantID: "acme", Roles: []string{RoleAuditor}}))
+	events, err := auditLog.List(auditor, persistence.AuditEventQuery{TenantID: "globex"}, persistence.PageRequest{})
+	assert.NoError(t, err)
+	if assert.Len(t, events.Items, 1) {
+		assert.Equal(t, "acme", events.Items[0].TenantID)
+	}
+
+	_, err = auditLog.Verify(auditor)
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	_, err = auditLog.List(roleContext([]string{RoleViewer}), persistence.AuditEventQuery{}, persistence.PageRequest{})
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	events, err = auditLog.List(tenantContext("", domain.ScopeOperator), persistence.AuditEventQuery{}, persistence.PageRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, events.Items, 2)
+}
+
+func TestAPIKeyService_GrantsRoles(t *testing.T) {
+	apiKeyService, _ := newTestAPIKeyService()
+	admin := roleContext([]string{RoleAdmin})
+
+	key, secret, err := apiKeyService.Create(admin, "till", "", APIKeyGrant{Roles: []string{RoleSigner}, Devices: []string{"device-1"}}, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, key.Scopes)
+
+	authenticated, err := apiKeyService.Authenticate(context.Background(), "10.0.0.1", secret)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{domain.ScopeSign}, authenticated.Scopes)
+	assert.Equal(t, []string{"device-1"}, authenticated.Devices)
+
+	_, _, err = apiKeyService.Create(admin, "unassigned", "", APIKeyGrant{Roles: []string{RoleSigner}}, nil)
+	assertAppErrorType(t, err, http.StatusBadRequest)
+
+	_, _, err = apiKeyService.Create(admin, "superuser", "", APIKeyGrant{Roles: []string{"root"}}, nil)
+	assertAppErrorType(t, err, http.StatusBadRequest)
+
+	// only admins hand out scopes they don't hold
+	_, _, err = apiKeyService.Create(roleContext([]string{RoleAuditor}), "viewer", "", APIKeyGrant{Roles: []string{RoleAdmin}}, nil)
+	assertAppErrorType(t, err, http.StatusForbidden)
+}
+
+func TestAccessPolicy_LoadsRolesFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{"roles": {"cashier": {"scopes": ["sign", "verify"], "assignedDevicesOnly": true}}}`), 0o600)
+
+	policy, err := LoadAccessPolicyFile(path)
+	assert.NoError(t, err)
+
+	role, found := policy.Role("cashier")
+	assert.True(t, found)
+	assert.True(t, role.AssignedDevicesOnly)
+
+	_, found = policy.Role(RoleAdmin)
+	assert.False(t, found)
+
+	caller := policy.Identity(&domain.APIKey{ID: "key", Name: "key", Scopes: []string{domain.ScopeVerify}, Roles: []string{"cashier", RoleAdmin}})
+	assert.Equal(t, []string{domain.ScopeSign, domain.ScopeVerify}, caller.Scopes)
+
+	_, err = NewAccessPolicy(map[string]Role{"cashier": {Scopes: []string{"everything"}}})
+	assert.Error(t, err)
+}
+
+func TestCertificateBinding_SignsOnlyForTheBoundDevice(t *testing.T) {
+	deviceService, signatureService := newTestAuthorizedServices()
+	signatureService = NewCertificateBoundSignatureService(signatureService)
+	admin := roleContext([]string{RoleAdmin})
+
+	bound, err := deviceService.Create(admin, crypto.SignatureAlgorithmECC, "till-1")
+	assert.NoError(t, err)
+	other, err := deviceService.Create(admin, crypto.SignatureAlgorithmECC, "till-2")
+	assert.NoError(t, err)
+
+	signer := tenantContext(domain.DefaultTenantID, domain.ScopeSign)
+	_, err = signatureService.Sign(signer, bound.UUID, "data", nil)
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	register := identity.NewCertificateContext(signer, identity.Certificate{Subject: "CN=till-1", Devices: []string{bound.UUID}})
+	_, err = signatureService.Sign(register, bound.UUID, "data", nil)
+	assert.NoError(t, err)
+
+	_, err = signatureService.Sign(register, other.UUID, "data", nil)
+	assertAppErrorType(t, err, http.StatusForbidden)
+}
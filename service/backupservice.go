@@ -0,0 +1,285 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/chuckiihub/signing-service/backup"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// Items fetched per call to the persistence layer while exporting.
+const backupBatchSize = 500
+
+// How many times, and after how long at first, a device whose last signature
+// is not stored yet is read again. The wait doubles every time.
+const (
+	backupSettleAttempts = 8
+	backupSettleBackoff  = 5 * time.Millisecond
+)
+
+type BackupService interface {
+	Export(ctx context.Context, w io.Writer, passphrase string) error
+	Import(ctx context.Context, r io.Reader, passphrase string) (*BackupSummary, error)
+}
+
+type BackupSummary struct {
+	Devices    int `json:"devices"`
+	Signatures int `json:"signatures"`
+}
+
+// The backup service only talks to the persistence interfaces so a backup
+// taken from one backend can be restored into any other one.
+type BackupServiceImplementation struct {
+	devicePersistence    persistence.DevicePersistance
+	signaturePersistence persistence.SignaturePersistance
+}
+
+func NewBackupService(
+	dDB persistence.DevicePersistance,
+	sDB persistence.SignaturePersistance,
+) BackupService {
+	return &BackupServiceImplementation{
+		devicePersistence:    dDB,
+		signaturePersistence: sDB,
+	}
+}
+
+// Streams every device and signature to w. If a passphrase is given the
+// private keys are encrypted with it, otherwise they are written as they are
+// stored.
+func (backupService *BackupServiceImplementation) Export(ctx context.Context, w io.Writer, passphrase string) error {
+	var sealer *backup.KeySealer
+	if passphrase != "" {
+		var err error
+		if sealer, err = backup.NewKeySealer(passphrase); err != nil {
+			return apperrors.WrapError(err, apperrors.InternalError)
+		}
+	}
+
+	writer, err := backup.NewWriter(w, time.Now().UTC(), sealer)
+	if err != nil {
+		return apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	cursor := ""
+	for {
+		page, err := backupService.devicePersistence.List(ctx, persistence.AllTenants, persistence.PageRequest{Cursor: cursor, Limit: backupBatchSize})
+		if err != nil {
+			return apperrors.WrapError(err, apperrors.InternalError)
+		}
+
+		for _, listedDevice := range page.Items {
+			if err := backupService.exportDevice(ctx, writer, listedDevice.UUID); err != nil {
+				return apperrors.WrapError(err, apperrors.InternalError)
+			}
+		}
+
+		if !page.HasMore {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if err := writer.Close(); err != nil {
+		return apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return nil
+}
+
+// Signing saves the device first and then the signature it points to. No lock
+// keeps the export out of that moment (signing optimistically takes none), so
+// the device is read until the signature it points to is stored: then its
+// chain is complete up to its counter, whatever the signing mode. Signatures
+// made meanwhile come after the counter and are left out.
+func (backupService *BackupServiceImplementation) exportDevice(ctx context.Context, writer *backup.Writer, deviceId string) error {
+	device, err := backupService.findSettledDevice(ctx, deviceId)
+	if err != nil {
+		return err
+	}
+
+	if err := writer.WriteDevice(*device); err != nil {
+		return err
+	}
+
+	if device.SignatureCounter == 0 {
+		return nil
+	}
+
+	query := persistence.SignatureQuery{DeviceUUID: deviceId, CounterTo: device.SignatureCounter}
+	cursor := ""
+	for {
+		page, err := backupService.signaturePersistence.FindByDevice(ctx, query, persistence.PageRequest{Cursor: cursor, Limit: backupBatchSize})
+		if err != nil {
+			return err
+		}
+
+		for i := range page.Items {
+			if err := writer.WriteSignature(&page.Items[i]); err != nil {
+				return err
+			}
+		}
+
+		if !page.HasMore {
+			return nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// Reads the device until the last signature it points to is stored, which
+// takes a few tries if it is being signed with right now.
+func (backupService *BackupServiceImplementation) findSettledDevice(ctx context.Context, deviceId string) (*domain.Device, error) {
+	backoff := backupSettleBackoff
+
+	for attempt := 1; ; attempt++ {
+		device, err := backupService.devicePersistence.FindByUUID(ctx, persistence.AllTenants, deviceId)
+		if err != nil {
+			return nil, err
+		}
+
+		if device == nil {
+			return nil, fmt.Errorf("device %s disappeared while exporting", deviceId)
+		}
+
+		settled, err := backupService.lastSignatureStored(ctx, device)
+		if err != nil || settled {
+			return device, err
+		}
+
+		if attempt >= backupSettleAttempts {
+			return nil, fmt.Errorf("the last signature of device %s is not stored, is the device being signed with all the time?", deviceId)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (backupService *BackupServiceImplementation) lastSignatureStored(ctx context.Context, device *domain.Device) (bool, error) {
+	if device.SignatureCounter == 0 {
+		return true, nil
+	}
+
+	query := persistence.SignatureQuery{DeviceUUID: device.UUID, CounterFrom: device.SignatureCounter, CounterTo: device.SignatureCounter}
+	page, err := backupService.signaturePersistence.FindByDevice(ctx, query, persistence.PageRequest{Limit: 1})
+	if err != nil {
+		return false, err
+	}
+
+	return len(page.Items) > 0 && page.Items[0].Signature == device.LastSignature, nil
+}
+
+// Restores an archive written by Export. The archive is fully read and checked
+// before anything is written, and devices or signatures that already exist in
+// the target storage are never overwritten. Meanwhile it is spooled to a
+// temporary file, so it is read twice without having to fit in memory.
+func (backupService *BackupServiceImplementation) Import(ctx context.Context, r io.Reader, passphrase string) (*BackupSummary, error) {
+	spool, err := os.CreateTemp("", "signing-service-restore-*")
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	if _, err := backupService.checkArchive(ctx, io.TeeReader(r, spool), passphrase); err != nil {
+		return nil, err
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return backupService.restoreArchive(ctx, spool, passphrase)
+}
+
+// Reads the whole archive, so its checksum is verified, and looks for devices
+// and signatures that already exist.
+func (backupService *BackupServiceImplementation) checkArchive(ctx context.Context, r io.Reader, passphrase string) (*BackupSummary, error) {
+	reader, err := backup.NewReader(r, passphrase)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.BadRequest)
+	}
+
+	summary := &BackupSummary{}
+	for {
+		entry, err := reader.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return summary, nil
+		}
+		if err != nil {
+			return nil, apperrors.WrapError(err, apperrors.BadRequest)
+		}
+
+		if entry.Device != nil {
+			summary.Devices++
+			existing, err := backupService.devicePersistence.FindByUUID(ctx, persistence.AllTenants, entry.Device.UUID)
+			if err != nil {
+				return nil, apperrors.WrapError(err, apperrors.InternalError)
+			}
+
+			if existing != nil {
+				return nil, apperrors.WrapCodedError(fmt.Errorf("device %s already exists", entry.Device.UUID), apperrors.Conflict, apperrors.CodeAlreadyExists)
+			}
+		}
+
+		if entry.Signature != nil {
+			summary.Signatures++
+			existing, err := backupService.signaturePersistence.FindByUUID(ctx, persistence.AllTenants, entry.Signature.UUID)
+			if err != nil {
+				return nil, apperrors.WrapError(err, apperrors.InternalError)
+			}
+
+			if existing != nil {
+				return nil, apperrors.WrapCodedError(fmt.Errorf("signature %s already exists", entry.Signature.UUID), apperrors.Conflict, apperrors.CodeAlreadyExists)
+			}
+		}
+	}
+}
+
+// Saves what checkArchive accepted. Every device is saved before its
+// signatures, as signing does.
+func (backupService *BackupServiceImplementation) restoreArchive(ctx context.Context, r io.Reader, passphrase string) (*BackupSummary, error) {
+	reader, err := backup.NewReader(r, passphrase)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	summary := &BackupSummary{}
+	for {
+		entry, err := reader.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return summary, nil
+		}
+		if err != nil {
+			return nil, apperrors.WrapError(errors.Join(errors.New("restore interrupted"), err), apperrors.InternalError)
+		}
+
+		if entry.Device != nil {
+			// fencing tokens only make sense for the lock service that issued them
+			entry.Device.FencingToken = 0
+			if _, err := backupService.devicePersistence.Save(ctx, entry.Device); err != nil {
+				return nil, apperrors.WrapError(errors.Join(errors.New("restore interrupted"), err), apperrors.InternalError)
+			}
+			summary.Devices++
+		}
+
+		if entry.Signature != nil {
+			if _, err := backupService.signaturePersistence.Save(ctx, entry.Signature); err != nil {
+				return nil, apperrors.WrapError(errors.Join(errors.New("restore interrupted"), err), apperrors.InternalError)
+			}
+			summary.Signatures++
+		}
+	}
+}
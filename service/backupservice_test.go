@@ -0,0 +1,141 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/backup"
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupService_ExportAndImportIntoAnotherStorage(t *testing.T) {
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	for i := 0; i < 3; i++ {
+		_, err := signatureService.Sign(context.Background(), device.UUID, "data", nil)
+		assert.NoError(t, err)
+	}
+
+	source := NewBackupService(signatureService.devicePersistence, signatureService.signaturePersistence)
+
+	var archive bytes.Buffer
+	assert.NoError(t, source.Export(context.Background(), &archive, "passphrase"))
+
+	devices, _ := readTestBackup(t, bytes.NewReader(archive.Bytes()))
+	assert.NotContains(t, string(devices[0].PrivateKey), "PRIVATE_KEY")
+
+	targetDevices := persistence.NewVolatileDeviceRepository()
+	targetSignatures := persistence.NewVolatileSignatureRepository()
+	target := NewBackupService(targetDevices, targetSignatures)
+
+	summary, err := target.Import(context.Background(), bytes.NewReader(archive.Bytes()), "passphrase")
+	assert.NoError(t, err)
+	assert.Equal(t, &BackupSummary{Devices: 1, Signatures: 3}, summary)
+
+	original, _ := signatureService.devicePersistence.FindByUUID(context.Background(), persistence.AllTenants, device.UUID)
+	restored, _ := targetDevices.FindByUUID(context.Background(), persistence.AllTenants, device.UUID)
+	assert.Equal(t, uint64(0), restored.FencingToken)
+	restored.FencingToken = original.FencingToken
+	// the version counts saves in the new storage
+	assert.Equal(t, uint64(1), restored.Version)
+	restored.Version = original.Version
+	assert.Equal(t, original, restored)
+
+	// signing keeps working on the restored storage
+	restoredService := NewSignatureService(targetDevices, targetSignatures, NewVolatileLockService(time.Second), PageLimits{Default: 10, Max: 10}, nil)
+	signature, err := restoredService.Sign(context.Background(), device.UUID, "after restore", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, signature.Counter)
+}
+
+func TestBackupService_ImportRejectsWrongPassphraseAndConflicts(t *testing.T) {
+	signatureService, _ := newTestSignatureService(t, crypto.SignatureAlgorithmRSA, NewMonotonicClock())
+	source := NewBackupService(signatureService.devicePersistence, signatureService.signaturePersistence)
+
+	var archive bytes.Buffer
+	assert.NoError(t, source.Export(context.Background(), &archive, "passphrase"))
+
+	target := NewBackupService(persistence.NewVolatileDeviceRepository(), persistence.NewVolatileSignatureRepository())
+	_, err := target.Import(context.Background(), bytes.NewReader(archive.Bytes()), "wrong")
+	assert.ErrorContains(t, err, "wrong passphrase")
+
+	// restoring into the storage it came from would overwrite devices
+	_, err = source.Import(context.Background(), bytes.NewReader(archive.Bytes()), "passphrase")
+	assert.ErrorContains(t, err, "already exists")
+}
+
+func TestBackupService_ExportWaitsForTheSignatureBeingSaved(t *testing.T) {
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	ctx := context.Background()
+
+	// a signer saved the device but not its signature yet, as signing
+	// optimistically does without any lock
+	var signature *domain.Signature
+	_, _, release, err := signatureService.devices.modify(ctx, device.UUID, func(device *domain.Device) error {
+		var err error
+		signature, err = signatureService.chainSignature(ctx, device, "data", nil)
+		return err
+	})
+	require.NoError(t, err)
+	release()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		signatureService.signaturePersistence.Save(ctx, signature)
+	}()
+
+	source := NewBackupService(signatureService.devicePersistence, signatureService.signaturePersistence)
+	var archive bytes.Buffer
+	require.NoError(t, source.Export(ctx, &archive, ""))
+
+	_, signatures := readTestBackup(t, &archive)
+	require.Len(t, signatures, 1)
+	assert.Equal(t, signature.UUID, signatures[0].UUID)
+}
+
+func TestBackupService_ImportRejectsTruncatedArchivesWithoutWriting(t *testing.T) {
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	_, err := signatureService.Sign(context.Background(), device.UUID, "data", nil)
+	require.NoError(t, err)
+
+	source := NewBackupService(signatureService.devicePersistence, signatureService.signaturePersistence)
+	var archive bytes.Buffer
+	require.NoError(t, source.Export(context.Background(), &archive, ""))
+
+	targetDevices := persistence.NewVolatileDeviceRepository()
+	target := NewBackupService(targetDevices, persistence.NewVolatileSignatureRepository())
+	_, err = target.Import(context.Background(), bytes.NewReader(archive.Bytes()[:archive.Len()-20]), "")
+	assert.Error(t, err)
+
+	restored, _ := targetDevices.FindByUUID(context.Background(), persistence.AllTenants, device.UUID)
+	assert.Nil(t, restored)
+}
+
+// Reads an archive without encrypted keys, checking it on the way.
+func readTestBackup(t *testing.T, r io.Reader) ([]domain.Device, []domain.Signature) {
+	reader, err := backup.NewSealedReader(r)
+	require.NoError(t, err)
+
+	var devices []domain.Device
+	var signatures []domain.Signature
+	for {
+		entry, err := reader.Next(context.Background())
+		if errors.Is(err, io.EOF) {
+			return devices, signatures
+		}
+		require.NoError(t, err)
+
+		if entry.Device != nil {
+			devices = append(devices, *entry.Device)
+		} else {
+			signatures = append(signatures, *entry.Signature)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+)
+
+type certificateBoundSignatureService struct {
+	SignatureService
+}
+
+// With mutual TLS every register signs with a client certificate bound to its
+// device, so a leaked API key alone can't sign for the register. Only signing
+// is bound, the rest of the calls are authorized as usual.
+func NewCertificateBoundSignatureService(signatureService SignatureService) SignatureService {
+	return &certificateBoundSignatureService{SignatureService: signatureService}
+}
+
+func (service *certificateBoundSignatureService) Sign(ctx context.Context, deviceId string, dataToBeSigned string, metadata map[string]string) (*domain.Signature, error) {
+	if identity.FromContext(ctx).IsAuthenticated() {
+		certificate, found := identity.CertificateFromContext(ctx)
+		if !found {
+			return nil, apperrors.WrapCodedError(errors.New("signing requires a client certificate"), apperrors.Forbidden, apperrors.CodeCertificateRequired)
+		}
+
+		if !slices.Contains(certificate.Devices, deviceId) {
+			return nil, apperrors.WrapCodedError(fmt.Errorf("the client certificate %s is not bound to device %s", certificate.Subject, deviceId), apperrors.Forbidden, apperrors.CodeCertificateRequired)
+		}
+	}
+
+	return service.SignatureService.Sign(ctx, deviceId, dataToBeSigned, metadata)
+}
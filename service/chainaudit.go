@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"io"
+
+	"github.com/chuckiihub/signing-service/chain"
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// Signatures read from the persistence at once while auditing or exporting.
+var auditPageSize = 500
+
+// Walks every signature of the device in counter order with a chain.Auditor,
+// verifying with the public key as an external auditor would.
+//
+// The audit does not lock the device: it covers the chain up to the counter
+// the device had when the audit started. A signature being saved at that very
+// moment may show up as missing at the end of the chain, auditing again
+// clears it.
+func (signingService *SignatureServiceImplementation) Audit(ctx context.Context, deviceId string) (*domain.ChainAudit, error) {
+	device, err := signingService.fetchDeviceOrReturnNotFound(ctx, deviceId)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCrypto, err := crypto.NewCrypto(device.Algorithm)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	auditor := chain.NewAuditor(device.UUID, device.SignatureCounter, func(ctx context.Context, signedData []byte, signature []byte) (bool, error) {
+		valid, err := deviceCrypto.VerifyWithPublicKey(ctx, signedData, signature, device.PublicKey)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return false, apperrors.WrapError(ctxErr, apperrors.Unavailable)
+		}
+
+		return err == nil && valid, nil
+	})
+
+	if err := signingService.walkChain(ctx, device, auditor.Check); err != nil {
+		return nil, err
+	}
+
+	audit := auditor.Finish(device.LastSignature, signingService.clock.Now())
+	if !audit.Intact {
+		signingService.publish(domain.NewChainIntegrityEvent(*device, audit))
+	}
+
+	return &audit, nil
+}
+
+// Writes the chain of the device to w in the export format of the chain
+// package, which cmd/verify checks offline. Like the audit, it covers the
+// chain up to the counter the device had when the export started.
+func (signingService *SignatureServiceImplementation) ExportChain(ctx context.Context, deviceId string, w io.Writer) error {
+	device, err := signingService.fetchDeviceOrReturnNotFound(ctx, deviceId)
+	if err != nil {
+		return err
+	}
+
+	writer, err := chain.NewExportWriter(w, chain.NewExportHeader(device, signingService.clock.Now()))
+	if err != nil {
+		return apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return signingService.walkChain(ctx, device, func(ctx context.Context, signature domain.Signature) error {
+		if err := writer.Write(signature); err != nil {
+			return apperrors.WrapError(err, apperrors.InternalError)
+		}
+		return nil
+	})
+}
+
+// Pages through the signatures of the device up to its counter. The cursor of
+// the persistence skips every signature with the counter of the last one
+// returned, which would hide duplicates split between two pages, so each page
+// starts again at the last counter of the previous one.
+func (signingService *SignatureServiceImplementation) walkChain(ctx context.Context, device *domain.Device, visit func(ctx context.Context, signature domain.Signature) error) error {
+	query := persistence.SignatureQuery{DeviceUUID: device.UUID, Order: persistence.OrderAscending}
+
+	for {
+		page, err := signingService.signaturePersistence.FindByDevice(ctx, query, persistence.PageRequest{Limit: auditPageSize})
+		if err != nil {
+			return wrapListError(err)
+		}
+
+		signatures := page.Items
+		if page.HasMore {
+			lastCounter := signatures[len(signatures)-1].Counter
+			if signatures[0].Counter == lastCounter {
+				// a whole page of duplicates, nothing else to do but to move on
+				query.CounterFrom = lastCounter + 1
+			} else {
+				for signatures[len(signatures)-1].Counter == lastCounter {
+					signatures = signatures[:len(signatures)-1]
+				}
+				query.CounterFrom = lastCounter
+			}
+		}
+
+		for _, signature := range signatures {
+			if signature.Counter > device.SignatureCounter {
+				// signed after the walk started
+				return nil
+			}
+
+			if err := visit(ctx, signature); err != nil {
+				return err
+			}
+		}
+
+		if !page.HasMore {
+			return nil
+		}
+	}
+}
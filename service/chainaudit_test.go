@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+// Signs count signatures and returns them in counter order.
+func signChain(t *testing.T, signatureService *SignatureServiceImplementation, deviceId string, count int) []domain.Signature {
+	chain := make([]domain.Signature, 0, count)
+	for i := 0; i < count; i++ {
+		// underscores in the data must not confuse the audit
+		signature, err := signatureService.Sign(context.Background(), deviceId, "receipt_with_underscores", nil)
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		chain = append(chain, *signature)
+	}
+
+	return chain
+}
+
+// Replaces the stored chain with the given signatures, as a tampered storage would.
+func storeChain(signatureService *SignatureServiceImplementation, chain []domain.Signature) {
+	repository := persistence.NewVolatileSignatureRepository()
+	for _, signature := range chain {
+		repository.Save(context.Background(), &signature)
+	}
+	signatureService.signaturePersistence = repository
+}
+
+func TestSignatureService_AuditIntactChain(t *testing.T) {
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	signChain(t, signatureService, device.UUID, 3)
+	_, _, err := signatureService.Decommission(context.Background(), device.UUID, "retired")
+	assert.NoError(t, err)
+
+	audit, err := signatureService.Audit(context.Background(), device.UUID)
+	assert.NoError(t, err)
+	assert.True(t, audit.Intact)
+	assert.Nil(t, audit.FirstBreak)
+	assert.Equal(t, 4, audit.SignaturesChecked)
+	assert.Equal(t, 4, audit.DeviceCounter)
+	assert.Empty(t, audit.Issues)
+}
+
+func TestSignatureService_AuditEmptyChain(t *testing.T) {
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmRSA, NewMonotonicClock())
+
+	audit, err := signatureService.Audit(context.Background(), device.UUID)
+	assert.NoError(t, err)
+	assert.True(t, audit.Intact)
+	assert.Equal(t, 0, audit.SignaturesChecked)
+}
+
+func TestSignatureService_AuditReportsTamperedSignatures(t *testing.T) {
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	chain := signChain(t, signatureService, device.UUID, 5)
+
+	// the data of the second signature is changed, its signature does not match anymore
+	chain[1].SignedData = "2_forged_" + chain[1].SignedData[len("2_receipt_with_underscores_"):]
+	// the fourth one embeds another counter
+	chain[3].SignedData = "9" + chain[3].SignedData[1:]
+	// the fifth one points somewhere else
+	chain[4].PreviousSignature = chain[0].Signature
+	// and the third one is gone
+	storeChain(signatureService, []domain.Signature{chain[0], chain[1], chain[3], chain[4]})
+
+	audit, err := signatureService.Audit(context.Background(), device.UUID)
+	assert.NoError(t, err)
+	assert.False(t, audit.Intact)
+	assert.Equal(t, 4, audit.SignaturesChecked)
+	assert.Equal(t, domain.ChainIssue{
+		Kind:          domain.ChainIssueInvalidSignature,
+		Counter:       2,
+		SignatureUUID: chain[1].UUID,
+		Detail:        "signature does not verify with the key of the device",
+	}, *audit.FirstBreak)
+
+	kinds := make([]domain.ChainIssueKind, 0)
+	for _, issue := range audit.Issues {
+		kinds = append(kinds, issue.Kind)
+	}
+	assert.Equal(t, []domain.ChainIssueKind{
+		domain.ChainIssueInvalidSignature, // 2
+		domain.ChainIssueGap,              // 3
+		domain.ChainIssueCounterMismatch,  // 4
+		domain.ChainIssueInvalidSignature, // 4
+		domain.ChainIssueBrokenLink,       // 5
+	}, kinds)
+	assert.Equal(t, []domain.CounterGap{{From: 3, To: 3}}, audit.Gaps)
+	assert.Empty(t, audit.Duplicates)
+}
+
+func TestSignatureService_AuditReportsGapsAndDuplicates(t *testing.T) {
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	chain := signChain(t, signatureService, device.UUID, 6)
+
+	duplicate := chain[1]
+	duplicate.UUID = "duplicate"
+	tampered := []domain.Signature{chain[0], chain[1], duplicate, chain[3], chain[4]}
+	storeChain(signatureService, tampered)
+
+	// small pages so the duplicates are split between two of them
+	defer func(pageSize int) { auditPageSize = pageSize }(auditPageSize)
+	auditPageSize = 2
+
+	audit, err := signatureService.Audit(context.Background(), device.UUID)
+	assert.NoError(t, err)
+	assert.False(t, audit.Intact)
+	assert.Equal(t, 5, audit.SignaturesChecked)
+	assert.Equal(t, []int{2}, audit.Duplicates)
+	assert.Equal(t, []domain.CounterGap{{From: 3, To: 3}, {From: 6, To: 6}}, audit.Gaps)
+	assert.Equal(t, domain.ChainIssueDuplicate, audit.FirstBreak.Kind)
+	assert.Equal(t, "duplicate", audit.FirstBreak.SignatureUUID)
+}
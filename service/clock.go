@@ -0,0 +1,24 @@
+package service
+
+import "time"
+
+// Clock gives the time used to timestamp signatures. It is an interface so
+// tests can control time.
+type Clock interface {
+	Now() time.Time
+}
+
+// The wall clock can go backwards (NTP adjustments, manual changes) which would
+// break the order of the signatures. This clock reads the wall clock only once
+// and then moves forward using the monotonic clock of the process.
+type MonotonicClock struct {
+	start time.Time
+}
+
+func NewMonotonicClock() *MonotonicClock {
+	return &MonotonicClock{start: time.Now()}
+}
+
+func (clock *MonotonicClock) Now() time.Time {
+	return clock.start.Add(time.Since(clock.start)).UTC()
+}
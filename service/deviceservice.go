@@ -1,11 +1,19 @@
 package service
 
 import (
-	"encoding/base64"
-
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"time"
+
+	"github.com/chuckiihub/signing-service/chain"
 	"github.com/chuckiihub/signing-service/crypto"
 	"github.com/chuckiihub/signing-service/domain"
 	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
 	"github.com/chuckiihub/signing-service/persistence"
 	"github.com/google/uuid"
 )
@@ -13,17 +21,27 @@ import (
 // Persistence layer
 type DeviceServiceImplementation struct {
 	persistence persistence.DevicePersistance
-	pageSize    int
+	devices     deviceWriter
+	pageLimits  PageLimits
+	events      EventPublisher
 }
 
 // Creates a new device, assigns the key pair and saves it to storage
-func (deviceService *DeviceServiceImplementation) Create(algorithm crypto.SignatureAlgorithm, label string) (*domain.Device, error) {
+func (deviceService *DeviceServiceImplementation) Create(ctx context.Context, algorithm crypto.SignatureAlgorithm, label string) (*domain.Device, error) {
 	uuid := uuid.NewString()
+	now := time.Now().UTC()
 	device := &domain.Device{
-		UUID:          uuid,
-		Algorithm:     algorithm,
-		Label:         label,
-		LastSignature: base64.StdEncoding.EncodeToString([]byte(uuid)),
+		UUID:           uuid,
+		TenantID:       domain.TenantOrDefault(tenantOf(ctx)),
+		Algorithm:      algorithm,
+		KeyVersion:     1,
+		Label:          label,
+		LastSignature:  chain.GenesisLink(uuid),
+		Revision:       1,
+		State:          domain.DeviceStateActive,
+		CreatedAt:      now,
+		StateChangedAt: now,
+		StateChangedBy: identity.FromContext(ctx).Name,
 	}
 
 	crypto, err := crypto.NewCrypto(device.Algorithm)
@@ -31,7 +49,7 @@ func (deviceService *DeviceServiceImplementation) Create(algorithm crypto.Signat
 		return nil, apperrors.WrapError(err, apperrors.InternalError)
 	}
 
-	keyPair, err := crypto.GenerateKeyPair()
+	keyPair, err := crypto.GenerateKeyPair(ctx)
 	if err != nil {
 		return nil, apperrors.WrapError(err, apperrors.InternalError)
 	}
@@ -43,40 +61,154 @@ func (deviceService *DeviceServiceImplementation) Create(algorithm crypto.Signat
 
 	device.PrivateKey = privateKey
 	device.PublicKey = publicKey
-	device, err = deviceService.persistence.Save(device)
+	device, err = deviceService.persistence.Save(ctx, device)
 	if err != nil {
 		return nil, apperrors.WrapError(err, apperrors.InternalError)
 	}
 
+	deviceService.publish(domain.NewDeviceEvent(domain.EventDeviceCreated, *device, device.CreatedAt))
 	return device, nil
 }
 
 // Gets a device from storage and retrieves it.
-func (deviceService *DeviceServiceImplementation) Get(uuid string) (*domain.Device, error) {
-	device, err := deviceService.persistence.FindByUUID(uuid)
+func (deviceService *DeviceServiceImplementation) Get(ctx context.Context, uuid string) (*domain.Device, error) {
+	device, err := deviceService.persistence.FindByUUID(ctx, tenantOf(ctx), uuid)
 
 	return device, err
 }
 
-// Get all devices from storage and retrieves them.
-func (deviceService *DeviceServiceImplementation) List(page int) ([]domain.Device, error) {
-	if page < 1 {
-		page = 1
+// Get a page of devices from storage and retrieves them.
+func (deviceService *DeviceServiceImplementation) List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.Device], error) {
+	devices, err := deviceService.persistence.List(ctx, tenantOf(ctx), deviceService.pageLimits.apply(pageRequest))
+	if err != nil {
+		return persistence.Page[domain.Device]{}, wrapListError(err)
+	}
+
+	return devices, nil
+}
+
+// Finds the devices matching the query, e.g. all the ECC devices of a store.
+func (deviceService *DeviceServiceImplementation) Search(ctx context.Context, query persistence.DeviceQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.Device], error) {
+	if query.CounterFrom != nil && query.CounterTo != nil && *query.CounterFrom > *query.CounterTo {
+		return persistence.Page[domain.Device]{}, apperrors.WrapError(errors.New("counterFrom cannot be greater than counterTo"), apperrors.BadRequest)
 	}
 
-	devices, err := deviceService.persistence.List(page, deviceService.pageSize)
+	if !query.CreatedFrom.IsZero() && !query.CreatedTo.IsZero() && query.CreatedFrom.After(query.CreatedTo) {
+		return persistence.Page[domain.Device]{}, apperrors.WrapError(errors.New("createdFrom cannot be after createdTo"), apperrors.BadRequest)
+	}
 
+	query.TenantID = tenantOf(ctx)
+	devices, err := deviceService.persistence.Search(ctx, query, deviceService.pageLimits.apply(pageRequest))
 	if err != nil {
-		return nil, apperrors.WrapError(err, apperrors.InternalError)
+		return persistence.Page[domain.Device]{}, wrapListError(err)
 	}
 
 	return devices, nil
 }
 
-func (deviceService *DeviceServiceImplementation) CheckHealth() domain.ServiceHealth {
+// Suspended devices keep their chain as it is and can be reactivated later on,
+// e.g. a register that was reported stolen and then found.
+func (deviceService *DeviceServiceImplementation) ChangeState(ctx context.Context, uuid string, state domain.DeviceState, reason string) (*domain.Device, error) {
+	if state != domain.DeviceStateActive && state != domain.DeviceStateSuspended {
+		return nil, apperrors.WrapError(fmt.Errorf("cannot change the state of a device to %q", state), apperrors.BadRequest)
+	}
+	actor := identity.FromContext(ctx).Name
+
+	device, _, release, err := deviceService.devices.modify(ctx, uuid, func(device *domain.Device) error {
+		if device.CurrentState() == domain.DeviceStateDecommissioned {
+			return apperrors.WrapCodedError(errors.New("decommissioned devices cannot change their state"), apperrors.Conflict, apperrors.CodeDeviceDecommissioned)
+		}
+
+		device.Revision++
+		device.State = state
+		device.StateReason = reason
+		device.StateChangedAt = time.Now().UTC()
+		device.StateChangedBy = actor
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	release()
+
+	slog.Info("device state changed", "deviceId", uuid, "state", state, "actor", actor)
+	deviceService.publish(domain.NewDeviceEvent(domain.EventDeviceStateChanged, *device, device.StateChangedAt))
+	return device, nil
+}
+
+// Returned when updating a device whose revision is not the expected one.
+var ErrRevisionMismatch = errors.New("the device was modified since it was read")
+
+// Expected revision updating whatever the current one is, `If-Match: *` over
+// HTTP. Revisions never get this far.
+const AnyRevision uint64 = math.MaxUint64
+
+// Tags a device can have at most.
+const maxDeviceTags = 20
+
+// DeviceChanges are the fields of a device that can be changed after its
+// creation, nil fields are left as they are. Tags are merged into the
+// existing ones and a nil value removes the tag.
+type DeviceChanges struct {
+	Label *string
+	Tags  map[string]*string
+}
+
+// Keys, counter and chain are never touched here, they only change when signing.
+func (deviceService *DeviceServiceImplementation) Update(ctx context.Context, uuid string, changes DeviceChanges, expectedRevision uint64) (*domain.Device, error) {
+	device, _, release, err := deviceService.devices.modify(ctx, uuid, func(device *domain.Device) error {
+		if expectedRevision != AnyRevision && device.Revision != expectedRevision {
+			return apperrors.WrapError(ErrRevisionMismatch, apperrors.PreconditionFailed)
+		}
+
+		if changes.Label != nil {
+			device.Label = *changes.Label
+		}
+
+		if len(changes.Tags) > 0 {
+			// the stored map may be shared with other copies of the device
+			tags := maps.Clone(device.Tags)
+			if tags == nil {
+				tags = make(map[string]string, len(changes.Tags))
+			}
+
+			for key, value := range changes.Tags {
+				if value == nil {
+					delete(tags, key)
+				} else {
+					tags[key] = *value
+				}
+			}
+
+			if len(tags) > maxDeviceTags {
+				return apperrors.WrapError(fmt.Errorf("a device cannot have more than %d tags", maxDeviceTags), apperrors.BadRequest)
+			}
+			device.Tags = tags
+		}
+
+		device.Revision++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	release()
+
+	deviceService.publish(domain.NewDeviceEvent(domain.EventDeviceUpdated, *device, time.Now().UTC()))
+	return device, nil
+}
+
+// Services built without a publisher don't announce anything.
+func (deviceService *DeviceServiceImplementation) publish(event domain.Event) {
+	if deviceService.events != nil {
+		deviceService.events.Publish(event)
+	}
+}
+
+func (deviceService *DeviceServiceImplementation) CheckHealth(ctx context.Context) domain.ServiceHealth {
 	health := domain.ServiceHealth{PersistenceLayer: make(map[string]domain.PersistenceHealth)}
 
-	dbHealth := deviceService.persistence.CheckHealth()
+	dbHealth := deviceService.persistence.CheckHealth(ctx)
 	health.PersistenceLayer["device"] = dbHealth
 	health.Status = dbHealth.Status
 
@@ -1,11 +1,15 @@
 package service
 
 import (
+	"context"
 	"encoding/base64"
 	"testing"
+	"time"
 
 	"github.com/chuckiihub/signing-service/crypto"
 	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/persistence"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -14,22 +18,27 @@ type MockDevicePersistence struct {
 	mock.Mock
 }
 
-func (m *MockDevicePersistence) Save(device *domain.Device) (*domain.Device, error) {
+func (m *MockDevicePersistence) Save(ctx context.Context, device *domain.Device) (*domain.Device, error) {
 	args := m.Called(device)
 	return args.Get(0).(*domain.Device), args.Error(1)
 }
 
-func (m *MockDevicePersistence) FindByUUID(uuid string) (*domain.Device, error) {
-	args := m.Called(uuid)
+func (m *MockDevicePersistence) FindByUUID(ctx context.Context, tenantID string, uuid string) (*domain.Device, error) {
+	args := m.Called(tenantID, uuid)
 	return args.Get(0).(*domain.Device), args.Error(1)
 }
 
-func (m *MockDevicePersistence) List(page int, pageSize int) ([]domain.Device, error) {
-	args := m.Called(page, pageSize)
-	return args.Get(0).([]domain.Device), args.Error(1)
+func (m *MockDevicePersistence) List(ctx context.Context, tenantID string, pageRequest persistence.PageRequest) (persistence.Page[domain.Device], error) {
+	args := m.Called(tenantID, pageRequest)
+	return args.Get(0).(persistence.Page[domain.Device]), args.Error(1)
 }
 
-func (m *MockDevicePersistence) CheckHealth() domain.PersistenceHealth {
+func (m *MockDevicePersistence) Search(ctx context.Context, query persistence.DeviceQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.Device], error) {
+	args := m.Called(query, pageRequest)
+	return args.Get(0).(persistence.Page[domain.Device]), args.Error(1)
+}
+
+func (m *MockDevicePersistence) CheckHealth(ctx context.Context) domain.PersistenceHealth {
 	args := m.Called()
 	return args.Get(0).(domain.PersistenceHealth)
 }
@@ -38,7 +47,7 @@ func TestDeviceService_Create(t *testing.T) {
 	mockPersistence := new(MockDevicePersistence)
 	deviceService := &DeviceServiceImplementation{
 		persistence: mockPersistence,
-		pageSize:    10,
+		pageLimits:  PageLimits{Default: 10, Max: 100},
 	}
 
 	uuid := "test-uuid"
@@ -58,7 +67,7 @@ func TestDeviceService_Create(t *testing.T) {
 
 	mockPersistence.On("Save", mock.AnythingOfType("*domain.Device")).Return(expectedDevice, nil)
 
-	device, err := deviceService.Create(algorithm, label)
+	device, err := deviceService.Create(context.Background(), algorithm, label)
 
 	assert.NoError(t, err)
 	assert.NotNil(t, device)
@@ -75,15 +84,15 @@ func TestDeviceService_Find(t *testing.T) {
 	mockPersistence := new(MockDevicePersistence)
 	deviceService := &DeviceServiceImplementation{
 		persistence: mockPersistence,
-		pageSize:    10,
+		pageLimits:  PageLimits{Default: 10, Max: 100},
 	}
 
 	uuid := "test-uuid"
 	expectedDevice := &domain.Device{UUID: uuid}
 
-	mockPersistence.On("FindByUUID", uuid).Return(expectedDevice, nil)
+	mockPersistence.On("FindByUUID", persistence.AllTenants, uuid).Return(expectedDevice, nil)
 
-	device, err := deviceService.Get(uuid)
+	device, err := deviceService.Get(context.Background(), uuid)
 
 	assert.NoError(t, err)
 	assert.NotNil(t, device)
@@ -93,24 +102,93 @@ func TestDeviceService_Find(t *testing.T) {
 }
 
 func TestDeviceService_List(t *testing.T) {
-	pageSize := 5
-	page := 1
-
 	mockPersistence := new(MockDevicePersistence)
 	deviceService := &DeviceServiceImplementation{
 		persistence: mockPersistence,
-		pageSize:    pageSize,
+		pageLimits:  PageLimits{Default: 5, Max: 10},
 	}
 
-	expectedDevices := []domain.Device{{UUID: "1"}, {UUID: "2"}}
+	expectedPage := persistence.Page[domain.Device]{Items: []domain.Device{{UUID: "1"}, {UUID: "2"}}}
 
-	mockPersistence.On("List", page, pageSize).Return(expectedDevices, nil)
+	mockPersistence.On("List", persistence.AllTenants, persistence.PageRequest{Limit: 5}).Return(expectedPage, nil)
 
-	devices, err := deviceService.List(page)
+	page, err := deviceService.List(context.Background(), persistence.PageRequest{})
 
 	assert.NoError(t, err)
-	assert.NotNil(t, devices)
-	assert.Len(t, devices, 2)
+	assert.Len(t, page.Items, 2)
+
+	mockPersistence.AssertExpectations(t)
+}
+
+func TestDeviceService_ListCapsTheLimit(t *testing.T) {
+	mockPersistence := new(MockDevicePersistence)
+	deviceService := &DeviceServiceImplementation{
+		persistence: mockPersistence,
+		pageLimits:  PageLimits{Default: 5, Max: 10},
+	}
+
+	mockPersistence.On("List", persistence.AllTenants, persistence.PageRequest{Cursor: "Mg", Limit: 10}).Return(persistence.Page[domain.Device]{}, nil)
 
+	_, err := deviceService.List(context.Background(), persistence.PageRequest{Cursor: "Mg", Limit: 500})
+
+	assert.NoError(t, err)
 	mockPersistence.AssertExpectations(t)
 }
+
+func TestDeviceService_ListWithInvalidCursorIsABadRequest(t *testing.T) {
+	mockPersistence := new(MockDevicePersistence)
+	deviceService := &DeviceServiceImplementation{
+		persistence: mockPersistence,
+		pageLimits:  PageLimits{Default: 5, Max: 10},
+	}
+
+	mockPersistence.On("List", mock.Anything, mock.Anything).Return(persistence.Page[domain.Device]{}, persistence.ErrInvalidCursor)
+
+	_, err := deviceService.List(context.Background(), persistence.PageRequest{Cursor: "???"})
+
+	var appErr apperrors.AppError
+	assert.ErrorAs(t, err, &appErr)
+	assert.Equal(t, apperrors.BadRequest, appErr.Type)
+}
+
+func TestDeviceService_UpdateChecksRevisionAndKeepsTheChain(t *testing.T) {
+	devicePersistence := persistence.NewVolatileDeviceRepository()
+	deviceService := NewDeviceService(devicePersistence, NewVolatileLockService(time.Second), PageLimits{Default: 10, Max: 10}, nil)
+	ctx := context.Background()
+
+	device, err := deviceService.Create(ctx, crypto.SignatureAlgorithmECC, "register")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), device.Revision)
+
+	label := "register 7"
+	store, location := "42", "Berlin"
+	updated, err := deviceService.Update(ctx, device.UUID, DeviceChanges{
+		Label: &label,
+		Tags:  map[string]*string{"store": &store, "location": &location},
+	}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "register 7", updated.Label)
+	assert.Equal(t, map[string]string{"store": "42", "location": "Berlin"}, updated.Tags)
+	assert.Equal(t, uint64(2), updated.Revision)
+
+	// someone still holding the first revision
+	_, err = deviceService.Update(ctx, device.UUID, DeviceChanges{Label: &label}, 1)
+	assert.ErrorIs(t, err, ErrRevisionMismatch)
+	var appErr apperrors.AppError
+	assert.ErrorAs(t, err, &appErr)
+	assert.Equal(t, apperrors.PreconditionFailed, appErr.Type)
+
+	updated, err = deviceService.Update(ctx, device.UUID, DeviceChanges{Tags: map[string]*string{"location": nil}}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"store": "42"}, updated.Tags)
+	assert.Equal(t, "register 7", updated.Label)
+
+	updated, err = deviceService.Update(ctx, device.UUID, DeviceChanges{Label: &label}, AnyRevision)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), updated.Revision)
+
+	assert.Equal(t, device.PrivateKey, updated.PrivateKey)
+	assert.Equal(t, device.PublicKey, updated.PublicKey)
+	assert.Equal(t, device.LastSignature, updated.LastSignature)
+	assert.Equal(t, device.SignatureCounter, updated.SignatureCounter)
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// Every change of a device (signing, lifecycle, updates) goes through the
+// deviceWriter so they never overwrite each other: with the lock service if
+// there is one, with compare-and-swap retries on the device version otherwise.
+type deviceWriter struct {
+	persistence persistence.DevicePersistance
+	lockService LockService
+
+	// Only used without a lock service.
+	versioned   persistence.VersionedDevicePersistance
+	retryPolicy RetryPolicy
+}
+
+func newLockingDeviceWriter(persistence persistence.DevicePersistance, lockService LockService) deviceWriter {
+	return deviceWriter{persistence: persistence, lockService: lockService}
+}
+
+func newOptimisticDeviceWriter(persistence persistence.VersionedDevicePersistance, retryPolicy RetryPolicy) deviceWriter {
+	return deviceWriter{persistence: persistence, versioned: persistence, retryPolicy: retryPolicy}
+}
+
+// Handy method to fetch a device and check errors.
+func (writer deviceWriter) fetch(ctx context.Context, deviceId string) (*domain.Device, error) {
+	device, err := writer.persistence.FindByUUID(ctx, tenantOf(ctx), deviceId)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	if device == nil {
+		return nil, apperrors.WrapCodedError(errors.New("device not found"), apperrors.NotFound, apperrors.CodeDeviceNotFound)
+	}
+
+	return device, nil
+}
+
+// Applies the change to a fresh copy of the device and saves it. The change may
+// be called more than once when signing optimistically, so it should not have
+// side effects outside the device. If it returns an error nothing is saved.
+//
+// Returns the saved device, the device as it was before the change and a
+// release function that must be called once the caller is done with the
+// device, e.g. after saving the signature the device points to.
+func (writer deviceWriter) modify(ctx context.Context, deviceId string, change func(device *domain.Device) error) (*domain.Device, domain.Device, func(), error) {
+	if writer.lockService == nil {
+		device, original, err := writer.modifyOptimistically(ctx, deviceId, change)
+		return device, original, func() {}, err
+	}
+
+	release, fencingToken, err := writer.lockService.Lock(ctx, deviceId)
+	if err != nil {
+		return nil, domain.Device{}, nil, apperrors.WrapError(err, apperrors.Unavailable)
+	}
+
+	// after obtaining the lock, I need to refetch the device to ensure it wasn't changed meanwhile.
+	device, err := writer.fetch(ctx, deviceId)
+	if err != nil {
+		release()
+		return nil, domain.Device{}, nil, err
+	}
+	original := *device
+	device.FencingToken = fencingToken
+
+	if err := change(device); err != nil {
+		release()
+		return nil, domain.Device{}, nil, err
+	}
+
+	if _, err = writer.persistence.Save(ctx, device); err != nil {
+		release()
+		if errors.Is(err, persistence.ErrStaleFencingToken) {
+			return nil, domain.Device{}, nil, apperrors.WrapCodedError(err, apperrors.Conflict, apperrors.CodeDeviceBusy)
+		}
+		return nil, domain.Device{}, nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return device, original, release, nil
+}
+
+// Without a lock, several requests may change the same device at the same
+// time. Only the one whose compare-and-swap on the device version succeeds
+// gets saved, the others start over from the new state of the device after a
+// short random backoff.
+func (writer deviceWriter) modifyOptimistically(ctx context.Context, deviceId string, change func(device *domain.Device) error) (*domain.Device, domain.Device, error) {
+	backoff := writer.retryPolicy.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		device, err := writer.fetch(ctx, deviceId)
+		if err != nil {
+			return nil, domain.Device{}, err
+		}
+		original := *device
+
+		if err := change(device); err != nil {
+			return nil, domain.Device{}, err
+		}
+
+		_, err = writer.versioned.SaveIfVersion(ctx, device, original.Version)
+		if err == nil {
+			return device, original, nil
+		}
+
+		if !errors.Is(err, persistence.ErrVersionConflict) {
+			return nil, domain.Device{}, apperrors.WrapError(err, apperrors.InternalError)
+		}
+
+		if attempt >= writer.retryPolicy.MaxAttempts {
+			slog.Warn("giving up modifying device after too many conflicts", "deviceId", deviceId, "attempts", attempt)
+			return nil, domain.Device{}, apperrors.WrapCodedError(err, apperrors.Conflict, apperrors.CodeDeviceBusy)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, domain.Device{}, apperrors.WrapError(ctx.Err(), apperrors.Unavailable)
+		case <-time.After(rand.N(backoff + 1)):
+		}
+		backoff = min(backoff*2, writer.retryPolicy.MaxBackoff)
+	}
+}
+
+// Saves the device back as it was before a change whose follow up failed.
+// Must be called before releasing the device. Without a lock someone may have
+// changed the device already, then it is not possible anymore.
+func (writer deviceWriter) revert(ctx context.Context, device *domain.Device, original domain.Device) error {
+	reverted := original
+	reverted.FencingToken = device.FencingToken
+
+	if writer.lockService == nil {
+		_, err := writer.versioned.SaveIfVersion(ctx, &reverted, device.Version)
+		return err
+	}
+
+	_, err := writer.persistence.Save(ctx, &reverted)
+	return err
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// EventPublisher is where the services announce what they changed.
+// Publishing never blocks the caller.
+type EventPublisher interface {
+	Publish(event domain.Event)
+}
+
+// EventPublishers hands every event to each of the publishers, in order.
+type EventPublishers []EventPublisher
+
+func (publishers EventPublishers) Publish(event domain.Event) {
+	for _, publisher := range publishers {
+		publisher.Publish(event)
+	}
+}
+
+// EventFilter selects the events of a subscription. Empty fields match
+// everything.
+type EventFilter struct {
+	TenantID   string
+	DeviceUUID string
+	Types      []domain.EventType
+}
+
+func (filter EventFilter) matches(event domain.Event) bool {
+	return (filter.TenantID == persistence.AllTenants || filter.TenantID == event.TenantID) &&
+		(filter.DeviceUUID == "" || filter.DeviceUUID == event.DeviceUUID) &&
+		(len(filter.Types) == 0 || slices.Contains(filter.Types, event.Type))
+}
+
+// EventBus hands the events over to the subscribers of this instance, in
+// memory. Subscribers have a buffer, one that falls behind is dropped instead
+// of slowing the publishers down, it has to resume from its last event.
+//
+// The signature events can be read again from the signature store, device
+// events can't: the last ones are kept to resume subscriptions.
+type EventBus struct {
+	subscriberBuffer int
+	recentSize       int
+
+	mutex        sync.Mutex
+	subscribers  map[*EventSubscription]struct{}
+	recentDevice []domain.Event
+}
+
+func NewEventBus(subscriberBuffer int, recentSize int) *EventBus {
+	return &EventBus{
+		subscriberBuffer: subscriberBuffer,
+		recentSize:       recentSize,
+		subscribers:      make(map[*EventSubscription]struct{}),
+	}
+}
+
+// EventSubscription receives the events published after it was made.
+type EventSubscription struct {
+	filter EventFilter
+	events chan domain.Event
+	bus    *EventBus
+	// Set before closing the channel when the subscriber fell behind.
+	lagged bool
+}
+
+// Closed when the subscription ends, either by Close or because the
+// subscriber fell behind.
+func (subscription *EventSubscription) Events() <-chan domain.Event {
+	return subscription.events
+}
+
+// Tells, once the channel is closed, whether events were lost.
+func (subscription *EventSubscription) Lagged() bool {
+	subscription.bus.mutex.Lock()
+	defer subscription.bus.mutex.Unlock()
+
+	return subscription.lagged
+}
+
+func (subscription *EventSubscription) Close() {
+	subscription.bus.unsubscribe(subscription, false)
+}
+
+func (bus *EventBus) Publish(event domain.Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if event.Type != domain.EventSignatureCreated {
+		bus.recentDevice = append(bus.recentDevice, event)
+		if len(bus.recentDevice) > bus.recentSize {
+			bus.recentDevice = slices.Delete(bus.recentDevice, 0, len(bus.recentDevice)-bus.recentSize)
+		}
+	}
+
+	for subscription := range bus.subscribers {
+		if !subscription.filter.matches(event) {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			bus.remove(subscription, true)
+		}
+	}
+}
+
+func (bus *EventBus) subscribe(filter EventFilter) *EventSubscription {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	subscription := &EventSubscription{
+		filter: filter,
+		events: make(chan domain.Event, bus.subscriberBuffer),
+		bus:    bus,
+	}
+	bus.subscribers[subscription] = struct{}{}
+
+	return subscription
+}
+
+func (bus *EventBus) unsubscribe(subscription *EventSubscription, lagged bool) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.remove(subscription, lagged)
+}
+
+func (bus *EventBus) remove(subscription *EventSubscription, lagged bool) {
+	if _, found := bus.subscribers[subscription]; !found {
+		return
+	}
+
+	delete(bus.subscribers, subscription)
+	subscription.lagged = lagged
+	close(subscription.events)
+}
+
+// The device events kept in memory matching the filter.
+func (bus *EventBus) recentDeviceEvents(filter EventFilter) []domain.Event {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	var events []domain.Event
+	for _, event := range bus.recentDevice {
+		if filter.matches(event) {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
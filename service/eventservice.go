@@ -0,0 +1,174 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+type EventService interface {
+	// Subscribes to the events of the tenant of the caller matching the
+	// filter. Given the ID of the last event a client received, the events
+	// after it are returned too, to be sent before the ones of the subscription.
+	Subscribe(ctx context.Context, filter EventFilter, lastEventID string) (*EventSubscription, []domain.Event, error)
+}
+
+const (
+	// Events published around the same time by different devices may reach
+	// the bus out of order, so resuming starts a bit before the last event
+	// and clients drop the IDs they already have.
+	eventReplayOverlap = time.Second
+	// Past this many events a client has to page through the signatures instead.
+	maxEventReplay      = 10000
+	eventReplayPageSize = 100
+)
+
+// Resuming reads the signatures from the signature store, so a client can
+// resume after a restart or from another replica. Device events are only kept
+// in memory by the bus, the last ones.
+type EventServiceImplementation struct {
+	bus                  *EventBus
+	devicePersistence    persistence.DevicePersistance
+	signaturePersistence persistence.SignaturePersistance
+}
+
+func NewEventService(bus *EventBus, dDB persistence.DevicePersistance, sDB persistence.SignaturePersistance) EventService {
+	return &EventServiceImplementation{
+		bus:                  bus,
+		devicePersistence:    dDB,
+		signaturePersistence: sDB,
+	}
+}
+
+func (events *EventServiceImplementation) Subscribe(ctx context.Context, filter EventFilter, lastEventID string) (*EventSubscription, []domain.Event, error) {
+	for _, eventType := range filter.Types {
+		if !slices.Contains(domain.EventTypes, eventType) {
+			return nil, nil, apperrors.WrapError(fmt.Errorf("unknown event type %q", eventType), apperrors.BadRequest)
+		}
+	}
+
+	var after time.Time
+	if lastEventID != "" {
+		var err error
+		if after, err = domain.ParseEventID(lastEventID); err != nil {
+			return nil, nil, apperrors.WrapError(err, apperrors.BadRequest)
+		}
+	}
+
+	filter.TenantID = tenantOf(ctx)
+
+	// subscribed before reading the past events, so nothing falls in between
+	subscription := events.bus.subscribe(filter)
+	if lastEventID == "" {
+		return subscription, nil, nil
+	}
+
+	replay, err := events.replay(ctx, filter, after.Add(-eventReplayOverlap), lastEventID)
+	if err != nil {
+		subscription.Close()
+		return nil, nil, err
+	}
+
+	return subscription, replay, nil
+}
+
+func (events *EventServiceImplementation) replay(ctx context.Context, filter EventFilter, from time.Time, lastEventID string) ([]domain.Event, error) {
+	var replay []domain.Event
+	for _, event := range events.bus.recentDeviceEvents(filter) {
+		if !event.Time.Before(from) {
+			replay = append(replay, event)
+		}
+	}
+
+	if len(filter.Types) == 0 || slices.Contains(filter.Types, domain.EventSignatureCreated) {
+		signatures, err := events.signaturesSince(ctx, filter, from)
+		if err != nil {
+			return nil, err
+		}
+		replay = append(replay, signatures...)
+	}
+
+	replay = slices.DeleteFunc(replay, func(event domain.Event) bool { return event.ID == lastEventID })
+	slices.SortFunc(replay, func(a domain.Event, b domain.Event) int {
+		if c := a.Time.Compare(b.Time); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return replay, nil
+}
+
+// Only the devices that signed since then are read.
+func (events *EventServiceImplementation) signaturesSince(ctx context.Context, filter EventFilter, from time.Time) ([]domain.Event, error) {
+	var replay []domain.Event
+
+	err := events.eachDevice(ctx, filter, func(device domain.Device) error {
+		if device.LastSignedAt.Before(from) {
+			return nil
+		}
+
+		query := persistence.SignatureQuery{DeviceUUID: device.UUID, CreatedFrom: from, Order: persistence.OrderAscending}
+		pageRequest := persistence.PageRequest{Limit: eventReplayPageSize}
+		for {
+			page, err := events.signaturePersistence.FindByDevice(ctx, query, pageRequest)
+			if err != nil {
+				return wrapListError(err)
+			}
+
+			for _, signature := range page.Items {
+				replay = append(replay, domain.NewSignatureEvent(signature))
+			}
+
+			if len(replay) > maxEventReplay {
+				return apperrors.WrapError(fmt.Errorf("more than %d events to resume, list the signatures instead", maxEventReplay), apperrors.BadRequest)
+			}
+
+			if !page.HasMore {
+				return nil
+			}
+			pageRequest.Cursor = page.NextCursor
+		}
+	})
+
+	return replay, err
+}
+
+func (events *EventServiceImplementation) eachDevice(ctx context.Context, filter EventFilter, visit func(device domain.Device) error) error {
+	if filter.DeviceUUID != "" {
+		device, err := events.devicePersistence.FindByUUID(ctx, filter.TenantID, filter.DeviceUUID)
+		if err != nil {
+			return apperrors.WrapError(err, apperrors.InternalError)
+		}
+		if device == nil {
+			return nil
+		}
+		return visit(*device)
+	}
+
+	query := persistence.DeviceQuery{TenantID: filter.TenantID}
+	pageRequest := persistence.PageRequest{Limit: eventReplayPageSize}
+	for {
+		page, err := events.devicePersistence.Search(ctx, query, pageRequest)
+		if err != nil {
+			return wrapListError(err)
+		}
+
+		for _, device := range page.Items {
+			if err := visit(device); err != nil {
+				return err
+			}
+		}
+
+		if !page.HasMore {
+			return nil
+		}
+		pageRequest.Cursor = page.NextCursor
+	}
+}
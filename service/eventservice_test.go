@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEventServices(subscriberBuffer int) (DeviceService, SignatureService, EventService) {
+	devicePersistence := persistence.NewVolatileDeviceRepository()
+	signaturePersistence := persistence.NewVolatileSignatureRepository()
+	lockService := NewVolatileLockService(time.Second)
+	pageLimits := PageLimits{Default: 10, Max: 10}
+	bus := NewEventBus(subscriberBuffer, 100)
+
+	deviceService := NewAuthorizedDeviceService(NewDeviceService(devicePersistence, lockService, pageLimits, bus))
+	signatureService := NewAuthorizedSignatureService(NewSignatureService(devicePersistence, signaturePersistence, lockService, pageLimits, bus))
+	eventService := NewAuthorizedEventService(NewEventService(bus, devicePersistence, signaturePersistence))
+
+	return deviceService, signatureService, eventService
+}
+
+func receive(t *testing.T, subscription *EventSubscription) domain.Event {
+	select {
+	case event, open := <-subscription.Events():
+		require.True(t, open, "the subscription was closed")
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event was published")
+		return domain.Event{}
+	}
+}
+
+func TestEvents_SignaturesAndDeviceChangesArePublished(t *testing.T) {
+	deviceService, signatureService, eventService := newTestEventServices(10)
+	admin := roleContext([]string{RoleAdmin})
+
+	subscription, missed, err := eventService.Subscribe(roleContext([]string{RoleViewer}), EventFilter{}, "")
+	require.NoError(t, err)
+	defer subscription.Close()
+	assert.Empty(t, missed)
+
+	device, err := deviceService.Create(admin, crypto.SignatureAlgorithmECC, "till")
+	require.NoError(t, err)
+	created := receive(t, subscription)
+	assert.Equal(t, domain.EventDeviceCreated, created.Type)
+	assert.Equal(t, device.UUID, created.DeviceUUID)
+	assert.Nil(t, created.Device.PrivateKey)
+
+	signature, err := signatureService.Sign(roleContext([]string{RoleSigner}, device.UUID), device.UUID, "data", nil)
+	require.NoError(t, err)
+	signed := receive(t, subscription)
+	assert.Equal(t, domain.EventSignatureCreated, signed.Type)
+	assert.Equal(t, signature.UUID, signed.Signature.UUID)
+
+	_, err = deviceService.ChangeState(admin, device.UUID, domain.DeviceStateSuspended, "stolen")
+	require.NoError(t, err)
+	assert.Equal(t, domain.EventDeviceStateChanged, receive(t, subscription).Type)
+}
+
+func TestEvents_FilterByDeviceAndType(t *testing.T) {
+	deviceService, signatureService, eventService := newTestEventServices(10)
+	admin := roleContext([]string{RoleAdmin})
+
+	device, err := deviceService.Create(admin, crypto.SignatureAlgorithmECC, "till-1")
+	require.NoError(t, err)
+	other, err := deviceService.Create(admin, crypto.SignatureAlgorithmECC, "till-2")
+	require.NoError(t, err)
+
+	filter := EventFilter{DeviceUUID: device.UUID, Types: []domain.EventType{domain.EventSignatureCreated}}
+	subscription, _, err := eventService.Subscribe(roleContext([]string{RoleViewer}), filter, "")
+	require.NoError(t, err)
+	defer subscription.Close()
+
+	signer := roleContext([]string{RoleSigner}, device.UUID, other.UUID)
+	_, err = signatureService.Sign(signer, other.UUID, "other", nil)
+	require.NoError(t, err)
+	label := "renamed"
+	_, err = deviceService.Update(admin, device.UUID, DeviceChanges{Label: &label}, device.Revision)
+	require.NoError(t, err)
+	signature, err := signatureService.Sign(signer, device.UUID, "mine", nil)
+	require.NoError(t, err)
+
+	event := receive(t, subscription)
+	assert.Equal(t, device.UUID, event.DeviceUUID)
+	assert.Equal(t, signature.UUID, event.Signature.UUID)
+	assert.Empty(t, subscription.Events())
+}
+
+func TestEvents_ResumeAfterTheLastEventFromTheStore(t *testing.T) {
+	deviceService, signatureService, eventService := newTestEventServices(10)
+	viewer := roleContext([]string{RoleViewer})
+
+	device, err := deviceService.Create(roleContext([]string{RoleAdmin}), crypto.SignatureAlgorithmECC, "till")
+	require.NoError(t, err)
+	signer := roleContext([]string{RoleSigner}, device.UUID)
+
+	var events []domain.Event
+	for _, data := range []string{"one", "two", "three"} {
+		signature, err := signatureService.Sign(signer, device.UUID, data, nil)
+		require.NoError(t, err)
+		events = append(events, domain.NewSignatureEvent(*signature))
+	}
+
+	// the client only got the first signature before disconnecting
+	filter := EventFilter{Types: []domain.EventType{domain.EventSignatureCreated}}
+	subscription, missed, err := eventService.Subscribe(viewer, filter, events[0].ID)
+	require.NoError(t, err)
+	defer subscription.Close()
+
+	require.Len(t, missed, 2)
+	assert.Equal(t, events[1].ID, missed[0].ID)
+	assert.Equal(t, events[2].ID, missed[1].ID)
+
+	_, _, err = eventService.Subscribe(viewer, filter, "not-an-id")
+	assertAppErrorType(t, err, apperrors.BadRequest)
+}
+
+func TestEvents_SlowSubscribersAreDropped(t *testing.T) {
+	bus := NewEventBus(1, 0)
+	subscription := bus.subscribe(EventFilter{})
+
+	device := domain.Device{UUID: "device"}
+	bus.Publish(domain.NewDeviceEvent(domain.EventDeviceCreated, device, time.Now()))
+	bus.Publish(domain.NewDeviceEvent(domain.EventDeviceUpdated, device, time.Now()))
+
+	assert.Equal(t, domain.EventDeviceCreated, (<-subscription.Events()).Type)
+	_, open := <-subscription.Events()
+	assert.False(t, open)
+	assert.True(t, subscription.Lagged())
+
+	// closing it again does nothing
+	subscription.Close()
+}
+
+func TestEvents_SubscribersOnlyGetWhatTheyMayRead(t *testing.T) {
+	deviceService, _, eventService := newTestEventServices(10)
+
+	device, err := deviceService.Create(roleContext([]string{RoleAdmin}), crypto.SignatureAlgorithmECC, "till")
+	require.NoError(t, err)
+
+	// signers read neither devices nor signatures
+	_, _, err = eventService.Subscribe(roleContext([]string{RoleSigner}, device.UUID), EventFilter{DeviceUUID: device.UUID}, "")
+	assertAppErrorType(t, err, apperrors.Forbidden)
+
+	// keys restricted to devices have to pick one of theirs
+	restricted := roleContext([]string{RoleViewer}, device.UUID)
+	_, _, err = eventService.Subscribe(restricted, EventFilter{}, "")
+	assertAppErrorType(t, err, apperrors.Forbidden)
+
+	subscription, _, err := eventService.Subscribe(restricted, EventFilter{DeviceUUID: device.UUID}, "")
+	require.NoError(t, err)
+	subscription.Close()
+
+	// calls made by the service itself are not checked
+	subscription, _, err = eventService.Subscribe(context.Background(), EventFilter{}, "")
+	require.NoError(t, err)
+	subscription.Close()
+}
@@ -1,7 +1,11 @@
 package service
 
-import "github.com/chuckiihub/signing-service/domain"
+import (
+	"context"
+
+	"github.com/chuckiihub/signing-service/domain"
+)
 
 type ServiceHealthCheck interface {
-	CheckHealth() domain.ServiceHealth
+	CheckHealth(ctx context.Context) domain.ServiceHealth
 }
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// Returned when an idempotency key comes back with other data to sign.
+var ErrIdempotencyKeyReused = errors.New("the idempotency key was already used with different data")
+
+// Returned when a retry arrives while the first request with the key is
+// still signing.
+var ErrIdempotencyKeyInFlight = errors.New("a request with the idempotency key is still in progress")
+
+type idempotencyKeyContextKey struct{}
+
+// Signs made with this context are idempotent: the first one signs and the
+// ones after it, with the same key and data, get the same signature back.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+const MaxIdempotencyKeyLength = 255
+
+// Keys are 1 to 255 printable ASCII characters, a UUID is a good one.
+func ValidIdempotencyKey(key string) bool {
+	if key == "" || len(key) > MaxIdempotencyKeyLength {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < ' ' || key[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+type idempotencyFingerprintContextKey struct{}
+
+// The key of the request and the fingerprint of what it signs, which end up
+// in the signature.
+func idempotencyOf(ctx context.Context) (string, string) {
+	key, _ := ctx.Value(idempotencyKeyContextKey{}).(string)
+	fingerprint, _ := ctx.Value(idempotencyFingerprintContextKey{}).(string)
+	return key, fingerprint
+}
+
+type idempotentSignatureService struct {
+	SignatureService
+	signatures persistence.SignaturePersistance
+	window     time.Duration
+	clock      Clock
+
+	mutex sync.Mutex
+	// Fingerprints of the requests signing right now, by scope.
+	inFlight map[idempotencyScope]string
+}
+
+// Keys are per device (so per tenant too), two registers picking the same key
+// don't get each other's signatures.
+type idempotencyScope struct {
+	tenantID string
+	deviceId string
+	key      string
+}
+
+// A register whose request timed out can't tell whether the service signed, so
+// it retries. With an idempotency key the retry gets the original signature
+// instead of a new one (and a new counter).
+//
+// The key is saved with the signature and looked up in the persistence, so
+// retries are answered after a restart or by another replica. Keys older than
+// the window sign again. Only the requests still signing are kept in memory,
+// so a retry arriving meanwhile at the same replica gets a 409.
+func NewIdempotentSignatureService(signatureService SignatureService, signatures persistence.SignaturePersistance, window time.Duration) SignatureService {
+	return &idempotentSignatureService{
+		SignatureService: signatureService,
+		signatures:       signatures,
+		window:           window,
+		clock:            NewMonotonicClock(),
+		inFlight:         make(map[idempotencyScope]string),
+	}
+}
+
+func (service *idempotentSignatureService) Sign(ctx context.Context, deviceId string, dataToBeSigned string, metadata map[string]string) (*domain.Signature, error) {
+	key, _ := idempotencyOf(ctx)
+	if key == "" {
+		return service.SignatureService.Sign(ctx, deviceId, dataToBeSigned, metadata)
+	}
+
+	scope := idempotencyScope{tenantID: tenantOf(ctx), deviceId: deviceId, key: key}
+	fingerprint := signRequestFingerprint(dataToBeSigned, metadata)
+	if err := service.reserve(scope, fingerprint); err != nil {
+		return nil, err
+	}
+	defer service.release(scope)
+
+	original, err := service.signatures.FindByIdempotencyKey(ctx, scope.tenantID, deviceId, key)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	if original != nil && service.clock.Now().Before(original.CreatedAt.Add(service.window)) {
+		if original.IdempotencyFingerprint != fingerprint {
+			return nil, apperrors.WrapCodedError(ErrIdempotencyKeyReused, apperrors.UnprocessableEntity, apperrors.CodeIdempotencyKeyReused)
+		}
+
+		return original, nil
+	}
+
+	ctx = context.WithValue(ctx, idempotencyFingerprintContextKey{}, fingerprint)
+	return service.SignatureService.Sign(ctx, deviceId, dataToBeSigned, metadata)
+}
+
+// Only one request per key signs at a time. When signing fails nothing was
+// signed, so releasing the key lets the retry sign.
+func (service *idempotentSignatureService) reserve(scope idempotencyScope, fingerprint string) error {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	inFlight, found := service.inFlight[scope]
+	if !found {
+		service.inFlight[scope] = fingerprint
+		return nil
+	}
+
+	if inFlight != fingerprint {
+		return apperrors.WrapCodedError(ErrIdempotencyKeyReused, apperrors.UnprocessableEntity, apperrors.CodeIdempotencyKeyReused)
+	}
+
+	return apperrors.WrapCodedError(ErrIdempotencyKeyInFlight, apperrors.Conflict, apperrors.CodeIdempotencyInFlight)
+}
+
+func (service *idempotentSignatureService) release(scope idempotencyScope) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	delete(service.inFlight, scope)
+}
+
+// Tells apart requests with different data or metadata.
+func signRequestFingerprint(dataToBeSigned string, metadata map[string]string) string {
+	digest := sha256.New()
+	writeFingerprintField(digest, dataToBeSigned)
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		writeFingerprintField(digest, key)
+		writeFingerprintField(digest, metadata[key])
+	}
+
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// Length prefixed so "ab"+"c" and "a"+"bc" differ.
+func writeFingerprintField(digest hash.Hash, field string) {
+	digest.Write(binary.BigEndian.AppendUint64(nil, uint64(len(field))))
+	digest.Write([]byte(field))
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type idempotencyTestStores struct {
+	devices    *persistence.VolatileDeviceRepository
+	signatures *persistence.SignatureVolatileRepository
+	clock      *stoppedClock
+}
+
+func newIdempotencyTestStores() idempotencyTestStores {
+	return idempotencyTestStores{
+		devices:    persistence.NewVolatileDeviceRepository(),
+		signatures: persistence.NewVolatileSignatureRepository(),
+		clock:      &stoppedClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+	}
+}
+
+// A service as a replica (or the service after a restart) would have, only
+// sharing the stores with the others.
+func (stores idempotencyTestStores) newService(window time.Duration) SignatureService {
+	signatureService := NewSignatureService(stores.devices, stores.signatures, NewVolatileLockService(time.Second), PageLimits{Default: 10, Max: 10}, nil)
+	signatureService.(*SignatureServiceImplementation).clock = stores.clock
+
+	idempotent := NewIdempotentSignatureService(signatureService, stores.signatures, window)
+	idempotent.(*idempotentSignatureService).clock = stores.clock
+
+	return idempotent
+}
+
+func (stores idempotencyTestStores) newDevice(t *testing.T) *domain.Device {
+	device, err := NewDeviceService(stores.devices, NewVolatileLockService(time.Second), PageLimits{Default: 10, Max: 10}, nil).Create(context.Background(), crypto.SignatureAlgorithmECC, "till")
+	require.NoError(t, err)
+
+	return device
+}
+
+func newTestIdempotentServices(t *testing.T, window time.Duration) (SignatureService, *stoppedClock, *domain.Device) {
+	stores := newIdempotencyTestStores()
+	return stores.newService(window), stores.clock, stores.newDevice(t)
+}
+
+func TestIdempotency_RetriesGetTheOriginalSignature(t *testing.T) {
+	signatureService, _, device := newTestIdempotentServices(t, time.Hour)
+	ctx := WithIdempotencyKey(tenantContext(domain.DefaultTenantID), "receipt-1")
+	metadata := map[string]string{"register": "1"}
+
+	first, err := signatureService.Sign(ctx, device.UUID, "receipt", metadata)
+	require.NoError(t, err)
+	assert.Equal(t, "receipt-1", first.IdempotencyKey)
+
+	retry, err := signatureService.Sign(ctx, device.UUID, "receipt", map[string]string{"register": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, first, retry)
+
+	// the retry did not move the chain
+	next, err := signatureService.Sign(tenantContext(domain.DefaultTenantID), device.UUID, "next", nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.Counter+1, next.Counter)
+	assert.Empty(t, next.IdempotencyKey)
+}
+
+func TestIdempotency_KeyReusedWithOtherDataIsRejected(t *testing.T) {
+	signatureService, _, device := newTestIdempotentServices(t, time.Hour)
+	ctx := WithIdempotencyKey(tenantContext(domain.DefaultTenantID), "receipt-1")
+
+	_, err := signatureService.Sign(ctx, device.UUID, "receipt", nil)
+	require.NoError(t, err)
+
+	_, err = signatureService.Sign(ctx, device.UUID, "another receipt", nil)
+	assertAppErrorType(t, err, apperrors.UnprocessableEntity)
+
+	_, err = signatureService.Sign(ctx, device.UUID, "receipt", map[string]string{"register": "1"})
+	assertAppErrorType(t, err, apperrors.UnprocessableEntity)
+
+	// other keys of the tenant can't reuse it either, keys belong to the device
+	other := WithIdempotencyKey(identity.NewContext(context.Background(), identity.Identity{KeyID: "other-key", TenantID: domain.DefaultTenantID}), "receipt-1")
+	_, err = signatureService.Sign(other, device.UUID, "another receipt", nil)
+	assertAppErrorType(t, err, apperrors.UnprocessableEntity)
+}
+
+func TestIdempotency_KeysExpireAfterTheWindow(t *testing.T) {
+	signatureService, clock, device := newTestIdempotentServices(t, time.Hour)
+	ctx := WithIdempotencyKey(tenantContext(domain.DefaultTenantID), "receipt-1")
+
+	first, err := signatureService.Sign(ctx, device.UUID, "receipt", nil)
+	require.NoError(t, err)
+
+	clock.now = clock.now.Add(59 * time.Minute)
+	retry, err := signatureService.Sign(ctx, device.UUID, "receipt", nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.UUID, retry.UUID)
+
+	clock.now = clock.now.Add(time.Minute)
+	again, err := signatureService.Sign(ctx, device.UUID, "receipt", nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.UUID, again.UUID)
+	assert.Equal(t, first.Counter+1, again.Counter)
+}
+
+func TestIdempotency_FailedSignsFreeTheKey(t *testing.T) {
+	signatureService, _, device := newTestIdempotentServices(t, time.Hour)
+	ctx := WithIdempotencyKey(tenantContext(domain.DefaultTenantID), "receipt-1")
+
+	_, err := signatureService.Sign(ctx, "missing-device", "receipt", nil)
+	assertAppErrorType(t, err, apperrors.NotFound)
+
+	// not stuck as in progress, the retry runs again
+	_, err = signatureService.Sign(ctx, "missing-device", "receipt", nil)
+	assertAppErrorType(t, err, apperrors.NotFound)
+
+	_, err = signatureService.Sign(ctx, device.UUID, "receipt", nil)
+	require.NoError(t, err)
+
+	assert.True(t, ValidIdempotencyKey("0b9f6a52-5d1e-4c0e-9a53-2f1f0f4f3c11"))
+	assert.False(t, ValidIdempotencyKey("with\nnewline"))
+}
+
+func TestIdempotency_RetriesAfterARestartGetTheOriginalSignature(t *testing.T) {
+	stores := newIdempotencyTestStores()
+	device := stores.newDevice(t)
+	ctx := WithIdempotencyKey(tenantContext(domain.DefaultTenantID), "receipt-1")
+
+	first, err := stores.newService(time.Hour).Sign(ctx, device.UUID, "receipt", nil)
+	require.NoError(t, err)
+
+	// nothing of the first service is left but the stores
+	restarted := stores.newService(time.Hour)
+	retry, err := restarted.Sign(ctx, device.UUID, "receipt", nil)
+	require.NoError(t, err)
+	assert.Equal(t, first, retry)
+
+	_, err = restarted.Sign(ctx, device.UUID, "another receipt", nil)
+	assertAppErrorType(t, err, apperrors.UnprocessableEntity)
+
+	stored, err := stores.devices.FindByUUID(context.Background(), persistence.AllTenants, device.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, first.Counter, stored.SignatureCounter)
+
+	// other tenants can't read the signature through the key
+	_, err = restarted.Sign(WithIdempotencyKey(tenantContext("acme"), "receipt-1"), device.UUID, "receipt", nil)
+	assertAppErrorType(t, err, apperrors.NotFound)
+}
+
+func TestIdempotency_RetriesWhileSigningAreRejected(t *testing.T) {
+	signatureService, _, device := newTestIdempotentServices(t, time.Hour)
+	ctx := WithIdempotencyKey(tenantContext(domain.DefaultTenantID), "receipt-1")
+
+	// the first request is still signing
+	scope := idempotencyScope{tenantID: domain.DefaultTenantID, deviceId: device.UUID, key: "receipt-1"}
+	require.NoError(t, signatureService.(*idempotentSignatureService).reserve(scope, signRequestFingerprint("receipt", nil)))
+
+	_, err := signatureService.Sign(ctx, device.UUID, "receipt", nil)
+	assertAppErrorType(t, err, apperrors.Conflict)
+
+	_, err = signatureService.Sign(ctx, device.UUID, "another receipt", nil)
+	assertAppErrorType(t, err, apperrors.UnprocessableEntity)
+
+	signatureService.(*idempotentSignatureService).release(scope)
+	_, err = signatureService.Sign(ctx, device.UUID, "receipt", nil)
+	require.NoError(t, err)
+}
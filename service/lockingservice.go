@@ -1,53 +1,122 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"sync"
+	"time"
 )
 
+var ErrLockTimeout = errors.New("timed out waiting for the device lock")
+
 // If we want to scale horizontally the application, we are gonna be needing
 // a external service for locking (like Redis) so 2 different processes don't
 // modify fields that should be modified atomically (like signatureCounter or LastSignature).
 
-// Right now, we are only implementing a InMemory Locking Service.
-
+// Lock waits for the lock of the device until the context is done. If the
+// context has no deadline the wait timeout of the implementation is used, so a
+// stuck holder makes other requests fail with ErrLockTimeout instead of piling
+// up behind it. A cancelled context returns context.Canceled.
+//
+// On success it returns the function releasing the lock (safe to call more
+// than once) and a fencing token: a number that grows every time the lock of a
+// device is acquired. It is saved with the device so the persistence layer can
+// reject writes from a holder whose lock expired in the meantime (see
+// persistence.ErrStaleFencingToken).
 type LockService interface {
-	Lock(deviceId string)
-	Unlock(deviceId string)
+	Lock(ctx context.Context, deviceId string) (release func(), fencingToken uint64, err error)
+}
+
+// Applies the default wait timeout when the caller didn't set a deadline.
+func withWaitTimeout(ctx context.Context, waitTimeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || waitTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, waitTimeout)
+}
+
+func lockWaitError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrLockTimeout
+	}
+
+	return ctx.Err()
 }
 
 type VolatileLockService struct {
-	deviceMutexes map[string]*sync.Mutex
-	generalMutex  sync.Mutex
+	deviceLocks  map[string]*deviceLock
+	fencingToken uint64
+	waitTimeout  time.Duration
+	generalMutex sync.Mutex
+}
+
+// A channel with room for one element works as a mutex we can stop waiting
+// for. The entry is removed from the map once nobody holds or waits for it,
+// so devices that are never used again don't keep their lock around.
+type deviceLock struct {
+	held       chan struct{}
+	references int
+}
+
+func NewVolatileLockService(waitTimeout time.Duration) *VolatileLockService {
+	return &VolatileLockService{
+		deviceLocks: make(map[string]*deviceLock),
+		waitTimeout: waitTimeout,
+	}
 }
 
 // To be called while signing data before modiying the `lastSignature`
 // and `signatureCounter` fields
-func (lockService *VolatileLockService) Lock(deviceId string) {
+func (lockService *VolatileLockService) Lock(ctx context.Context, deviceId string) (func(), uint64, error) {
+	ctx, cancel := withWaitTimeout(ctx, lockService.waitTimeout)
+	defer cancel()
+
 	lockService.generalMutex.Lock()
-	if _, ok := lockService.deviceMutexes[deviceId]; !ok {
-		lockService.deviceMutexes[deviceId] = &sync.Mutex{}
+	lock, ok := lockService.deviceLocks[deviceId]
+	if !ok {
+		lock = &deviceLock{held: make(chan struct{}, 1)}
+		lockService.deviceLocks[deviceId] = lock
 	}
+	lock.references++
 	lockService.generalMutex.Unlock()
 
-	lockService.deviceMutexes[deviceId].Lock()
-	slog.Debug("lock acquired for device", "deviceId", deviceId)
-}
+	select {
+	case lock.held <- struct{}{}:
+	case <-ctx.Done():
+		lockService.dropReference(deviceId, lock)
+		slog.Debug("gave up waiting for the lock of device", "deviceId", deviceId, "error", ctx.Err())
+		return nil, 0, lockWaitError(ctx)
+	}
 
-// After successfull save in databaseof the device (and the signature),
-// we would need to unlock the device so other threads/processes can modify it.
-func (lockService *VolatileLockService) Unlock(deviceId string) {
-	mutex, ok := lockService.deviceMutexes[deviceId]
-	if !ok {
-		slog.Warn("attempting to unlock a non existent lock for device", "deviceId", deviceId)
-		return
+	// A single counter for every device is enough for tokens to grow per
+	// device, and it survives the removal of the device entry.
+	lockService.generalMutex.Lock()
+	lockService.fencingToken++
+	token := lockService.fencingToken
+	lockService.generalMutex.Unlock()
+
+	slog.Debug("lock acquired for device", "deviceId", deviceId, "token", token)
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			<-lock.held
+			lockService.dropReference(deviceId, lock)
+			slog.Debug("lock released for device", "deviceId", deviceId)
+		})
 	}
-	mutex.Unlock()
-	slog.Debug("lock released for device", "deviceId", deviceId)
+
+	return release, token, nil
 }
 
-func NewVolatileLockService() *VolatileLockService {
-	return &VolatileLockService{
-		deviceMutexes: make(map[string]*sync.Mutex),
+func (lockService *VolatileLockService) dropReference(deviceId string, lock *deviceLock) {
+	lockService.generalMutex.Lock()
+	defer lockService.generalMutex.Unlock()
+
+	lock.references--
+	if lock.references == 0 {
+		delete(lockService.deviceLocks, deviceId)
 	}
 }
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolatileLockService_LockIsExclusiveAndTokensGrow(t *testing.T) {
+	lockService := NewVolatileLockService(time.Second)
+
+	release, firstToken, err := lockService.Lock(context.Background(), "device")
+	assert.NoError(t, err)
+
+	acquired := make(chan uint64)
+	go func() {
+		release, token, _ := lockService.Lock(context.Background(), "device")
+		release()
+		acquired <- token
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("The lock was acquired twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	// releasing twice must not release the lock of the next holder
+	release()
+
+	secondToken := <-acquired
+	assert.Greater(t, secondToken, firstToken)
+}
+
+func TestVolatileLockService_WaitEndsWithTheContext(t *testing.T) {
+	lockService := NewVolatileLockService(20 * time.Millisecond)
+
+	release, _, err := lockService.Lock(context.Background(), "device")
+	assert.NoError(t, err)
+	defer release()
+
+	// default wait timeout
+	_, _, err = lockService.Lock(context.Background(), "device")
+	assert.ErrorIs(t, err, ErrLockTimeout)
+
+	// deadline of the caller
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = lockService.Lock(ctx, "device")
+	assert.ErrorIs(t, err, ErrLockTimeout)
+
+	// cancelled caller
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, _, err = lockService.Lock(ctx, "device")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// other devices are not affected
+	releaseOther, _, err := lockService.Lock(context.Background(), "other")
+	assert.NoError(t, err)
+	releaseOther()
+}
+
+func TestVolatileLockService_UnusedLocksAreRemoved(t *testing.T) {
+	lockService := NewVolatileLockService(time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, _, err := lockService.Lock(context.Background(), "device")
+			if err == nil {
+				release()
+			}
+		}()
+	}
+	wg.Wait()
+
+	release, _, _ := lockService.Lock(context.Background(), "held")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lockService.Lock(ctx, "held")
+
+	assert.Len(t, lockService.deviceLocks, 1)
+	release()
+	assert.Empty(t, lockService.deviceLocks)
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Acquires the lock only if nobody holds it and, in the same step, increases
+// the fencing token of the device.
+var acquireLockScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0`)
+
+// Renewing and releasing only touch the lock if we are still its owner, an
+// expired lease might already belong to someone else.
+var renewLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// RedisLockService locks devices across every replica of the service. Locks are
+// leases with a TTL, so a crashed holder does not block a device forever, and
+// they are renewed in the background while held. As a paused holder can still
+// lose its lease, every acquisition returns a fencing token which the
+// persistence layer checks on save.
+type RedisLockService struct {
+	client        *redis.Client
+	leaseTTL      time.Duration
+	waitTimeout   time.Duration
+	retryInterval time.Duration
+}
+
+func NewRedisLockService(client *redis.Client, leaseTTL time.Duration, waitTimeout time.Duration) *RedisLockService {
+	return &RedisLockService{
+		client:        client,
+		leaseTTL:      leaseTTL,
+		waitTimeout:   waitTimeout,
+		retryInterval: 10 * time.Millisecond,
+	}
+}
+
+func lockKey(deviceId string) string {
+	return "signing-service:lock:" + deviceId
+}
+
+func fencingKey(deviceId string) string {
+	return "signing-service:fence:" + deviceId
+}
+
+// Waits for the lock of the device until the context is done or, without a
+// deadline, up to the wait timeout.
+func (lockService *RedisLockService) Lock(ctx context.Context, deviceId string) (func(), uint64, error) {
+	waitCtx, cancel := withWaitTimeout(ctx, lockService.waitTimeout)
+	defer cancel()
+
+	owner := uuid.NewString()
+	retryInterval := lockService.retryInterval
+
+	for {
+		token, err := acquireLockScript.Run(
+			waitCtx,
+			lockService.client,
+			[]string{lockKey(deviceId), fencingKey(deviceId)},
+			owner, lockService.leaseTTL.Milliseconds(),
+		).Uint64()
+		if err != nil {
+			if waitCtx.Err() != nil {
+				return nil, 0, lockWaitError(waitCtx)
+			}
+			return nil, 0, err
+		}
+
+		if token > 0 {
+			stopRenewal := make(chan struct{})
+			go lockService.renew(ctx, deviceId, owner, stopRenewal)
+
+			slog.Debug("lock acquired for device", "deviceId", deviceId, "token", token)
+
+			var once sync.Once
+			release := func() {
+				once.Do(func() {
+					close(stopRenewal)
+					lockService.release(deviceId, owner)
+				})
+			}
+			return release, token, nil
+		}
+
+		select {
+		case <-waitCtx.Done():
+			return nil, 0, lockWaitError(waitCtx)
+		case <-time.After(retryInterval):
+		}
+		retryInterval = min(retryInterval*2, 200*time.Millisecond)
+	}
+}
+
+// Extends the lease every third of its TTL until the lock is released. If the
+// context of the holder is done the renewal stops as well, so a stuck request
+// only blocks the device for one more lease.
+func (lockService *RedisLockService) renew(ctx context.Context, deviceId string, owner string, stopRenewal chan struct{}) {
+	ticker := time.NewTicker(lockService.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopRenewal:
+			return
+		case <-ctx.Done():
+			slog.Warn("stopped renewing the lock lease of device", "deviceId", deviceId, "error", ctx.Err())
+			return
+		case <-ticker.C:
+			renewed, err := renewLockScript.Run(
+				context.Background(),
+				lockService.client,
+				[]string{lockKey(deviceId)},
+				owner, lockService.leaseTTL.Milliseconds(),
+			).Int()
+
+			if err != nil || renewed == 0 {
+				// Saves will be rejected by the fencing token from now on.
+				slog.Warn("lost the lock lease of device", "deviceId", deviceId, "error", err)
+				return
+			}
+		}
+	}
+}
+
+func (lockService *RedisLockService) release(deviceId string, owner string) {
+	// The request context may be done already, we still want to release.
+	err := releaseLockScript.Run(context.Background(), lockService.client, []string{lockKey(deviceId)}, owner).Err()
+	if err != nil {
+		// the lease will expire by itself
+		slog.Warn("could not release the lock of device", "deviceId", deviceId, "error", err.Error())
+		return
+	}
+
+	slog.Debug("lock released for device", "deviceId", deviceId)
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisLockService(t *testing.T, waitTimeout time.Duration) (*RedisLockService, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return NewRedisLockService(client, time.Minute, waitTimeout), server
+}
+
+func TestRedisLockService_LockIsExclusiveAndTokensGrow(t *testing.T) {
+	lockService, _ := newTestRedisLockService(t, time.Second)
+
+	release, firstToken, err := lockService.Lock(context.Background(), "device")
+	assert.NoError(t, err)
+
+	acquired := make(chan uint64)
+	go func() {
+		release, token, _ := lockService.Lock(context.Background(), "device")
+		release()
+		acquired <- token
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("The lock was acquired twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release()
+
+	secondToken := <-acquired
+	assert.Greater(t, secondToken, firstToken)
+}
+
+func TestRedisLockService_LockTimesOut(t *testing.T) {
+	lockService, _ := newTestRedisLockService(t, 50*time.Millisecond)
+
+	_, _, err := lockService.Lock(context.Background(), "device")
+	assert.NoError(t, err)
+
+	other := NewRedisLockService(lockService.client, time.Minute, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = other.Lock(ctx, "device")
+	assert.ErrorIs(t, err, ErrLockTimeout)
+
+	// default wait timeout
+	_, _, err = lockService.Lock(context.Background(), "device")
+	assert.ErrorIs(t, err, ErrLockTimeout)
+}
+
+func TestRedisLockService_ExpiredHolderCannotOverwriteNewerState(t *testing.T) {
+	lockService, server := newTestRedisLockService(t, time.Second)
+	devices := persistence.NewVolatileDeviceRepository()
+	devices.Save(context.Background(), &domain.Device{UUID: "device"})
+
+	releasePaused, pausedToken, err := lockService.Lock(context.Background(), "device")
+	assert.NoError(t, err)
+	pausedDevice, _ := devices.FindByUUID(context.Background(), persistence.AllTenants, "device")
+
+	// the holder pauses for longer than its lease
+	server.FastForward(2 * time.Minute)
+
+	// another replica takes the lock and signs
+	replica := NewRedisLockService(lockService.client, time.Minute, time.Second)
+	release, newToken, err := replica.Lock(context.Background(), "device")
+	assert.NoError(t, err)
+
+	newDevice, _ := devices.FindByUUID(context.Background(), persistence.AllTenants, "device")
+	newDevice.SignatureCounter = 1
+	newDevice.FencingToken = newToken
+	_, err = devices.Save(context.Background(), newDevice)
+	assert.NoError(t, err)
+	release()
+
+	// the paused holder wakes up and tries to save its stale state
+	pausedDevice.SignatureCounter = 1
+	pausedDevice.FencingToken = pausedToken
+	_, err = devices.Save(context.Background(), pausedDevice)
+	assert.ErrorIs(t, err, persistence.ErrStaleFencingToken)
+
+	// releasing an expired lease does not release the lock of someone else
+	_, _, err = replica.Lock(context.Background(), "device")
+	assert.NoError(t, err)
+	releasePaused()
+	assert.True(t, server.Exists(lockKey("device")))
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// Signatures moved to the archive per segment.
+const retentionBatchSize = 10000
+
+// The retention service moves signatures older than the hot retention period
+// from the hot storage to the archive. A batch is first written to the archive
+// and only then evicted, so a signature is always reachable from one of them.
+// When the eviction fails the batch is archived again on the next run, which
+// the archive skips as it already has it.
+type RetentionService struct {
+	hot          persistence.EvictableSignaturePersistance
+	archive      persistence.SignatureArchive
+	hotRetention time.Duration
+	clock        Clock
+}
+
+func NewRetentionService(
+	hot persistence.EvictableSignaturePersistance,
+	archive persistence.SignatureArchive,
+	hotRetention time.Duration,
+) *RetentionService {
+	return &RetentionService{
+		hot:          hot,
+		archive:      archive,
+		hotRetention: hotRetention,
+		clock:        NewMonotonicClock(),
+	}
+}
+
+// Archives every signature older than the retention period and returns how
+// many signatures were moved.
+func (retentionService *RetentionService) ArchiveExpired(ctx context.Context) (int, error) {
+	cutoff := retentionService.clock.Now().Add(-retentionService.hotRetention)
+	archived := 0
+
+	for {
+		batch, err := retentionService.hot.OldestCreatedBefore(ctx, cutoff, retentionBatchSize)
+		if err != nil || len(batch) == 0 {
+			return archived, err
+		}
+
+		if err := retentionService.archive.Append(ctx, batch); err != nil {
+			return archived, err
+		}
+
+		if err := retentionService.hot.Evict(ctx, batch); err != nil {
+			return archived, err
+		}
+
+		archived += len(batch)
+	}
+}
+
+// Run archives expired signatures every interval until the context is done.
+func (retentionService *RetentionService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		archived, err := retentionService.ArchiveExpired(ctx)
+		if err != nil {
+			slog.Error("could not archive signatures", "error", err.Error())
+		} else if archived > 0 {
+			slog.Info("signatures archived", "count", archived)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
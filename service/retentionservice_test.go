@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionService_ArchivedSignaturesAreStillReachable(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-60 * 24 * time.Hour)
+
+	hot := persistence.NewVolatileSignatureRepository()
+	archive, err := persistence.NewFileSignatureArchive(t.TempDir())
+	assert.NoError(t, err)
+
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, &FakeClock{times: []time.Time{old, old.Add(time.Hour), now, now.Add(time.Minute)}})
+	signatureService.signaturePersistence = persistence.NewSignatureTieredRepository(hot, archive)
+
+	oldSignature, _ := signatureService.Sign(context.Background(), device.UUID, "old", nil)
+	signatureService.Sign(context.Background(), device.UUID, "old too", nil)
+	signatureService.Sign(context.Background(), device.UUID, "recent", nil)
+
+	retentionService := NewRetentionService(hot, archive, 30*24*time.Hour)
+	retentionService.clock = &FakeClock{times: []time.Time{now}}
+
+	archived, err := retentionService.ArchiveExpired(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, archived)
+
+	inHot, _ := hot.FindByUUID(context.Background(), persistence.AllTenants, oldSignature.UUID)
+	assert.Nil(t, inHot)
+
+	retrieved, err := signatureService.Get(context.Background(), oldSignature.UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, oldSignature.Signature, retrieved.Signature)
+
+	chain, err := signatureService.ListByDevice(context.Background(), device.UUID, persistence.SignatureQuery{}, persistence.PageRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, chain.Items, 3)
+	for i, signature := range chain.Items[1:] {
+		assert.Equal(t, chain.Items[i].Signature, signature.PreviousSignature)
+	}
+
+	// signing after archiving continues the chain
+	next, err := signatureService.Sign(context.Background(), device.UUID, "after archiving", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, next.Counter)
+}
+
+// Hot storage whose evictions fail until told otherwise.
+type failingEvictions struct {
+	*persistence.SignatureVolatileRepository
+	fail bool
+}
+
+func (hot *failingEvictions) Evict(ctx context.Context, signatures []domain.Signature) error {
+	if hot.fail {
+		return errors.New("storage unavailable")
+	}
+
+	return hot.SignatureVolatileRepository.Evict(ctx, signatures)
+}
+
+func TestRetentionService_RetriesAFailedEvictionWithoutArchivingTwice(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-60 * 24 * time.Hour)
+
+	hot := &failingEvictions{SignatureVolatileRepository: persistence.NewVolatileSignatureRepository(), fail: true}
+	archive, err := persistence.NewFileSignatureArchive(t.TempDir())
+	require.NoError(t, err)
+
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, &FakeClock{times: []time.Time{old, old.Add(time.Hour), now}})
+	signatureService.signaturePersistence = persistence.NewSignatureTieredRepository(hot, archive)
+
+	for _, data := range []string{"old", "old too", "recent"} {
+		_, err := signatureService.Sign(context.Background(), device.UUID, data, nil)
+		require.NoError(t, err)
+	}
+
+	retentionService := NewRetentionService(hot, archive, 30*24*time.Hour)
+	retentionService.clock = &FakeClock{times: []time.Time{now, now}}
+
+	_, err = retentionService.ArchiveExpired(context.Background())
+	assert.Error(t, err)
+
+	// the next run gets the same batch from the hot storage
+	hot.fail = false
+	archived, err := retentionService.ArchiveExpired(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, archived)
+
+	count, err := archive.CountByDevice(context.Background(), persistence.SignatureQuery{DeviceUUID: device.UUID})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	chain, err := signatureService.ListByDevice(context.Background(), device.UUID, persistence.SignatureQuery{}, persistence.PageRequest{IncludeTotal: true})
+	require.NoError(t, err)
+	require.Len(t, chain.Items, 3)
+	for i, signature := range chain.Items {
+		assert.Equal(t, i+1, signature.Counter)
+	}
+	assert.Equal(t, 3, *chain.Total)
+}
@@ -1,45 +1,143 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
 	"github.com/chuckiihub/signing-service/crypto"
 	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
 	"github.com/chuckiihub/signing-service/persistence"
 )
 
 type SignatureService interface {
-	Sign(deviceId string, dataToBeSigned string) (*domain.Signature, error)
-	Verify(deviceId string, dataToBeSigned string, signature string) (bool, error)
-	Get(uuid string) (*domain.Signature, error)
-	List(page int) ([]domain.Signature, error)
-	CheckHealth() domain.ServiceHealth
+	Sign(ctx context.Context, deviceId string, dataToBeSigned string, metadata map[string]string) (*domain.Signature, error)
+	Verify(ctx context.Context, deviceId string, dataToBeSigned string, signature string) (bool, error)
+	Get(ctx context.Context, uuid string) (*domain.Signature, error)
+	List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.Signature], error)
+	ListByDevice(ctx context.Context, deviceId string, query persistence.SignatureQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.Signature], error)
+	// Closes the chain of the device with a last signature, the device cannot sign anymore.
+	Decommission(ctx context.Context, deviceId string, reason string) (*domain.Device, *domain.Signature, error)
+	// Walks the whole chain of the device re-verifying every signature and link.
+	Audit(ctx context.Context, deviceId string) (*domain.ChainAudit, error)
+	// Writes the public key and the chain of the device, to be verified offline.
+	ExportChain(ctx context.Context, deviceId string, w io.Writer) error
+	CheckHealth(ctx context.Context) domain.ServiceHealth
 }
 
 type DeviceService interface {
-	Create(algorithm crypto.SignatureAlgorithm, label string) (*domain.Device, error)
-	Get(uuid string) (*domain.Device, error)
-	List(page int) ([]domain.Device, error)
-	CheckHealth() domain.ServiceHealth
+	Create(ctx context.Context, algorithm crypto.SignatureAlgorithm, label string) (*domain.Device, error)
+	Get(ctx context.Context, uuid string) (*domain.Device, error)
+	List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.Device], error)
+	Search(ctx context.Context, query persistence.DeviceQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.Device], error)
+	// Suspends or reactivates a device. Decommissioning is done by the
+	// SignatureService as it writes to the chain of the device.
+	ChangeState(ctx context.Context, uuid string, state domain.DeviceState, reason string) (*domain.Device, error)
+	// Changes the label or tags of the device if its revision still is the expected one.
+	Update(ctx context.Context, uuid string, changes DeviceChanges, expectedRevision uint64) (*domain.Device, error)
+	CheckHealth(ctx context.Context) domain.ServiceHealth
 }
 
 func NewSignatureService(
 	dDB persistence.DevicePersistance,
 	sDB persistence.SignaturePersistance,
 	l LockService,
-	pageSize int) SignatureService {
+	pageLimits PageLimits,
+	events EventPublisher) SignatureService {
+	return &SignatureServiceImplementation{
+		devicePersistence:    dDB,
+		signaturePersistence: sDB,
+		devices:              newLockingDeviceWriter(dDB, l),
+		pageLimits:           pageLimits,
+		clock:                NewMonotonicClock(),
+		events:               events,
+	}
+}
+
+// Signs without a lock service, relying on the compare-and-swap of the device
+// persistence instead. Conflicting requests are retried following the policy.
+func NewOptimisticSignatureService(
+	dDB persistence.VersionedDevicePersistance,
+	sDB persistence.SignaturePersistance,
+	pageLimits PageLimits,
+	retryPolicy RetryPolicy,
+	events EventPublisher) SignatureService {
 	return &SignatureServiceImplementation{
 		devicePersistence:    dDB,
 		signaturePersistence: sDB,
-		lockService:          l,
-		pageSize:             pageSize,
+		devices:              newOptimisticDeviceWriter(dDB, retryPolicy),
+		pageLimits:           pageLimits,
+		clock:                NewMonotonicClock(),
+		events:               events,
 	}
 }
 
 func NewDeviceService(
 	persistence persistence.DevicePersistance,
-	pageSize int,
+	l LockService,
+	pageLimits PageLimits,
+	events EventPublisher,
+) DeviceService {
+	return &DeviceServiceImplementation{
+		persistence: persistence,
+		devices:     newLockingDeviceWriter(persistence, l),
+		pageLimits:  pageLimits,
+		events:      events,
+	}
+}
+
+// Device service to use along with NewOptimisticSignatureService.
+func NewOptimisticDeviceService(
+	persistence persistence.VersionedDevicePersistance,
+	pageLimits PageLimits,
+	retryPolicy RetryPolicy,
+	events EventPublisher,
 ) DeviceService {
 	return &DeviceServiceImplementation{
 		persistence: persistence,
-		pageSize:    pageSize,
+		devices:     newOptimisticDeviceWriter(persistence, retryPolicy),
+		pageLimits:  pageLimits,
+		events:      events,
 	}
 }
+
+// RetryPolicy bounds how many times a change is retried after losing a
+// compare-and-swap. The wait between attempts is random, up to a backoff which
+// doubles every attempt until MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// PageLimits caps the page size clients can ask for when listing.
+type PageLimits struct {
+	Default int
+	Max     int
+}
+
+// Fills the limit with the default one when the client did not choose it and
+// caps it to the maximum allowed.
+func (limits PageLimits) apply(pageRequest persistence.PageRequest) persistence.PageRequest {
+	if pageRequest.Limit < 1 {
+		pageRequest.Limit = limits.Default
+	}
+
+	if pageRequest.Limit > limits.Max {
+		pageRequest.Limit = limits.Max
+	}
+
+	return pageRequest
+}
+
+// Repositories answer with ErrInvalidCursor when the client sends a cursor
+// they did not create, which is a client error.
+func wrapListError(err error) error {
+	if errors.Is(err, persistence.ErrInvalidCursor) {
+		return apperrors.WrapError(err, apperrors.BadRequest)
+	}
+
+	return apperrors.WrapError(err, apperrors.InternalError)
+}
@@ -1,14 +1,18 @@
 package service
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"log/slog"
+	"time"
 
+	"github.com/chuckiihub/signing-service/chain"
 	"github.com/chuckiihub/signing-service/crypto"
 	"github.com/chuckiihub/signing-service/domain"
 	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
 	"github.com/chuckiihub/signing-service/persistence"
 	"github.com/google/uuid"
 )
@@ -16,96 +20,214 @@ import (
 type SignatureServiceImplementation struct {
 	devicePersistence    persistence.DevicePersistance
 	signaturePersistence persistence.SignaturePersistance
-	lockService          LockService
-	pageSize             int
+	devices              deviceWriter
+	pageLimits           PageLimits
+	clock                Clock
+	events               EventPublisher
 }
 
-// Handy method to fetch a device and check errors.
-func (signingService *SignatureServiceImplementation) fetchDeviceOrReturnNotFound(deviceId string) (*domain.Device, error) {
-	device, err := signingService.devicePersistence.FindByUUID(deviceId)
-	if err != nil {
-		return nil, apperrors.WrapError(err, apperrors.InternalError)
-	}
+// Returned when signing with a device that is not active.
+var ErrDeviceNotActive = errors.New("device is not active")
 
-	if device == nil {
-		return nil, apperrors.WrapError(errors.New("device not found"), apperrors.NotFound)
-	}
+// Data signed by the closing signature of a decommissioned device.
+const decommissionSignedData = "DEVICE_DECOMMISSIONED"
 
-	return device, nil
+// Handy method to fetch a device and check errors.
+func (signingService *SignatureServiceImplementation) fetchDeviceOrReturnNotFound(ctx context.Context, deviceId string) (*domain.Device, error) {
+	return signingService.devices.fetch(ctx, deviceId)
 }
 
-func (signingService *SignatureServiceImplementation) Sign(deviceId string, dataToBeSigned string) (*domain.Signature, error) {
+// Signs the data with the device key, chaining it with the last signature of the device.
+// The metadata is optional and stored as is next to the signature.
+func (signingService *SignatureServiceImplementation) Sign(ctx context.Context, deviceId string, dataToBeSigned string, metadata map[string]string) (*domain.Signature, error) {
 	if deviceId == "" {
 		return nil, apperrors.WrapError(errors.New("deviceId is required"), apperrors.BadRequest)
 	}
-	newSignatureUUID := uuid.NewString()
 
 	// I check before the lock so we don't use the locking service in vain
 	// in case of, for example, a DoS attack with non existing deviceIds.
-	_, err := signingService.fetchDeviceOrReturnNotFound(deviceId)
+	device, err := signingService.fetchDeviceOrReturnNotFound(ctx, deviceId)
 	if err != nil {
-		return nil, apperrors.WrapError(err, apperrors.InternalError)
+		return nil, err
 	}
 
-	signingService.lockService.Lock(deviceId)
-	defer signingService.lockService.Unlock(deviceId)
+	if err := checkCanSign(device); err != nil {
+		return nil, err
+	}
 
-	// after obtaining the lock, I need to refetch the device to ensure it wasn't changed meanwhile.
-	device, err := signingService.fetchDeviceOrReturnNotFound(deviceId)
+	signature, err := signingService.signWithDevice(ctx, deviceId, func(device *domain.Device) (*domain.Signature, error) {
+		// the state could have changed while waiting for the lock
+		if err := checkCanSign(device); err != nil {
+			return nil, err
+		}
+
+		return signingService.chainSignature(ctx, device, dataToBeSigned, metadata)
+	})
 	if err != nil {
-		return nil, apperrors.WrapError(err, apperrors.InternalError)
+		return nil, err
+	}
+
+	signingService.publish(domain.NewSignatureEvent(*signature))
+	return signature, nil
+}
+
+// Services built without a publisher don't announce anything.
+func (signingService *SignatureServiceImplementation) publish(event domain.Event) {
+	if signingService.events != nil {
+		signingService.events.Publish(event)
+	}
+}
+
+func checkCanSign(device *domain.Device) error {
+	if state := device.CurrentState(); state != domain.DeviceStateActive {
+		return apperrors.WrapCodedError(fmt.Errorf("%w: the device is %s", ErrDeviceNotActive, state), apperrors.Conflict, apperrors.CodeDeviceNotActive)
+	}
+
+	return nil
+}
+
+// Decommissioning closes the chain of the device: a last signature, recording
+// who closed it and why, is saved in the same write that moves the device to
+// the decommissioned state, so no signature can ever follow it.
+func (signingService *SignatureServiceImplementation) Decommission(ctx context.Context, deviceId string, reason string) (*domain.Device, *domain.Signature, error) {
+	actor := identity.FromContext(ctx).Name
+
+	var decommissioned domain.Device
+	signature, err := signingService.signWithDevice(ctx, deviceId, func(device *domain.Device) (*domain.Signature, error) {
+		if device.CurrentState() == domain.DeviceStateDecommissioned {
+			return nil, apperrors.WrapCodedError(errors.New("the device is already decommissioned"), apperrors.Conflict, apperrors.CodeDeviceDecommissioned)
+		}
+
+		metadata := map[string]string{
+			"lifecycle": string(domain.DeviceStateDecommissioned),
+			"actor":     actor,
+		}
+		if reason != "" {
+			metadata["reason"] = reason
+		}
+
+		signature, err := signingService.chainSignature(ctx, device, decommissionSignedData, metadata)
+		if err != nil {
+			return nil, err
+		}
+
+		device.Revision++
+		device.State = domain.DeviceStateDecommissioned
+		device.StateReason = reason
+		device.StateChangedAt = signature.CreatedAt
+		device.StateChangedBy = actor
+		decommissioned = *device
+
+		return signature, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slog.Info("device decommissioned", "deviceId", deviceId, "actor", actor)
+	signingService.publish(domain.NewSignatureEvent(*signature))
+	signingService.publish(domain.NewDeviceEvent(domain.EventDeviceStateChanged, decommissioned, decommissioned.StateChangedAt))
+	return &decommissioned, signature, nil
+}
+
+// Moves the device to the next signature built by chain and saves that
+// signature while the device is still held.
+func (signingService *SignatureServiceImplementation) signWithDevice(ctx context.Context, deviceId string, chain func(device *domain.Device) (*domain.Signature, error)) (*domain.Signature, error) {
+	var signature *domain.Signature
+	device, original, release, err := signingService.devices.modify(ctx, deviceId, func(device *domain.Device) error {
+		var err error
+		signature, err = chain(device)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	originalLastSignature := device.LastSignature
-	originalSignatureCounter := device.SignatureCounter
+	defer release()
+
+	return signingService.saveSignature(ctx, device, original, signature)
+}
+
+// Builds the next signature of the chain and moves the device to it (counter,
+// last signature and timestamp). Nothing is saved here.
+func (signingService *SignatureServiceImplementation) chainSignature(ctx context.Context, device *domain.Device, dataToBeSigned string, metadata map[string]string) (*domain.Signature, error) {
+	previousSignature := device.LastSignature
 
 	// no need to increment using atomic package as said in the requirements as it's protected by the lock
+	// (or by the compare-and-swap when signing optimistically)
 	device.SignatureCounter++
 
 	dataToBeSigned = signingService.preSignEncoding(*device, dataToBeSigned)
-	signature, err := signingService.signData(device, dataToBeSigned)
+	signature, err := signingService.signData(ctx, device, dataToBeSigned)
 	if err != nil {
 		slog.Warn("error while signing data", "error", err.Error())
 		return nil, err
 	}
 
 	signatureDTO := &domain.Signature{
-		UUID:       newSignatureUUID,
-		DeviceUUID: device.UUID,
-		SignedData: dataToBeSigned,
-		Signature:  base64.StdEncoding.EncodeToString(signature),
+		UUID:              uuid.NewString(),
+		DeviceUUID:        device.UUID,
+		TenantID:          device.TenantID,
+		Counter:           device.SignatureCounter,
+		SignedData:        dataToBeSigned,
+		Signature:         base64.StdEncoding.EncodeToString(signature),
+		PreviousSignature: previousSignature,
+		Algorithm:         device.Algorithm,
+		KeyVersion:        device.KeyVersion,
+		CreatedAt:         signingService.nextTimestamp(device),
+		Metadata:          metadata,
 	}
+	signatureDTO.IdempotencyKey, signatureDTO.IdempotencyFingerprint = idempotencyOf(ctx)
 
 	device.LastSignature = signatureDTO.Signature
-	if _, err = signingService.devicePersistence.Save(device); err != nil {
-		// If saving the device fails, we discard the newly created signature.
-		return nil, apperrors.WrapError(err, apperrors.InternalError)
-	}
+	device.LastSignedAt = signatureDTO.CreatedAt
 
-	signatureDTO, err = signingService.signaturePersistence.Save(signatureDTO)
+	return signatureDTO, nil
+}
+
+// Saves the signature once the device points to it.
+func (signingService *SignatureServiceImplementation) saveSignature(ctx context.Context, device *domain.Device, original domain.Device, signatureDTO *domain.Signature) (*domain.Signature, error) {
+	// The device already points to this signature, a client going away now
+	// must not leave it half saved.
+	ctx = context.WithoutCancel(ctx)
+
+	signatureDTO, err := signingService.signaturePersistence.Save(ctx, signatureDTO)
 	if err != nil {
+		slog.Warn("error saving signature, trying to rollback device last signature and signatureCounter", "error", err.Error())
+
 		// If saving the signature fails, we rollbacks the device to its previous state.
-		device.SignatureCounter = originalSignatureCounter
-		device.LastSignature = originalLastSignature
-		signingService.devicePersistence.Save(device)
+		if err := signingService.devices.revert(ctx, device, original); err != nil {
+			slog.Error("could not rollback device after failing to save its signature", "deviceId", device.UUID, "error", err.Error())
+		}
 
-		slog.Warn("error saving signature, trying to rollback device last signature and signatureCounter", "error", err.Error())
 		return nil, apperrors.WrapError(err, apperrors.InternalError)
 	}
 
 	return signatureDTO, nil
 }
 
+// Signatures of a device must never go back in time, not even after a restart
+// on a node with a clock behind the previous one, so the timestamp is at least
+// one tick after the last signature of the device.
+func (signingService *SignatureServiceImplementation) nextTimestamp(device *domain.Device) time.Time {
+	now := signingService.clock.Now()
+	if !now.After(device.LastSignedAt) {
+		return device.LastSignedAt.Add(time.Nanosecond)
+	}
+
+	return now
+}
+
 // This method should be called ALWAYS locking the device for writing using the
-// LockingService. This protects the field SignatureCounter and LastSignature
+// LockingService (or a compare-and-swap of the device version). This protects the field SignatureCounter and LastSignature
 // while signing requests.
-func (signingService *SignatureServiceImplementation) signData(device *domain.Device, dataToBeSigned string) ([]byte, error) {
+func (signingService *SignatureServiceImplementation) signData(ctx context.Context, device *domain.Device, dataToBeSigned string) ([]byte, error) {
 
 	crypto, err := crypto.NewCrypto(device.Algorithm)
 	if err != nil {
 		return nil, apperrors.WrapError(err, apperrors.InternalError)
 	}
 
-	signature, err := crypto.Sign([]byte(dataToBeSigned), []byte(device.PrivateKey))
+	signature, err := crypto.Sign(ctx, []byte(dataToBeSigned), []byte(device.PrivateKey))
 	if err != nil {
 		slog.Warn("error while trying to sign Signer", "error", err.Error())
 		return nil, apperrors.WrapError(err, apperrors.InternalError)
@@ -116,18 +238,18 @@ func (signingService *SignatureServiceImplementation) signData(device *domain.De
 
 // This method is used to concatenate the signatureCounter and the lastSignature to the data to be signed
 // to create a unique signature for each device.
-// If necessary, in the future, it could be moved to an Encoding package so support for other encodings is added.
+// The encoding itself lives in the chain package, shared with the offline verifier.
 func (signingService *SignatureServiceImplementation) preSignEncoding(device domain.Device, data string) string {
 	lastSignature := device.LastSignature
 	if device.LastSignature == "" {
 		lastSignature = device.UUID
 	}
 
-	return fmt.Sprintf("%d_%s_%s", device.SignatureCounter, data, base64.StdEncoding.EncodeToString([]byte(lastSignature)))
+	return chain.Encode(device.SignatureCounter, data, lastSignature)
 }
 
-func (signingService *SignatureServiceImplementation) Get(uuid string) (*domain.Signature, error) {
-	signatureObject, err := signingService.signaturePersistence.FindByUUID(uuid)
+func (signingService *SignatureServiceImplementation) Get(ctx context.Context, uuid string) (*domain.Signature, error) {
+	signatureObject, err := signingService.signaturePersistence.FindByUUID(ctx, tenantOf(ctx), uuid)
 	if err != nil {
 		return nil, apperrors.WrapError(err, apperrors.InternalError)
 	}
@@ -135,25 +257,44 @@ func (signingService *SignatureServiceImplementation) Get(uuid string) (*domain.
 	return signatureObject, nil
 }
 
-func (signingService *SignatureServiceImplementation) List(page int) ([]domain.Signature, error) {
-	if page < 1 {
-		page = 1
+func (signingService *SignatureServiceImplementation) List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.Signature], error) {
+	signatures, err := signingService.signaturePersistence.List(ctx, tenantOf(ctx), signingService.pageLimits.apply(pageRequest))
+	if err != nil {
+		return persistence.Page[domain.Signature]{}, wrapListError(err)
+	}
+
+	return signatures, nil
+}
+
+// Lists the signatures of a single device using the device index of the
+// persistence layer, so there is no need to go through every signature.
+func (signingService *SignatureServiceImplementation) ListByDevice(ctx context.Context, deviceId string, query persistence.SignatureQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.Signature], error) {
+	if _, err := signingService.fetchDeviceOrReturnNotFound(ctx, deviceId); err != nil {
+		return persistence.Page[domain.Signature]{}, err
 	}
 
-	signatures, err := signingService.signaturePersistence.List(page, signingService.pageSize)
+	if query.CounterFrom > 0 && query.CounterTo > 0 && query.CounterFrom > query.CounterTo {
+		return persistence.Page[domain.Signature]{}, apperrors.WrapError(errors.New("counterFrom cannot be greater than counterTo"), apperrors.BadRequest)
+	}
 
+	if !query.CreatedFrom.IsZero() && !query.CreatedTo.IsZero() && query.CreatedFrom.After(query.CreatedTo) {
+		return persistence.Page[domain.Signature]{}, apperrors.WrapError(errors.New("from cannot be after to"), apperrors.BadRequest)
+	}
+
+	query.DeviceUUID = deviceId
+	signatures, err := signingService.signaturePersistence.FindByDevice(ctx, query, signingService.pageLimits.apply(pageRequest))
 	if err != nil {
-		return nil, apperrors.WrapError(err, apperrors.InternalError)
+		return persistence.Page[domain.Signature]{}, wrapListError(err)
 	}
 
 	return signatures, nil
 }
 
 // Check the health of the dependencies of this service.
-func (signingService *SignatureServiceImplementation) CheckHealth() domain.ServiceHealth {
+func (signingService *SignatureServiceImplementation) CheckHealth(ctx context.Context) domain.ServiceHealth {
 	health := domain.ServiceHealth{Status: domain.HealthStatusPass, PersistenceLayer: map[string]domain.PersistenceHealth{}}
-	deviceDbHealth := signingService.devicePersistence.CheckHealth()
-	signatureDbHealth := signingService.signaturePersistence.CheckHealth()
+	deviceDbHealth := signingService.devicePersistence.CheckHealth(ctx)
+	signatureDbHealth := signingService.signaturePersistence.CheckHealth(ctx)
 
 	health.PersistenceLayer["device"] = deviceDbHealth
 	health.PersistenceLayer["signature"] = signatureDbHealth
@@ -168,8 +309,8 @@ func (signingService *SignatureServiceImplementation) CheckHealth() domain.Servi
 // This method will receive the full signedData and signature and return a boolean
 // indicating if the signature is valid for the given dataToBeSigned.
 // If there's any error returned the signature is not valid.
-func (signingService *SignatureServiceImplementation) Verify(deviceId string, dataToBeSigned string, signature string) (bool, error) {
-	device, err := signingService.fetchDeviceOrReturnNotFound(deviceId)
+func (signingService *SignatureServiceImplementation) Verify(ctx context.Context, deviceId string, dataToBeSigned string, signature string) (bool, error) {
+	device, err := signingService.fetchDeviceOrReturnNotFound(ctx, deviceId)
 	if err != nil {
 		return false, err
 	}
@@ -185,5 +326,5 @@ func (signingService *SignatureServiceImplementation) Verify(deviceId string, da
 	}
 
 	// Verify using the decoded signature
-	return crypto.Verify([]byte(dataToBeSigned), decodedSignature, device.PrivateKey)
+	return crypto.Verify(ctx, []byte(dataToBeSigned), decodedSignature, device.PrivateKey)
 }
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+// Clock that returns the times it is given, in order.
+type FakeClock struct {
+	times []time.Time
+}
+
+func (clock *FakeClock) Now() time.Time {
+	now := clock.times[0]
+	clock.times = clock.times[1:]
+	return now
+}
+
+func newTestSignatureService(t *testing.T, algorithm crypto.SignatureAlgorithm, clock Clock) (*SignatureServiceImplementation, *domain.Device) {
+	devicePersistence := persistence.NewVolatileDeviceRepository()
+	lockService := NewVolatileLockService(time.Second)
+	deviceService := NewDeviceService(devicePersistence, lockService, PageLimits{Default: 10, Max: 10}, nil)
+
+	device, err := deviceService.Create(context.Background(), algorithm, "test-device")
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+
+	signatureService := &SignatureServiceImplementation{
+		devicePersistence:    devicePersistence,
+		signaturePersistence: persistence.NewVolatileSignatureRepository(),
+		devices:              newLockingDeviceWriter(devicePersistence, lockService),
+		pageLimits:           PageLimits{Default: 10, Max: 10},
+		clock:                clock,
+	}
+
+	return signatureService, device
+}
+
+func TestSignatureService_SignStoresMetadata(t *testing.T) {
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	metadata := map[string]string{"transactionId": "tx-1", "registerId": "register-42"}
+
+	first, err := signatureService.Sign(context.Background(), device.UUID, "first", nil)
+	assert.NoError(t, err)
+
+	second, err := signatureService.Sign(context.Background(), device.UUID, "second", metadata)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, first.Counter)
+	assert.Equal(t, device.LastSignature, first.PreviousSignature)
+	assert.Equal(t, 2, second.Counter)
+	assert.Equal(t, first.Signature, second.PreviousSignature)
+	assert.Equal(t, crypto.SignatureAlgorithmECC, second.Algorithm)
+	assert.Equal(t, 1, second.KeyVersion)
+
+	stored, err := signatureService.Get(context.Background(), second.UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, metadata, stored.Metadata)
+	assert.Equal(t, second.CreatedAt, stored.CreatedAt)
+}
+
+func TestSignatureService_SignTimestampsNeverGoBackwards(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// the second reading is an hour behind, as after a wall clock adjustment
+	clock := &FakeClock{times: []time.Time{now, now.Add(-time.Hour)}}
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, clock)
+
+	first, err := signatureService.Sign(context.Background(), device.UUID, "first", nil)
+	assert.NoError(t, err)
+
+	second, err := signatureService.Sign(context.Background(), device.UUID, "second", nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, now, first.CreatedAt)
+	assert.True(t, second.CreatedAt.After(first.CreatedAt))
+}
+
+func TestSignatureService_OptimisticSigningHasNoGapsNorDuplicates(t *testing.T) {
+	lockedService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	signatureService := NewOptimisticSignatureService(
+		lockedService.devicePersistence.(*persistence.VolatileDeviceRepository),
+		lockedService.signaturePersistence,
+		PageLimits{Default: 100, Max: 1000},
+		RetryPolicy{MaxAttempts: 1000, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+		nil,
+	)
+
+	const workers, signaturesPerWorker = 16, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*signaturesPerWorker)
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < signaturesPerWorker; i++ {
+				if _, err := signatureService.Sign(context.Background(), device.UUID, "data", nil); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Not expecting error while signing concurrently: %v", err)
+	}
+
+	page, err := signatureService.ListByDevice(context.Background(), device.UUID, persistence.SignatureQuery{}, persistence.PageRequest{Limit: 1000})
+	assert.NoError(t, err)
+	assert.Len(t, page.Items, workers*signaturesPerWorker)
+
+	previousSignature := device.LastSignature
+	for i, signature := range page.Items {
+		assert.Equal(t, i+1, signature.Counter)
+		assert.Equal(t, previousSignature, signature.PreviousSignature)
+		previousSignature = signature.Signature
+	}
+
+	stored, _ := signatureService.(*SignatureServiceImplementation).devicePersistence.FindByUUID(context.Background(), persistence.AllTenants, device.UUID)
+	assert.Equal(t, workers*signaturesPerWorker, stored.SignatureCounter)
+	assert.Equal(t, previousSignature, stored.LastSignature)
+}
+
+// Device persistence where someone else always wins the compare-and-swap.
+type alwaysConflictingDevices struct {
+	*persistence.VolatileDeviceRepository
+}
+
+func (devices alwaysConflictingDevices) SaveIfVersion(ctx context.Context, device *domain.Device, expectedVersion uint64) (*domain.Device, error) {
+	return nil, persistence.ErrVersionConflict
+}
+
+func TestSignatureService_OptimisticSigningGivesUpAfterMaxAttempts(t *testing.T) {
+	lockedService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	signatureService := NewOptimisticSignatureService(
+		alwaysConflictingDevices{lockedService.devicePersistence.(*persistence.VolatileDeviceRepository)},
+		lockedService.signaturePersistence,
+		PageLimits{Default: 10, Max: 10},
+		RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		nil,
+	)
+
+	_, err := signatureService.Sign(context.Background(), device.UUID, "data", nil)
+
+	var appErr apperrors.AppError
+	assert.ErrorAs(t, err, &appErr)
+	assert.Equal(t, apperrors.Conflict, appErr.Type)
+}
+
+func TestSignatureService_SignHonorsCancellation(t *testing.T) {
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := signatureService.Sign(ctx, device.UUID, "data", nil)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	stored, _ := signatureService.devicePersistence.FindByUUID(context.Background(), persistence.AllTenants, device.UUID)
+	assert.Equal(t, 0, stored.SignatureCounter)
+}
+
+func TestSignatureService_SuspendedDevicesCannotSignButVerify(t *testing.T) {
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	deviceService := &DeviceServiceImplementation{persistence: signatureService.devicePersistence, devices: signatureService.devices}
+	ctx := identity.NewContext(context.Background(), identity.Identity{Name: "store-manager"})
+
+	signature, err := signatureService.Sign(ctx, device.UUID, "before", nil)
+	assert.NoError(t, err)
+
+	suspended, err := deviceService.ChangeState(ctx, device.UUID, domain.DeviceStateSuspended, "reported stolen")
+	assert.NoError(t, err)
+	assert.Equal(t, domain.DeviceStateSuspended, suspended.State)
+	assert.Equal(t, "store-manager", suspended.StateChangedBy)
+	assert.Equal(t, "reported stolen", suspended.StateReason)
+
+	_, err = signatureService.Sign(ctx, device.UUID, "while suspended", nil)
+	assert.ErrorIs(t, err, ErrDeviceNotActive)
+
+	verified, err := signatureService.Verify(ctx, device.UUID, signature.SignedData, signature.Signature)
+	assert.NoError(t, err)
+	assert.True(t, verified)
+
+	_, err = deviceService.ChangeState(ctx, device.UUID, domain.DeviceStateActive, "found")
+	assert.NoError(t, err)
+
+	next, err := signatureService.Sign(ctx, device.UUID, "after", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, next.Counter)
+}
+
+func TestSignatureService_DecommissionClosesTheChain(t *testing.T) {
+	signatureService, device := newTestSignatureService(t, crypto.SignatureAlgorithmECC, NewMonotonicClock())
+	deviceService := &DeviceServiceImplementation{persistence: signatureService.devicePersistence, devices: signatureService.devices}
+	ctx := identity.NewContext(context.Background(), identity.Identity{Name: "operator"})
+
+	last, err := signatureService.Sign(ctx, device.UUID, "last", nil)
+	assert.NoError(t, err)
+
+	decommissioned, closing, err := signatureService.Decommission(ctx, device.UUID, "retired")
+	assert.NoError(t, err)
+	assert.Equal(t, domain.DeviceStateDecommissioned, decommissioned.State)
+	assert.Equal(t, "operator", decommissioned.StateChangedBy)
+	assert.Equal(t, closing.CreatedAt, decommissioned.StateChangedAt)
+
+	assert.Equal(t, 2, closing.Counter)
+	assert.Equal(t, last.Signature, closing.PreviousSignature)
+	assert.Equal(t, map[string]string{"lifecycle": "decommissioned", "actor": "operator", "reason": "retired"}, closing.Metadata)
+
+	stored, _ := signatureService.devicePersistence.FindByUUID(ctx, persistence.AllTenants, device.UUID)
+	assert.Equal(t, closing.Signature, stored.LastSignature)
+
+	_, err = signatureService.Sign(ctx, device.UUID, "after", nil)
+	assert.ErrorIs(t, err, ErrDeviceNotActive)
+
+	_, _, err = signatureService.Decommission(ctx, device.UUID, "again")
+	var appErr apperrors.AppError
+	assert.ErrorAs(t, err, &appErr)
+	assert.Equal(t, apperrors.Conflict, appErr.Type)
+
+	_, err = deviceService.ChangeState(ctx, device.UUID, domain.DeviceStateActive, "")
+	assert.ErrorAs(t, err, &appErr)
+	assert.Equal(t, apperrors.Conflict, appErr.Type)
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+)
+
+// Registers keep a connection open and sign every few seconds. A signature
+// made right before the connection drops is in the chain, but the register
+// never got it. Sessions keep the signatures until the register acknowledges
+// them, so they can be sent again when it reconnects.
+type SigningSessionService interface {
+	// Opens the session of the caller with the device, or resumes it after a
+	// reconnect. Signatures up to lastAcked are forgotten and the ones after
+	// it are returned, to be sent again.
+	Open(ctx context.Context, deviceId string, lastAcked int) (*SigningSession, []domain.Signature, error)
+}
+
+// Returned when signing in a session with too many signatures the client did
+// not acknowledge yet.
+var ErrTooManyUnacknowledged = errors.New("too many unacknowledged signatures")
+
+// Sessions live in memory: after a restart, or when the client comes back to
+// another replica, there is nothing to resume and the client has to look for
+// its last signatures in the signature listing.
+type SigningSessionServiceImplementation struct {
+	signatureService SignatureService
+	// Most signatures a session keeps unacknowledged before refusing to sign.
+	window int
+	// How long a session without connections is kept.
+	ttl      time.Duration
+	clock    Clock
+	sessions map[signingSessionKey]*SigningSession
+	mutex    sync.Mutex
+}
+
+// Each key has its own session with each device, nobody else can resume it.
+type signingSessionKey struct {
+	tenantID string
+	keyID    string
+	deviceId string
+}
+
+func NewSigningSessionService(signatureService SignatureService, window int, ttl time.Duration) *SigningSessionServiceImplementation {
+	return &SigningSessionServiceImplementation{
+		signatureService: signatureService,
+		window:           window,
+		ttl:              ttl,
+		clock:            NewMonotonicClock(),
+		sessions:         make(map[signingSessionKey]*SigningSession),
+	}
+}
+
+// The sign scope is checked here too, so clients that can't sign are turned
+// away when connecting instead of on every message.
+func (sessions *SigningSessionServiceImplementation) Open(ctx context.Context, deviceId string, lastAcked int) (*SigningSession, []domain.Signature, error) {
+	if deviceId == "" {
+		return nil, nil, apperrors.WrapError(errors.New("deviceId is required"), apperrors.BadRequest)
+	}
+
+	if err := authorizeDevice(ctx, domain.ScopeSign, deviceId); err != nil {
+		return nil, nil, err
+	}
+
+	caller := identity.FromContext(ctx)
+	key := signingSessionKey{tenantID: caller.TenantID, keyID: caller.KeyID, deviceId: deviceId}
+	now := sessions.clock.Now()
+
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	sessions.sweep(now)
+
+	session, found := sessions.sessions[key]
+	if !found {
+		session = &SigningSession{
+			DeviceId:         deviceId,
+			signatureService: sessions.signatureService,
+			window:           sessions.window,
+			clock:            sessions.clock,
+		}
+		sessions.sessions[key] = session
+	}
+
+	session.mutex.Lock()
+	session.connections++
+	session.mutex.Unlock()
+
+	session.Ack(lastAcked)
+
+	return session, session.Unacknowledged(), nil
+}
+
+// Drops the sessions nobody is connected to since longer than the TTL.
+func (sessions *SigningSessionServiceImplementation) sweep(now time.Time) {
+	for key, session := range sessions.sessions {
+		session.mutex.Lock()
+		expired := session.connections == 0 && now.Sub(session.lastSeen) > sessions.ttl
+		session.mutex.Unlock()
+
+		if expired {
+			delete(sessions.sessions, key)
+		}
+	}
+}
+
+// SigningSession signs with one device for one key, over any number of
+// connections (one at a time, usually).
+type SigningSession struct {
+	DeviceId         string
+	signatureService SignatureService
+	window           int
+	clock            Clock
+
+	// Signs one at a time, so the unacknowledged signatures stay in counter order.
+	signing sync.Mutex
+
+	mutex          sync.Mutex
+	unacknowledged []domain.Signature
+	connections    int
+	lastSeen       time.Time
+}
+
+// Signs like SignatureService.Sign and keeps the signature until it is
+// acknowledged. With a full window it refuses to sign, the client has to
+// acknowledge what it received first.
+func (session *SigningSession) Sign(ctx context.Context, dataToBeSigned string, metadata map[string]string) (*domain.Signature, error) {
+	session.signing.Lock()
+	defer session.signing.Unlock()
+
+	session.mutex.Lock()
+	full := len(session.unacknowledged) >= session.window
+	session.mutex.Unlock()
+
+	if full {
+		return nil, apperrors.WrapCodedError(fmt.Errorf("%w, up to %d are kept", ErrTooManyUnacknowledged, session.window), apperrors.TooManyRequests, apperrors.CodeTooManyUnacked)
+	}
+
+	signature, err := session.signatureService.Sign(ctx, session.DeviceId, dataToBeSigned, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mutex.Lock()
+	session.unacknowledged = append(session.unacknowledged, *signature)
+	session.mutex.Unlock()
+
+	return signature, nil
+}
+
+// Forgets the signatures up to the counter, the client has them.
+func (session *SigningSession) Ack(counter int) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	acknowledged := 0
+	for acknowledged < len(session.unacknowledged) && session.unacknowledged[acknowledged].Counter <= counter {
+		acknowledged++
+	}
+
+	session.unacknowledged = append([]domain.Signature(nil), session.unacknowledged[acknowledged:]...)
+}
+
+func (session *SigningSession) Unacknowledged() []domain.Signature {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return append([]domain.Signature(nil), session.unacknowledged...)
+}
+
+// Called when a connection ends, the session is kept for the TTL from then on.
+func (session *SigningSession) Close() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	session.connections--
+	session.lastSeen = session.clock.Now()
+}
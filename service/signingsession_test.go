@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigningSessions(t *testing.T, window int) (*SigningSessionServiceImplementation, *stoppedClock, string) {
+	deviceService, signatureService := newTestAuthorizedServices()
+
+	device, err := deviceService.Create(roleContext([]string{RoleAdmin}), crypto.SignatureAlgorithmECC, "till")
+	require.NoError(t, err)
+
+	sessions := NewSigningSessionService(signatureService, window, time.Minute)
+	clock := &stoppedClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	sessions.clock = clock
+
+	return sessions, clock, device.UUID
+}
+
+func counters(signatures []domain.Signature) []int {
+	result := []int{}
+	for _, signature := range signatures {
+		result = append(result, signature.Counter)
+	}
+	return result
+}
+
+func TestSigningSession_ResendsWhatWasNotAcknowledged(t *testing.T) {
+	sessions, _, deviceId := newTestSigningSessions(t, 10)
+	signer := roleContext([]string{RoleSigner}, deviceId)
+
+	session, unacknowledged, err := sessions.Open(signer, deviceId, 0)
+	require.NoError(t, err)
+	assert.Empty(t, unacknowledged)
+
+	for _, data := range []string{"one", "two", "three"} {
+		_, err := session.Sign(signer, data, nil)
+		require.NoError(t, err)
+	}
+	session.Ack(1)
+	assert.Equal(t, []int{2, 3}, counters(session.Unacknowledged()))
+
+	// the connection dropped before the register got the third signature
+	session.Close()
+
+	resumed, unacknowledged, err := sessions.Open(signer, deviceId, 2)
+	require.NoError(t, err)
+	assert.Same(t, session, resumed)
+	assert.Equal(t, []int{3}, counters(unacknowledged))
+}
+
+func TestSigningSession_RefusesToSignWithAFullWindow(t *testing.T) {
+	sessions, _, deviceId := newTestSigningSessions(t, 2)
+	signer := roleContext([]string{RoleSigner}, deviceId)
+
+	session, _, err := sessions.Open(signer, deviceId, 0)
+	require.NoError(t, err)
+
+	_, err = session.Sign(signer, "one", nil)
+	require.NoError(t, err)
+	_, err = session.Sign(signer, "two", nil)
+	require.NoError(t, err)
+
+	_, err = session.Sign(signer, "three", nil)
+	assertAppErrorType(t, err, apperrors.TooManyRequests)
+	assert.ErrorIs(t, err, ErrTooManyUnacknowledged)
+
+	session.Ack(2)
+	signature, err := session.Sign(signer, "three", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, signature.Counter)
+}
+
+func TestSigningSession_BelongsToTheKeyThatOpenedIt(t *testing.T) {
+	sessions, _, deviceId := newTestSigningSessions(t, 10)
+	signer := roleContext([]string{RoleSigner}, deviceId)
+
+	session, _, err := sessions.Open(signer, deviceId, 0)
+	require.NoError(t, err)
+	_, err = session.Sign(signer, "one", nil)
+	require.NoError(t, err)
+
+	other := identity.NewContext(context.Background(), DefaultAccessPolicy().Identity(&domain.APIKey{ID: "other", Name: "other", TenantID: domain.DefaultTenantID, Roles: []string{RoleSigner}, Devices: []string{deviceId}}))
+	_, unacknowledged, err := sessions.Open(other, deviceId, 0)
+	require.NoError(t, err)
+	assert.Empty(t, unacknowledged)
+
+	// clients that can't sign are turned away when connecting
+	_, _, err = sessions.Open(roleContext([]string{RoleViewer}), deviceId, 0)
+	assertAppErrorType(t, err, apperrors.Forbidden)
+}
+
+func TestSigningSession_ExpiresAfterTheClientIsGone(t *testing.T) {
+	sessions, clock, deviceId := newTestSigningSessions(t, 10)
+	signer := roleContext([]string{RoleSigner}, deviceId)
+
+	session, _, err := sessions.Open(signer, deviceId, 0)
+	require.NoError(t, err)
+	_, err = session.Sign(signer, "one", nil)
+	require.NoError(t, err)
+	session.Close()
+
+	clock.now = clock.now.Add(2 * time.Minute)
+
+	resumed, unacknowledged, err := sessions.Open(signer, deviceId, 0)
+	require.NoError(t, err)
+	assert.NotSame(t, session, resumed)
+	assert.Empty(t, unacknowledged)
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/persistence"
+)
+
+// Tenant the request is made on behalf of. Calls that are not, like a restore
+// on startup, get persistence.AllTenants and see every tenant.
+func tenantOf(ctx context.Context) string {
+	return identity.FromContext(ctx).TenantID
+}
+
+// Looks up the configuration of the tenant of the request, nil for calls not
+// made on behalf of a tenant, which have no limits.
+func findRequestTenant(ctx context.Context, tenants persistence.TenantPersistance) (*domain.Tenant, error) {
+	tenantID := tenantOf(ctx)
+	if tenantID == persistence.AllTenants {
+		return nil, nil
+	}
+
+	tenant, err := tenants.FindByID(ctx, tenantID)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	// keys of a tenant that was removed from the configuration
+	if tenant == nil {
+		return nil, apperrors.WrapError(fmt.Errorf("tenant %q does not exist", tenantID), apperrors.Forbidden)
+	}
+
+	return tenant, nil
+}
+
+// The tenant services wrap the real ones and apply the configuration of the
+// tenant of the request: allowed algorithms and quotas. Isolation itself is
+// not done here but in the services and the persistence, so it holds without
+// these wrappers.
+
+type tenantDeviceService struct {
+	DeviceService
+	tenants persistence.TenantPersistance
+	// Creations are serialized per tenant so two of them can't both take the
+	// last device of the quota. This only holds within one instance.
+	creating sync.Map
+}
+
+func NewTenantDeviceService(deviceService DeviceService, tenants persistence.TenantPersistance) DeviceService {
+	return &tenantDeviceService{DeviceService: deviceService, tenants: tenants}
+}
+
+func (service *tenantDeviceService) Create(ctx context.Context, algorithm crypto.SignatureAlgorithm, label string) (*domain.Device, error) {
+	tenant, err := findRequestTenant(ctx, service.tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenant == nil {
+		return service.DeviceService.Create(ctx, algorithm, label)
+	}
+
+	if !tenant.AllowsAlgorithm(algorithm) {
+		return nil, apperrors.WrapCodedError(fmt.Errorf("%s devices are not allowed for tenant %s", algorithm, tenant.ID), apperrors.Forbidden, apperrors.CodeTenantLimitReached)
+	}
+
+	if tenant.MaxDevices == 0 {
+		return service.DeviceService.Create(ctx, algorithm, label)
+	}
+
+	mutex, _ := service.creating.LoadOrStore(tenant.ID, &sync.Mutex{})
+	mutex.(*sync.Mutex).Lock()
+	defer mutex.(*sync.Mutex).Unlock()
+
+	// searches are scoped to the tenant of the request
+	devices, err := service.DeviceService.Search(ctx, persistence.DeviceQuery{}, persistence.PageRequest{Limit: 1, IncludeTotal: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if *devices.Total >= tenant.MaxDevices {
+		return nil, apperrors.WrapCodedError(fmt.Errorf("tenant %s reached its quota of %d devices", tenant.ID, tenant.MaxDevices), apperrors.Forbidden, apperrors.CodeTenantLimitReached)
+	}
+
+	return service.DeviceService.Create(ctx, algorithm, label)
+}
+
+type tenantSignatureService struct {
+	SignatureService
+	tenants persistence.TenantPersistance
+	signing *windowLimiter
+	clock   Clock
+}
+
+func NewTenantSignatureService(signatureService SignatureService, tenants persistence.TenantPersistance) SignatureService {
+	return &tenantSignatureService{
+		SignatureService: signatureService,
+		tenants:          tenants,
+		signing:          newWindowLimiter(time.Minute),
+		clock:            NewMonotonicClock(),
+	}
+}
+
+// Signatures are counted per instance, with several of them behind a load
+// balancer each one lets the tenant sign up to its limit.
+func (service *tenantSignatureService) Sign(ctx context.Context, deviceId string, dataToBeSigned string, metadata map[string]string) (*domain.Signature, error) {
+	tenant, err := findRequestTenant(ctx, service.tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenant != nil && tenant.SignaturesPerMinute > 0 {
+		if retryAfter, ok := service.signing.take(tenant.ID, tenant.SignaturesPerMinute, service.clock.Now()); !ok {
+			reason := fmt.Sprintf("tenant %s reached its limit of %d signatures per minute", tenant.ID, tenant.SignaturesPerMinute)
+			return nil, apperrors.WrapError(&RateLimitError{Reason: reason, RetryAfter: retryAfter}, apperrors.TooManyRequests)
+		}
+	}
+
+	return service.SignatureService.Sign(ctx, deviceId, dataToBeSigned, metadata)
+}
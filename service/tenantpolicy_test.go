@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func tenantContext(tenantID string, scopes ...string) context.Context {
+	return identity.NewContext(context.Background(), identity.Identity{Name: tenantID + "-key", KeyID: tenantID + "-key", TenantID: tenantID, Scopes: scopes})
+}
+
+func newTestTenantServices(t *testing.T, tenants ...domain.Tenant) (DeviceService, SignatureService) {
+	tenantPersistence, err := persistence.NewVolatileTenantRepository(tenants)
+	if err != nil {
+		t.Fatalf("Failed to configure the tenants: %v", err)
+	}
+
+	devicePersistence := persistence.NewVolatileDeviceRepository()
+	lockService := NewVolatileLockService(time.Second)
+	pageLimits := PageLimits{Default: 10, Max: 10}
+
+	deviceService := NewTenantDeviceService(NewDeviceService(devicePersistence, lockService, pageLimits, nil), tenantPersistence)
+	signatureService := NewTenantSignatureService(NewSignatureService(devicePersistence, persistence.NewVolatileSignatureRepository(), lockService, pageLimits, nil), tenantPersistence)
+
+	return deviceService, signatureService
+}
+
+func TestTenants_CannotReachTheDevicesOfOtherTenants(t *testing.T) {
+	deviceService, signatureService := newTestTenantServices(t, domain.Tenant{ID: "acme"}, domain.Tenant{ID: "globex"})
+	acme, globex := tenantContext("acme"), tenantContext("globex")
+
+	device, err := deviceService.Create(acme, crypto.SignatureAlgorithmECC, "till")
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", device.TenantID)
+
+	signature, err := signatureService.Sign(acme, device.UUID, "data", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", signature.TenantID)
+
+	// knowing the UUIDs is not enough
+	found, err := deviceService.Get(globex, device.UUID)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+
+	_, err = signatureService.Sign(globex, device.UUID, "data", nil)
+	assertAppErrorType(t, err, http.StatusNotFound)
+
+	_, err = deviceService.ChangeState(globex, device.UUID, domain.DeviceStateSuspended, "")
+	assertAppErrorType(t, err, http.StatusNotFound)
+
+	foundSignature, err := signatureService.Get(globex, signature.UUID)
+	assert.NoError(t, err)
+	assert.Nil(t, foundSignature)
+
+	devices, err := deviceService.List(globex, persistence.PageRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, devices.Items)
+
+	signatures, err := signatureService.List(globex, persistence.PageRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, signatures.Items)
+
+	devices, err = deviceService.List(acme, persistence.PageRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, devices.Items, 1)
+}
+
+func TestTenants_AlgorithmsAndDeviceQuota(t *testing.T) {
+	deviceService, _ := newTestTenantServices(t, domain.Tenant{ID: "acme", AllowedAlgorithms: []string{"ECC"}, MaxDevices: 2})
+	acme := tenantContext("acme")
+
+	_, err := deviceService.Create(acme, crypto.SignatureAlgorithmRSA, "till")
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	for range 2 {
+		_, err := deviceService.Create(acme, crypto.SignatureAlgorithmECC, "till")
+		assert.NoError(t, err)
+	}
+
+	_, err = deviceService.Create(acme, crypto.SignatureAlgorithmECC, "till")
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	// the quota is per tenant
+	_, err = deviceService.Create(tenantContext(domain.DefaultTenantID), crypto.SignatureAlgorithmRSA, "till")
+	assert.NoError(t, err)
+
+	_, err = deviceService.Create(tenantContext("removed"), crypto.SignatureAlgorithmECC, "till")
+	assertAppErrorType(t, err, http.StatusForbidden)
+}
+
+func TestTenants_SignaturesPerMinute(t *testing.T) {
+	deviceService, signatureService := newTestTenantServices(t, domain.Tenant{ID: "acme", SignaturesPerMinute: 2})
+	acme := tenantContext("acme")
+
+	device, err := deviceService.Create(acme, crypto.SignatureAlgorithmECC, "till")
+	assert.NoError(t, err)
+
+	for range 2 {
+		_, err := signatureService.Sign(acme, device.UUID, "data", nil)
+		assert.NoError(t, err)
+	}
+
+	_, err = signatureService.Sign(acme, device.UUID, "data", nil)
+	assertAppErrorType(t, err, http.StatusTooManyRequests)
+
+	var rateLimit *RateLimitError
+	assert.True(t, errors.As(err, &rateLimit))
+	assert.LessOrEqual(t, rateLimit.RetryAfter, time.Minute)
+}
+
+func TestTenants_APIKeysOfOtherTenants(t *testing.T) {
+	apiKeyService, _ := newTestAPIKeyService()
+	acmeAdmin := tenantContext("acme", domain.ScopeAdmin, domain.ScopeSign)
+	operator := tenantContext(domain.DefaultTenantID, domain.ScopeOperator, domain.ScopeSign)
+
+	key, _, err := apiKeyService.Create(acmeAdmin, "till", "", APIKeyGrant{Scopes: []string{domain.ScopeSign}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", key.TenantID)
+
+	_, _, err = apiKeyService.Create(acmeAdmin, "escalation", "", APIKeyGrant{Scopes: []string{domain.ScopeOperator}}, nil)
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	_, _, err = apiKeyService.Create(acmeAdmin, "elsewhere", domain.DefaultTenantID, APIKeyGrant{Scopes: []string{domain.ScopeSign}}, nil)
+	assertAppErrorType(t, err, http.StatusForbidden)
+
+	_, _, err = apiKeyService.Create(operator, "acme-till", "acme", APIKeyGrant{Scopes: []string{domain.ScopeSign}}, nil)
+	assert.NoError(t, err)
+
+	_, _, err = apiKeyService.Create(operator, "nowhere", "missing", APIKeyGrant{Scopes: []string{domain.ScopeSign}}, nil)
+	assertAppErrorType(t, err, http.StatusBadRequest)
+
+	operatorKey, _, err := apiKeyService.Create(operator, "operator-till", "", APIKeyGrant{Scopes: []string{domain.ScopeSign}}, nil)
+	assert.NoError(t, err)
+
+	found, err := apiKeyService.Get(acmeAdmin, operatorKey.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+
+	_, err = apiKeyService.Revoke(acmeAdmin, operatorKey.ID)
+	assertAppErrorType(t, err, http.StatusNotFound)
+
+	keys, err := apiKeyService.List(acmeAdmin, persistence.PageRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, keys.Items, 2)
+
+	keys, err = apiKeyService.List(operator, persistence.PageRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, keys.Items, 3)
+}
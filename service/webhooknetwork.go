@@ -0,0 +1,83 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// Returned when a webhook URL points, or resolves, to an address of the
+// network of the service.
+var ErrWebhookAddressNotAllowed = errors.New("webhook URLs can't point to loopback, link-local or private addresses")
+
+// Shared address space of carrier-grade NATs (RFC 6598), as private as the
+// RFC 1918 ranges.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Webhook URLs are chosen by the tenants, so without this any of them could
+// make the service post to the cloud metadata endpoint or to the services
+// next to it. Only public addresses are allowed, plus the networks of the
+// configuration (e.g. a local stub while testing).
+type webhookNetworkPolicy struct {
+	allowedNetworks []netip.Prefix
+}
+
+func (policy webhookNetworkPolicy) allows(address netip.Addr) bool {
+	address = address.Unmap()
+
+	for _, network := range policy.allowedNetworks {
+		if network.Contains(address) {
+			return true
+		}
+	}
+
+	// loopback, link-local, multicast and unspecified addresses are not global
+	return address.IsGlobalUnicast() && !address.IsPrivate() && !sharedAddressSpace.Contains(address)
+}
+
+// The URL is resolved again on every delivery, so this only catches hosts
+// given as IP addresses early. The dialer checks the others.
+func (policy webhookNetworkPolicy) allowsHost(host string) bool {
+	address, err := netip.ParseAddr(host)
+	return err != nil || policy.allows(address)
+}
+
+// Checks the address actually dialed, after resolving, so a name resolving to
+// a private address (or changing to one after the endpoint was created) is
+// rejected too.
+func (policy webhookNetworkPolicy) control(network string, address string, _ syscall.RawConn) error {
+	addressPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("unexpected address %q: %w", address, err)
+	}
+
+	if !policy.allows(addressPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrWebhookAddressNotAllowed, addressPort.Addr())
+	}
+
+	return nil
+}
+
+// Connects straight to the endpoints, without the proxy of the environment,
+// and doesn't follow redirects, which would get around the checks of the
+// dialer for the first hop. A redirect is a failed attempt like any other
+// non 2xx answer.
+func newWebhookClient(timeout time.Duration, policy webhookNetworkPolicy) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: policy.control}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
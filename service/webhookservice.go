@@ -0,0 +1,445 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/identity"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/google/uuid"
+)
+
+// Headers of every delivery. The signature lets receivers check the delivery
+// comes from the service and was not replayed long after, see WebhookSignature.
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	webhookSecretPrefix = "whsec_"
+	// Only the status of the response matters, the rest is read to reuse the
+	// connection, up to this size.
+	webhookMaxResponseBody = 64 * 1024
+	// Deliveries attempted at the same time.
+	webhookBatchSize = 16
+)
+
+var ErrWebhookEndpointNotFound = errors.New("webhook endpoint not found")
+
+type WebhookService interface {
+	// The endpoint is returned with its secret, which is only shown once.
+	// No types means every type of event.
+	Create(ctx context.Context, endpointURL string, types []domain.EventType, description string) (*domain.WebhookEndpoint, error)
+	Get(ctx context.Context, id string) (*domain.WebhookEndpoint, error)
+	List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.WebhookEndpoint], error)
+	// The deliveries already made stay in the history.
+	Delete(ctx context.Context, id string) (*domain.WebhookEndpoint, error)
+	Deliveries(ctx context.Context, endpointId string, pageRequest persistence.PageRequest) (persistence.Page[domain.WebhookDelivery], error)
+	// The deliveries that failed every attempt.
+	DeadLetters(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.WebhookDelivery], error)
+	// Sends the payload of a delivery again, as a new delivery.
+	Replay(ctx context.Context, deliveryId string) (*domain.WebhookDelivery, error)
+}
+
+// Turns an event into the body posted to the endpoints.
+type WebhookPayloadEncoder func(event *domain.Event) ([]byte, error)
+
+// The service is also an EventPublisher. Publishing only queues the event,
+// Run turns them into deliveries and makes them, so a slow or failing
+// endpoint never slows signing down. When the queue is full events are
+// dropped, and logged.
+//
+// Failed deliveries are tried again after a backoff which doubles every
+// attempt, see RetryPolicy, and end in the dead letters after the last one.
+type WebhookServiceImplementation struct {
+	persistence  persistence.WebhookPersistance
+	pageLimits   PageLimits
+	retryPolicy  RetryPolicy
+	encode       WebhookPayloadEncoder
+	network      webhookNetworkPolicy
+	client       *http.Client
+	clock        Clock
+	pollInterval time.Duration
+
+	queue chan domain.Event
+	// Wakes Run up when a delivery is due right away.
+	wake chan struct{}
+}
+
+func NewWebhookService(
+	persistence persistence.WebhookPersistance,
+	pageLimits PageLimits,
+	retryPolicy RetryPolicy,
+	queueSize int,
+	timeout time.Duration,
+	encode WebhookPayloadEncoder,
+	// Private networks endpoints may be in anyway, see webhookNetworkPolicy.
+	allowedNetworks []netip.Prefix,
+) *WebhookServiceImplementation {
+	network := webhookNetworkPolicy{allowedNetworks: allowedNetworks}
+
+	return &WebhookServiceImplementation{
+		persistence:  persistence,
+		pageLimits:   pageLimits,
+		retryPolicy:  retryPolicy,
+		encode:       encode,
+		network:      network,
+		client:       newWebhookClient(timeout, network),
+		clock:        NewMonotonicClock(),
+		pollInterval: time.Second,
+		queue:        make(chan domain.Event, queueSize),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+func (service *WebhookServiceImplementation) Create(ctx context.Context, endpointURL string, types []domain.EventType, description string) (*domain.WebhookEndpoint, error) {
+	parsed, err := url.Parse(endpointURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, apperrors.WrapError(errors.New("webhook URLs have to be absolute http or https URLs"), apperrors.BadRequest)
+	}
+
+	if !service.network.allowsHost(parsed.Hostname()) {
+		return nil, apperrors.WrapError(ErrWebhookAddressNotAllowed, apperrors.BadRequest)
+	}
+
+	for _, eventType := range types {
+		if !slices.Contains(domain.EventTypes, eventType) {
+			return nil, apperrors.WrapError(fmt.Errorf("unknown event type %q", eventType), apperrors.BadRequest)
+		}
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	endpoint := &domain.WebhookEndpoint{
+		ID:          uuid.NewString(),
+		TenantID:    domain.TenantOrDefault(tenantOf(ctx)),
+		URL:         endpointURL,
+		Secret:      webhookSecretPrefix + secret,
+		Description: description,
+		Types:       slices.Compact(slices.Sorted(slices.Values(types))),
+		CreatedAt:   service.clock.Now(),
+		CreatedBy:   identity.FromContext(ctx).Name,
+	}
+
+	if err := service.persistence.CreateEndpoint(ctx, endpoint); err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return endpoint, nil
+}
+
+func (service *WebhookServiceImplementation) Get(ctx context.Context, id string) (*domain.WebhookEndpoint, error) {
+	endpoint, err := service.persistence.FindEndpoint(ctx, tenantOf(ctx), id)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return endpoint, nil
+}
+
+func (service *WebhookServiceImplementation) List(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.WebhookEndpoint], error) {
+	endpoints, err := service.persistence.ListEndpoints(ctx, tenantOf(ctx), service.pageLimits.apply(pageRequest))
+	if err != nil {
+		return persistence.Page[domain.WebhookEndpoint]{}, wrapListError(err)
+	}
+
+	return endpoints, nil
+}
+
+func (service *WebhookServiceImplementation) Delete(ctx context.Context, id string) (*domain.WebhookEndpoint, error) {
+	endpoint, err := service.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint == nil {
+		return nil, apperrors.WrapCodedError(ErrWebhookEndpointNotFound, apperrors.NotFound, apperrors.CodeWebhookNotFound)
+	}
+
+	// pending deliveries of the endpoint fail when their turn comes
+	if _, err := service.persistence.DeleteEndpoint(ctx, tenantOf(ctx), id); err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	return endpoint, nil
+}
+
+func (service *WebhookServiceImplementation) Deliveries(ctx context.Context, endpointId string, pageRequest persistence.PageRequest) (persistence.Page[domain.WebhookDelivery], error) {
+	query := persistence.WebhookDeliveryQuery{TenantID: tenantOf(ctx), EndpointID: endpointId}
+	return service.listDeliveries(ctx, query, pageRequest)
+}
+
+func (service *WebhookServiceImplementation) DeadLetters(ctx context.Context, pageRequest persistence.PageRequest) (persistence.Page[domain.WebhookDelivery], error) {
+	query := persistence.WebhookDeliveryQuery{TenantID: tenantOf(ctx), Status: domain.WebhookDeliveryFailed}
+	return service.listDeliveries(ctx, query, pageRequest)
+}
+
+func (service *WebhookServiceImplementation) listDeliveries(ctx context.Context, query persistence.WebhookDeliveryQuery, pageRequest persistence.PageRequest) (persistence.Page[domain.WebhookDelivery], error) {
+	deliveries, err := service.persistence.ListDeliveries(ctx, query, service.pageLimits.apply(pageRequest))
+	if err != nil {
+		return persistence.Page[domain.WebhookDelivery]{}, wrapListError(err)
+	}
+
+	return deliveries, nil
+}
+
+func (service *WebhookServiceImplementation) Replay(ctx context.Context, deliveryId string) (*domain.WebhookDelivery, error) {
+	delivery, err := service.persistence.FindDelivery(ctx, tenantOf(ctx), deliveryId)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	if delivery == nil {
+		return nil, apperrors.WrapCodedError(errors.New("webhook delivery not found"), apperrors.NotFound, apperrors.CodeDeliveryNotFound)
+	}
+
+	if delivery.Status == domain.WebhookDeliveryPending {
+		return nil, apperrors.WrapCodedError(errors.New("the delivery is still being attempted"), apperrors.Conflict, apperrors.CodeDeliveryPending)
+	}
+
+	endpoint, err := service.persistence.FindEndpoint(ctx, delivery.TenantID, delivery.EndpointID)
+	if err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+
+	if endpoint == nil {
+		return nil, apperrors.WrapCodedError(fmt.Errorf("%w, it was deleted", ErrWebhookEndpointNotFound), apperrors.Conflict, apperrors.CodeWebhookDeleted)
+	}
+
+	replay := service.newDelivery(delivery.TenantID, delivery.EndpointID, delivery.EventID, delivery.EventType, delivery.Payload)
+	replay.ReplayOf = delivery.ID
+
+	if err := service.persistence.SaveDelivery(ctx, replay); err != nil {
+		return nil, apperrors.WrapError(err, apperrors.InternalError)
+	}
+	service.wakeUp()
+
+	return replay, nil
+}
+
+// Never blocks, see WebhookServiceImplementation.
+func (service *WebhookServiceImplementation) Publish(event domain.Event) {
+	select {
+	case service.queue <- event:
+	default:
+		slog.Error("webhook queue is full, the event is not delivered", "eventId", event.ID, "type", event.Type)
+	}
+}
+
+// Turns the published events into deliveries and makes the ones due until the
+// context is done.
+func (service *WebhookServiceImplementation) Run(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-service.queue:
+				service.enqueue(ctx, event)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(service.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-service.wake:
+		}
+
+		service.deliverDue(ctx)
+	}
+}
+
+// Makes a delivery of the event for every endpoint wanting it.
+func (service *WebhookServiceImplementation) enqueue(ctx context.Context, event domain.Event) {
+	endpoints, err := service.persistence.FindSubscribedEndpoints(ctx, event.TenantID, event.Type)
+	if err != nil {
+		slog.Error("could not find the webhook endpoints of an event", "eventId", event.ID, "error", err.Error())
+		return
+	}
+
+	if len(endpoints) == 0 {
+		return
+	}
+
+	payload, err := service.encode(&event)
+	if err != nil {
+		slog.Error("could not encode a webhook payload", "eventId", event.ID, "error", err.Error())
+		return
+	}
+
+	for _, endpoint := range endpoints {
+		delivery := service.newDelivery(endpoint.TenantID, endpoint.ID, event.ID, event.Type, payload)
+		if err := service.persistence.SaveDelivery(ctx, delivery); err != nil {
+			slog.Error("could not save a webhook delivery", "eventId", event.ID, "webhookId", endpoint.ID, "error", err.Error())
+		}
+	}
+
+	service.wakeUp()
+}
+
+func (service *WebhookServiceImplementation) newDelivery(tenantID string, endpointID string, eventID string, eventType domain.EventType, payload []byte) *domain.WebhookDelivery {
+	now := service.clock.Now()
+
+	return &domain.WebhookDelivery{
+		ID:            uuid.NewString(),
+		EndpointID:    endpointID,
+		TenantID:      tenantID,
+		EventID:       eventID,
+		EventType:     eventType,
+		Payload:       payload,
+		Status:        domain.WebhookDeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+func (service *WebhookServiceImplementation) wakeUp() {
+	select {
+	case service.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Attempts the deliveries due, a batch at a time, until none is left.
+func (service *WebhookServiceImplementation) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := service.persistence.DueDeliveries(ctx, service.clock.Now(), webhookBatchSize)
+		if err != nil {
+			slog.Error("could not read the webhook deliveries due", "error", err.Error())
+			return
+		}
+
+		var wait sync.WaitGroup
+		for _, delivery := range due {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				service.attempt(ctx, delivery)
+			}()
+		}
+		wait.Wait()
+
+		if len(due) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+func (service *WebhookServiceImplementation) attempt(ctx context.Context, delivery domain.WebhookDelivery) {
+	endpoint, err := service.persistence.FindEndpoint(ctx, delivery.TenantID, delivery.EndpointID)
+	if err != nil {
+		// tried again on the next round
+		slog.Error("could not read a webhook endpoint", "webhookId", delivery.EndpointID, "error", err.Error())
+		return
+	}
+
+	attempt := domain.WebhookAttempt{At: service.clock.Now()}
+	if endpoint == nil {
+		attempt.Error = ErrWebhookEndpointNotFound.Error()
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		delivery.Status = domain.WebhookDeliveryFailed
+	} else {
+		attempt.StatusCode, err = service.post(ctx, endpoint, &delivery)
+		attempt.Duration = service.clock.Now().Sub(attempt.At)
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		delivery.Attempts = append(delivery.Attempts, attempt)
+
+		switch {
+		case err == nil:
+			delivery.Status = domain.WebhookDeliveryDelivered
+			delivery.DeliveredAt = &attempt.At
+		case len(delivery.Attempts) >= service.retryPolicy.MaxAttempts:
+			delivery.Status = domain.WebhookDeliveryFailed
+		default:
+			delivery.NextAttemptAt = attempt.At.Add(service.backoff(len(delivery.Attempts)))
+		}
+	}
+
+	if delivery.Status == domain.WebhookDeliveryFailed {
+		slog.Warn("webhook delivery failed, moved to the dead letters", "deliveryId", delivery.ID, "webhookId", delivery.EndpointID, "error", attempt.Error)
+	}
+
+	// a fresh context, the attempt was made even if the service is stopping
+	if err := service.persistence.SaveDelivery(context.WithoutCancel(ctx), &delivery); err != nil {
+		slog.Error("could not save a webhook delivery", "deliveryId", delivery.ID, "error", err.Error())
+	}
+}
+
+// Posts the payload, any 2xx response is a success.
+func (service *WebhookServiceImplementation) post(ctx context.Context, endpoint *domain.WebhookEndpoint, delivery *domain.WebhookDelivery) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "signing-service-webhooks")
+	request.Header.Set(WebhookIDHeader, delivery.ID)
+	request.Header.Set(WebhookEventHeader, string(delivery.EventType))
+	request.Header.Set(WebhookSignatureHeader, WebhookSignature(endpoint.Secret, service.clock.Now().Unix(), delivery.Payload))
+
+	response, err := service.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, webhookMaxResponseBody))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("the endpoint answered %d", response.StatusCode)
+	}
+
+	return response.StatusCode, nil
+}
+
+// The wait before the attempt after the given number of attempts.
+func (service *WebhookServiceImplementation) backoff(attempts int) time.Duration {
+	backoff := service.retryPolicy.InitialBackoff
+	for i := 1; i < attempts && backoff < service.retryPolicy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, service.retryPolicy.MaxBackoff)
+}
+
+// Value of the X-Webhook-Signature header: `t=<unix time>,v1=<signature>`,
+// the signature being the hex HMAC-SHA256 of `<unix time>.<body>` with the
+// secret of the endpoint. Receivers compute it again and compare, and may
+// reject old timestamps.
+func WebhookSignature(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return "t=" + strconv.FormatInt(timestamp, 10) + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
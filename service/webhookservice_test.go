@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chuckiihub/signing-service/crypto"
+	"github.com/chuckiihub/signing-service/domain"
+	apperrors "github.com/chuckiihub/signing-service/errors"
+	"github.com/chuckiihub/signing-service/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Local HTTP stub standing in for the endpoint of a tenant.
+type webhookReceiver struct {
+	mutex    sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newWebhookReceiver(t *testing.T, status int) (*webhookReceiver, *httptest.Server) {
+	receiver := &webhookReceiver{status: status}
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+
+		receiver.mutex.Lock()
+		defer receiver.mutex.Unlock()
+		receiver.requests = append(receiver.requests, request)
+		receiver.bodies = append(receiver.bodies, body)
+		response.WriteHeader(receiver.status)
+	}))
+	t.Cleanup(server.Close)
+
+	return receiver, server
+}
+
+func (receiver *webhookReceiver) answer(status int) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	receiver.status = status
+}
+
+func (receiver *webhookReceiver) received() int {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	return len(receiver.requests)
+}
+
+// The receivers listen on loopback, which has to be allowed like in a local setup.
+func newTestWebhookService(maxAttempts int, queueSize int) (*WebhookServiceImplementation, *stoppedClock) {
+	return newTestWebhookServiceAllowing(maxAttempts, queueSize, netip.MustParsePrefix("127.0.0.0/8"))
+}
+
+func newTestWebhookServiceAllowing(maxAttempts int, queueSize int, allowedNetworks ...netip.Prefix) (*WebhookServiceImplementation, *stoppedClock) {
+	retryPolicy := RetryPolicy{MaxAttempts: maxAttempts, InitialBackoff: time.Minute, MaxBackoff: time.Hour}
+	webhooks := NewWebhookService(persistence.NewVolatileWebhookRepository(), PageLimits{Default: 10, Max: 10}, retryPolicy, queueSize, time.Second, func(event *domain.Event) ([]byte, error) {
+		return []byte(`{"id":"` + event.ID + `"}`), nil
+	}, allowedNetworks)
+	clock := &stoppedClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	webhooks.clock = clock
+
+	return webhooks, clock
+}
+
+func deviceCreatedEvent(at time.Time) domain.Event {
+	return domain.NewDeviceEvent(domain.EventDeviceCreated, domain.Device{UUID: "device", TenantID: domain.DefaultTenantID}, at)
+}
+
+func TestWebhooks_DeliveriesAreSignedWithTheSecret(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, http.StatusNoContent)
+	webhooks, clock := newTestWebhookService(3, 10)
+	admin := roleContext([]string{RoleAdmin})
+
+	endpoint, err := webhooks.Create(admin, server.URL, nil, "monitoring")
+	require.NoError(t, err)
+	assert.NotEmpty(t, endpoint.Secret)
+
+	event := deviceCreatedEvent(clock.now)
+	webhooks.enqueue(context.Background(), event)
+	webhooks.deliverDue(context.Background())
+
+	require.Equal(t, 1, receiver.received())
+	request, body := receiver.requests[0], receiver.bodies[0]
+	assert.Equal(t, `{"id":"`+event.ID+`"}`, string(body))
+	assert.Equal(t, string(domain.EventDeviceCreated), request.Header.Get(WebhookEventHeader))
+	assert.Equal(t, WebhookSignature(endpoint.Secret, clock.now.Unix(), body), request.Header.Get(WebhookSignatureHeader))
+
+	deliveries, err := webhooks.Deliveries(admin, endpoint.ID, persistence.PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, deliveries.Items, 1)
+	assert.Equal(t, domain.WebhookDeliveryDelivered, deliveries.Items[0].Status)
+	assert.Equal(t, http.StatusNoContent, deliveries.Items[0].Attempts[0].StatusCode)
+	assert.Equal(t, request.Header.Get(WebhookIDHeader), deliveries.Items[0].ID)
+}
+
+func TestWebhooks_FailuresAreRetriedThenDeadLettered(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, http.StatusInternalServerError)
+	webhooks, clock := newTestWebhookService(3, 10)
+	admin := roleContext([]string{RoleAdmin})
+
+	_, err := webhooks.Create(admin, server.URL, nil, "")
+	require.NoError(t, err)
+	webhooks.enqueue(context.Background(), deviceCreatedEvent(clock.now))
+
+	webhooks.deliverDue(context.Background())
+	// not due again before the backoff
+	webhooks.deliverDue(context.Background())
+	assert.Equal(t, 1, receiver.received())
+
+	clock.now = clock.now.Add(time.Minute)
+	webhooks.deliverDue(context.Background())
+	assert.Equal(t, 2, receiver.received())
+
+	// the backoff doubled
+	clock.now = clock.now.Add(time.Minute)
+	webhooks.deliverDue(context.Background())
+	assert.Equal(t, 2, receiver.received())
+	clock.now = clock.now.Add(time.Minute)
+	webhooks.deliverDue(context.Background())
+	assert.Equal(t, 3, receiver.received())
+
+	deadLetters, err := webhooks.DeadLetters(admin, persistence.PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, deadLetters.Items, 1)
+	failed := deadLetters.Items[0]
+	assert.Equal(t, domain.WebhookDeliveryFailed, failed.Status)
+	assert.Len(t, failed.Attempts, 3)
+
+	// once the endpoint is fixed, the dead letter is sent again
+	receiver.answer(http.StatusOK)
+	replay, err := webhooks.Replay(admin, failed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, failed.ID, replay.ReplayOf)
+
+	_, err = webhooks.Replay(admin, replay.ID)
+	assertAppErrorType(t, err, apperrors.Conflict)
+	assertAppErrorCode(t, err, apperrors.CodeDeliveryPending)
+
+	_, err = webhooks.Replay(admin, "missing")
+	assertAppErrorCode(t, err, apperrors.CodeDeliveryNotFound)
+
+	webhooks.deliverDue(context.Background())
+	assert.Equal(t, 4, receiver.received())
+	assert.Equal(t, receiver.bodies[0], receiver.bodies[3])
+
+	delivered, err := webhooks.persistence.FindDelivery(admin, domain.DefaultTenantID, replay.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.WebhookDeliveryDelivered, delivered.Status)
+}
+
+func TestWebhooks_EndpointsOnlyGetTheirEvents(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, http.StatusOK)
+	webhooks, clock := newTestWebhookService(3, 10)
+
+	_, err := webhooks.Create(roleContext([]string{RoleAdmin}), server.URL, []domain.EventType{domain.EventSignatureCreated}, "")
+	require.NoError(t, err)
+	_, err = webhooks.Create(tenantContext("acme", domain.ScopeWebhooks), server.URL, nil, "")
+	require.NoError(t, err)
+
+	// neither the type of the first endpoint nor the tenant of the second
+	webhooks.enqueue(context.Background(), deviceCreatedEvent(clock.now))
+	webhooks.deliverDue(context.Background())
+	assert.Equal(t, 0, receiver.received())
+
+	_, err = webhooks.Create(roleContext([]string{RoleAdmin}), "ftp://example.com", nil, "")
+	assertAppErrorType(t, err, apperrors.BadRequest)
+
+	// viewers can't manage webhooks, and signers can't read what they'd get
+	authorized := NewAuthorizedWebhookService(webhooks)
+	_, err = authorized.Create(roleContext([]string{RoleViewer}), server.URL, nil, "")
+	assertAppErrorType(t, err, apperrors.Forbidden)
+	_, err = authorized.Create(tenantContext(domain.DefaultTenantID, domain.ScopeWebhooks), server.URL, []domain.EventType{domain.EventSignatureCreated}, "")
+	assertAppErrorType(t, err, apperrors.Forbidden)
+}
+
+func TestWebhooks_InternalAddressesAreNotReached(t *testing.T) {
+	receiver, server := newWebhookReceiver(t, http.StatusOK)
+	webhooks, clock := newTestWebhookServiceAllowing(1, 10)
+	admin := roleContext([]string{RoleAdmin})
+
+	for _, endpointURL := range []string{
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/hook",
+		"https://[::1]:8443/hook",
+		"http://[::ffff:192.168.1.1]/hook",
+		server.URL,
+	} {
+		_, err := webhooks.Create(admin, endpointURL, nil, "")
+		assertAppErrorType(t, err, apperrors.BadRequest)
+	}
+
+	// a name is only resolved when delivering, and resolves to loopback
+	endpoint, err := webhooks.Create(admin, strings.Replace(server.URL, "127.0.0.1", "localhost", 1), nil, "")
+	require.NoError(t, err)
+
+	webhooks.enqueue(context.Background(), deviceCreatedEvent(clock.now))
+	webhooks.deliverDue(context.Background())
+	assert.Equal(t, 0, receiver.received())
+
+	deliveries, err := webhooks.Deliveries(admin, endpoint.ID, persistence.PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, deliveries.Items, 1)
+	assert.Equal(t, domain.WebhookDeliveryFailed, deliveries.Items[0].Status)
+	assert.Contains(t, deliveries.Items[0].Attempts[0].Error, ErrWebhookAddressNotAllowed.Error())
+}
+
+func TestWebhooks_RedirectsAreNotFollowed(t *testing.T) {
+	target, targetServer := newWebhookReceiver(t, http.StatusOK)
+	redirect := httptest.NewServer(http.RedirectHandler(targetServer.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirect.Close)
+	webhooks, clock := newTestWebhookService(1, 10)
+	admin := roleContext([]string{RoleAdmin})
+
+	endpoint, err := webhooks.Create(admin, redirect.URL, nil, "")
+	require.NoError(t, err)
+
+	webhooks.enqueue(context.Background(), deviceCreatedEvent(clock.now))
+	webhooks.deliverDue(context.Background())
+	assert.Equal(t, 0, target.received())
+
+	deliveries, err := webhooks.Deliveries(admin, endpoint.ID, persistence.PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, deliveries.Items, 1)
+	assert.Equal(t, domain.WebhookDeliveryFailed, deliveries.Items[0].Status)
+	assert.Equal(t, http.StatusTemporaryRedirect, deliveries.Items[0].Attempts[0].StatusCode)
+}
+
+func TestWebhooks_SigningIsNeverBlocked(t *testing.T) {
+	// nothing takes the events out of the queue
+	webhooks, _ := newTestWebhookService(3, 1)
+
+	devicePersistence := persistence.NewVolatileDeviceRepository()
+	lockService := NewVolatileLockService(time.Second)
+	pageLimits := PageLimits{Default: 10, Max: 10}
+	deviceService := NewDeviceService(devicePersistence, lockService, pageLimits, webhooks)
+	signatureService := NewSignatureService(devicePersistence, persistence.NewVolatileSignatureRepository(), lockService, pageLimits, webhooks)
+
+	device, err := deviceService.Create(context.Background(), crypto.SignatureAlgorithmECC, "till")
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, data := range []string{"one", "two", "three"} {
+			_, err := signatureService.Sign(context.Background(), device.UUID, data, nil)
+			assert.NoError(t, err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("signing waited for the webhooks")
+	}
+}
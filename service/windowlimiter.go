@@ -0,0 +1,99 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Returned (wrapped in a 429 AppError) when a client or a tenant went over one
+// of its limits, it can try again after RetryAfter.
+type RateLimitError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("%s, try again in %s", err.Reason, err.RetryAfter.Round(time.Second))
+}
+
+// Counts events per key (a client address, a tenant) in fixed windows of time.
+// Keys over the maximum are blocked until their window ends.
+type windowLimiter struct {
+	window time.Duration
+	keys   map[string]*limiterWindow
+	mutex  sync.Mutex
+}
+
+type limiterWindow struct {
+	start  time.Time
+	events int
+}
+
+// Past this many keys the expired windows are dropped, so a flood of
+// addresses does not grow the map forever.
+const windowLimiterSweepSize = 10000
+
+func newWindowLimiter(window time.Duration) *windowLimiter {
+	return &windowLimiter{
+		window: window,
+		keys:   make(map[string]*limiterWindow),
+	}
+}
+
+// Tells whether the key reached max events in its window and, if so, for how
+// long it stays blocked.
+func (limiter *windowLimiter) exceeded(key string, max int, now time.Time) (time.Duration, bool) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	window := limiter.current(key, now)
+	if window.events < max {
+		return 0, false
+	}
+
+	return window.start.Add(limiter.window).Sub(now), true
+}
+
+func (limiter *windowLimiter) add(key string, now time.Time) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.current(key, now).events++
+}
+
+// Counts an event for the key unless it already reached max. Returns false,
+// and for how long the key stays blocked, when it did.
+func (limiter *windowLimiter) take(key string, max int, now time.Time) (time.Duration, bool) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	window := limiter.current(key, now)
+	if window.events >= max {
+		return window.start.Add(limiter.window).Sub(now), false
+	}
+
+	window.events++
+	return 0, true
+}
+
+// Returns the window of the key, a new one if the last one is over. The mutex
+// must be held.
+func (limiter *windowLimiter) current(key string, now time.Time) *limiterWindow {
+	window, exists := limiter.keys[key]
+	if exists && now.Before(window.start.Add(limiter.window)) {
+		return window
+	}
+
+	if !exists && len(limiter.keys) >= windowLimiterSweepSize {
+		for key, window := range limiter.keys {
+			if !now.Before(window.start.Add(limiter.window)) {
+				delete(limiter.keys, key)
+			}
+		}
+	}
+
+	window = &limiterWindow{start: now}
+	limiter.keys[key] = window
+	return window
+}
@@ -0,0 +1,192 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Client certificates are bound to devices with URI SANs like this one
+// followed by the UUID of the device. A certificate can name several devices.
+const DeviceURIPrefix = "urn:signing-service:device:"
+
+// Cipher policies, they only matter for TLS 1.2 as TLS 1.3 suites can't be
+// chosen in Go and all of them are fine.
+const (
+	// Forward secret AEAD suites only.
+	CipherPolicyIntermediate = "intermediate"
+	// Like intermediate but without AES-128.
+	CipherPolicyModern = "modern"
+)
+
+// Client authentication modes.
+const (
+	// Clients may present a certificate, it is verified when they do.
+	ClientAuthOptional = "optional"
+	// Every client must present a valid certificate.
+	ClientAuthRequire = "require"
+)
+
+var cipherPolicies = map[string][]uint16{
+	CipherPolicyIntermediate: {
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	},
+	CipherPolicyModern: {
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	},
+}
+
+var minVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// "1.2" or "1.3", defaults to 1.2.
+	MinVersion string
+	// One of the CipherPolicy constants, defaults to intermediate.
+	CipherPolicy string
+	// CA the client certificates must be issued by, mutual TLS is off without it.
+	ClientCAFile string
+	// One of the ClientAuth constants, defaults to optional.
+	ClientAuth string
+}
+
+func (config Config) MutualTLS() bool {
+	return config.ClientCAFile != ""
+}
+
+// The certificates are read from disk on Reload, so they can be renewed
+// without restarting. Connections already open keep the certificates they
+// were made with, new ones get the reloaded ones.
+type Reloader struct {
+	config Config
+	base   *tls.Config
+	// Swapped as a whole so handshakes see either the old or the new files.
+	current atomic.Pointer[loaded]
+}
+
+type loaded struct {
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// Validates the configuration and loads the certificates a first time.
+func NewReloader(config Config) (*Reloader, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("TLS needs both a certificate and a key file")
+	}
+
+	minVersion, found := minVersions[orDefault(config.MinVersion, "1.2")]
+	if !found {
+		return nil, fmt.Errorf("unknown TLS version %q, use 1.2 or 1.3", config.MinVersion)
+	}
+
+	cipherSuites, found := cipherPolicies[orDefault(config.CipherPolicy, CipherPolicyIntermediate)]
+	if !found {
+		return nil, fmt.Errorf("unknown cipher policy %q, use %s or %s", config.CipherPolicy, CipherPolicyIntermediate, CipherPolicyModern)
+	}
+
+	clientAuth := tls.NoClientCert
+	if config.MutualTLS() {
+		switch orDefault(config.ClientAuth, ClientAuthOptional) {
+		case ClientAuthOptional:
+			clientAuth = tls.VerifyClientCertIfGiven
+		case ClientAuthRequire:
+			clientAuth = tls.RequireAndVerifyClientCert
+		default:
+			return nil, fmt.Errorf("unknown client authentication %q, use %s or %s", config.ClientAuth, ClientAuthOptional, ClientAuthRequire)
+		}
+	}
+
+	reloader := &Reloader{
+		config: config,
+		base: &tls.Config{
+			MinVersion:   minVersion,
+			CipherSuites: cipherSuites,
+			ClientAuth:   clientAuth,
+			// the per client configuration replaces the one of the listener,
+			// protocols included
+			NextProtos: []string{"h2", "http/1.1"},
+		},
+	}
+
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// Reads the files again. On error the certificates in use are kept.
+func (reloader *Reloader) Reload() error {
+	certificate, err := tls.LoadX509KeyPair(reloader.config.CertFile, reloader.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load the TLS certificate: %w", err)
+	}
+
+	next := &loaded{certificate: &certificate}
+	if reloader.config.MutualTLS() {
+		pem, err := os.ReadFile(reloader.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read the client CA: %w", err)
+		}
+
+		next.clientCAs = x509.NewCertPool()
+		if !next.clientCAs.AppendCertsFromPEM(pem) {
+			return errors.New("the client CA file has no PEM certificate")
+		}
+	}
+
+	reloader.current.Store(next)
+	return nil
+}
+
+// Configuration for the listener, it always uses the last loaded certificates.
+func (reloader *Reloader) TLSConfig() *tls.Config {
+	config := reloader.base.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		current := reloader.current.Load()
+
+		forClient := reloader.base.Clone()
+		forClient.Certificates = []tls.Certificate{*current.certificate}
+		forClient.ClientCAs = current.clientCAs
+		return forClient, nil
+	}
+
+	return config
+}
+
+// UUIDs of the devices the client certificate is bound to.
+func BoundDevices(certificate *x509.Certificate) []string {
+	devices := make([]string, 0, len(certificate.URIs))
+	for _, uri := range certificate.URIs {
+		if device, found := strings.CutPrefix(uri.String(), DeviceURIPrefix); found && device != "" {
+			devices = append(devices, device)
+		}
+	}
+
+	return devices
+}
+
+func orDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
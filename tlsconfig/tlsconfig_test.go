@@ -0,0 +1,170 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+// Issues a certificate signed by the issuer, or a self signed CA without one.
+func issueCertificate(t *testing.T, serial int64, issuer *testCertificate, uris ...string) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, uri := range uris {
+		parsed, _ := url.Parse(uri)
+		template.URIs = append(template.URIs, parsed)
+	}
+
+	parent, signer := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parent, signer = issuer.certificate, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	certificate, _ := x509.ParseCertificate(der)
+	return &testCertificate{certificate: certificate, key: key}
+}
+
+func (certificate *testCertificate) write(t *testing.T, certFile string, keyFile string) {
+	keyDER, _ := x509.MarshalECPrivateKey(certificate.key)
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.certificate.Raw}), 0o600)
+	if keyFile != "" {
+		os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	}
+}
+
+func (certificate *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{certificate.certificate.Raw}, PrivateKey: certificate.key}
+}
+
+func TestReloader_ServesReloadedCertificatesAndReadsClientBindings(t *testing.T) {
+	directory := t.TempDir()
+	config := Config{
+		CertFile:     filepath.Join(directory, "server.pem"),
+		KeyFile:      filepath.Join(directory, "server.key"),
+		ClientCAFile: filepath.Join(directory, "ca.pem"),
+	}
+
+	ca := issueCertificate(t, 1, nil)
+	ca.write(t, config.ClientCAFile, "")
+	issueCertificate(t, 2, ca).write(t, config.CertFile, config.KeyFile)
+
+	reloader, err := NewReloader(config)
+	if err != nil {
+		t.Fatalf("Failed to load the certificates: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if len(request.TLS.VerifiedChains) > 0 {
+			response.Write([]byte(strings.Join(BoundDevices(request.TLS.VerifiedChains[0][0]), ",")))
+		}
+	}))
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	register := issueCertificate(t, 3, ca, DeviceURIPrefix+"device-1", "https://example.com")
+	get := func(clientCertificates ...tls.Certificate) (string, *big.Int, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       clientCertificates,
+		}}}
+		response, err := client.Get(server.URL)
+		if err != nil {
+			return "", nil, err
+		}
+		defer response.Body.Close()
+
+		body := make([]byte, 100)
+		n, _ := response.Body.Read(body)
+		return string(body[:n]), response.TLS.PeerCertificates[0].SerialNumber, nil
+	}
+
+	devices, serial, err := get(register.tlsCertificate())
+	if err != nil || devices != "device-1" || serial.Int64() != 2 {
+		t.Fatalf("Expected device-1 from certificate 2, got %q from %v (%v)", devices, serial, err)
+	}
+
+	// clients without a certificate are fine, the optional mode only verifies given ones
+	devices, _, err = get()
+	if err != nil || devices != "" {
+		t.Fatalf("Expected no devices without a client certificate, got %q (%v)", devices, err)
+	}
+
+	stranger := issueCertificate(t, 4, issueCertificate(t, 5, nil), DeviceURIPrefix+"device-1")
+	if _, _, err := get(stranger.tlsCertificate()); err == nil {
+		t.Fatalf("Expected a certificate of another CA to be rejected")
+	}
+
+	issueCertificate(t, 6, ca).write(t, config.CertFile, config.KeyFile)
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+
+	if _, serial, _ = get(register.tlsCertificate()); serial.Int64() != 6 {
+		t.Fatalf("Expected the reloaded certificate 6, got %v", serial)
+	}
+
+	// a broken file keeps the current certificate
+	os.WriteFile(config.CertFile, []byte("garbage"), 0o600)
+	if err := reloader.Reload(); err == nil {
+		t.Fatalf("Expected the broken certificate to fail reloading")
+	}
+
+	if _, serial, _ = get(register.tlsCertificate()); serial.Int64() != 6 {
+		t.Fatalf("Expected certificate 6 to be kept, got %v", serial)
+	}
+}
+
+func TestNewReloader_RejectsUnknownSettings(t *testing.T) {
+	cases := map[string]Config{
+		"no key":      {CertFile: "server.pem"},
+		"version":     {CertFile: "server.pem", KeyFile: "server.key", MinVersion: "1.0"},
+		"ciphers":     {CertFile: "server.pem", KeyFile: "server.key", CipherPolicy: "weak"},
+		"client auth": {CertFile: "server.pem", KeyFile: "server.key", ClientCAFile: "ca.pem", ClientAuth: "sometimes"},
+	}
+
+	for name, config := range cases {
+		if _, err := NewReloader(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}